@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"flag"
+	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/BinaryArchaism/rpcgate/internal/buildinfo"
 	"github.com/BinaryArchaism/rpcgate/internal/config"
 	"github.com/BinaryArchaism/rpcgate/internal/logger"
 	"github.com/BinaryArchaism/rpcgate/internal/metrics"
@@ -15,15 +17,32 @@ import (
 	"github.com/BinaryArchaism/rpcgate/internal/startstop"
 )
 
+// Build info injected with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+//
+//nolint:gochecknoglobals // set by linker
+var (
+	version string
+	commit  string
+	date    string
+)
+
 func main() {
 	configPath := flag.String("config", "", "Path to config")
 	flag.Parse()
+	buildinfo.Set(version, commit, date)
 
 	cfg, err := config.ParseConfig(*configPath)
 	if err != nil {
 		log.Panic().Err(err).Str("config_path", *configPath).Msg("Failed to parse config")
 	}
 	logger.SetupLogger(cfg)
+	build := buildinfo.Get()
+	log.Info().
+		Str("version", build.Version).
+		Str("commit", build.Commit).
+		Str("date", build.Date).
+		Str("go_version", build.GoVersion).
+		Msg("rpcgate build")
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
@@ -33,10 +52,18 @@ func main() {
 	srv := proxy.New(cfg)
 	apps = append(apps, srv)
 
+	degradedToggle := make(chan os.Signal, 1)
+	signal.Notify(degradedToggle, syscall.SIGUSR1)
+	go func() {
+		for range degradedToggle {
+			srv.ToggleDegraded()
+		}
+	}()
+
 	if cfg.Metrics.Enabled {
 		metricsSrv := metrics.New(cfg)
 		apps = append(apps, metricsSrv)
 	}
 
-	startstop.RunGracefull(ctx, apps...)
+	startstop.RunGracefull(ctx, cfg.ShutdownTimeout, apps...)
 }
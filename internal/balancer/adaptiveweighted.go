@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptiveWeighted implements smooth weighted round-robin over providers whose weights are
+// periodically tuned toward their observed capacity: success rate divided by mean latency of
+// successful requests. Capacity does not depend on share of traffic provider gets,
+// so weights converge instead of feeding on themselves.
+type AdaptiveWeighted struct {
+	ejection
+
+	smooth    float64
+	minWeight float64
+
+	mutex     sync.Mutex
+	providers []*AWProvider
+}
+
+// AWProvider is provider of AdaptiveWeighted with its weight and observations of current window.
+type AWProvider struct {
+	Payload Payload
+
+	weight  float64
+	current float64
+
+	requests  int64
+	successes int64
+	latency   time.Duration // total latency of successful requests
+
+	unhealthyUntil cooldownDeadline
+}
+
+// ProviderWeight is current weight of provider of AdaptiveWeighted, mean weight of providers is 1.
+type ProviderWeight struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+}
+
+// NewAdaptiveWeighted returns a new AdaptiveWeighted instance with equal weights of providers.
+// On every Adjust weight moves toward observed capacity by smooth share, but never below minWeight,
+// so provider which was slow once keeps getting requests to prove it recovered.
+//
+// The passed slice of Payload is copied, so it is safe to modify
+// the original slice after calling this function.
+func NewAdaptiveWeighted(providers []Payload, smooth, minWeight float64) *AdaptiveWeighted {
+	p := make([]*AWProvider, 0, len(providers))
+	for _, pr := range providers {
+		p = append(p, &AWProvider{Payload: pr, weight: 1})
+	}
+	return &AdaptiveWeighted{
+		smooth:    smooth,
+		minWeight: minWeight,
+		providers: p,
+	}
+}
+
+// Borrow returns provider picked by smooth weighted round-robin, ejected, drained and lagging providers are skipped.
+// Providers in cooldown are skipped too, unless every other provider is in cooldown as well.
+// Release callback records result of request for next Adjust.
+func (b *AdaptiveWeighted) Borrow() (Payload, Release) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var (
+		best  *AWProvider
+		total float64
+	)
+	providers := available(&b.ejection, b.providers, func(p *AWProvider) Payload { return p.Payload })
+	providers = preferOutOfCooldown(providers, func(p *AWProvider) *cooldownDeadline { return &p.unhealthyUntil },
+		time.Now())
+	for _, p := range providers {
+		p.current += p.weight
+		total += p.weight
+		if best == nil || p.current > best.current {
+			best = p
+		}
+	}
+	if best == nil {
+		return Payload{}, func(bool, time.Duration) {}
+	}
+	best.current -= total
+	best.requests++
+
+	return best.Payload, func(ok bool, latency time.Duration) {
+		if !ok {
+			return
+		}
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		best.successes++
+		best.latency += latency
+	}
+}
+
+// RateLimited starts cooldown of provider answered with 429 for as long as its Retry-After asks,
+// see rateLimitCooldown. Balancer has no cooldown of failed providers, so Retry-After is required.
+func (b *AdaptiveWeighted) RateLimited(name string, retryAfter time.Duration) {
+	cooldown := rateLimitCooldown(0, retryAfter)
+	if cooldown <= 0 {
+		return
+	}
+	for _, p := range b.providers {
+		if p.Payload.Name == name {
+			p.unhealthyUntil.extend(time.Now().Add(cooldown))
+		}
+	}
+}
+
+// Adjust moves weights of providers toward their capacity observed since previous Adjust
+// and starts new observation window. Providers without requests in window keep their weights.
+func (b *AdaptiveWeighted) Adjust() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	capacities := make([]float64, len(b.providers))
+	var (
+		sum      float64
+		observed int
+	)
+	for i, p := range b.providers {
+		if p.requests == 0 {
+			capacities[i] = -1
+			continue
+		}
+		if p.successes > 0 && p.latency > 0 {
+			successRate := float64(p.successes) / float64(p.requests)
+			meanLatency := p.latency.Seconds() / float64(p.successes)
+			capacities[i] = successRate / meanLatency
+		}
+		sum += capacities[i]
+		observed++
+	}
+	for i, p := range b.providers {
+		if capacities[i] >= 0 && sum > 0 {
+			// target weights of observed providers keep their mean at 1.
+			target := capacities[i] / sum * float64(observed)
+			p.weight = max((1-b.smooth)*p.weight+b.smooth*target, b.minWeight)
+		}
+		p.requests, p.successes, p.latency = 0, 0, 0
+	}
+}
+
+// Run calls Adjust every interval until done is closed.
+func (b *AdaptiveWeighted) Run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Adjust()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Weights returns current weights of providers.
+func (b *AdaptiveWeighted) Weights() []ProviderWeight {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	result := make([]ProviderWeight, 0, len(b.providers))
+	for _, p := range b.providers {
+		result = append(result, ProviderWeight{Name: p.Payload.Name, Weight: p.weight})
+	}
+	return result
+}
+
+// Health returns health of providers, ejected, drained, lagging providers and providers in cooldown are unhealthy.
+func (b *AdaptiveWeighted) Health() []ProviderHealth {
+	now := time.Now()
+	return health(&b.ejection, b.providers, func(p *AWProvider) Payload { return p.Payload },
+		func(p *AWProvider) bool { return !p.unhealthyUntil.active(now) })
+}
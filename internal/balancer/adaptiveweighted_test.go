@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AdaptiveWeighted_Borrow(t *testing.T) {
+	aw := NewAdaptiveWeighted([]Payload{{Name: "first"}, {Name: "second"}}, 0.5, 0.1)
+
+	counts := make(map[string]int)
+	for range 4 {
+		payload, _ := aw.Borrow()
+		counts[payload.Name]++
+	}
+	// equal weights are served in turn.
+	require.Equal(t, map[string]int{"first": 2, "second": 2}, counts)
+
+	aw.Eject("first")
+	payload, _ := aw.Borrow()
+	require.Equal(t, "second", payload.Name)
+	aw.Eject("second")
+	payload, _ = aw.Borrow()
+	require.Empty(t, payload.Name)
+}
+
+func Test_AdaptiveWeighted_Adjust(t *testing.T) {
+	aw := NewAdaptiveWeighted([]Payload{{Name: "fast"}, {Name: "slow"}}, 0.5, 0.1)
+	latency := map[string]time.Duration{"fast": 10 * time.Millisecond, "slow": 40 * time.Millisecond}
+
+	weight := func(name string) float64 {
+		for _, w := range aw.Weights() {
+			if w.Name == name {
+				return w.Weight
+			}
+		}
+		return 0
+	}
+
+	prev := weight("fast")
+	for range 5 {
+		for range 100 {
+			payload, release := aw.Borrow()
+			release(true, latency[payload.Name])
+		}
+		aw.Adjust()
+		require.Greater(t, weight("fast"), prev)
+		prev = weight("fast")
+	}
+	// capacity of fast provider is 4 times bigger, so weights converge to 1.6 and 0.4.
+	require.InDelta(t, 1.6, weight("fast"), 0.05)
+	require.InDelta(t, 0.4, weight("slow"), 0.05)
+
+	counts := make(map[string]int)
+	for range 100 {
+		payload, _ := aw.Borrow()
+		counts[payload.Name]++
+	}
+	require.InDelta(t, 80, counts["fast"], 2)
+}
+
+func Test_AdaptiveWeighted_AdjustFailures(t *testing.T) {
+	aw := NewAdaptiveWeighted([]Payload{{Name: "healthy"}, {Name: "failing"}, {Name: "idle"}}, 1, 0.1)
+	aw.Eject("idle")
+	for range 10 {
+		payload, release := aw.Borrow()
+		release(payload.Name == "healthy", time.Millisecond)
+	}
+	aw.Adjust()
+
+	require.Equal(t, []ProviderWeight{
+		{Name: "healthy", Weight: 2},
+		{Name: "failing", Weight: 0.1},
+		{Name: "idle", Weight: 1},
+	}, aw.Weights())
+}
@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BlockFetcher returns latest block number of provider.
+type BlockFetcher func(ctx context.Context, provider Payload) (uint64, error)
+
+// BlockLagObserver is called with lag of provider behind the highest block among providers after every poll.
+type BlockLagObserver func(provider string, lag uint64)
+
+// LagMarker is implemented by balancers which skip providers lagging behind others.
+type LagMarker interface {
+	SetLagging(name string, lagging bool) bool
+}
+
+// BlockLagPoller periodically compares latest blocks of providers. Provider more than maxLag blocks
+// behind the highest one is marked as lagging in balancer until it catches up.
+// Provider which failed to answer keeps its mark.
+type BlockLagPoller struct {
+	providers []Payload
+	marker    LagMarker
+	fetch     BlockFetcher
+	maxLag    uint64
+	observer  BlockLagObserver
+}
+
+// NewBlockLagPoller returns poller of providers marking lagging ones in marker.
+//
+// The passed slice of Payload is copied, so it is safe to modify
+// the original slice after calling this function.
+func NewBlockLagPoller(providers []Payload, marker LagMarker, fetch BlockFetcher, maxLag uint64) *BlockLagPoller {
+	return &BlockLagPoller{
+		providers: append([]Payload(nil), providers...),
+		marker:    marker,
+		fetch:     fetch,
+		maxLag:    maxLag,
+	}
+}
+
+// SetObserver sets observer of provider lags, it must be set before poller is used.
+func (p *BlockLagPoller) SetObserver(observer BlockLagObserver) {
+	p.observer = observer
+}
+
+// Poll fetches latest blocks of all providers concurrently and updates their marks.
+func (p *BlockLagPoller) Poll(ctx context.Context) {
+	blocks := make([]uint64, len(p.providers))
+	answered := make([]bool, len(p.providers))
+	var wg sync.WaitGroup
+	for i, provider := range p.providers {
+		wg.Go(func() {
+			block, err := p.fetch(ctx, provider)
+			blocks[i], answered[i] = block, err == nil
+		})
+	}
+	wg.Wait()
+
+	var highest uint64
+	for i := range p.providers {
+		if answered[i] {
+			highest = max(highest, blocks[i])
+		}
+	}
+	for i, provider := range p.providers {
+		if !answered[i] {
+			continue
+		}
+		lag := highest - blocks[i]
+		p.marker.SetLagging(provider.Name, lag > p.maxLag)
+		if p.observer != nil {
+			p.observer(provider.Name, lag)
+		}
+	}
+}
+
+// Run calls Poll every interval until done is closed, every poll is bounded by interval.
+func (p *BlockLagPoller) Run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		p.Poll(ctx)
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BlockLagPoller_Poll(t *testing.T) {
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}, {URL: "c", Name: "c"}}
+	var (
+		mu     sync.Mutex
+		blocks = map[string]uint64{"a": 100, "b": 90, "c": 98}
+		down   = map[string]bool{}
+		lags   = map[string]uint64{}
+	)
+	fetch := func(_ context.Context, p Payload) (uint64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down[p.Name] {
+			return 0, errors.New("connection refused")
+		}
+		return blocks[p.Name], nil
+	}
+	rr := NewRoundRobin(payload)
+	poller := NewBlockLagPoller(payload, rr, fetch, 5)
+	poller.SetObserver(func(provider string, lag uint64) { lags[provider] = lag })
+
+	borrowed := func() map[string]int {
+		result := make(map[string]int)
+		for range 30 {
+			p, release := rr.Borrow()
+			result[p.Name]++
+			release(true, time.Millisecond)
+		}
+		return result
+	}
+
+	// b is 10 blocks behind, c is within threshold.
+	poller.Poll(context.Background())
+	require.Equal(t, map[string]uint64{"a": 0, "b": 10, "c": 2}, lags)
+	require.Equal(t, map[string]int{"a": 15, "c": 15}, borrowed())
+	require.Equal(t, []ProviderHealth{{Name: "a", Healthy: true}, {Name: "b", Healthy: false}, {Name: "c", Healthy: true}},
+		rr.Health())
+
+	// b failed to answer and keeps its mark.
+	down["b"] = true
+	poller.Poll(context.Background())
+	require.Equal(t, map[string]int{"a": 15, "c": 15}, borrowed())
+
+	// b caught up.
+	down["b"] = false
+	blocks["b"] = 97
+	poller.Poll(context.Background())
+	require.Equal(t, uint64(3), lags["b"])
+	require.Equal(t, map[string]int{"a": 10, "b": 10, "c": 10}, borrowed())
+}
+
+func Test_BlockLagPoller_Run(t *testing.T) {
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}
+	polled := make(chan struct{}, 10)
+	fetch := func(_ context.Context, p Payload) (uint64, error) {
+		if p.Name == "a" {
+			polled <- struct{}{}
+			return 100, nil
+		}
+		return 1, nil
+	}
+	lc := NewLeastConnection(payload)
+	done := make(chan struct{})
+	go NewBlockLagPoller(payload, lc, fetch, 10).Run(time.Millisecond, done)
+	<-polled
+	<-polled
+	close(done)
+
+	p, _ := lc.Borrow()
+	require.Equal(t, "a", p.Name)
+}
+
+func Test_SetLagging(t *testing.T) {
+	type lagBalancer interface {
+		Borrow() (Payload, Release)
+		Eject(name string) bool
+		SetLagging(name string, lagging bool) bool
+	}
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}
+	testCases := []struct {
+		name     string
+		balancer lagBalancer
+	}{
+		{name: "p2cewma", balancer: NewP2CEWMA(payload, 0.3, 8, 0.8, time.Second)},
+		{name: "round-robin", balancer: NewRoundRobin(payload)},
+		{name: "least-connection", balancer: NewLeastConnection(payload)},
+		{name: "least-pending-bytes", balancer: NewLeastPendingBytes(payload)},
+		{name: "adaptive-weighted", balancer: NewAdaptiveWeighted(payload, 0.3, 0.1)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.True(t, tc.balancer.SetLagging("a", true))
+			require.False(t, tc.balancer.SetLagging("a", true))
+			for range 20 {
+				p, release := tc.balancer.Borrow()
+				require.Equal(t, "b", p.Name)
+				release(true, time.Millisecond)
+			}
+
+			// lagging provider is borrowed if it is the only one left.
+			tc.balancer.Eject("b")
+			p, _ := tc.balancer.Borrow()
+			require.Equal(t, "a", p.Name)
+
+			require.True(t, tc.balancer.SetLagging("a", false))
+			require.False(t, tc.balancer.SetLagging("a", false))
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// rateLimitCooldownFactor lengthens cooldown of provider answered with 429 without Retry-After.
+	rateLimitCooldownFactor = 2
+	// maxRateLimitCooldown bounds cooldown requested by provider in Retry-After.
+	maxRateLimitCooldown = 10 * time.Minute
+)
+
+// cooldownDeadline is time until which failed provider is excluded from balancing.
+// Zero value is deadline of provider which never failed.
+type cooldownDeadline struct {
+	unixNano atomic.Int64
+}
+
+// active returns true if provider is in cooldown at now.
+func (d *cooldownDeadline) active(now time.Time) bool {
+	return now.UnixNano() < d.unixNano.Load()
+}
+
+// onRelease starts cooldown of passed duration if request failed, zero duration disables cooldown.
+// Success does not end cooldown early, it could be a request borrowed before the failure.
+func (d *cooldownDeadline) onRelease(ok bool, cooldown time.Duration) {
+	if ok || cooldown <= 0 {
+		return
+	}
+	d.extend(time.Now().Add(cooldown))
+}
+
+// extend moves deadline to until, later deadline is kept, so longer cooldown of rate limited provider
+// is not shortened by failure of another request.
+func (d *cooldownDeadline) extend(until time.Time) {
+	for {
+		current := d.unixNano.Load()
+		if until.UnixNano() <= current || d.unixNano.CompareAndSwap(current, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+// rateLimitCooldown returns cooldown of provider answered with 429: retryAfter requested by provider
+// bounded by maxRateLimitCooldown, but not shorter than lengthened generic cooldown.
+func rateLimitCooldown(cooldown, retryAfter time.Duration) time.Duration {
+	return max(min(retryAfter, maxRateLimitCooldown), rateLimitCooldownFactor*cooldown)
+}
+
+// preferOutOfCooldown returns providers not in cooldown at now, providers are returned as is
+// if every one of them is in cooldown.
+func preferOutOfCooldown[P any](providers []P, deadline func(P) *cooldownDeadline, now time.Time) []P {
+	inCooldown := func(p P) bool { return deadline(p).active(now) }
+	if !slices.ContainsFunc(providers, inCooldown) {
+		return providers
+	}
+	if healthy := slices.DeleteFunc(slices.Clone(providers), inCooldown); len(healthy) > 0 {
+		return healthy
+	}
+	return providers
+}
@@ -0,0 +1,113 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rateLimitCooldown(t *testing.T) {
+	require.Equal(t, 30*time.Second, rateLimitCooldown(5*time.Second, 30*time.Second))
+	require.Equal(t, 10*time.Second, rateLimitCooldown(5*time.Second, 0))
+	require.Equal(t, 10*time.Second, rateLimitCooldown(5*time.Second, time.Second))
+	require.Equal(t, maxRateLimitCooldown, rateLimitCooldown(5*time.Second, 24*time.Hour))
+	require.Zero(t, rateLimitCooldown(0, 0))
+}
+
+func Test_cooldownDeadline_extend(t *testing.T) {
+	var d cooldownDeadline
+	now := time.Now()
+	d.extend(now.Add(time.Minute))
+	// generic failure does not shorten longer cooldown.
+	d.onRelease(false, time.Millisecond)
+	require.True(t, d.active(now.Add(59*time.Second)))
+	require.False(t, d.active(now.Add(time.Minute)))
+}
+
+func Test_RateLimited(t *testing.T) {
+	type rateLimitedBalancer interface {
+		Borrow() (Payload, Release)
+		RateLimited(name string, retryAfter time.Duration)
+		Health() []ProviderHealth
+	}
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}
+	testCases := []struct {
+		name     string
+		balancer rateLimitedBalancer
+	}{
+		{name: "p2cewma", balancer: NewP2CEWMA(payload, 0.3, 8, 0.8, time.Millisecond)},
+		// cooldown is disabled, but Retry-After is honored.
+		{name: "round-robin", balancer: NewRoundRobin(payload)},
+		{name: "least-connection", balancer: NewLeastConnection(payload)},
+		{name: "least-pending-bytes", balancer: NewLeastPendingBytes(payload)},
+		{name: "adaptive-weighted", balancer: NewAdaptiveWeighted(payload, 0.3, 0.1)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.balancer.RateLimited("b", time.Minute)
+			require.Equal(t, []ProviderHealth{{Name: "a", Healthy: true}, {Name: "b", Healthy: false}},
+				tc.balancer.Health())
+			for range 10 {
+				p, release := tc.balancer.Borrow()
+				require.Equal(t, "a", p.Name)
+				release(true, time.Millisecond)
+			}
+			// rate limited provider is borrowed if there is no other one.
+			tc.balancer.RateLimited("a", time.Minute)
+			borrowed := make(map[string]bool)
+			for range 10 {
+				p, release := tc.balancer.Borrow()
+				borrowed[p.Name] = true
+				release(true, time.Millisecond)
+			}
+			require.NotEmpty(t, borrowed)
+			require.False(t, borrowed[""])
+		})
+	}
+}
+
+func Test_singleProviderFailure(t *testing.T) {
+	payload := []Payload{{Name: "node", URL: "node"}}
+	p2cewma := NewP2CEWMADefault(payload)
+	lc := NewLeastConnection(payload)
+	lc.SetCooldown(time.Minute)
+	rr := NewRoundRobin(payload)
+	rr.SetCooldown(time.Minute)
+
+	balancers := map[string]interface {
+		Borrow() (Payload, Release)
+		RateLimited(name string, retryAfter time.Duration)
+		Health() []ProviderHealth
+	}{"p2cewma": p2cewma, "least-connection": lc, "round-robin": rr}
+	for name, b := range balancers {
+		t.Run(name, func(t *testing.T) {
+			// lone provider is borrowed after failures and rate limit put it in cooldown.
+			for range 3 {
+				p, release := b.Borrow()
+				require.Equal(t, payload[0], p)
+				release(false, 10*time.Millisecond)
+			}
+			b.RateLimited("node", time.Minute)
+			p, _ := b.Borrow()
+			require.Equal(t, payload[0], p)
+			// but it is still reported unhealthy.
+			require.False(t, b.Health()[0].Healthy)
+		})
+	}
+
+	// penalty and latency of lone provider are still updated.
+	stats := p2cewma.Stats()[0]
+	require.Positive(t, stats.Penalty)
+	require.Positive(t, stats.EWMAMS)
+	require.Positive(t, stats.CooldownRemaining)
+
+	// fail closed balancers leave lone provider in cooldown, so request can be routed to fallback rpc.
+	p2cewma.SetFailClosed(true)
+	lc.SetFailClosed(true)
+	rr.SetFailClosed(true)
+	for name, b := range balancers {
+		p, _ := b.Borrow()
+		require.Equal(t, Payload{}, p, name)
+	}
+}
@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ejection tracks providers removed from balancing at runtime, it is embedded by balancers.
+// Ejected provider is never borrowed again, balancer with every provider ejected
+// returns empty Payload like balancer without providers.
+// Lagging provider is skipped until its mark is cleared, unless every provider which is not ejected lags.
+// Drained provider is skipped like ejected one until it is undrained, its stats are kept.
+type ejection struct {
+	count    atomic.Int64
+	names    sync.Map // provider name -> struct{}
+	lagging  atomic.Int64
+	laggers  sync.Map // provider name -> struct{}
+	draining atomic.Int64
+	drained  sync.Map // provider name -> struct{}
+}
+
+// Eject removes provider with passed name from balancing.
+// Returns false if provider is already ejected.
+func (e *ejection) Eject(name string) bool {
+	if _, loaded := e.names.LoadOrStore(name, struct{}{}); loaded {
+		return false
+	}
+	e.count.Add(1)
+	return true
+}
+
+// isEjected returns true if provider with passed name is ejected.
+func (e *ejection) isEjected(name string) bool {
+	if e.count.Load() == 0 {
+		return false
+	}
+	_, ok := e.names.Load(name)
+	return ok
+}
+
+// SetLagging marks provider with passed name as lagging behind other providers or clears its mark.
+// Returns false if mark of provider is not changed.
+func (e *ejection) SetLagging(name string, lagging bool) bool {
+	if !lagging {
+		if _, loaded := e.laggers.LoadAndDelete(name); !loaded {
+			return false
+		}
+		e.lagging.Add(-1)
+		return true
+	}
+	if _, loaded := e.laggers.LoadOrStore(name, struct{}{}); loaded {
+		return false
+	}
+	e.lagging.Add(1)
+	return true
+}
+
+// isLagging returns true if provider with passed name is marked as lagging.
+func (e *ejection) isLagging(name string) bool {
+	if e.lagging.Load() == 0 {
+		return false
+	}
+	_, ok := e.laggers.Load(name)
+	return ok
+}
+
+// Drain stops borrowing of provider with passed name until Undrain is called,
+// requests borrowed before are not affected. Returns false if provider is already drained.
+func (e *ejection) Drain(name string) bool {
+	if _, loaded := e.drained.LoadOrStore(name, struct{}{}); loaded {
+		return false
+	}
+	e.draining.Add(1)
+	return true
+}
+
+// Undrain returns drained provider with passed name to balancing.
+// Returns false if provider is not drained.
+func (e *ejection) Undrain(name string) bool {
+	if _, loaded := e.drained.LoadAndDelete(name); !loaded {
+		return false
+	}
+	e.draining.Add(-1)
+	return true
+}
+
+// isDrained returns true if provider with passed name is drained.
+func (e *ejection) isDrained(name string) bool {
+	if e.draining.Load() == 0 {
+		return false
+	}
+	_, ok := e.drained.Load(name)
+	return ok
+}
+
+// available returns providers which are not ejected, drained and lagging, lagging ones are returned
+// if there are no others. Passed slice is returned as is if no provider is ejected, drained or lagging.
+func available[P any](e *ejection, providers []P, payload func(P) Payload) []P {
+	if e.count.Load() == 0 && e.lagging.Load() == 0 && e.draining.Load() == 0 {
+		return providers
+	}
+	var result, lagging []P
+	for _, p := range providers {
+		switch name := payload(p).Name; {
+		case e.isEjected(name), e.isDrained(name):
+		case e.isLagging(name):
+			lagging = append(lagging, p)
+		default:
+			result = append(result, p)
+		}
+	}
+	if len(result) == 0 {
+		return lagging
+	}
+	return result
+}
+
+// health returns health of providers, provider is unhealthy if it is ejected, drained, lagging
+// or healthy returns false for it.
+func health[P any](e *ejection, providers []P, payload func(P) Payload, healthy func(P) bool) []ProviderHealth {
+	result := make([]ProviderHealth, 0, len(providers))
+	for _, p := range providers {
+		name := payload(p).Name
+		result = append(result, ProviderHealth{
+			Name:    name,
+			Healthy: !e.isEjected(name) && !e.isDrained(name) && !e.isLagging(name) && (healthy == nil || healthy(p)),
+		})
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Eject(t *testing.T) {
+	type ejectingBalancer interface {
+		Borrow() (Payload, Release)
+		Eject(name string) bool
+	}
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}, {URL: "c", Name: "c"}}
+	testCases := []struct {
+		name     string
+		balancer ejectingBalancer
+	}{
+		{name: "p2cewma", balancer: NewP2CEWMA(payload, 0.3, 8, 0.8, time.Second)},
+		{name: "round-robin", balancer: NewRoundRobin(payload)},
+		{name: "least-connection", balancer: NewLeastConnection(payload)},
+		{name: "least-pending-bytes", balancer: NewLeastPendingBytes(payload)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.True(t, tc.balancer.Eject("b"))
+			require.False(t, tc.balancer.Eject("b"))
+
+			borrowed := make(map[string]int)
+			for range 100 {
+				p, release := tc.balancer.Borrow()
+				borrowed[p.Name]++
+				release(true, time.Millisecond)
+			}
+			require.Zero(t, borrowed["b"])
+			require.Equal(t, 100, borrowed["a"]+borrowed["c"])
+
+			// balancer with every provider ejected has nothing to borrow.
+			require.True(t, tc.balancer.Eject("a"))
+			require.True(t, tc.balancer.Eject("c"))
+			p, _ := tc.balancer.Borrow()
+			require.Empty(t, p)
+		})
+	}
+}
+
+func Test_Drain(t *testing.T) {
+	type drainingBalancer interface {
+		Borrow() (Payload, Release)
+		Drain(name string) bool
+		Undrain(name string) bool
+	}
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}
+	testCases := []struct {
+		name     string
+		balancer drainingBalancer
+	}{
+		{name: "p2cewma", balancer: NewP2CEWMA(payload, 0.3, 8, 0.8, time.Second)},
+		{name: "round-robin", balancer: NewRoundRobin(payload)},
+		{name: "least-connection", balancer: NewLeastConnection(payload)},
+		{name: "least-pending-bytes", balancer: NewLeastPendingBytes(payload)},
+		{name: "adaptive-weighted", balancer: NewAdaptiveWeighted(payload, 0.3, 0.1)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.False(t, tc.balancer.Undrain("b"))
+			require.True(t, tc.balancer.Drain("b"))
+			require.False(t, tc.balancer.Drain("b"))
+			for range 20 {
+				p, release := tc.balancer.Borrow()
+				require.Equal(t, "a", p.Name)
+				release(true, time.Millisecond)
+			}
+
+			// balancer with every provider drained has nothing to borrow.
+			require.True(t, tc.balancer.Drain("a"))
+			p, _ := tc.balancer.Borrow()
+			require.Empty(t, p)
+
+			// undrained provider is borrowed again.
+			require.True(t, tc.balancer.Undrain("b"))
+			p, release := tc.balancer.Borrow()
+			require.Equal(t, "b", p.Name)
+			release(true, time.Millisecond)
+		})
+	}
+}
+
+func Test_Health(t *testing.T) {
+	type healthBalancer interface {
+		Eject(name string) bool
+		Health() []ProviderHealth
+	}
+	payload := []Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}
+	testCases := []struct {
+		name     string
+		balancer healthBalancer
+	}{
+		{name: "p2cewma", balancer: NewP2CEWMA(payload, 0.3, 8, 0.8, time.Second)},
+		{name: "round-robin", balancer: NewRoundRobin(payload)},
+		{name: "least-connection", balancer: NewLeastConnection(payload)},
+		{name: "least-pending-bytes", balancer: NewLeastPendingBytes(payload)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, []ProviderHealth{{Name: "a", Healthy: true}, {Name: "b", Healthy: true}},
+				tc.balancer.Health())
+			tc.balancer.Eject("b")
+			require.Equal(t, []ProviderHealth{{Name: "a", Healthy: true}, {Name: "b", Healthy: false}},
+				tc.balancer.Health())
+		})
+	}
+
+	t.Run("p2cewma cooldown", func(t *testing.T) {
+		b := NewP2CEWMA(payload[:1], 0.3, 8, 0.8, time.Hour)
+		_, release := b.Borrow()
+		release(false, time.Millisecond)
+		require.Equal(t, []ProviderHealth{{Name: "a", Healthy: false}}, b.Health())
+	})
+}
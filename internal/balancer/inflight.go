@@ -0,0 +1,22 @@
+package balancer
+
+// InFlightObserver is called with number of in-flight requests of provider every time it changes.
+type InFlightObserver func(provider string, inFlight int64)
+
+// inFlightHook notifies observer about in-flight requests of providers, it is embedded by balancers
+// tracking them. Balancer package does not depend on metrics, so they are exported through observer.
+type inFlightHook struct {
+	observer InFlightObserver
+}
+
+// SetInFlightObserver sets observer of in-flight requests, it must be set before balancer is used.
+func (h *inFlightHook) SetInFlightObserver(observer InFlightObserver) {
+	h.observer = observer
+}
+
+// observeInFlight passes number of in-flight requests of provider to observer if it is set.
+func (h *inFlightHook) observeInFlight(provider string, inFlight int64) {
+	if h.observer != nil {
+		h.observer(provider, inFlight)
+	}
+}
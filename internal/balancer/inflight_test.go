@@ -0,0 +1,45 @@
+package balancer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SetInFlightObserver(t *testing.T) {
+	type observableBalancer interface {
+		Borrow() (Payload, Release)
+		SetInFlightObserver(observer InFlightObserver)
+	}
+	payload := []Payload{{URL: "a", Name: "a"}}
+	testCases := []struct {
+		name     string
+		balancer observableBalancer
+	}{
+		{name: "p2cewma", balancer: NewP2CEWMADefault(payload)},
+		{name: "least-connection", balancer: NewLeastConnection(payload)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mutex    sync.Mutex
+				observed []int64
+			)
+			tc.balancer.SetInFlightObserver(func(provider string, inFlight int64) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				require.Equal(t, "a", provider)
+				observed = append(observed, inFlight)
+			})
+
+			_, releaseFirst := tc.balancer.Borrow()
+			_, releaseSecond := tc.balancer.Borrow()
+			releaseFirst(true, time.Millisecond)
+			releaseSecond(true, time.Millisecond)
+
+			require.Equal(t, []int64{1, 2, 1, 0}, observed)
+		})
+	}
+}
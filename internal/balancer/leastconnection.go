@@ -2,15 +2,21 @@ package balancer
 
 import (
 	"math/rand/v2"
+	"slices"
 	"sync/atomic"
 	"time"
 )
 
-// LeastConnection implements a least-connections load balancer.
+// LeastConnection implements a weighted least-connections load balancer.
 // It tracks the number of in-flight requests per provider and
-// prefers providers with fewer active requests.
+// prefers providers with fewer active requests per unit of weight.
 type LeastConnection struct {
-	providers []*LCProvider
+	ejection
+	inFlightHook
+
+	providers  []*LCProvider
+	cooldown   time.Duration
+	failClosed bool
 }
 
 // NewLeastConnection returns a new LeastConnection balancer.
@@ -20,8 +26,13 @@ type LeastConnection struct {
 func NewLeastConnection(providers []Payload) *LeastConnection {
 	p := make([]*LCProvider, 0, len(providers))
 	for _, pr := range providers {
+		weight := pr.Weight
+		if weight <= 0 {
+			weight = 1
+		}
 		p = append(p, &LCProvider{
 			Payload: pr,
+			weight:  weight,
 		})
 	}
 	return &LeastConnection{
@@ -29,63 +40,124 @@ func NewLeastConnection(providers []Payload) *LeastConnection {
 	}
 }
 
+// SetCooldown makes provider failed with release(false, _) skipped for passed duration, 0 disables it.
+// It must be set before balancer is used.
+func (lc *LeastConnection) SetCooldown(cooldown time.Duration) {
+	lc.cooldown = cooldown
+}
+
+// SetFailClosed makes Borrow return empty Payload instead of provider in cooldown when every provider
+// is in cooldown, so request can be routed to fallback rpc. It must be set before balancer is used.
+func (lc *LeastConnection) SetFailClosed(failClosed bool) {
+	lc.failClosed = failClosed
+}
+
+// RateLimited starts cooldown of provider answered with 429, see rateLimitCooldown.
+// Retry-After is honored even if cooldown is disabled.
+func (lc *LeastConnection) RateLimited(name string, retryAfter time.Duration) {
+	cooldown := rateLimitCooldown(lc.cooldown, retryAfter)
+	if cooldown <= 0 {
+		return
+	}
+	for _, p := range lc.providers {
+		if p.Payload.Name == name {
+			p.unhealthyUntil.extend(time.Now().Add(cooldown))
+		}
+	}
+}
+
 // LCProvider wraps a Payload and keeps track of in-flight requests.
 type LCProvider struct {
 	Payload Payload
 
-	inFlight int64
+	inFlight       int64
+	weight         float64
+	unhealthyUntil cooldownDeadline
 }
 
-// Borrow returns provider payload with least request in flight and release function.
+// Borrow returns provider payload with least request in flight per weight and release function.
 //
 // The release callback MUST be called when the request is finished
 // to correctly decrement the in-flight counter.
 func (lc *LeastConnection) Borrow() (Payload, Release) {
-	p := lc.pickLeast()
+	return lc.BorrowExcept(nil)
+}
+
+// BorrowExcept is like Borrow, but providers for which skip returns true are skipped too.
+func (lc *LeastConnection) BorrowExcept(skip func(name string) bool) (Payload, Release) {
+	p := lc.pickLeast(skip)
 	if p == nil {
 		return Payload{}, func(bool, time.Duration) {}
 	}
 
-	p.inFlightInc()
-	return p.Payload, func(bool, time.Duration) {
-		p.inFlightDec()
+	lc.observeInFlight(p.Payload.Name, p.inFlightInc())
+	return p.Payload, func(ok bool, _ time.Duration) {
+		p.unhealthyUntil.onRelease(ok, lc.cooldown)
+		lc.observeInFlight(p.Payload.Name, p.inFlightDec())
 	}
 }
 
-// pickLeast returns provider with least request in flight.
-func (lc *LeastConnection) pickLeast() *LCProvider {
-	n := len(lc.providers)
+// pickLeast returns provider with least request in flight per weight, ejected, drained, lagging and skipped providers
+// are skipped. Providers in cooldown are skipped too, unless every other provider is in cooldown as well
+// and balancer is not fail closed.
+func (lc *LeastConnection) pickLeast(skip func(name string) bool) *LCProvider {
+	providers := available(&lc.ejection, lc.providers, func(p *LCProvider) Payload { return p.Payload })
+	if skip != nil {
+		providers = slices.DeleteFunc(slices.Clone(providers), func(p *LCProvider) bool { return skip(p.Payload.Name) })
+	}
+	now := time.Now()
+	if slices.ContainsFunc(providers, func(p *LCProvider) bool { return p.unhealthyUntil.active(now) }) {
+		healthy := slices.DeleteFunc(slices.Clone(providers), func(p *LCProvider) bool {
+			return p.unhealthyUntil.active(now)
+		})
+		if len(healthy) > 0 || lc.failClosed {
+			providers = healthy
+		}
+	}
+	n := len(providers)
 	if n == 0 {
 		return nil
 	}
 	if n == 1 {
-		return lc.providers[0]
+		return providers[0]
 	}
 
-	minProvider := lc.providers[rand.IntN(len(lc.providers))] //nolint:gosec // unnecessary
-	minInFlight := minProvider.loadInFlight()
+	minProvider := providers[rand.IntN(len(providers))] //nolint:gosec // unnecessary
+	minLoad := minProvider.load()
 
-	for _, p := range lc.providers {
-		inFlight := p.loadInFlight()
-		if inFlight < minInFlight {
+	for _, p := range providers {
+		load := p.load()
+		if load < minLoad {
 			minProvider = p
-			minInFlight = inFlight
+			minLoad = load
 		}
 	}
 	return minProvider
 }
 
-// inFlightInc increments the in-flight counter.
-func (p *LCProvider) inFlightInc() {
-	atomic.AddInt64(&p.inFlight, 1)
+// load returns requests in flight per weight of provider.
+func (p *LCProvider) load() float64 {
+	return float64(p.loadInFlight()) / p.weight
+}
+
+// inFlightInc increments the in-flight counter and returns its new value.
+func (p *LCProvider) inFlightInc() int64 {
+	return atomic.AddInt64(&p.inFlight, 1)
 }
 
-// inFlightDec decrements the in-flight counter.
-func (p *LCProvider) inFlightDec() {
-	atomic.AddInt64(&p.inFlight, -1)
+// inFlightDec decrements the in-flight counter and returns its new value.
+func (p *LCProvider) inFlightDec() int64 {
+	return atomic.AddInt64(&p.inFlight, -1)
 }
 
 // loadInFlight loads atomic inFlight var.
 func (p *LCProvider) loadInFlight() int64 {
 	return atomic.LoadInt64(&p.inFlight)
 }
+
+// Health returns health of providers, ejected, drained, lagging providers and providers in cooldown are unhealthy.
+func (lc *LeastConnection) Health() []ProviderHealth {
+	now := time.Now()
+	return health(&lc.ejection, lc.providers, func(p *LCProvider) Payload { return p.Payload },
+		func(p *LCProvider) bool { return !p.unhealthyUntil.active(now) })
+}
@@ -2,6 +2,7 @@ package balancer
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -48,3 +49,90 @@ func Test_LeastConnection(t *testing.T) {
 		require.Equal(t, p4.URL, p2.URL)
 	})
 }
+
+func Test_LeastConnection_BorrowExcept(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	lc := NewLeastConnection(payload)
+
+	// second is picked even with more requests in flight.
+	skipFirst := func(name string) bool { return name == "first" }
+	for range 3 {
+		gotPayload, _ := lc.BorrowExcept(skipFirst)
+		require.Equal(t, payload[1], gotPayload)
+	}
+	gotPayload, _ := lc.BorrowExcept(func(string) bool { return true })
+	require.Equal(t, Payload{}, gotPayload)
+}
+
+func Test_LeastConnection_cooldown(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	lc := NewLeastConnection(payload)
+	lc.SetCooldown(50 * time.Millisecond)
+
+	p, release := lc.BorrowExcept(func(name string) bool { return name == "second" })
+	require.Equal(t, payload[0], p)
+	release(false, 0)
+	require.False(t, lc.Health()[0].Healthy)
+
+	// failed provider is skipped during cooldown even with more requests in flight on the other one.
+	for range 3 {
+		p, _ = lc.Borrow()
+		require.Equal(t, payload[1], p)
+	}
+
+	// provider in cooldown is borrowed if there is no other one.
+	p, _ = lc.BorrowExcept(func(name string) bool { return name == "second" })
+	require.Equal(t, payload[0], p)
+
+	// and re-included after cooldown.
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, lc.Health()[0].Healthy)
+	p, _ = lc.Borrow()
+	require.Equal(t, payload[0], p)
+}
+
+func Test_LeastConnection_failClosed(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	lc := NewLeastConnection(payload)
+	lc.SetCooldown(time.Minute)
+	lc.SetFailClosed(true)
+
+	p, release := lc.BorrowExcept(func(name string) bool { return name == "second" })
+	require.Equal(t, payload[0], p)
+	release(false, 0)
+	p, _ = lc.Borrow()
+	require.Equal(t, payload[1], p)
+
+	// provider in cooldown is not borrowed even if there is no other one.
+	p, _ = lc.BorrowExcept(func(name string) bool { return name == "second" })
+	require.Equal(t, Payload{}, p)
+	lc.RateLimited("second", time.Minute)
+	p, _ = lc.Borrow()
+	require.Equal(t, Payload{}, p)
+}
+
+func Test_LeastConnection_weight(t *testing.T) {
+	payload := []Payload{
+		{Name: "small", URL: "small", Weight: 1},
+		{Name: "large", URL: "large", Weight: 2},
+	}
+	lc := NewLeastConnection(payload)
+
+	// requests are held and released like under steady load.
+	var releases []Release
+	inFlight := make(map[string]int)
+	for i := range 3000 {
+		p, release := lc.Borrow()
+		inFlight[p.Name]++
+		releases = append(releases, func(ok bool, d time.Duration) {
+			inFlight[p.Name]--
+			release(ok, d)
+		})
+		if i >= 300 {
+			releases[0](true, 0)
+			releases = releases[1:]
+		}
+	}
+	require.InDelta(t, 100, inFlight["small"], 2)
+	require.InDelta(t, 200, inFlight["large"], 2)
+}
@@ -0,0 +1,167 @@
+package balancer
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// LeastPendingBytes implements a load balancer which prefers providers
+// with the least pending response bytes, modelling bandwidth saturation.
+//
+// Pending bytes of provider are estimated as a sum of expected response sizes
+// of its in-flight requests, where expected size is EWMA of observed response sizes.
+type LeastPendingBytes struct {
+	ejection
+
+	providers []*LPBProvider
+	byName    map[string]*LPBProvider
+}
+
+// NewLeastPendingBytes returns a new LeastPendingBytes balancer.
+//
+// The passed slice of Payload is copied, so it is safe to modify
+// the original slice after calling this function.
+func NewLeastPendingBytes(providers []Payload) *LeastPendingBytes {
+	p := make([]*LPBProvider, 0, len(providers))
+	byName := make(map[string]*LPBProvider, len(providers))
+	for _, pr := range providers {
+		provider := &LPBProvider{
+			Payload: pr,
+		}
+		p = append(p, provider)
+		byName[pr.Name] = provider
+	}
+	return &LeastPendingBytes{
+		providers: p,
+		byName:    byName,
+	}
+}
+
+// LPBProvider wraps a Payload and keeps track of pending response bytes.
+type LPBProvider struct {
+	Payload Payload
+
+	mutex          sync.Mutex
+	pendingBytes   float64
+	avgSize        float64
+	unhealthyUntil cooldownDeadline
+}
+
+// Borrow returns provider payload with least pending bytes and release function.
+//
+// The release callback MUST be called when the request is finished
+// to correctly decrement pending bytes.
+func (b *LeastPendingBytes) Borrow() (Payload, Release) {
+	p := b.pickLeast()
+	if p == nil {
+		return Payload{}, func(bool, time.Duration) {}
+	}
+
+	reserved := p.reserve()
+	return p.Payload, func(bool, time.Duration) {
+		p.unreserve(reserved)
+	}
+}
+
+// ObserveResponseSize updates expected response size of provider with passed name.
+// It should be called for every completed request.
+func (b *LeastPendingBytes) ObserveResponseSize(name string, size int) {
+	p, ok := b.byName[name]
+	if !ok {
+		return
+	}
+	p.observe(float64(size))
+}
+
+// RateLimited starts cooldown of provider answered with 429 for as long as its Retry-After asks,
+// see rateLimitCooldown. Balancer has no cooldown of failed providers, so Retry-After is required.
+func (b *LeastPendingBytes) RateLimited(name string, retryAfter time.Duration) {
+	cooldown := rateLimitCooldown(0, retryAfter)
+	if p, ok := b.byName[name]; ok && cooldown > 0 {
+		p.unhealthyUntil.extend(time.Now().Add(cooldown))
+	}
+}
+
+// pickLeast returns provider with least pending bytes, ejected, drained and lagging providers are skipped.
+// Providers in cooldown are skipped too, unless every other provider is in cooldown as well.
+func (b *LeastPendingBytes) pickLeast() *LPBProvider {
+	providers := available(&b.ejection, b.providers, func(p *LPBProvider) Payload { return p.Payload })
+	providers = preferOutOfCooldown(providers, func(p *LPBProvider) *cooldownDeadline { return &p.unhealthyUntil },
+		time.Now())
+	n := len(providers)
+	if n == 0 {
+		return nil
+	}
+	if n == 1 {
+		return providers[0]
+	}
+
+	minProvider := providers[rand.IntN(n)] //nolint:gosec // unnecessary
+	minPending := minProvider.loadPendingBytes()
+
+	for _, p := range providers {
+		pending := p.loadPendingBytes()
+		if pending < minPending {
+			minProvider = p
+			minPending = pending
+		}
+	}
+	return minProvider
+}
+
+// reserve adds expected response size to pending bytes and returns reserved amount.
+func (p *LPBProvider) reserve() float64 {
+	// baseSize is expected response size of provider without observations.
+	const baseSize = 1024
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	reserved := p.avgSize
+	if reserved == 0 {
+		reserved = baseSize
+	}
+	p.pendingBytes += reserved
+	return reserved
+}
+
+// unreserve subtracts previously reserved amount from pending bytes.
+func (p *LPBProvider) unreserve(reserved float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.pendingBytes -= reserved
+	if p.pendingBytes < 0 {
+		p.pendingBytes = 0
+	}
+}
+
+// observe updates EWMA of response size.
+func (p *LPBProvider) observe(size float64) {
+	const alpha = 0.3
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.avgSize == 0 {
+		p.avgSize = size
+		return
+	}
+	p.avgSize = (1-alpha)*p.avgSize + alpha*size
+}
+
+// loadPendingBytes returns current pending bytes.
+func (p *LPBProvider) loadPendingBytes() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.pendingBytes
+}
+
+// Health returns health of providers, ejected, drained, lagging providers and providers in cooldown are unhealthy.
+func (b *LeastPendingBytes) Health() []ProviderHealth {
+	now := time.Now()
+	return health(&b.ejection, b.providers, func(p *LPBProvider) Payload { return p.Payload },
+		func(p *LPBProvider) bool { return !p.unhealthyUntil.active(now) })
+}
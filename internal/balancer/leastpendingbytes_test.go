@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LeastPendingBytes(t *testing.T) {
+	t.Run("nil providers", func(t *testing.T) {
+		b := NewLeastPendingBytes(nil)
+		require.NotNil(t, b)
+		p, _ := b.Borrow()
+		require.Empty(t, p)
+	})
+	t.Run("one provider", func(t *testing.T) {
+		b := NewLeastPendingBytes([]Payload{{Name: "first"}})
+		p1, _ := b.Borrow()
+		p2, _ := b.Borrow()
+		require.Equal(t, p1, p2)
+	})
+	t.Run("large responses deprioritized", func(t *testing.T) {
+		b := NewLeastPendingBytes([]Payload{{Name: "large"}, {Name: "small"}})
+		b.ObserveResponseSize("large", 1<<20)
+		b.ObserveResponseSize("small", 1<<10)
+
+		// large provider has single in-flight request.
+		b.providers[0].reserve()
+		// small provider has more in-flight requests, but less pending bytes.
+		b.providers[1].reserve()
+		b.providers[1].reserve()
+
+		for range 10 {
+			p, release := b.Borrow()
+			require.Equal(t, "small", p.Name)
+			release(true, 0)
+		}
+	})
+	t.Run("release", func(t *testing.T) {
+		b := NewLeastPendingBytes([]Payload{{Name: "first"}, {Name: "second"}})
+		p1, r1 := b.Borrow()
+		p2, r2 := b.Borrow()
+		require.NotEqual(t, p1.Name, p2.Name)
+		r1(true, 0)
+		r2(true, 0)
+		require.Zero(t, b.providers[0].loadPendingBytes())
+		require.Zero(t, b.providers[1].loadPendingBytes())
+	})
+	t.Run("observe ewma", func(t *testing.T) {
+		var p LPBProvider
+		p.observe(100)
+		require.InDelta(t, 100.0, p.avgSize, delta)
+		p.observe(200)
+		require.InDelta(t, 130.0, p.avgSize, delta)
+	})
+}
@@ -11,14 +11,34 @@ import (
 // P2CEWMA implements the “power of two choices” load balancer
 // with EWMA latency, in-flight load and error penalties.
 type P2CEWMA struct {
+	ejection
+	inFlightHook
+
 	smooth         float64
 	loadNormalizer float64
 	penaltyDecay   float64
 	cooldown       time.Duration
+	streakBonus    streakBonus
+	failClosed     bool
 
 	providers []*Provider
 }
 
+// streakBonus lowers score of provider by share proportional to its success streak,
+// full bonus is given for streak of length successes. Zero value gives no bonus.
+type streakBonus struct {
+	bonus  float64
+	length int64
+}
+
+// multiplier returns score multiplier for provider with passed success streak.
+func (s streakBonus) multiplier(streak int64) float64 {
+	if s.bonus == 0 || s.length == 0 {
+		return 1
+	}
+	return 1 - s.bonus*float64(min(streak, s.length))/float64(s.length)
+}
+
 // NewP2CEWMADefault constructs a P2CEWMA with default parameters.
 func NewP2CEWMADefault(providers []Payload) *P2CEWMA {
 	const (
@@ -54,6 +74,21 @@ func NewP2CEWMA(
 	}
 }
 
+// SetStreakBonus makes providers with long success streaks mildly preferred: score of provider is lowered
+// by up to bonus share, full bonus is reached after length consecutive successes and any failure resets streak.
+// It must be set before balancer is used.
+func (b *P2CEWMA) SetStreakBonus(bonus float64, length int) {
+	b.streakBonus = streakBonus{bonus: bonus, length: int64(length)}
+}
+
+// SetFailClosed makes Borrow return empty Payload when every provider is in cooldown, so request
+// can be routed to fallback rpc. Otherwise provider closest to recovery is borrowed.
+// Fail closed balancer doesn't borrow even lone configured provider in cooldown.
+// It must be set before balancer is used.
+func (b *P2CEWMA) SetFailClosed(failClosed bool) {
+	b.failClosed = failClosed
+}
+
 // Borrow picks a provider and returns its Payload plus a release callback.
 // You MUST call release(ok, latency) after the upstream request completes,
 // where ok indicates provider-level success and latency is the end-to-end duration.
@@ -64,39 +99,158 @@ func (b *P2CEWMA) Borrow() (Payload, Release) {
 		return Payload{}, func(bool, time.Duration) {}
 	}
 
-	provider.inFlightInc()
+	b.observeInFlight(provider.Payload.Name, provider.inFlightInc())
 	return provider.Payload, func(ok bool, d time.Duration) {
 		provider.onRelease(ok, d, b.smooth, b.penaltyDecay, b.cooldown)
-		provider.inFlightDec()
+		b.observeInFlight(provider.Payload.Name, provider.inFlightDec())
 	}
 }
 
 // p2c (“power of two choices”): pick two random providers and return the one with the lower score.
+// Ejected, drained and lagging providers are skipped. If both picked providers are in cooldown, see outOfCooldown.
+// Lone configured provider is returned even in cooldown unless balancer is fail closed, its failures still update
+// penalty and cooldown seen in stats.
 func (b *P2CEWMA) p2c() *Provider {
-	n := len(b.providers)
+	providers := available(&b.ejection, b.providers, func(p *Provider) Payload { return p.Payload })
+	n := len(providers)
 	if n == 0 {
 		return nil
 	}
+	now := time.Now()
 	if n == 1 {
-		return b.providers[0]
+		if (len(b.providers) == 1 && !b.failClosed) || !providers[0].inCooldown(now) {
+			return providers[0]
+		}
+		return b.outOfCooldown(providers, now)
+	}
+
+	i, j := pickPair(n)
+	pi, pj := providers[i], providers[j]
+
+	si := pi.score(now, b.loadNormalizer, b.streakBonus)
+	sj := pj.score(now, b.loadNormalizer, b.streakBonus)
+
+	best, score := pj, sj
+	if si < sj {
+		best, score = pi, si
+	}
+	if math.IsInf(score, 1) {
+		return b.outOfCooldown(providers, now)
+	}
+	return best
+}
+
+// outOfCooldown returns provider with the lowest score among providers not in cooldown.
+// If every provider is in cooldown, nil is returned by fail closed balancer
+// and provider closest to recovery by others.
+func (b *P2CEWMA) outOfCooldown(providers []*Provider, now time.Time) *Provider {
+	var best, recovering *Provider
+	bestScore := math.Inf(1)
+	var recoveringUntil time.Time
+	for _, p := range providers {
+		if score := p.score(now, b.loadNormalizer, b.streakBonus); score < bestScore {
+			best, bestScore = p, score
+		}
+		if until := p.cooldownUntil(); recovering == nil || until.Before(recoveringUntil) {
+			recovering, recoveringUntil = p, until
+		}
+	}
+	if best != nil || b.failClosed {
+		return best
 	}
+	return recovering
+}
 
+// pickPair returns two distinct random indexes in [0, n), every pair is equally likely.
+// Second index is picked from n-1 remaining ones, indexes from i onward are shifted by one to skip i.
+func pickPair(n int) (int, int) {
 	i := rand.IntN(n)     //nolint:gosec // unnecessary
 	j := rand.IntN(n - 1) //nolint:gosec // unnecessary
-	if i == j {
+	if j >= i {
 		j++
 	}
+	return i, j
+}
 
+// RateLimited penalizes provider answered with 429 heavier than generic failure and starts its cooldown,
+// see rateLimitCooldown.
+func (b *P2CEWMA) RateLimited(name string, retryAfter time.Duration) {
+	for _, p := range b.providers {
+		if p.Payload.Name == name {
+			p.onRateLimited(rateLimitCooldown(b.cooldown, retryAfter))
+		}
+	}
+}
+
+// Health returns health of providers, ejected, drained, lagging providers and providers in cooldown are unhealthy.
+func (b *P2CEWMA) Health() []ProviderHealth {
 	now := time.Now()
-	pi, pj := b.providers[i], b.providers[j]
+	return health(&b.ejection, b.providers, func(p *Provider) Payload { return p.Payload },
+		func(p *Provider) bool { return !p.inCooldown(now) })
+}
 
-	si := pi.score(now, b.loadNormalizer)
-	sj := pj.score(now, b.loadNormalizer)
+// ProviderStats is snapshot of provider state as seen by P2CEWMA.
+type ProviderStats struct {
+	Name    string
+	EWMAMS  float64 // latency ewma in milliseconds, zero until first response
+	Penalty float64
+	Healthy bool // false while provider is in cooldown
+	// CooldownRemaining is time left until provider is balanced again, zero for healthy provider.
+	CooldownRemaining time.Duration
+	Drained           bool // true while provider is drained, its other stats are kept
+}
 
-	if si < sj {
-		return pi
+// Stats returns snapshot of every provider state. Provider mutex is held only to copy its fields,
+// so stats can be collected on metrics scrape without slowing down balancing.
+func (b *P2CEWMA) Stats() []ProviderStats {
+	now := time.Now()
+	stats := make([]ProviderStats, 0, len(b.providers))
+	for _, p := range b.providers {
+		ps := p.stats(now)
+		ps.Drained = b.isDrained(ps.Name)
+		stats = append(stats, ps)
+	}
+	return stats
+}
+
+// P2CEWMASnapshot is learned state of providers saved by Snapshot and restored by Restore.
+type P2CEWMASnapshot struct {
+	TakenAt   time.Time          `json:"taken_at"`
+	Providers []ProviderSnapshot `json:"providers"`
+}
+
+// ProviderSnapshot is learned state of provider matched by name on restore.
+type ProviderSnapshot struct {
+	Name           string    `json:"name"`
+	EWMAMS         float64   `json:"ewma_ms"`
+	Penalty        float64   `json:"penalty"`
+	UnhealthyUntil time.Time `json:"unhealthy_until,omitzero"`
+	Streak         int64     `json:"streak"`
+}
+
+// Snapshot returns learned state of every provider, so it can be restored by balancer of the same rpc
+// after restart.
+func (b *P2CEWMA) Snapshot() P2CEWMASnapshot {
+	snapshot := P2CEWMASnapshot{TakenAt: time.Now(), Providers: make([]ProviderSnapshot, 0, len(b.providers))}
+	for _, p := range b.providers {
+		snapshot.Providers = append(snapshot.Providers, p.snapshot())
+	}
+	return snapshot
+}
+
+// Restore sets learned state of providers found in snapshot by name, other providers are left cold.
+// Penalties and cooldowns are restored only if snapshot is not older than maxAge, so provider
+// which failed long ago is not punished again, while latencies are restored from snapshot of any age.
+// It must be called before balancer is used.
+func (b *P2CEWMA) Restore(snapshot P2CEWMASnapshot, maxAge time.Duration) {
+	fresh := time.Since(snapshot.TakenAt) <= maxAge
+	for _, ps := range snapshot.Providers {
+		for _, p := range b.providers {
+			if p.Payload.Name == ps.Name {
+				p.restore(ps, fresh)
+			}
+		}
 	}
-	return pj
 }
 
 // Provider represents an upstream RPC provider with metadata (Payload)
@@ -108,19 +262,21 @@ type Provider struct {
 	ewmaMS         float64
 	penalty        float64
 	unhealthyUntil time.Time
+	streak         int64 // consecutive successful responses
 
 	inFlight int64
 }
 
 // score computes a lower-is-better score from EWMA latency, current in-flight load,
-// and an error penalty. Returns +Inf while the provider is in cooldown.
-func (p *Provider) score(now time.Time, loadNormalizer float64) float64 {
+// an error penalty and a success streak bonus. Returns +Inf while the provider is in cooldown.
+func (p *Provider) score(now time.Time, loadNormalizer float64, bonus streakBonus) float64 {
 	const baseEWMA = 75
 
 	p.mutex.Lock()
 	base := p.ewmaMS
 	pen := p.penalty
 	until := p.unhealthyUntil
+	streak := p.streak
 	p.mutex.Unlock()
 
 	if now.Before(until) {
@@ -134,7 +290,64 @@ func (p *Provider) score(now time.Time, loadNormalizer float64) float64 {
 	inFlight := atomic.LoadInt64(&p.inFlight)
 	reqLoad := 1 + float64(inFlight)/loadNormalizer
 
-	return base * reqLoad * (1 + pen)
+	return base * reqLoad * (1 + pen) * bonus.multiplier(streak)
+}
+
+// stats returns snapshot of provider state at now.
+func (p *Provider) stats(now time.Time) ProviderStats {
+	p.mutex.Lock()
+	ewmaMS, penalty, until := p.ewmaMS, p.penalty, p.unhealthyUntil
+	p.mutex.Unlock()
+
+	stats := ProviderStats{Name: p.Payload.Name, EWMAMS: ewmaMS, Penalty: penalty, Healthy: true}
+	if now.Before(until) {
+		stats.Healthy = false
+		stats.CooldownRemaining = until.Sub(now)
+	}
+	return stats
+}
+
+// snapshot returns learned state of provider.
+func (p *Provider) snapshot() ProviderSnapshot {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return ProviderSnapshot{
+		Name:           p.Payload.Name,
+		EWMAMS:         p.ewmaMS,
+		Penalty:        p.penalty,
+		UnhealthyUntil: p.unhealthyUntil,
+		Streak:         p.streak,
+	}
+}
+
+// restore sets learned state of provider, penalty and cooldown are restored only if unhealthy is true.
+func (p *Provider) restore(ps ProviderSnapshot, unhealthy bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.ewmaMS = ps.EWMAMS
+	p.streak = ps.Streak
+	if unhealthy {
+		p.penalty = ps.Penalty
+		p.unhealthyUntil = ps.UnhealthyUntil
+	}
+}
+
+// inCooldown returns true if provider failed recently and is excluded from balancing until cooldown ends.
+func (p *Provider) inCooldown(now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return now.Before(p.unhealthyUntil)
+}
+
+// cooldownUntil returns time when provider leaves cooldown.
+func (p *Provider) cooldownUntil() time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.unhealthyUntil
 }
 
 // onRelease updates EWMA latency (ms), decays or sets the error penalty,
@@ -160,9 +373,11 @@ func (p *Provider) onRelease(
 	p.ewmaMS = (1-alpha)*p.ewmaMS + float64(lat.Milliseconds())*alpha
 
 	if !ok {
-		p.penalty = penaltyValue
-		p.unhealthyUntil = time.Now().Add(cooldown)
+		p.penalty = max(p.penalty, penaltyValue)
+		p.extendCooldown(time.Now().Add(cooldown))
+		p.streak = 0
 	} else {
+		p.streak++
 		p.penalty *= penaltyDecay
 		if p.penalty < penaltyLostValue {
 			p.penalty = 0
@@ -170,12 +385,31 @@ func (p *Provider) onRelease(
 	}
 }
 
-// inFlightInc increments the in-flight counter.
-func (p *Provider) inFlightInc() {
-	atomic.AddInt64(&p.inFlight, 1)
+// onRateLimited sets penalty of rate limited provider and starts its cooldown.
+func (p *Provider) onRateLimited(cooldown time.Duration) {
+	const penaltyRateLimitedValue = 1
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.penalty = penaltyRateLimitedValue
+	p.extendCooldown(time.Now().Add(cooldown))
+	p.streak = 0
+}
+
+// extendCooldown moves end of cooldown to until, later end is kept. Mutex must be held.
+func (p *Provider) extendCooldown(until time.Time) {
+	if until.After(p.unhealthyUntil) {
+		p.unhealthyUntil = until
+	}
+}
+
+// inFlightInc increments the in-flight counter and returns its new value.
+func (p *Provider) inFlightInc() int64 {
+	return atomic.AddInt64(&p.inFlight, 1)
 }
 
-// inFlightDec decrements the in-flight counter.
-func (p *Provider) inFlightDec() {
-	atomic.AddInt64(&p.inFlight, -1)
+// inFlightDec decrements the in-flight counter and returns its new value.
+func (p *Provider) inFlightDec() int64 {
+	return atomic.AddInt64(&p.inFlight, -1)
 }
@@ -21,10 +21,10 @@ func Test_P2CEWMA_NewP2CEWMA(t *testing.T) {
 	}
 	b := NewP2CEWMADefault(nil)
 	require.NotNil(t, b)
-	require.Equal(t, expected, *b)
+	require.Equal(t, &expected, b)
 	b = NewP2CEWMA(nil, 0.3, 8, 0.8, 10*time.Second)
 	require.NotNil(t, b)
-	require.Equal(t, expected, *b)
+	require.Equal(t, &expected, b)
 
 	b = NewP2CEWMADefault([]Payload{})
 	require.NotNil(t, b)
@@ -74,18 +74,116 @@ func Test_P2CEWMA_p2c(t *testing.T) {
 	})
 }
 
+func Test_P2CEWMA_allInCooldown(t *testing.T) {
+	newBalancer := func() *P2CEWMA {
+		b := NewP2CEWMADefault([]Payload{{Name: "1"}, {Name: "2"}, {Name: "3"}})
+		now := time.Now()
+		b.providers[0].unhealthyUntil = now.Add(5 * time.Second)
+		b.providers[1].unhealthyUntil = now.Add(time.Second)
+		b.providers[2].unhealthyUntil = now.Add(10 * time.Second)
+		return b
+	}
+	t.Run("best effort", func(t *testing.T) {
+		b := newBalancer()
+		for range 10 {
+			p, _ := b.Borrow()
+			require.Equal(t, "2", p.Name)
+		}
+	})
+	t.Run("fail closed", func(t *testing.T) {
+		b := newBalancer()
+		b.SetFailClosed(true)
+		for range 10 {
+			p, _ := b.Borrow()
+			require.Equal(t, Payload{}, p)
+		}
+	})
+	t.Run("one out of cooldown", func(t *testing.T) {
+		b := newBalancer()
+		b.SetFailClosed(true)
+		b.providers[2].unhealthyUntil = time.Time{}
+		for range 10 {
+			p, _ := b.Borrow()
+			require.Equal(t, "3", p.Name)
+		}
+	})
+	t.Run("single provider", func(t *testing.T) {
+		b := NewP2CEWMADefault([]Payload{{Name: "1"}})
+		b.providers[0].unhealthyUntil = time.Now().Add(time.Second)
+		p, _ := b.Borrow()
+		require.Equal(t, "1", p.Name)
+		b.SetFailClosed(true)
+		p, _ = b.Borrow()
+		require.Equal(t, Payload{}, p)
+	})
+	t.Run("one available", func(t *testing.T) {
+		b := newBalancer()
+		b.SetFailClosed(true)
+		b.Drain("1")
+		b.Drain("2")
+		// the only available provider of several configured ones is not borrowed in cooldown.
+		p, _ := b.Borrow()
+		require.Equal(t, Payload{}, p)
+		b.SetFailClosed(false)
+		p, _ = b.Borrow()
+		require.Equal(t, "3", p.Name)
+	})
+}
+
+func Test_pickPair(t *testing.T) {
+	const drawsPerPair = 20000
+	for _, n := range []int{2, 3, 4, 5} {
+		pairs := n * (n - 1)
+		counts := make(map[[2]int]int, pairs)
+		for range drawsPerPair * pairs {
+			i, j := pickPair(n)
+			require.NotEqual(t, i, j)
+			require.True(t, i >= 0 && i < n && j >= 0 && j < n)
+			counts[[2]int{i, j}]++
+		}
+		// every ordered pair of distinct providers is picked equally often.
+		require.Len(t, counts, pairs)
+		for pair, count := range counts {
+			require.InEpsilon(t, drawsPerPair, count, 0.05, "n=%d pair=%v", n, pair)
+		}
+	}
+}
+
 func Test_Provider_score(t *testing.T) {
 	t.Run("score ok", func(t *testing.T) {
 		var p Provider
-		require.InDelta(t, 75.0, p.score(time.Now(), 8), delta)
+		require.InDelta(t, 75.0, p.score(time.Now(), 8, streakBonus{}), delta)
 	})
 	t.Run("unhealthy endpoint", func(t *testing.T) {
 		var p Provider
 		p.onRelease(false, time.Duration(75)*time.Millisecond, 0.3, 0.8, 10*time.Second)
-		require.InDelta(t, math.Inf(1), p.score(time.Now(), 8), delta)
+		require.InDelta(t, math.Inf(1), p.score(time.Now(), 8, streakBonus{}), delta)
 	})
 }
 
+func Test_P2CEWMA_SetStreakBonus(t *testing.T) {
+	b := NewP2CEWMA([]Payload{{Name: "stable"}, {Name: "flaky"}}, 0.3, 8, 0.8, time.Millisecond)
+	b.SetStreakBonus(0.1, 10)
+	stable, flaky := b.providers[0], b.providers[1]
+	for range 20 {
+		stable.onRelease(true, 50*time.Millisecond, 0.3, 0.8, time.Millisecond)
+	}
+	flaky.onRelease(false, 50*time.Millisecond, 0.3, 0.8, time.Millisecond)
+	for range 5 {
+		flaky.onRelease(true, 50*time.Millisecond, 0.3, 0.8, time.Millisecond)
+	}
+	// penalty of flaky provider has decayed, so only streak tells providers apart.
+	flaky.penalty = 0
+	time.Sleep(2 * time.Millisecond)
+
+	now := time.Now()
+	require.InDelta(t, 50*0.9, stable.score(now, 8, b.streakBonus), delta)
+	require.InDelta(t, 50*0.95, flaky.score(now, 8, b.streakBonus), delta)
+	for range 10 {
+		require.Equal(t, "stable", b.p2c().Payload.Name)
+	}
+}
+
 func Test_Provider_onRelease(t *testing.T) {
 	t.Run("success stable ms", func(t *testing.T) {
 		var p Provider
@@ -124,6 +222,72 @@ func Test_Provider_onRelease(t *testing.T) {
 	})
 }
 
+func Test_P2CEWMA_Stats(t *testing.T) {
+	b := NewP2CEWMA([]Payload{{URL: "a", Name: "a"}}, 0.3, 8, 0.8, 10*time.Second)
+	require.Equal(t, []ProviderStats{{Name: "a", Healthy: true}}, b.Stats())
+
+	_, release := b.Borrow()
+	release(false, 50*time.Millisecond)
+	stats := b.Stats()
+	require.Len(t, stats, 1)
+	require.InDelta(t, 50.0, stats[0].EWMAMS, delta)
+	require.InDelta(t, 0.5, stats[0].Penalty, delta)
+	require.False(t, stats[0].Healthy)
+	require.Greater(t, stats[0].CooldownRemaining, 9*time.Second)
+	require.LessOrEqual(t, stats[0].CooldownRemaining, 10*time.Second)
+	require.False(t, stats[0].Drained)
+
+	// drained provider keeps its stats.
+	b.Drain("a")
+	stats = b.Stats()
+	require.True(t, stats[0].Drained)
+	require.InDelta(t, 50.0, stats[0].EWMAMS, delta)
+}
+
+func Test_P2CEWMA_RateLimited(t *testing.T) {
+	b := NewP2CEWMA([]Payload{{URL: "a", Name: "a"}}, 0.3, 8, 0.8, 10*time.Second)
+
+	// without Retry-After cooldown is longer than for generic failure.
+	b.RateLimited("a", 0)
+	stats := b.Stats()[0]
+	require.InDelta(t, 1, stats.Penalty, delta)
+	require.Greater(t, stats.CooldownRemaining, 19*time.Second)
+
+	// failure neither shortens cooldown nor lowers penalty.
+	b.RateLimited("a", time.Minute)
+	_, release := b.Borrow()
+	release(false, time.Millisecond)
+	stats = b.Stats()[0]
+	require.InDelta(t, 1, stats.Penalty, delta)
+	require.Greater(t, stats.CooldownRemaining, 59*time.Second)
+}
+
+func Test_P2CEWMA_Restore(t *testing.T) {
+	b := NewP2CEWMA([]Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}, 0.3, 8, 0.8, 10*time.Second)
+	_, release := b.Borrow()
+	release(false, 50*time.Millisecond)
+	snapshot := b.Snapshot()
+	require.Len(t, snapshot.Providers, 2)
+
+	// providers are matched by name, unknown ones are skipped.
+	restored := NewP2CEWMA([]Payload{{URL: "b", Name: "b"}, {URL: "a", Name: "a"}, {URL: "c", Name: "c"}},
+		0.3, 8, 0.8, 10*time.Second)
+	restored.Restore(snapshot, time.Minute)
+	providers := restored.Snapshot().Providers
+	require.ElementsMatch(t, snapshot.Providers, providers[:2])
+	require.Equal(t, ProviderSnapshot{Name: "c"}, providers[2])
+
+	// penalty and cooldown of stale snapshot are not restored.
+	snapshot.TakenAt = snapshot.TakenAt.Add(-2 * time.Minute)
+	restored = NewP2CEWMA([]Payload{{URL: "a", Name: "a"}, {URL: "b", Name: "b"}}, 0.3, 8, 0.8, 10*time.Second)
+	restored.Restore(snapshot, time.Minute)
+	for i, stats := range restored.Stats() {
+		require.True(t, stats.Healthy)
+		require.Zero(t, stats.Penalty)
+		require.InDelta(t, snapshot.Providers[i].EWMAMS, stats.EWMAMS, delta)
+	}
+}
+
 func Test_Provider_inFlight(t *testing.T) {
 	p := Provider{
 		inFlight: 10,
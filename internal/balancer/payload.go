@@ -8,4 +8,12 @@ type Release func(success bool, latency time.Duration)
 type Payload struct {
 	URL  string
 	Name string
+	// Weight is relative capacity of provider used by least-connection balancer, 0 is treated as 1.
+	Weight float64
+}
+
+// ProviderHealth is health of provider as seen by load balancer.
+type ProviderHealth struct {
+	Name    string
+	Healthy bool
 }
@@ -8,9 +8,14 @@ import (
 // RoundRobin implements a simple round-robin load-balancing algorithm
 // over a static list of providers (Payloads).
 type RoundRobin struct {
-	payload   []Payload
-	currentIX int
-	mutex     sync.Mutex
+	ejection
+
+	payload        []Payload
+	unhealthyUntil []cooldownDeadline // per payload
+	cooldown       time.Duration
+	failClosed     bool
+	currentIX      int
+	mutex          sync.Mutex
 }
 
 // NewRoundRobin returns a new RoundRobin instance.
@@ -26,21 +31,95 @@ func NewRoundRobin(urls []Payload) *RoundRobin {
 		})
 	}
 	return &RoundRobin{
-		payload: payload,
+		payload:        payload,
+		unhealthyUntil: make([]cooldownDeadline, len(payload)),
 	}
 }
 
+// SetCooldown makes provider failed with release(false, _) skipped for passed duration, 0 disables it.
+// It must be set before balancer is used.
+func (rr *RoundRobin) SetCooldown(cooldown time.Duration) {
+	rr.cooldown = cooldown
+}
+
+// SetFailClosed makes Borrow return empty Payload instead of provider in cooldown when every provider
+// is in cooldown, so request can be routed to fallback rpc. It must be set before balancer is used.
+func (rr *RoundRobin) SetFailClosed(failClosed bool) {
+	rr.failClosed = failClosed
+}
+
 // Borrow returns the next Payload in sequence and advances the index.
 // The sequence wraps around to the beginning once it reaches the end.
+// Ejected and drained providers are skipped, empty Payload is returned if all of them are skipped.
+// Providers in cooldown and lagging providers are skipped too, unless every other provider is skipped as well.
+// Fail closed balancer never borrows provider in cooldown, see SetFailClosed.
 func (rr *RoundRobin) Borrow() (Payload, Release) {
+	return rr.BorrowExcept(nil)
+}
+
+// BorrowExcept is like Borrow, but providers for which skip returns true are skipped too.
+func (rr *RoundRobin) BorrowExcept(skip func(name string) bool) (Payload, Release) {
 	rr.mutex.Lock()
 	defer rr.mutex.Unlock()
 
-	payload := rr.payload[rr.currentIX]
-	rr.currentIX++
-	if rr.currentIX == len(rr.payload) {
-		rr.currentIX = 0
+	now := time.Now()
+	fallback := -1
+	for range rr.payload {
+		ix := rr.currentIX
+		payload := rr.payload[ix]
+		rr.currentIX++
+		if rr.currentIX == len(rr.payload) {
+			rr.currentIX = 0
+		}
+		if rr.isEjected(payload.Name) || rr.isDrained(payload.Name) || (skip != nil && skip(payload.Name)) {
+			continue
+		}
+		inCooldown := rr.unhealthyUntil[ix].active(now)
+		if !inCooldown && !rr.isLagging(payload.Name) {
+			return payload, rr.release(ix)
+		}
+		if inCooldown && rr.failClosed {
+			continue
+		}
+		if fallback == -1 {
+			fallback = ix
+		}
+	}
+	if fallback != -1 {
+		return rr.payload[fallback], rr.release(fallback)
+	}
+
+	return Payload{}, func(bool, time.Duration) {}
+}
+
+// release returns release function starting cooldown of provider with passed index on failure.
+func (rr *RoundRobin) release(ix int) Release {
+	return func(ok bool, _ time.Duration) {
+		rr.unhealthyUntil[ix].onRelease(ok, rr.cooldown)
 	}
+}
 
-	return payload, func(bool, time.Duration) {}
+// RateLimited starts cooldown of provider answered with 429, see rateLimitCooldown.
+// Retry-After is honored even if cooldown is disabled.
+func (rr *RoundRobin) RateLimited(name string, retryAfter time.Duration) {
+	cooldown := rateLimitCooldown(rr.cooldown, retryAfter)
+	if cooldown <= 0 {
+		return
+	}
+	for ix, payload := range rr.payload {
+		if payload.Name == name {
+			rr.unhealthyUntil[ix].extend(time.Now().Add(cooldown))
+		}
+	}
+}
+
+// Health returns health of providers, ejected, drained, lagging providers and providers in cooldown are unhealthy.
+func (rr *RoundRobin) Health() []ProviderHealth {
+	now := time.Now()
+	ixs := make([]int, len(rr.payload))
+	for i := range ixs {
+		ixs[i] = i
+	}
+	return health(&rr.ejection, ixs, func(ix int) Payload { return rr.payload[ix] },
+		func(ix int) bool { return !rr.unhealthyUntil[ix].active(now) })
 }
@@ -2,6 +2,7 @@ package balancer
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -30,3 +31,79 @@ func Test_RoundRobin(t *testing.T) {
 	gotPayload, _ = rr.Borrow()
 	require.Equal(t, payload[0], gotPayload)
 }
+
+func Test_RoundRobin_BorrowExcept(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	rr := NewRoundRobin(payload)
+
+	skipFirst := func(name string) bool { return name == "first" }
+	for range 3 {
+		gotPayload, _ := rr.BorrowExcept(skipFirst)
+		require.Equal(t, payload[1], gotPayload)
+	}
+	gotPayload, _ := rr.BorrowExcept(func(string) bool { return true })
+	require.Equal(t, Payload{}, gotPayload)
+}
+
+func Test_RoundRobin_cooldown(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	rr := NewRoundRobin(payload)
+	rr.SetCooldown(50 * time.Millisecond)
+
+	gotPayload, release := rr.Borrow()
+	require.Equal(t, payload[0], gotPayload)
+	release(false, 0)
+	require.False(t, rr.Health()[0].Healthy)
+
+	// failed provider is skipped during cooldown.
+	for range 3 {
+		gotPayload, release = rr.Borrow()
+		require.Equal(t, payload[1], gotPayload)
+		release(true, 0)
+	}
+
+	// provider in cooldown is borrowed if there is no other one.
+	gotPayload, _ = rr.BorrowExcept(func(name string) bool { return name == "second" })
+	require.Equal(t, payload[0], gotPayload)
+
+	// and re-included after cooldown.
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, rr.Health()[0].Healthy)
+	var got []Payload
+	for range 2 {
+		gotPayload, _ = rr.Borrow()
+		got = append(got, gotPayload)
+	}
+	require.ElementsMatch(t, payload, got)
+}
+
+func Test_RoundRobin_failClosed(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	rr := NewRoundRobin(payload)
+	rr.SetCooldown(time.Minute)
+	rr.SetFailClosed(true)
+
+	_, release := rr.Borrow()
+	release(false, 0)
+	gotPayload, _ := rr.Borrow()
+	require.Equal(t, payload[1], gotPayload)
+
+	// provider in cooldown is not borrowed even if there is no other one.
+	gotPayload, _ = rr.BorrowExcept(func(name string) bool { return name == "second" })
+	require.Equal(t, Payload{}, gotPayload)
+	rr.RateLimited("second", time.Minute)
+	gotPayload, _ = rr.Borrow()
+	require.Equal(t, Payload{}, gotPayload)
+}
+
+func Test_RoundRobin_cooldownDisabled(t *testing.T) {
+	payload := []Payload{{Name: "first", URL: "first"}, {Name: "second", URL: "second"}}
+	rr := NewRoundRobin(payload)
+
+	_, release := rr.Borrow()
+	release(false, 0)
+	gotPayload, _ := rr.Borrow()
+	require.Equal(t, payload[1], gotPayload)
+	gotPayload, _ = rr.Borrow()
+	require.Equal(t, payload[0], gotPayload)
+}
@@ -0,0 +1,43 @@
+package buildinfo
+
+import "runtime"
+
+const (
+	defaultVersion = "dev"
+	unknown        = "unknown"
+)
+
+// Info describes running rpcgate build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+//nolint:gochecknoglobals // set once at startup
+var info = Info{
+	Version:   defaultVersion,
+	Commit:    unknown,
+	Date:      unknown,
+	GoVersion: runtime.Version(),
+}
+
+// Set sets build info injected into main package with -ldflags, empty values keep defaults.
+// It must be called before any component is started.
+func Set(version, commit, date string) {
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Commit = commit
+	}
+	if date != "" {
+		info.Date = date
+	}
+}
+
+// Get returns build info.
+func Get() Info {
+	return info
+}
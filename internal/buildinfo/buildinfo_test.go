@@ -0,0 +1,23 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Set(t *testing.T) {
+	defaults := info
+	t.Cleanup(func() { info = defaults })
+
+	require.Equal(t, Info{Version: "dev", Commit: "unknown", Date: "unknown", GoVersion: runtime.Version()}, Get())
+
+	Set("v1.2.0", "", "2025-01-02T03:04:05Z")
+	require.Equal(t, Info{
+		Version:   "v1.2.0",
+		Commit:    "unknown",
+		Date:      "2025-01-02T03:04:05Z",
+		GoVersion: runtime.Version(),
+	}, Get())
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// LRU is in-memory least recently used cache with expiring entries.
+//
+// Keys are spread over shards, every shard is guarded by its own mutex and evicts
+// its least recently used entries independently, so concurrent access to different
+// keys rarely contends on the same lock.
+type LRU struct {
+	seed   maphash.Seed
+	shards []*shard
+	now    func() time.Time
+}
+
+type shard struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // front is the most recently used entry
+}
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// New returns cache holding up to capacity entries spread over shards.
+// Capacity is split evenly between shards, every shard holds at least one entry.
+func New(capacity, shards int) *LRU {
+	if shards < 1 {
+		shards = 1
+	}
+	perShard := max((capacity+shards-1)/shards, 1)
+
+	c := &LRU{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*shard, shards),
+		now:    time.Now,
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			capacity: perShard,
+			items:    make(map[string]*list.Element, perShard),
+			order:    list.New(),
+		}
+	}
+	return c
+}
+
+// shard returns shard holding key.
+func (c *LRU) shard(key string) *shard {
+	return c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+// Get returns value of key, expired entries are removed and not returned.
+func (c *LRU) Get(key string) ([]byte, bool) {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry) //nolint:forcetypeassert // only entries are stored
+	if !c.now().Before(e.expiresAt) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+	return e.value, true
+}
+
+// Set stores value of key for ttl, least recently used entry of shard is evicted if shard is full.
+func (c *LRU) Set(key string, value []byte, ttl time.Duration) {
+	expiresAt := c.now().Add(ttl)
+
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		e := elem.Value.(*entry) //nolint:forcetypeassert // only entries are stored
+		e.value, e.expiresAt = value, expiresAt
+		s.order.MoveToFront(elem)
+		return
+	}
+	if s.order.Len() >= s.capacity {
+		s.remove(s.order.Back())
+	}
+	s.items[key] = s.order.PushFront(&entry{key: key, value: value, expiresAt: expiresAt})
+}
+
+// Delete removes key from cache.
+func (c *LRU) Delete(key string) {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+}
+
+// Len returns number of entries in cache, including expired ones not removed yet.
+func (c *LRU) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		n += s.order.Len()
+		s.mutex.Unlock()
+	}
+	return n
+}
+
+// remove deletes element from shard, must be called with mutex held.
+func (s *shard) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.items, elem.Value.(*entry).key) //nolint:forcetypeassert // only entries are stored
+}
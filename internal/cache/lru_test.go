@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LRU_eviction(t *testing.T) {
+	c := New(3, 1)
+
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Set("c", []byte("3"), time.Minute)
+	// a becomes the most recently used, so b is evicted first.
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	c.Set("d", []byte("4"), time.Minute)
+
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok = c.Get(key)
+		require.True(t, ok, key)
+	}
+	require.Equal(t, 3, c.Len())
+
+	// updating existing key does not evict anything.
+	c.Set("c", []byte("33"), time.Minute)
+	value, ok := c.Get("c")
+	require.True(t, ok)
+	require.Equal(t, []byte("33"), value)
+	require.Equal(t, 3, c.Len())
+
+	c.Delete("c")
+	_, ok = c.Get("c")
+	require.False(t, ok)
+	require.Equal(t, 2, c.Len())
+}
+
+func Test_LRU_expiration(t *testing.T) {
+	now := time.Now()
+	c := New(10, 4)
+	c.now = func() time.Time { return now }
+
+	c.Set("key", []byte("value"), time.Second)
+	_, ok := c.Get("key")
+	require.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = c.Get("key")
+	require.False(t, ok)
+	require.Zero(t, c.Len())
+}
+
+func Test_LRU_shards(t *testing.T) {
+	const capacity = 64
+
+	c := New(capacity, 8)
+	for i := range 10 * capacity {
+		c.Set(strconv.Itoa(i), []byte{1}, time.Minute)
+	}
+	require.LessOrEqual(t, c.Len(), capacity)
+	// every key is stored in a single shard.
+	c.Set("key", []byte("value"), time.Minute)
+	value, ok := c.Get("key")
+	require.True(t, ok)
+	require.Equal(t, []byte("value"), value)
+}
+
+// BenchmarkLRU_parallel compares concurrent access to cache with single and multiple shards,
+// single shard serializes all goroutines on one mutex.
+func BenchmarkLRU_parallel(b *testing.B) {
+	const keys = 1 << 12
+
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key-" + strconv.Itoa(i)
+	}
+	for _, shards := range []int{1, 16, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			c := New(keys, shards)
+			for _, name := range names {
+				c.Set(name, []byte(name), time.Hour)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// goroutines start at different keys, so they do not walk shards in lockstep.
+				i := rand.IntN(keys) //nolint:gosec // unnecessary
+				for pb.Next() {
+					name := names[i%keys]
+					if i%10 == 0 {
+						c.Set(name, []byte(name), time.Hour)
+					} else {
+						c.Get(name)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
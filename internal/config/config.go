@@ -4,9 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,17 +20,95 @@ import (
 	"github.com/goccy/go-yaml"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	P2CEWMAName = "p2cewma"
 	RRName      = "round-robin"
 	LCName      = "least-connection"
+	LPBName     = "least-pending-bytes"
+	AWName      = "adaptive-weighted"
+)
+
+const (
+	ConcurrencyLimitReject = "reject"
+	ConcurrencyLimitQueue  = "queue"
+)
+
+const (
+	CompressionNever     = "never"
+	CompressionAlways    = "always"
+	CompressionThreshold = "threshold"
+)
+
+const (
+	BatchFailureAny      = "any"
+	BatchFailureMajority = "majority"
+	BatchFailureAll      = "all"
+)
+
+const (
+	AllInCooldownBestEffort = "best_effort"
+	AllInCooldownFailClosed = "fail_closed"
+)
+
+const (
+	UpstreamMethodPost = "POST"
+	UpstreamMethodGet  = "GET"
+
+	DefaultUpstreamContentType = "application/json"
+)
+
+const (
+	WSEmptyMessagesSkip  = "skip"
+	WSEmptyMessagesClose = "close"
+)
+
+const (
+	DeadlineFormatMS      = "ms"      // milliseconds left
+	DeadlineFormatSeconds = "seconds" // seconds left with millisecond precision
+	DeadlineFormatUnixMS  = "unix_ms" // unix time of deadline in milliseconds
+)
+
+const (
+	SamplerAlways = "always"
+	SamplerNever  = "never"
+	SamplerRatio  = "ratio"
+)
+
+// Fields of access log, see Logger.AccessFields.
+const (
+	AccessFieldRequestID     = "request_id"
+	AccessFieldConnID        = "conn_id"
+	AccessFieldRemoteIP      = "remote_ip"
+	AccessFieldStatus        = "status"
+	AccessFieldLatency       = "latency"
+	AccessFieldPath          = "path"
+	AccessFieldClient        = "client"
+	AccessFieldProvider      = "provider"
+	AccessFieldRequestBytes  = "request_bytes"
+	AccessFieldResponseBytes = "response_bytes"
+	AccessFieldMethod        = "method"
+	AccessFieldUserAgent     = "user_agent"
+)
+
+const (
+	QuotaDay   = "day"
+	QuotaMonth = "month"
+)
+
+const (
+	MetricsAuthBasic  = "basic"
+	MetricsAuthBearer = "bearer"
 )
 
 const (
 	defaultServerPort  = 8080
 	defaultMetricsPort = 9090
+	defaultAdminPort   = 9091
+	defaultAdminHost   = "127.0.0.1"
+	maxPort            = 65535
 	defaultMetricsPath = "/metrics"
 	defaultConfigPath  = "/.config/rpcgate/rpcgate.yaml"
 )
@@ -34,59 +118,474 @@ const (
 	ewmaLoadNormalizer = 8
 	ewmaPenaltyDecay   = 0.8
 	ewmaCooldown       = 10 * time.Second
+	ewmaStreakLength   = 100
+)
+
+const (
+	adaptiveWeightedInterval  = 10 * time.Second
+	adaptiveWeightedSmooth    = 0.3
+	adaptiveWeightedMinWeight = 0.1
+)
+
+const (
+	defaultConcurrencyQueueTimeout = time.Second
+	defaultWSReconnectBackoff      = 100 * time.Millisecond
+	defaultCompressionThreshold    = 1024
+	defaultWSBufferSize            = 1024
+
+	defaultProviderTimeoutThreshold    = 3
+	defaultProviderTimeoutFactor       = 2.0
+	defaultProviderTimeoutShrinkFactor = 0.5
+
+	defaultResponseCacheShards = 16
+	defaultResponseCacheTTL    = time.Second
+	defaultTracingServiceName  = "rpcgate"
+	defaultLogMaxBodyBytes     = 4096
+	defaultShutdownTimeout     = 5 * time.Second
+	defaultBlockLagInterval    = 10 * time.Second
+
+	defaultBalancerSnapshotInterval = 30 * time.Second
+	defaultBalancerSnapshotMaxAge   = 5 * time.Minute
 )
 
 type Config struct {
 	GlobalRPCConfig `yaml:",inline"`
 
-	Clients Clients `yaml:"clients"`
-	Logger  Logger  `yaml:"logger"`
-	Metrics Metrics `yaml:"metrics"`
-	RPCs    []RPC   `yaml:"rpcs"`
-	Port    int64   `yaml:"port"`
+	Clients          Clients          `yaml:"clients"`
+	Logger           Logger           `yaml:"logger"`
+	Metrics          Metrics          `yaml:"metrics"`
+	DegradedMode     DegradedMode     `yaml:"degraded_mode"`
+	ConcurrencyLimit ConcurrencyLimit `yaml:"concurrency_limit"`
+	CORS             CORS             `yaml:"cors"`
+	UpstreamHeaders  UpstreamHeaders  `yaml:"upstream_headers"`
+	DNSCache         DNSCache         `yaml:"dns_cache"`
+	ResponseCache    ResponseCache    `yaml:"response_cache"`
+	Tracing          Tracing          `yaml:"tracing"`
+	Websocket        Websocket        `yaml:"websocket"`
+	Debug            Debug            `yaml:"debug"`
+	Healthz          Healthz          `yaml:"healthz"`
+	Admin            Admin            `yaml:"admin"`
+	Deadline         Deadline         `yaml:"deadline"`
+	BalancerSnapshot BalancerSnapshot `yaml:"balancer_snapshot"`
+	TLS              TLS              `yaml:"tls"`
+	RPCs             []RPC            `yaml:"rpcs"`
+	Port             int64            `yaml:"port"`
+	// UpstreamRequestIDHeader is header carrying gateway request id to providers, empty disables it.
+	UpstreamRequestIDHeader string `yaml:"upstream_request_id_header"`
+	// AllowEmptyRPCs lets gateway start without rpcs, config without rpcs is rejected by default.
+	AllowEmptyRPCs bool `yaml:"allow_empty_rpcs"`
+	// MethodRateLimits limit requests of every method across all clients, like expensive debug_* methods.
+	// Requests have to pass both client and method limits.
+	MethodRateLimits map[string]RateLimit `yaml:"method_rate_limits"`
+	// ShutdownTimeout bounds draining of in-flight requests and websocket sessions on shutdown, 5s by default.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// StrictConfig rejects config with unknown keys, true by default. If disabled, unknown keys are logged
+	// as warning, so config written for newer version still can be used.
+	StrictConfig *bool `yaml:"strict_config"`
+}
+
+// TLS configures serving of clients over https, empty CertFile disables it.
+// ClientCAFile is required for mtls type of auth and used to verify client certificates.
+type TLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// HTTP2 serves clients negotiating h2 next to http/1.1 ones on the same port.
+	HTTP2 bool `yaml:"http2"`
+}
+
+// Deadline bounds time of proxied http request including retries, zero Timeout disables it.
+// Time left until deadline is sent to provider in Header, so provider can abort expensive work early.
+type Deadline struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Header  string        `yaml:"header"` // empty disables propagation to provider
+	Format  string        `yaml:"format"` // one of [ms, seconds, unix_ms], ms by default
+}
+
+// BalancerSnapshot configures periodic saving of provider stats learned by p2cewma balancers to file,
+// they are restored on startup, so balancing does not start cold. Empty Path disables it.
+type BalancerSnapshot struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"` // 30s by default
+	// MaxAge bounds age of snapshot whose penalties and cooldowns are restored, 5m by default.
+	// Latencies of providers are restored from snapshot of any age.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Healthz configures health endpoints.
+type Healthz struct {
+	// Detailed enables /healthz/detailed reporting healthy providers of every rpc.
+	Detailed bool `yaml:"detailed"`
+}
+
+// Admin configures server of admin endpoints, like drain of providers. It listens on loopback interface
+// by default, so it is not reachable by clients unless other host is set.
+type Admin struct {
+	Enabled bool        `yaml:"enabled"`
+	Port    int64       `yaml:"port"`
+	Host    string      `yaml:"host"` // 127.0.0.1 by default
+	Auth    MetricsAuth `yaml:"auth"` // the same as metrics auth, empty leaves endpoints open
+}
+
+// Debug enables features intended only for testing in staging environments.
+type Debug struct {
+	// FaultInjection enables provider faults, config with faults is rejected without it.
+	FaultInjection bool `yaml:"fault_injection"`
+	// RethrowPanics crashes process on panic after it is logged and counted instead of answering 500.
+	RethrowPanics bool `yaml:"rethrow_panics"`
+}
+
+// CORS configures Access-Control headers for browser clients, empty AllowedOrigins disables CORS.
+// AllowedOrigins supports '*' wildcard, otherwise matched origin is echoed back.
+type CORS struct {
+	AllowedOrigins []string      `yaml:"allowed_origins"`
+	AllowedHeaders []string      `yaml:"allowed_headers"`
+	MaxAge         time.Duration `yaml:"max_age"`
+}
+
+// UpstreamHeaders limits headers of provider responses forwarded to clients, only one of lists can be set.
+// Hop-by-hop headers are never forwarded, Content-Type and Retry-After are always forwarded.
+type UpstreamHeaders struct {
+	Allowed []string `yaml:"allowed"`
+	Denied  []string `yaml:"denied"`
+}
+
+// Websocket configures buffers of client websocket connections.
+// HandshakeTimeout bounds reading of upgrade request and writing of handshake response, zero disables it.
+type Websocket struct {
+	ReadBufferSize   int           `yaml:"read_buffer_size"`
+	WriteBufferSize  int           `yaml:"write_buffer_size"`
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout"`
+}
+
+// DNSCache configures caching of provider hosts resolution, disabled if both ttls are zero.
+// Failed resolutions are cached for NegativeTTL.
+type DNSCache struct {
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+}
+
+// Tracing configures export of request spans to OTLP/HTTP collector, empty Endpoint disables it.
+// Sampler decides on traces started by gateway, traces continued from client traceparent header
+// follow sampling decision of client.
+type Tracing struct {
+	Endpoint    string  `yaml:"endpoint"`     // collector traces url, like http://collector:4318/v1/traces
+	ServiceName string  `yaml:"service_name"` // rpcgate by default
+	Sampler     string  `yaml:"sampler"`      // one of [always, never, ratio], always by default
+	SampleRatio float64 `yaml:"sample_ratio"` // share of sampled traces for ratio sampler, (0;1]
+}
+
+// ResponseCache configures in-memory cache of successful responses to listed methods, zero Size disables it.
+// Responses are cached per rpc, method and params.
+type ResponseCache struct {
+	Size    int           `yaml:"size"`   // max number of cached responses
+	Shards  int           `yaml:"shards"` // 16 by default, more shards reduce lock contention
+	TTL     time.Duration `yaml:"ttl"`    // 1s by default
+	Methods []string      `yaml:"methods"`
+	// NewHeads drops cached responses depending on latest block when new block arrives.
+	NewHeads ResponseCacheNewHeads `yaml:"new_heads"`
+}
+
+// ResponseCacheNewHeads subscribes to newHeads of websocket providers by rpc name and drops cached responses
+// to listed methods of rpc on every new block, so they are not served stale until ttl expires.
+type ResponseCacheNewHeads struct {
+	URLs    map[string]string `yaml:"urls"`    // websocket url of provider by rpc name
+	Methods []string          `yaml:"methods"` // block dependent methods, must be cached
+}
+
+// ConcurrencyLimit caps concurrent upstream requests.
+// When limit is reached requests are rejected or queued up to QueueTimeout depending on Mode.
+type ConcurrencyLimit struct {
+	MaxConcurrent int64         `yaml:"max_concurrent"`
+	Mode          string        `yaml:"mode"`
+	QueueTimeout  time.Duration `yaml:"queue_timeout"`
+}
+
+// DegradedMode configures reduced set of methods served during partial outages.
+// Enabled sets initial state, mode can be toggled at runtime with SIGUSR1.
+type DegradedMode struct {
+	Enabled        bool     `yaml:"enabled"`
+	AllowedMethods []string `yaml:"allowed_methods"`
 }
 
 type GlobalRPCConfig struct {
 	BalancerType    string        `yaml:"balancer_type"`
 	NoRPCValidation bool          `yaml:"no_rpc_validation"`
 	P2CEWMA         P2CEWMAConfig `yaml:"p2cewma"`
+	// AdaptiveWeighted configures tuning of provider weights of adaptive-weighted balancer.
+	AdaptiveWeighted AdaptiveWeighted `yaml:"adaptive_weighted"`
+	// Cooldown is time provider failed by request is skipped by round-robin and least-connection balancers,
+	// 0 disables it.
+	Cooldown time.Duration `yaml:"cooldown"`
+	// AllInCooldown is behavior of balancer when every provider is in cooldown, see P2CEWMAConfig.AllInCooldown.
+	// It is used by round-robin and least-connection balancers and by p2cewma unless p2cewma sets its own.
+	AllInCooldown string `yaml:"all_in_cooldown"`
 }
 
 type Metrics struct {
 	Enabled bool   `yaml:"enabled"`
 	Port    int64  `yaml:"port"`
 	Path    string `yaml:"path"`
+	// ProviderTagLabels is allowlist of provider tag keys added as labels to provider metrics.
+	ProviderTagLabels []string `yaml:"provider_tag_labels"`
+	// ExactStatusCode labels request metrics with exact status code instead of its family like 2xx.
+	ExactStatusCode bool `yaml:"exact_status_code"`
+	// LatencyBuckets are upper bounds of latency histograms buckets in seconds, defaults are used if empty.
+	LatencyBuckets []float64 `yaml:"latency_buckets"`
+	// BatchMethodLatency observes estimated latency of every method of batch, batch latency divided equally
+	// between its requests.
+	BatchMethodLatency bool `yaml:"batch_method_latency"`
+	// Auth protects metrics endpoint, empty type leaves it open.
+	Auth MetricsAuth `yaml:"auth"`
+	// Labels disables high cardinality labels of provider metrics, like client: false. Labels are kept by default,
+	// only chain_id, balancer, method and client can be disabled.
+	Labels map[string]bool `yaml:"labels"`
+}
+
+// MetricsAuth configures auth of scrapers, type is one of [basic, bearer]. Password of basic auth
+// can be bcrypt hash like passwords of clients.
+type MetricsAuth struct {
+	Type     string `yaml:"type"`
+	Login    string `yaml:"login"`    // only for basic type of auth.
+	Password string `yaml:"password"` // only for basic type of auth.
+	Token    string `yaml:"token"`    // only for bearer type of auth.
 }
 
 type Clients struct {
-	AuthRequired bool     `yaml:"auth_required"` // only for basic type of auth.
-	Type         string   `yaml:"type"`
-	Clients      []Client `yaml:"clients"`
+	AuthRequired bool        `yaml:"auth_required"` // only for basic type of auth.
+	Type         string      `yaml:"type"`
+	Compression  Compression `yaml:"compression"`    // default for all clients
+	RateLimit    RateLimit   `yaml:"rate_limit"`     // default for all clients
+	MaxBatchSize int         `yaml:"max_batch_size"` // default for all clients, 0 is unlimited
+	JWT          JWT         `yaml:"jwt"`            // only for jwt type of auth.
+	APIKeyHeader string      `yaml:"api_key_header"` // only for api_key type of auth, X-API-Key by default.
+	// only for query type of auth, keeps names of unlisted clients instead of collapsing them to _unknown_.
+	AllowUnlisted bool     `yaml:"allow_unlisted"`
+	Clients       []Client `yaml:"clients"`
+}
+
+// JWT configures validation of bearer tokens, HMAC algorithms use Secret,
+// RSA and ECDSA algorithms use keys fetched from JWKSURL.
+type JWT struct {
+	Algorithm   string `yaml:"algorithm"`    // HS256 by default
+	Secret      string `yaml:"secret"`       // HMAC secret
+	JWKSURL     string `yaml:"jwks_url"`     // url of json web key set
+	ClientClaim string `yaml:"client_claim"` // claim used as client name, sub by default
 }
 
 type Client struct {
-	Login    string `yaml:"login"`
-	Password string `yaml:"password"`
+	Login        string      `yaml:"login"`
+	Password     string      `yaml:"password"`
+	APIKey       string      `yaml:"api_key"`
+	Compression  Compression `yaml:"compression"`    // empty mode inherits clients default
+	RateLimit    RateLimit   `yaml:"rate_limit"`     // zero rps inherits clients default
+	MaxBatchSize int         `yaml:"max_batch_size"` // zero inherits clients default
+	Quota        Quota       `yaml:"quota"`
+	// only one of allowed and denied methods can be set, all methods are allowed if both are empty.
+	AllowedMethods []string `yaml:"allowed_methods"`
+	DeniedMethods  []string `yaml:"denied_methods"`
+	AllowedRPCs    []string `yaml:"allowed_rpcs"` // all rpcs are allowed if empty
+}
+
+// Quota configures number of requests client can send per period, zero Requests disables it.
+// Usage is reset at the start of every day or month in UTC and is not persisted across restarts.
+type Quota struct {
+	Requests int64  `yaml:"requests"`
+	Period   string `yaml:"period"` // [day, month], day by default
+}
+
+// RateLimit configures token bucket limiting requests of client, zero RPS disables it.
+type RateLimit struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"` // bucket size, rps rounded up by default
+}
+
+// Compression configures gzip compression of responses for clients accepting it.
+type Compression struct {
+	Mode      string `yaml:"mode"`      // [never, always, threshold]
+	Threshold int    `yaml:"threshold"` // min response size in bytes compressed in threshold mode
 }
 
 type Logger struct {
-	Level   zerolog.Level `yaml:"level"`
-	Format  string        `yaml:"format"`
-	Writer  string        `yaml:"writer"`
-	NoColor bool          `yaml:"no_color"`
+	Level      zerolog.Level `yaml:"level"`
+	Format     string        `yaml:"format"`
+	Writer     string        `yaml:"writer"`
+	NoColor    bool          `yaml:"no_color"`
+	ParamsHash bool          `yaml:"params_hash"` // log hash and size of request params in access log
+	// AccessFields are fields of access log in order of output, fields logged before they were configurable
+	// are used by default.
+	AccessFields []string `yaml:"access_fields"`
+	// LogBodies logs request and response bodies at debug level, params of RedactedMethods are never logged.
+	LogBodies       bool     `yaml:"log_bodies"`
+	RedactedMethods []string `yaml:"redacted_methods"`
+	MaxBodyBytes    int      `yaml:"max_body_bytes"` // longer bodies are truncated, 4096 by default
+	// LogBodiesProviders scopes body logging to requests served by these providers, all providers if empty.
+	LogBodiesProviders []string `yaml:"log_bodies_providers"`
+	// LogBodiesSampleRatio is share of requests whose bodies are logged, (0;1], 1 by default.
+	LogBodiesSampleRatio float64 `yaml:"log_bodies_sample_ratio"`
 }
 
 type RPC struct {
 	GlobalRPCConfig `yaml:",inline"`
 
-	Name      string     `yaml:"name"`
-	ChainID   int64      `yaml:"chain_id"`
-	Providers []Provider `yaml:"providers"`
+	Name             string           `yaml:"name"`
+	ChainID          int64            `yaml:"chain_id"`
+	Providers        []Provider       `yaml:"providers"`
+	WSSubscriptions  WSSubscriptions  `yaml:"ws_subscriptions"`
+	ConcurrencyLimit ConcurrencyLimit `yaml:"concurrency_limit"`
+	Fallback         string           `yaml:"fallback"` // rpc name used when all providers are unhealthy
+	Retry            Retry            `yaml:"retry"`
+	WSMultiplexing   bool             `yaml:"ws_multiplexing"` // share upstream websocket connections between clients
+	WSReconnect      WSReconnect      `yaml:"ws_reconnect"`
+	WSKeepalive      WSKeepalive      `yaml:"ws_keepalive"`
+	// WSMaxMessageBytes limits size of websocket messages from client and provider, 0 means no limit.
+	WSMaxMessageBytes int64 `yaml:"ws_max_message_bytes"`
+	// ValidateChainID ejects provider whose eth_chainId response differs from ChainID.
+	ValidateChainID bool `yaml:"validate_chain_id"`
+	// MaxBlockLag marks providers more blocks behind the highest block among providers as unhealthy
+	// until they catch up, 0 disables polling of eth_blockNumber.
+	MaxBlockLag int64 `yaml:"max_block_lag"`
+	// BlockLagInterval is period of eth_blockNumber polling, 10s by default.
+	BlockLagInterval time.Duration `yaml:"block_lag_interval"`
+	// BatchFailurePolicy is share of failed responses making batch count as provider failure,
+	// one of [any, majority, all], any by default.
+	BatchFailurePolicy string `yaml:"batch_failure_policy"`
+	// MethodAliases translates method names sent by clients to canonical names sent to providers.
+	MethodAliases map[string]string `yaml:"method_aliases"`
+	// WSEmptyMessages is handling of websocket messages without payload, one of [skip, close], skip by default.
+	WSEmptyMessages string `yaml:"ws_empty_messages"`
+	// WSSubscriptionAffinity are provider tags required to serve eth_subscribe subscription type,
+	// like tier: high for logs. Subscription is sent to matching provider within the same session.
+	WSSubscriptionAffinity map[string]map[string]string `yaml:"ws_subscription_affinity"`
+	// WSForwardHeaders are headers of client websocket handshake forwarded to provider, like Origin.
+	// Provider headers take precedence over forwarded ones.
+	WSForwardHeaders []string `yaml:"ws_forward_headers"`
+	// ForwardClientIP sends client ip to providers in X-Forwarded-For and X-Real-IP headers.
+	ForwardClientIP ForwardClientIP `yaml:"forward_client_ip"`
+	// UpstreamRequest is http method and content type of requests sent to providers.
+	UpstreamRequest UpstreamRequest `yaml:"upstream_request"`
+	// HTTPClient is client of http requests to providers, rpcs without it share default client.
+	HTTPClient HTTPClient `yaml:"http_client"`
+	// MethodTimeouts bound requests to providers by method, like long eth_getLogs and short eth_blockNumber.
+	// They replace provider and http client timeouts, batch is bounded by the longest timeout of its methods.
+	MethodTimeouts map[string]time.Duration `yaml:"method_timeouts"`
+	// UserErrorCodes are json-rpc error codes caused by user call, like revert, which do not count as provider
+	// failure. Default codes are replaced if set.
+	UserErrorCodes []int64 `yaml:"user_error_codes"`
+	// NonUserErrorPatterns are case-insensitive substrings of error messages which count as provider failure
+	// even if error code is user one. Default patterns are replaced if set.
+	NonUserErrorPatterns []string `yaml:"non_user_error_patterns"`
+	// TreatServerErrorsAsUser makes every -32000 error user one, like on nodes reporting most call failures
+	// with it. Messages matching non-user patterns still count as provider failure.
+	TreatServerErrorsAsUser bool `yaml:"treat_server_errors_as_user"`
+	// ProviderDefaults are merged into every provider of rpc which does not set them.
+	ProviderDefaults ProviderDefaults `yaml:"provider_defaults"`
+	// SLOTargetSeconds is latency of requests counted within slo by request_slo_total, 0 disables it.
+	SLOTargetSeconds float64 `yaml:"slo_target_seconds"`
+	// Port is own port of rpc, every request on it is routed to rpc regardless of path, 0 disables it.
+	// Rpc is still served by its path on server port.
+	Port int64 `yaml:"port"`
+}
+
+// HTTPClient configures own client of http requests to providers of rpc, zero values keep defaults.
+// Timeout bounds requests to providers without their own timeout.
+type HTTPClient struct {
+	Timeout             time.Duration `yaml:"timeout"`                // 0 means no timeout
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`     // 512 by default
+	MaxIdleConnDuration time.Duration `yaml:"max_idle_conn_duration"` // 10s by default
+	// StreamResponseBytes is size of provider responses above which they are streamed to client
+	// without buffering, 0 means responses are always buffered.
+	StreamResponseBytes int `yaml:"stream_response_bytes"`
+}
+
+// UpstreamRequest configures http requests sent to providers. Method is one of [POST, GET], POST by default.
+// GET request carries json-rpc request in query string and has no body, so it has no ContentType.
+// ContentType of POST request is application/json by default.
+type UpstreamRequest struct {
+	Method      string `yaml:"method"`
+	ContentType string `yaml:"content_type"`
+}
+
+// ForwardClientIP configures X-Forwarded-For and X-Real-IP headers of http requests to providers.
+// X-Forwarded-For sent by client is kept only if client is one of TrustedProxies, given as ips or cidrs,
+// otherwise it is replaced with client ip.
+type ForwardClientIP struct {
+	Enabled        bool     `yaml:"enabled"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// Retry configures retries of failed requests on another provider.
+// Only retry-safe methods are retried: read-only methods known to rpcgate and SafeMethods.
+// UnsafeMethods override defaults.
+type Retry struct {
+	Attempts      int      `yaml:"attempts"` // additional attempts, 0 disables retries
+	SafeMethods   []string `yaml:"safe_methods"`
+	UnsafeMethods []string `yaml:"unsafe_methods"`
+}
+
+// WSReconnect configures reconnect to upstream when websocket connection drops.
+// Active subscriptions of client are replayed on new connection.
+type WSReconnect struct {
+	Attempts int           `yaml:"attempts"` // 0 disables reconnect
+	Backoff  time.Duration `yaml:"backoff"`  // delay before first attempt, doubled on every next one
+}
+
+// WSKeepalive configures websocket ping frames sent to client and provider,
+// connection is closed if pong is not received within Timeout.
+type WSKeepalive struct {
+	Interval time.Duration `yaml:"interval"` // 0 disables keepalive
+	Timeout  time.Duration `yaml:"timeout"`  // equals to Interval by default
+}
+
+// WSSubscriptions restricts eth_subscribe subscription types available over websocket.
+// Only one of Allowed or Denied can be set, empty means all subscriptions are allowed.
+type WSSubscriptions struct {
+	Allowed []string `yaml:"allowed"`
+	Denied  []string `yaml:"denied"`
 }
 
 type Provider struct {
-	Name    string `yaml:"name"`
-	ConnURL string `yaml:"conn_url"`
+	Name    string            `yaml:"name"`
+	ConnURL string            `yaml:"conn_url"`
+	Tags    map[string]string `yaml:"tags"`  // arbitrary attributes like region, tier or vendor
+	Fault   Fault             `yaml:"fault"` // only with debug.fault_injection enabled
+	Timeout ProviderTimeout   `yaml:"timeout"`
+	// MaxWSConnections limits websocket sessions proxied to provider at once, 0 means no limit.
+	MaxWSConnections int64 `yaml:"max_ws_connections"`
+	// MaxBatchSize is largest batch accepted by provider, larger batches are split to fit it, 0 means no limit.
+	MaxBatchSize int `yaml:"max_batch_size"`
+	// Weight is relative capacity of provider used by least-connection balancer, 1 by default.
+	Weight float64 `yaml:"weight"`
+	// Headers are sent to provider with http requests and websocket handshakes, like api key or Origin.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// ProviderDefaults are fields shared by providers of rpc. Provider tags and headers are merged with default
+// ones by key, weight and timeout are taken as a whole if provider does not set them.
+type ProviderDefaults struct {
+	Tags    map[string]string `yaml:"tags"`
+	Timeout ProviderTimeout   `yaml:"timeout"`
+	Weight  float64           `yaml:"weight"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// ProviderTimeout bounds http requests to provider, zero Initial disables it.
+// Timeout starts at Initial and after Threshold consecutive timed out requests is multiplied by Factor
+// until it reaches Limit. Limit above Initial gives slow provider more time, limit below Initial makes
+// requests to unresponsive provider fail fast. Every request answered in time moves timeout
+// one step back to Initial.
+type ProviderTimeout struct {
+	Initial   time.Duration `yaml:"initial"`
+	Limit     time.Duration `yaml:"limit"`     // equals to Initial by default, so timeout is fixed
+	Factor    float64       `yaml:"factor"`    // 2 by default, 0.5 if Limit is below Initial
+	Threshold int           `yaml:"threshold"` // 3 by default
+}
+
+// Fault is artificial latency and error rate injected into requests to provider,
+// used to verify failover and cooldown behavior without a real bad provider.
+type Fault struct {
+	Latency   time.Duration `yaml:"latency"`
+	ErrorRate float64       `yaml:"error_rate"` // share of requests failed with bad gateway, [0, 1]
 }
 
 type P2CEWMAConfig struct {
@@ -94,6 +593,21 @@ type P2CEWMAConfig struct {
 	LoadNormalizer  float64       `yaml:"load_normalizer"`
 	PenaltyDecay    float64       `yaml:"penalty_decay"`
 	CooldownTimeout time.Duration `yaml:"cooldown_timeout"`
+	// StreakBonus is max share of score taken off provider with long success streak, [0;1), 0 disables it.
+	StreakBonus float64 `yaml:"streak_bonus"`
+	// StreakLength is number of consecutive successes giving full bonus, 100 by default.
+	StreakLength int `yaml:"streak_length"`
+	// AllInCooldown is behavior when every provider is in cooldown: 'best_effort' borrows provider closest
+	// to recovery, 'fail_closed' borrows none, so request is routed to fallback rpc. 'best_effort' by default.
+	// Rpc with lone provider can fail closed only with fallback.
+	AllInCooldown string `yaml:"all_in_cooldown"`
+}
+
+// AdaptiveWeighted configures how often and how fast weights of providers follow their observed capacity.
+type AdaptiveWeighted struct {
+	Interval  time.Duration `yaml:"interval"`   // period of weights adjustment, 10s by default
+	Smooth    float64       `yaml:"smooth"`     // share of weight moved toward capacity, (0;1], 0.3 by default
+	MinWeight float64       `yaml:"min_weight"` // lowest weight of provider, mean weight is 1, 0.1 by default
 }
 
 func ParseConfig(path string) (Config, error) {
@@ -114,15 +628,27 @@ func ParseConfig(path string) (Config, error) {
 	if err != nil {
 		return Config{}, fmt.Errorf("can not unmarshal yaml config file: %w", err)
 	}
+	// keys are checked once strict_config is known, typo in key silently leaves option unset otherwise.
+	if err = yaml.UnmarshalWithOptions(yml, &Config{}, yaml.DisallowUnknownField()); err != nil {
+		if cfg.StrictConfig == nil || *cfg.StrictConfig {
+			return Config{}, fmt.Errorf("yaml config file has unknown key, set strict_config: false to ignore: %w", err)
+		}
+		log.Warn().Err(err).Msg("yaml config file has unknown key, it is ignored")
+	}
 
 	cfg.Port = getPort(cfg.Port, defaultServerPort)
 	cfg.Metrics.Port = getPort(cfg.Metrics.Port, defaultMetricsPort)
+	cfg.Admin.Port = getPort(cfg.Admin.Port, defaultAdminPort)
 	if cfg.Metrics.Path != "" {
 		cfg.Metrics.Path = "/" + strings.TrimPrefix(cfg.Metrics.Path, "/")
 	} else {
 		cfg.Metrics.Path = defaultMetricsPath
 	}
 
+	if len(cfg.DegradedMode.AllowedMethods) == 0 {
+		cfg.DegradedMode.AllowedMethods = defaultDegradedAllowedMethods()
+	}
+
 	err = validateConfig(&cfg)
 	if err != nil {
 		return Config{}, fmt.Errorf("can not validate config file: %w", err)
@@ -131,6 +657,32 @@ func ParseConfig(path string) (Config, error) {
 	return cfg, nil
 }
 
+// defaultDegradedAllowedMethods returns read-only methods served in degraded mode by default.
+func defaultDegradedAllowedMethods() []string {
+	return []string{
+		"eth_blockNumber",
+		"eth_chainId",
+		"eth_call",
+		"eth_estimateGas",
+		"eth_gasPrice",
+		"eth_maxPriorityFeePerGas",
+		"eth_feeHistory",
+		"eth_getBalance",
+		"eth_getCode",
+		"eth_getStorageAt",
+		"eth_getTransactionCount",
+		"eth_getBlockByNumber",
+		"eth_getBlockByHash",
+		"eth_getTransactionByHash",
+		"eth_getTransactionReceipt",
+		"eth_getLogs",
+		"eth_subscribe",
+		"eth_unsubscribe",
+		"net_version",
+		"web3_clientVersion",
+	}
+}
+
 func getPort(port, defaultPort int64) int64 {
 	if port == 0 {
 		return defaultPort
@@ -143,37 +695,336 @@ func validateConfig(cfg *Config) error {
 	if err := validateGlobalRPCConfig(&cfg.GlobalRPCConfig); err != nil {
 		return fmt.Errorf("global rpc config is invalid: %w", err)
 	}
-	if err := validateLogger(cfg.Logger); err != nil {
+	if err := validateLogger(&cfg.Logger); err != nil {
 		return fmt.Errorf("logger config is invalid: %w", err)
 	}
-	if err := validateClients(cfg.Clients); err != nil {
+	if err := validateClients(&cfg.Clients); err != nil {
 		return fmt.Errorf("clients config is invalid: %w", err)
 	}
+	if err := validateTLS(cfg.TLS, cfg.Clients.Type); err != nil {
+		return fmt.Errorf("tls config is invalid: %w", err)
+	}
+	if err := validateConcurrencyLimit(&cfg.ConcurrencyLimit); err != nil {
+		return fmt.Errorf("concurrency_limit config is invalid: %w", err)
+	}
+	if err := validateResponseCache(&cfg.ResponseCache); err != nil {
+		return fmt.Errorf("response_cache config is invalid: %w", err)
+	}
+	if err := validateTracing(&cfg.Tracing); err != nil {
+		return fmt.Errorf("tracing config is invalid: %w", err)
+	}
+	if err := validateDeadline(&cfg.Deadline); err != nil {
+		return fmt.Errorf("deadline config is invalid: %w", err)
+	}
+	if err := validateBalancerSnapshot(&cfg.BalancerSnapshot); err != nil {
+		return fmt.Errorf("balancer_snapshot config is invalid: %w", err)
+	}
+	for method, limit := range cfg.MethodRateLimits {
+		if err := validateRateLimit(&limit); err != nil {
+			return fmt.Errorf("method_rate_limits[%s] is invalid: %w", method, err)
+		}
+		cfg.MethodRateLimits[method] = limit
+	}
+	if cfg.DNSCache.TTL < 0 || cfg.DNSCache.NegativeTTL < 0 {
+		return errors.New("dns_cache ttls must be >= 0")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout incorrect, must be >= 0, got: %s", cfg.ShutdownTimeout)
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if err := validateProviderTagLabels(cfg.Metrics.ProviderTagLabels); err != nil {
+		return fmt.Errorf("metrics.provider_tag_labels is invalid: %w", err)
+	}
+	if err := validateLatencyBuckets(cfg.Metrics.LatencyBuckets); err != nil {
+		return fmt.Errorf("metrics.latency_buckets is invalid: %w", err)
+	}
+	if err := validateMetricLabels(cfg.Metrics.Labels); err != nil {
+		return fmt.Errorf("metrics.labels is invalid: %w", err)
+	}
+	if err := validateMetricsAuth(cfg.Metrics.Auth); err != nil {
+		return fmt.Errorf("metrics.auth is invalid: %w", err)
+	}
+	if err := validateAdmin(&cfg.Admin); err != nil {
+		return fmt.Errorf("admin config is invalid: %w", err)
+	}
+	if err := validateWebsocket(&cfg.Websocket); err != nil {
+		return fmt.Errorf("websocket config is invalid: %w", err)
+	}
+	if cfg.CORS.MaxAge < 0 {
+		return fmt.Errorf("cors.max_age incorrect, must be >= 0, got: %s", cfg.CORS.MaxAge)
+	}
+	if err := validateUpstreamHeaders(cfg.UpstreamHeaders); err != nil {
+		return fmt.Errorf("upstream_headers is invalid: %w", err)
+	}
 	if err := validateRPCs(cfg); err != nil {
 		return fmt.Errorf("rpc config is invalid: %w", err)
 	}
+	if err := validatePorts(*cfg); err != nil {
+		return fmt.Errorf("ports are invalid: %w", err)
+	}
+	if err := validateFallbacks(cfg.RPCs); err != nil {
+		return fmt.Errorf("rpc config is invalid: %w", err)
+	}
+	if err := validateFailClosed(cfg.RPCs); err != nil {
+		return fmt.Errorf("rpc config is invalid: %w", err)
+	}
+	if err := validateAllowedRPCs(cfg.Clients.Clients, cfg.RPCs); err != nil {
+		return fmt.Errorf("clients config is invalid: %w", err)
+	}
+	if err := validateFaults(cfg); err != nil {
+		return fmt.Errorf("rpc config is invalid: %w", err)
+	}
+	if err := validateResponseCacheNewHeads(cfg.ResponseCache, cfg.RPCs); err != nil {
+		return fmt.Errorf("response_cache config is invalid: %w", err)
+	}
+	return nil
+}
+
+// validateResponseCacheNewHeads checks that newHeads are followed for configured rpcs over websocket
+// and invalidate only cached methods.
+func validateResponseCacheNewHeads(cfg ResponseCache, rpcs []RPC) error {
+	heads := cfg.NewHeads
+	if len(heads.URLs) == 0 {
+		if len(heads.Methods) > 0 {
+			return errors.New("new_heads.urls are required")
+		}
+		return nil
+	}
+	if cfg.Size == 0 {
+		return errors.New("new_heads requires size > 0")
+	}
+	if len(heads.Methods) == 0 {
+		return errors.New("new_heads.methods are required")
+	}
+	names := make(map[string]struct{}, len(rpcs))
+	for _, rpc := range rpcs {
+		names[rpc.Name] = struct{}{}
+	}
+	for name, url := range heads.URLs {
+		if _, exist := names[name]; !exist {
+			return fmt.Errorf("new_heads.urls: rpc '%s' is not configured", name)
+		}
+		if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+			return fmt.Errorf("new_heads.urls[%s] incorrect, must be ws:// or wss:// url, got: %s", name, url)
+		}
+	}
+	for _, method := range heads.Methods {
+		if !slices.Contains(cfg.Methods, method) {
+			return fmt.Errorf("new_heads.methods: method '%s' is not cached", method)
+		}
+	}
+	return nil
+}
+
+// validateFaults checks that provider faults are configured only with fault injection enabled.
+func validateFaults(cfg *Config) error {
+	for _, rpc := range cfg.RPCs {
+		for _, provider := range rpc.Providers {
+			if provider.Fault == (Fault{}) {
+				continue
+			}
+			if !cfg.Debug.FaultInjection {
+				return fmt.Errorf("rpc[%s].providers[%s].fault requires debug.fault_injection enabled",
+					rpc.Name, provider.Name)
+			}
+			if provider.Fault.Latency < 0 {
+				return fmt.Errorf("rpc[%s].providers[%s].fault.latency incorrect, must be >= 0, got: %s",
+					rpc.Name, provider.Name, provider.Fault.Latency)
+			}
+			if provider.Fault.ErrorRate < 0 || provider.Fault.ErrorRate > 1 {
+				return fmt.Errorf("rpc[%s].providers[%s].fault.error_rate incorrect, must be in [0, 1], got: %f",
+					rpc.Name, provider.Name, provider.Fault.ErrorRate)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAllowedRPCs checks that rpcs allowed for clients are configured.
+func validateAllowedRPCs(clients []Client, rpcs []RPC) error {
+	names := make(map[string]struct{}, len(rpcs))
+	for _, rpc := range rpcs {
+		names[rpc.Name] = struct{}{}
+	}
+	for _, client := range clients {
+		for _, name := range client.AllowedRPCs {
+			if _, exist := names[name]; !exist {
+				return fmt.Errorf("clients[%s].allowed_rpcs: rpc '%s' is not configured", client.Login, name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateFallbacks checks that fallback rpcs exist and do not form a loop.
+func validateFallbacks(rpcs []RPC) error {
+	nameToFallback := make(map[string]string, len(rpcs))
+	for _, rpc := range rpcs {
+		nameToFallback[rpc.Name] = rpc.Fallback
+	}
+	for _, rpc := range rpcs {
+		if rpc.Fallback == "" {
+			continue
+		}
+		if _, exist := nameToFallback[rpc.Fallback]; !exist {
+			return fmt.Errorf("rpc[%s].fallback '%s' is not configured", rpc.Name, rpc.Fallback)
+		}
+		visited := map[string]struct{}{rpc.Name: {}}
+		for next := rpc.Fallback; next != ""; next = nameToFallback[next] {
+			if _, exist := visited[next]; exist {
+				return fmt.Errorf("rpc[%s].fallback forms a loop through '%s'", rpc.Name, next)
+			}
+			visited[next] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// validateFailClosed checks that rpc with lone provider fails closed only to fallback rpc. Otherwise every failure
+// of the provider would leave rpc without any, while lone provider is kept by best effort balancer.
+func validateFailClosed(rpcs []RPC) error {
+	for _, rpc := range rpcs {
+		var allInCooldown string
+		switch rpc.BalancerType {
+		case P2CEWMAName:
+			allInCooldown = rpc.P2CEWMA.AllInCooldown
+		case RRName, LCName:
+			allInCooldown = rpc.AllInCooldown
+		}
+		if allInCooldown == AllInCooldownFailClosed && len(rpc.Providers) == 1 && rpc.Fallback == "" {
+			return fmt.Errorf("rpc[%s] with single provider can fail closed only with fallback", rpc.Name)
+		}
+	}
 	return nil
 }
 
 func validateRPCs(cfg *Config) error {
+	if len(cfg.RPCs) == 0 && !cfg.AllowEmptyRPCs {
+		return errors.New("no rpcs configured, set allow_empty_rpcs to start without them")
+	}
 	var emptyGlobalRPCCfg GlobalRPCConfig
 	names := make(map[string]struct{})
 	for i, rpc := range cfg.RPCs {
 		if len(rpc.Providers) == 0 {
-			return fmt.Errorf("rpc[%s].name is not unique", rpc.Name)
+			return fmt.Errorf("rpc[%s] has no providers", rpc.Name)
 		}
 		_, exist := names[rpc.Name]
 		if exist {
 			return fmt.Errorf("rpc[%s].name is not unique", rpc.Name)
 		}
+		names[rpc.Name] = struct{}{}
+		mergeProviderDefaults(&cfg.RPCs[i])
 		if err := validateProviderConnURL(rpc); err != nil {
 			return fmt.Errorf("rpc[%s] config is invalid: %w", rpc.Name, err)
 		}
+		if len(rpc.WSSubscriptions.Allowed) > 0 && len(rpc.WSSubscriptions.Denied) > 0 {
+			return fmt.Errorf("rpc[%s].ws_subscriptions must have only one of 'allowed' or 'denied'", rpc.Name)
+		}
+		if err := validateConcurrencyLimit(&cfg.RPCs[i].ConcurrencyLimit); err != nil {
+			return fmt.Errorf("rpc[%s].concurrency_limit is invalid: %w", rpc.Name, err)
+		}
+		if rpc.Retry.Attempts < 0 {
+			return fmt.Errorf("rpc[%s].retry.attempts incorrect, must be >= 0, got: %d", rpc.Name, rpc.Retry.Attempts)
+		}
+		if err := validateWSReconnect(&cfg.RPCs[i].WSReconnect); err != nil {
+			return fmt.Errorf("rpc[%s].ws_reconnect is invalid: %w", rpc.Name, err)
+		}
+		if err := validateWSSubscriptionAffinity(rpc); err != nil {
+			return fmt.Errorf("rpc[%s].ws_subscription_affinity is invalid: %w", rpc.Name, err)
+		}
+		if err := validateWSKeepalive(&cfg.RPCs[i].WSKeepalive); err != nil {
+			return fmt.Errorf("rpc[%s].ws_keepalive is invalid: %w", rpc.Name, err)
+		}
+		if err := validateForwardClientIP(rpc.ForwardClientIP); err != nil {
+			return fmt.Errorf("rpc[%s].forward_client_ip is invalid: %w", rpc.Name, err)
+		}
+		if err := validateUpstreamRequest(&cfg.RPCs[i].UpstreamRequest); err != nil {
+			return fmt.Errorf("rpc[%s].upstream_request is invalid: %w", rpc.Name, err)
+		}
+		if err := validateHTTPClient(rpc.HTTPClient); err != nil {
+			return fmt.Errorf("rpc[%s].http_client is invalid: %w", rpc.Name, err)
+		}
+		for method, timeout := range rpc.MethodTimeouts {
+			if timeout <= 0 {
+				return fmt.Errorf("rpc[%s].method_timeouts[%s] incorrect, must be > 0, got: %s", rpc.Name, method, timeout)
+			}
+		}
+		if slices.Contains(rpc.NonUserErrorPatterns, "") {
+			return fmt.Errorf("rpc[%s].non_user_error_patterns must not contain empty pattern", rpc.Name)
+		}
+		if rpc.SLOTargetSeconds < 0 {
+			return fmt.Errorf("rpc[%s].slo_target_seconds incorrect, must be >= 0, got: %f", rpc.Name, rpc.SLOTargetSeconds)
+		}
+		if rpc.MaxBlockLag < 0 {
+			return fmt.Errorf("rpc[%s].max_block_lag incorrect, must be >= 0, got: %d", rpc.Name, rpc.MaxBlockLag)
+		}
+		if rpc.BlockLagInterval < 0 {
+			return fmt.Errorf("rpc[%s].block_lag_interval incorrect, must be >= 0, got: %s", rpc.Name, rpc.BlockLagInterval)
+		}
+		if rpc.MaxBlockLag > 0 && rpc.BlockLagInterval == 0 {
+			cfg.RPCs[i].BlockLagInterval = defaultBlockLagInterval
+		}
+		for j, provider := range rpc.Providers {
+			if err := validateProviderTimeout(&cfg.RPCs[i].Providers[j].Timeout); err != nil {
+				return fmt.Errorf("rpc[%s].providers[%s].timeout is invalid: %w", rpc.Name, provider.Name, err)
+			}
+			if provider.MaxWSConnections < 0 {
+				return fmt.Errorf("rpc[%s].providers[%s].max_ws_connections incorrect, must be >= 0, got: %d",
+					rpc.Name, provider.Name, provider.MaxWSConnections)
+			}
+			if provider.MaxBatchSize < 0 {
+				return fmt.Errorf("rpc[%s].providers[%s].max_batch_size incorrect, must be >= 0, got: %d",
+					rpc.Name, provider.Name, provider.MaxBatchSize)
+			}
+			if provider.Weight < 0 {
+				return fmt.Errorf("rpc[%s].providers[%s].weight incorrect, must be > 0, got: %f",
+					rpc.Name, provider.Name, provider.Weight)
+			}
+			if provider.Weight == 0 {
+				cfg.RPCs[i].Providers[j].Weight = 1
+			}
+			if err := validateHeaderNames(slices.Collect(maps.Keys(provider.Headers))); err != nil {
+				return fmt.Errorf("rpc[%s].providers[%s].headers is invalid: %w", rpc.Name, provider.Name, err)
+			}
+		}
+		if err := validateHeaderNames(rpc.WSForwardHeaders); err != nil {
+			return fmt.Errorf("rpc[%s].ws_forward_headers is invalid: %w", rpc.Name, err)
+		}
+		if len(rpc.WSForwardHeaders) > 0 && rpc.WSMultiplexing {
+			return fmt.Errorf("rpc[%s].ws_forward_headers can not be used with ws_multiplexing", rpc.Name)
+		}
+		switch rpc.BatchFailurePolicy {
+		case "":
+			cfg.RPCs[i].BatchFailurePolicy = BatchFailureAny
+		case BatchFailureAny, BatchFailureMajority, BatchFailureAll:
+		default:
+			return fmt.Errorf("rpc[%s].batch_failure_policy incorrect, must be one of 'any', 'majority', 'all' or empty",
+				rpc.Name)
+		}
+		switch rpc.WSEmptyMessages {
+		case "":
+			cfg.RPCs[i].WSEmptyMessages = WSEmptyMessagesSkip
+		case WSEmptyMessagesSkip, WSEmptyMessagesClose:
+		default:
+			return fmt.Errorf("rpc[%s].ws_empty_messages incorrect, must be one of 'skip', 'close' or empty", rpc.Name)
+		}
+		for alias, method := range rpc.MethodAliases {
+			if alias == "" || method == "" || alias == method {
+				return fmt.Errorf("rpc[%s].method_aliases[%s] incorrect, alias and method must be different non-empty names",
+					rpc.Name, alias)
+			}
+		}
+		if rpc.WSMaxMessageBytes < 0 {
+			return fmt.Errorf("rpc[%s].ws_max_message_bytes incorrect, must be >= 0, got: %d",
+				rpc.Name, rpc.WSMaxMessageBytes)
+		}
 		if rpc.GlobalRPCConfig == emptyGlobalRPCCfg {
 			cfg.RPCs[i].GlobalRPCConfig = cfg.GlobalRPCConfig
 			continue
 		}
-		if err := validateGlobalRPCConfig(&rpc.GlobalRPCConfig); err != nil {
+		if err := validateGlobalRPCConfig(&cfg.RPCs[i].GlobalRPCConfig); err != nil {
 			return fmt.Errorf("rpc[%s] config is invalid: %w", rpc.Name, err)
 		}
 		if !rpc.NoRPCValidation {
@@ -185,9 +1036,62 @@ func validateRPCs(cfg *Config) error {
 	return nil
 }
 
+func validateProviderTimeout(cfg *ProviderTimeout) error {
+	if cfg.Initial < 0 {
+		return fmt.Errorf("initial incorrect, must be >= 0, got: %s", cfg.Initial)
+	}
+	if cfg.Initial == 0 {
+		if *cfg != (ProviderTimeout{}) {
+			return errors.New("initial is required")
+		}
+		return nil
+	}
+	if cfg.Limit < 0 {
+		return fmt.Errorf("limit incorrect, must be >= 0, got: %s", cfg.Limit)
+	}
+	if cfg.Threshold < 0 {
+		return fmt.Errorf("threshold incorrect, must be >= 0, got: %d", cfg.Threshold)
+	}
+	if cfg.Limit == 0 {
+		cfg.Limit = cfg.Initial
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = defaultProviderTimeoutThreshold
+	}
+	switch {
+	case cfg.Factor == 0 && cfg.Limit < cfg.Initial:
+		cfg.Factor = defaultProviderTimeoutShrinkFactor
+	case cfg.Factor == 0:
+		cfg.Factor = defaultProviderTimeoutFactor
+	case cfg.Limit > cfg.Initial && cfg.Factor <= 1:
+		return fmt.Errorf("factor incorrect, must be > 1 with limit above initial, got: %f", cfg.Factor)
+	case cfg.Limit < cfg.Initial && (cfg.Factor <= 0 || cfg.Factor >= 1):
+		return fmt.Errorf("factor incorrect, must be in (0, 1) with limit below initial, got: %f", cfg.Factor)
+	case cfg.Factor <= 0:
+		return fmt.Errorf("factor incorrect, must be > 0, got: %f", cfg.Factor)
+	}
+	return nil
+}
+
+// validateProviderConnURL checks providers of rpc have non-empty unique names, which are used as metric labels,
+// and unique urls of the same kind, so copy-pasted provider does not double weight of upstream.
 func validateProviderConnURL(rpc RPC) error {
 	var http, ws int
-	for _, provider := range rpc.Providers {
+	names := make(map[string]struct{}, len(rpc.Providers))
+	urls := make(map[string]struct{}, len(rpc.Providers))
+	for i, provider := range rpc.Providers {
+		if provider.Name == "" {
+			// conn_url is not reported, as it can contain api key.
+			return fmt.Errorf("rpc[%s].provider[%d].name is empty", rpc.Name, i)
+		}
+		if _, exist := names[provider.Name]; exist {
+			return fmt.Errorf("rpc[%s].provider[%s].name is not unique", rpc.Name, provider.Name)
+		}
+		names[provider.Name] = struct{}{}
+		if _, exist := urls[provider.ConnURL]; exist {
+			return fmt.Errorf("rpc[%s].provider[%s].conn_url is not unique", rpc.Name, provider.Name)
+		}
+		urls[provider.ConnURL] = struct{}{}
 		parsedURL, err := url.Parse(provider.ConnURL)
 		if err != nil {
 			return fmt.Errorf("rpc[%s].provider[%s].conn_url invalid", rpc.Name, provider.Name)
@@ -196,7 +1100,7 @@ func validateProviderConnURL(rpc RPC) error {
 		case "http", "https":
 			http++
 		case "ws", "wss":
-			if rpc.BalancerType == "" || rpc.BalancerType == P2CEWMAName {
+			if rpc.BalancerType != RRName && rpc.BalancerType != LCName {
 				return fmt.Errorf("rpc[%s].balancer_type is unsupported for websocket", rpc.Name)
 			}
 			ws++
@@ -215,25 +1119,59 @@ func validateProviderConnURL(rpc RPC) error {
 	return fmt.Errorf("rpc[%s] has both http and websocket connections", rpc.Name)
 }
 
+// validateAllInCooldown checks behavior of balancer when every provider is in cooldown, 'best_effort' by default.
+func validateAllInCooldown(allInCooldown *string) error {
+	switch *allInCooldown {
+	case "":
+		*allInCooldown = AllInCooldownBestEffort
+	case AllInCooldownBestEffort, AllInCooldownFailClosed:
+	default:
+		return fmt.Errorf("incorrect, must be one of 'best_effort', 'fail_closed' or empty, got: %s", *allInCooldown)
+	}
+	return nil
+}
+
 func validateGlobalRPCConfig(cfg *GlobalRPCConfig) error {
 	switch cfg.BalancerType {
 	case "", P2CEWMAName:
 		cfg.BalancerType = P2CEWMAName
 	case RRName, LCName:
+		if cfg.Cooldown < 0 {
+			return fmt.Errorf("cooldown incorrect, must be >= 0, got: %s", cfg.Cooldown)
+		}
+		if err := validateAllInCooldown(&cfg.AllInCooldown); err != nil {
+			return fmt.Errorf("all_in_cooldown %w", err)
+		}
 		return nil
+	case LPBName:
+		return nil
+	case AWName:
+		return validateAdaptiveWeighted(&cfg.AdaptiveWeighted)
 	default:
 		return errors.New(
-			"balancer_type incorrect, must be one of 'round-robin', 'p2cewma', 'least-connection' or empty",
+			"balancer_type incorrect, must be one of " +
+				"'round-robin', 'p2cewma', 'least-connection', 'least-pending-bytes', 'adaptive-weighted' or empty",
 		)
 	}
 
-	isEmpty := cfg.P2CEWMA == P2CEWMAConfig{}
+	if err := validateAllInCooldown(&cfg.AllInCooldown); err != nil {
+		return fmt.Errorf("all_in_cooldown %w", err)
+	}
+	if cfg.P2CEWMA.AllInCooldown == "" {
+		cfg.P2CEWMA.AllInCooldown = cfg.AllInCooldown
+	}
+	if err := validateAllInCooldown(&cfg.P2CEWMA.AllInCooldown); err != nil {
+		return fmt.Errorf("p2cewma.all_in_cooldown %w", err)
+	}
+
+	isEmpty := cfg.P2CEWMA == P2CEWMAConfig{AllInCooldown: cfg.P2CEWMA.AllInCooldown}
 	if isEmpty {
 		cfg.P2CEWMA = P2CEWMAConfig{
 			Smooth:          ewmaSmooth,
 			LoadNormalizer:  ewmaLoadNormalizer,
 			PenaltyDecay:    ewmaPenaltyDecay,
 			CooldownTimeout: ewmaCooldown,
+			AllInCooldown:   cfg.P2CEWMA.AllInCooldown,
 		}
 		return nil
 	}
@@ -247,35 +1185,671 @@ func validateGlobalRPCConfig(cfg *GlobalRPCConfig) error {
 	if cfg.P2CEWMA.LoadNormalizer <= 0 {
 		return fmt.Errorf("p2cewma.load_normalizer incorrect, must be > 0, got: %f", cfg.P2CEWMA.LoadNormalizer)
 	}
+	if cfg.P2CEWMA.StreakBonus < 0 || cfg.P2CEWMA.StreakBonus >= 1 {
+		return fmt.Errorf("p2cewma.streak_bonus incorrect, must be [0;1), got: %f", cfg.P2CEWMA.StreakBonus)
+	}
+	if cfg.P2CEWMA.StreakLength < 0 {
+		return fmt.Errorf("p2cewma.streak_length incorrect, must be >= 0, got: %d", cfg.P2CEWMA.StreakLength)
+	}
+	if cfg.P2CEWMA.StreakBonus > 0 && cfg.P2CEWMA.StreakLength == 0 {
+		cfg.P2CEWMA.StreakLength = ewmaStreakLength
+	}
 
 	return nil
 }
 
-func validateLogger(cfg Logger) error {
-	switch cfg.Format {
-	case "", "json", "inline":
-	default:
-		return errors.New("logger.format incorrect, must be on of 'json', 'inline' or empty")
+func validateForwardClientIP(cfg ForwardClientIP) error {
+	for _, proxy := range cfg.TrustedProxies {
+		if _, err := ParseTrustedProxy(proxy); err != nil {
+			return fmt.Errorf("trusted_proxies incorrect, must be ips or cidrs, got: %s", proxy)
+		}
+	}
+	return nil
+}
+
+func validateUpstreamRequest(cfg *UpstreamRequest) error {
+	cfg.Method = strings.ToUpper(cfg.Method)
+	switch cfg.Method {
+	case "":
+		cfg.Method = UpstreamMethodPost
+	case UpstreamMethodPost, UpstreamMethodGet:
+	default:
+		return fmt.Errorf("method incorrect, must be one of 'POST', 'GET' or empty, got: %s", cfg.Method)
+	}
+	if cfg.Method == UpstreamMethodGet {
+		if cfg.ContentType != "" {
+			return errors.New("content_type must be empty for 'GET', request has no body")
+		}
+		return nil
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = DefaultUpstreamContentType
+	}
+	if mediaType, _, err := mime.ParseMediaType(cfg.ContentType); err != nil || !strings.Contains(mediaType, "/") {
+		return fmt.Errorf("content_type incorrect, must be media type, got: %s", cfg.ContentType)
+	}
+	return nil
+}
+
+func validateHTTPClient(cfg HTTPClient) error {
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("timeout incorrect, must be >= 0, got: %s", cfg.Timeout)
+	}
+	if cfg.MaxConnsPerHost < 0 {
+		return fmt.Errorf("max_conns_per_host incorrect, must be >= 0, got: %d", cfg.MaxConnsPerHost)
+	}
+	if cfg.MaxIdleConnDuration < 0 {
+		return fmt.Errorf("max_idle_conn_duration incorrect, must be >= 0, got: %s", cfg.MaxIdleConnDuration)
+	}
+	if cfg.StreamResponseBytes < 0 {
+		return fmt.Errorf("stream_response_bytes incorrect, must be >= 0, got: %d", cfg.StreamResponseBytes)
+	}
+	return nil
+}
+
+// ParseTrustedProxy parses trusted proxy given as ip or cidr, ip is returned as single address prefix.
+func ParseTrustedProxy(proxy string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(proxy); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+func validateUpstreamHeaders(cfg UpstreamHeaders) error {
+	if len(cfg.Allowed) > 0 && len(cfg.Denied) > 0 {
+		return errors.New("must have only one of 'allowed' or 'denied'")
+	}
+	for _, header := range slices.Concat(cfg.Allowed, cfg.Denied) {
+		if strings.TrimSpace(header) == "" {
+			return errors.New("header name must not be empty")
+		}
+	}
+	return nil
+}
+
+func validateAdaptiveWeighted(cfg *AdaptiveWeighted) error {
+	if cfg.Interval < 0 {
+		return fmt.Errorf("adaptive_weighted.interval incorrect, must be >= 0, got: %s", cfg.Interval)
+	}
+	if cfg.Smooth < 0 || cfg.Smooth > 1 {
+		return fmt.Errorf("adaptive_weighted.smooth incorrect, must be (0;1], got: %f", cfg.Smooth)
+	}
+	if cfg.MinWeight < 0 || cfg.MinWeight > 1 {
+		return fmt.Errorf("adaptive_weighted.min_weight incorrect, must be (0;1], got: %f", cfg.MinWeight)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = adaptiveWeightedInterval
+	}
+	if cfg.Smooth == 0 {
+		cfg.Smooth = adaptiveWeightedSmooth
+	}
+	if cfg.MinWeight == 0 {
+		cfg.MinWeight = adaptiveWeightedMinWeight
+	}
+	return nil
+}
+
+func validateLogger(cfg *Logger) error {
+	switch cfg.Format {
+	case "", "json", "inline":
+	default:
+		return errors.New("logger.format incorrect, must be on of 'json', 'inline' or empty")
 	}
 	switch cfg.Writer {
 	case "", "stdout", "none":
 	default:
 		return errors.New("logger.writer incorrect, must be on of 'stdout', 'none' or empty")
 	}
+	if cfg.MaxBodyBytes < 0 {
+		return fmt.Errorf("logger.max_body_bytes incorrect, must be >= 0, got: %d", cfg.MaxBodyBytes)
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = defaultLogMaxBodyBytes
+	}
+	if cfg.LogBodiesSampleRatio < 0 || cfg.LogBodiesSampleRatio > 1 {
+		return fmt.Errorf("logger.log_bodies_sample_ratio incorrect, must be in (0;1], got: %v", cfg.LogBodiesSampleRatio)
+	}
+	if cfg.LogBodiesSampleRatio == 0 {
+		cfg.LogBodiesSampleRatio = 1
+	}
+	if len(cfg.AccessFields) == 0 {
+		cfg.AccessFields = []string{
+			AccessFieldRequestID, AccessFieldConnID, AccessFieldRemoteIP, AccessFieldStatus,
+			AccessFieldLatency, AccessFieldPath, AccessFieldClient, AccessFieldProvider,
+		}
+		return nil
+	}
+	seen := make(map[string]struct{}, len(cfg.AccessFields))
+	for _, field := range cfg.AccessFields {
+		switch field {
+		case AccessFieldRequestID, AccessFieldConnID, AccessFieldRemoteIP, AccessFieldStatus,
+			AccessFieldLatency, AccessFieldPath, AccessFieldClient, AccessFieldProvider,
+			AccessFieldRequestBytes, AccessFieldResponseBytes, AccessFieldMethod, AccessFieldUserAgent:
+		default:
+			return fmt.Errorf("logger.access_fields has unknown field '%s'", field)
+		}
+		if _, exist := seen[field]; exist {
+			return fmt.Errorf("logger.access_fields has duplicated field '%s'", field)
+		}
+		seen[field] = struct{}{}
+	}
 
 	return nil
 }
 
-func validateClients(cfg Clients) error {
+func validateClients(cfg *Clients) error {
 	switch cfg.Type {
-	case "", "basic", "query":
+	case "", "basic", "query", "mtls":
+	case "jwt":
+		if err := validateJWT(&cfg.JWT); err != nil {
+			return fmt.Errorf("clients.jwt is invalid: %w", err)
+		}
+	case "api_key":
+		if err := validateAPIKeys(cfg); err != nil {
+			return err
+		}
 	default:
-		return errors.New("clients.type incorrect, must be on of 'basic', 'query' or empty")
+		return errors.New("clients.type incorrect, must be on of 'basic', 'query', 'jwt', 'api_key', 'mtls' or empty")
+	}
+	if err := validateCompression(&cfg.Compression); err != nil {
+		return fmt.Errorf("clients.compression is invalid: %w", err)
+	}
+	if cfg.Compression.Mode == "" {
+		cfg.Compression.Mode = CompressionNever
+	}
+	if err := validateRateLimit(&cfg.RateLimit); err != nil {
+		return fmt.Errorf("clients.rate_limit is invalid: %w", err)
+	}
+	if cfg.MaxBatchSize < 0 {
+		return fmt.Errorf("clients.max_batch_size incorrect, must be >= 0, got: %d", cfg.MaxBatchSize)
+	}
+	for i, client := range cfg.Clients {
+		if err := validateCompression(&cfg.Clients[i].Compression); err != nil {
+			return fmt.Errorf("clients[%s].compression is invalid: %w", client.Login, err)
+		}
+		if err := validateRateLimit(&cfg.Clients[i].RateLimit); err != nil {
+			return fmt.Errorf("clients[%s].rate_limit is invalid: %w", client.Login, err)
+		}
+		if IsPasswordHash(client.Password) {
+			if _, err := bcrypt.Cost([]byte(client.Password)); err != nil {
+				return fmt.Errorf("clients[%s].password is malformed bcrypt hash: %w", client.Login, err)
+			}
+		}
+		if err := validateQuota(&cfg.Clients[i].Quota); err != nil {
+			return fmt.Errorf("clients[%s].quota is invalid: %w", client.Login, err)
+		}
+		if client.MaxBatchSize < 0 {
+			return fmt.Errorf("clients[%s].max_batch_size incorrect, must be >= 0, got: %d",
+				client.Login, client.MaxBatchSize)
+		}
+		if len(client.AllowedMethods) > 0 && len(client.DeniedMethods) > 0 {
+			return fmt.Errorf("clients[%s] can not have both allowed_methods and denied_methods", client.Login)
+		}
+	}
+
+	return nil
+}
+
+func validateMetricsAuth(cfg MetricsAuth) error {
+	switch cfg.Type {
+	case "":
+	case MetricsAuthBasic:
+		if cfg.Login == "" || cfg.Password == "" {
+			return errors.New("login and password are required for basic type of auth")
+		}
+		if IsPasswordHash(cfg.Password) {
+			if _, err := bcrypt.Cost([]byte(cfg.Password)); err != nil {
+				return fmt.Errorf("password is malformed bcrypt hash: %w", err)
+			}
+		}
+	case MetricsAuthBearer:
+		if cfg.Token == "" {
+			return errors.New("token is required for bearer type of auth")
+		}
+	default:
+		return fmt.Errorf("type incorrect, must be one of 'basic', 'bearer' or empty, got: %s", cfg.Type)
+	}
+	return nil
+}
+
+func validateAdmin(cfg *Admin) error {
+	if cfg.Host == "" {
+		cfg.Host = defaultAdminHost
+	}
+	if err := validateMetricsAuth(cfg.Auth); err != nil {
+		return fmt.Errorf("auth is invalid: %w", err)
+	}
+	return nil
+}
+
+// IsPasswordHash returns true if client password is bcrypt hash rather than plaintext.
+func IsPasswordHash(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$")
+}
+
+func validateQuota(cfg *Quota) error {
+	if cfg.Requests < 0 {
+		return fmt.Errorf("requests incorrect, must be >= 0, got: %d", cfg.Requests)
+	}
+	switch cfg.Period {
+	case "":
+		cfg.Period = QuotaDay
+	case QuotaDay, QuotaMonth:
+	default:
+		return errors.New("period incorrect, must be one of 'day', 'month' or empty")
+	}
+
+	return nil
+}
+
+func validateCompression(cfg *Compression) error {
+	switch cfg.Mode {
+	case "", CompressionNever, CompressionAlways:
+	case CompressionThreshold:
+		if cfg.Threshold < 0 {
+			return fmt.Errorf("threshold incorrect, must be >= 0, got: %d", cfg.Threshold)
+		}
+		if cfg.Threshold == 0 {
+			cfg.Threshold = defaultCompressionThreshold
+		}
+	default:
+		return errors.New("mode incorrect, must be one of 'never', 'always', 'threshold' or empty")
 	}
 
 	return nil
 }
 
+func validateAPIKeys(cfg *Clients) error {
+	if cfg.APIKeyHeader == "" {
+		cfg.APIKeyHeader = "X-API-Key"
+	}
+	keys := make(map[string]struct{}, len(cfg.Clients))
+	for _, client := range cfg.Clients {
+		if client.APIKey == "" {
+			return fmt.Errorf("clients[%s].api_key is required", client.Login)
+		}
+		if _, exist := keys[client.APIKey]; exist {
+			return fmt.Errorf("clients[%s].api_key is not unique", client.Login)
+		}
+		keys[client.APIKey] = struct{}{}
+	}
+
+	return nil
+}
+
+func validateJWT(cfg *JWT) error {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = "HS256"
+	}
+	if cfg.ClientClaim == "" {
+		cfg.ClientClaim = "sub"
+	}
+	switch cfg.Algorithm {
+	case "HS256", "HS384", "HS512":
+		if cfg.Secret == "" {
+			return fmt.Errorf("secret is required for %s", cfg.Algorithm)
+		}
+	case "RS256", "RS384", "RS512", "ES256", "ES384", "ES512":
+		if cfg.JWKSURL == "" {
+			return fmt.Errorf("jwks_url is required for %s", cfg.Algorithm)
+		}
+		if _, err := url.ParseRequestURI(cfg.JWKSURL); err != nil {
+			return fmt.Errorf("jwks_url incorrect: %w", err)
+		}
+	default:
+		return fmt.Errorf("algorithm '%s' is not supported", cfg.Algorithm)
+	}
+
+	return nil
+}
+
+func validateRateLimit(cfg *RateLimit) error {
+	if cfg.RPS < 0 {
+		return fmt.Errorf("rps incorrect, must be >= 0, got: %v", cfg.RPS)
+	}
+	if cfg.Burst < 0 {
+		return fmt.Errorf("burst incorrect, must be >= 0, got: %d", cfg.Burst)
+	}
+	if cfg.RPS > 0 && cfg.Burst == 0 {
+		cfg.Burst = int(math.Ceil(cfg.RPS))
+	}
+
+	return nil
+}
+
+func validateResponseCache(cfg *ResponseCache) error {
+	if cfg.Size < 0 {
+		return fmt.Errorf("size incorrect, must be >= 0, got: %d", cfg.Size)
+	}
+	if cfg.Size == 0 {
+		return nil
+	}
+	if len(cfg.Methods) == 0 {
+		return errors.New("methods are required")
+	}
+	if cfg.Shards < 0 {
+		return fmt.Errorf("shards incorrect, must be >= 0, got: %d", cfg.Shards)
+	}
+	if cfg.Shards == 0 {
+		cfg.Shards = defaultResponseCacheShards
+	}
+	if cfg.TTL < 0 {
+		return fmt.Errorf("ttl incorrect, must be >= 0, got: %s", cfg.TTL)
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = defaultResponseCacheTTL
+	}
+	return nil
+}
+
+func validateDeadline(cfg *Deadline) error {
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("timeout incorrect, must be >= 0, got: %s", cfg.Timeout)
+	}
+	if cfg.Timeout == 0 {
+		if cfg.Header != "" || cfg.Format != "" {
+			return errors.New("timeout is required")
+		}
+		return nil
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = DeadlineFormatMS
+	case DeadlineFormatMS, DeadlineFormatSeconds, DeadlineFormatUnixMS:
+	default:
+		return fmt.Errorf("format incorrect, must be one of 'ms', 'seconds', 'unix_ms' or empty, got: %s", cfg.Format)
+	}
+	return nil
+}
+
+func validateBalancerSnapshot(cfg *BalancerSnapshot) error {
+	if cfg.Path == "" {
+		if cfg.Interval != 0 || cfg.MaxAge != 0 {
+			return errors.New("path is required")
+		}
+		return nil
+	}
+	if cfg.Interval < 0 {
+		return fmt.Errorf("interval incorrect, must be >= 0, got: %s", cfg.Interval)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultBalancerSnapshotInterval
+	}
+	if cfg.MaxAge < 0 {
+		return fmt.Errorf("max_age incorrect, must be >= 0, got: %s", cfg.MaxAge)
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = defaultBalancerSnapshotMaxAge
+	}
+	return nil
+}
+
+func validateTracing(cfg *Tracing) error {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(cfg.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint incorrect, must be http or https url, got: %s", cfg.Endpoint)
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultTracingServiceName
+	}
+	switch cfg.Sampler {
+	case "":
+		cfg.Sampler = SamplerAlways
+	case SamplerAlways, SamplerNever:
+	case SamplerRatio:
+		if cfg.SampleRatio <= 0 || cfg.SampleRatio > 1 {
+			return fmt.Errorf("sample_ratio incorrect, must be in (0;1], got: %v", cfg.SampleRatio)
+		}
+	default:
+		return fmt.Errorf("sampler incorrect, must be one of 'always', 'never', 'ratio' or empty, got: %s", cfg.Sampler)
+	}
+	return nil
+}
+
+func validateConcurrencyLimit(cfg *ConcurrencyLimit) error {
+	if cfg.MaxConcurrent < 0 {
+		return fmt.Errorf("max_concurrent incorrect, must be >= 0, got: %d", cfg.MaxConcurrent)
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = ConcurrencyLimitReject
+	case ConcurrencyLimitReject:
+	case ConcurrencyLimitQueue:
+		if cfg.QueueTimeout < 0 {
+			return fmt.Errorf("queue_timeout incorrect, must be >= 0, got: %s", cfg.QueueTimeout)
+		}
+		if cfg.QueueTimeout == 0 {
+			cfg.QueueTimeout = defaultConcurrencyQueueTimeout
+		}
+	default:
+		return errors.New("mode incorrect, must be one of 'reject', 'queue' or empty")
+	}
+
+	return nil
+}
+
+func validateWSReconnect(cfg *WSReconnect) error {
+	if cfg.Attempts < 0 {
+		return fmt.Errorf("attempts incorrect, must be >= 0, got: %d", cfg.Attempts)
+	}
+	if cfg.Backoff < 0 {
+		return fmt.Errorf("backoff incorrect, must be >= 0, got: %s", cfg.Backoff)
+	}
+	if cfg.Attempts > 0 && cfg.Backoff == 0 {
+		cfg.Backoff = defaultWSReconnectBackoff
+	}
+
+	return nil
+}
+
+// validateLatencyBuckets checks buckets are positive and sorted in increasing order.
+func validateLatencyBuckets(buckets []float64) error {
+	for i, bucket := range buckets {
+		if bucket <= 0 {
+			return fmt.Errorf("bucket must be > 0, got: %f", bucket)
+		}
+		if i > 0 && bucket <= buckets[i-1] {
+			return fmt.Errorf("buckets must be in increasing order, got %f after %f", bucket, buckets[i-1])
+		}
+	}
+	return nil
+}
+
+// validateMetricLabels checks only labels safe to disable are configured: labels identifying rpc and provider
+// are kept, so metrics still tell which provider served requests.
+func validateMetricLabels(labels map[string]bool) error {
+	optional := []string{"chain_id", "balancer", "method", "client"}
+	for label := range labels {
+		if !slices.Contains(optional, label) {
+			return fmt.Errorf("label incorrect, must be one of 'chain_id', 'balancer', 'method', 'client', got: %s", label)
+		}
+	}
+	return nil
+}
+
+// validateProviderTagLabels checks tag keys are valid prometheus label names
+// which don't clash with labels of provider metrics.
+func validateProviderTagLabels(labels []string) error {
+	re := regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	reserved := map[string]struct{}{
+		"chain_id": {}, "rpc_name": {}, "transport": {}, "provider": {}, "balancer": {}, "method": {}, "client": {},
+		"status_code": {}, "error_code": {},
+	}
+	seen := make(map[string]struct{}, len(labels))
+	for _, label := range labels {
+		if !re.MatchString(label) || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("label '%s' is not valid prometheus label name", label)
+		}
+		if _, exist := reserved[label]; exist {
+			return fmt.Errorf("label '%s' clashes with metric label", label)
+		}
+		if _, exist := seen[label]; exist {
+			return fmt.Errorf("label '%s' is not unique", label)
+		}
+		seen[label] = struct{}{}
+	}
+
+	return nil
+}
+
+func validateTLS(cfg TLS, clientsType string) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	if clientsType == "mtls" && (cfg.CertFile == "" || cfg.ClientCAFile == "") {
+		return errors.New("cert_file, key_file and client_ca_file are required for mtls type of auth")
+	}
+	if cfg.HTTP2 && cfg.CertFile == "" {
+		return errors.New("cert_file and key_file are required for http2")
+	}
+
+	return nil
+}
+
+func validateWebsocket(cfg *Websocket) error {
+	if cfg.ReadBufferSize < 0 {
+		return fmt.Errorf("read_buffer_size incorrect, must be >= 0, got: %d", cfg.ReadBufferSize)
+	}
+	if cfg.WriteBufferSize < 0 {
+		return fmt.Errorf("write_buffer_size incorrect, must be >= 0, got: %d", cfg.WriteBufferSize)
+	}
+	if cfg.HandshakeTimeout < 0 {
+		return fmt.Errorf("handshake_timeout incorrect, must be >= 0, got: %s", cfg.HandshakeTimeout)
+	}
+	if cfg.ReadBufferSize == 0 {
+		cfg.ReadBufferSize = defaultWSBufferSize
+	}
+	if cfg.WriteBufferSize == 0 {
+		cfg.WriteBufferSize = defaultWSBufferSize
+	}
+
+	return nil
+}
+
+func validateWSKeepalive(cfg *WSKeepalive) error {
+	if cfg.Interval < 0 {
+		return fmt.Errorf("interval incorrect, must be >= 0, got: %s", cfg.Interval)
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("timeout incorrect, must be >= 0, got: %s", cfg.Timeout)
+	}
+	if cfg.Interval > 0 && cfg.Timeout == 0 {
+		cfg.Timeout = cfg.Interval
+	}
+
+	return nil
+}
+
+// validateWSSubscriptionAffinity checks that every subscription type can be served by some provider.
+// Subscriptions of multiplexed and reconnected sessions are bound to single upstream, so affinity is not
+// supported with them.
+func validateWSSubscriptionAffinity(rpc RPC) error {
+	if len(rpc.WSSubscriptionAffinity) == 0 {
+		return nil
+	}
+	if rpc.WSMultiplexing {
+		return errors.New("can not be used with ws_multiplexing")
+	}
+	if rpc.WSReconnect.Attempts > 0 {
+		return errors.New("can not be used with ws_reconnect")
+	}
+	for subscription, tags := range rpc.WSSubscriptionAffinity {
+		if len(tags) == 0 {
+			return fmt.Errorf("subscription '%s' has no tags", subscription)
+		}
+		matched := slices.ContainsFunc(rpc.Providers, func(provider Provider) bool {
+			for key, value := range tags {
+				if provider.Tags[key] != value {
+					return false
+				}
+			}
+			return true
+		})
+		if !matched {
+			return fmt.Errorf("no provider has tags of subscription '%s'", subscription)
+		}
+	}
+	return nil
+}
+
+// validateHeaderNames checks that headers sent to provider do not replace headers set by gateway itself.
+func validateHeaderNames(names []string) error {
+	reserved := []string{"Connection", "Content-Length", "Content-Type", "Host", "Upgrade"}
+	for _, name := range names {
+		switch canonical := http.CanonicalHeaderKey(name); {
+		case name == "":
+			return errors.New("header name must not be empty")
+		case slices.Contains(reserved, canonical), strings.HasPrefix(canonical, "Sec-Websocket-"):
+			return fmt.Errorf("header '%s' is set by gateway and can not be configured", name)
+		}
+	}
+	return nil
+}
+
+// mergeProviderDefaults sets default fields of rpc to providers which do not set them.
+func mergeProviderDefaults(rpc *RPC) {
+	defaults := rpc.ProviderDefaults
+	for i := range rpc.Providers {
+		provider := &rpc.Providers[i]
+		if len(defaults.Tags) > 0 {
+			tags := maps.Clone(defaults.Tags)
+			maps.Copy(tags, provider.Tags)
+			provider.Tags = tags
+		}
+		if len(defaults.Headers) > 0 {
+			headers := maps.Clone(defaults.Headers)
+			maps.Copy(headers, provider.Headers)
+			provider.Headers = headers
+		}
+		if provider.Timeout == (ProviderTimeout{}) {
+			provider.Timeout = defaults.Timeout
+		}
+		if provider.Weight == 0 {
+			provider.Weight = defaults.Weight
+		}
+	}
+}
+
+// validatePorts checks that server, metrics, admin and own ports of rpcs do not collide.
+func validatePorts(cfg Config) error {
+	ports := map[int64]string{cfg.Port: "port"}
+	if cfg.Metrics.Enabled {
+		if cfg.Metrics.Port == cfg.Port {
+			return fmt.Errorf("metrics.port collides with port, got: %d", cfg.Metrics.Port)
+		}
+		ports[cfg.Metrics.Port] = "metrics.port"
+	}
+	if cfg.Admin.Enabled {
+		if cfg.Admin.Port < 0 || cfg.Admin.Port > maxPort {
+			return fmt.Errorf("admin.port incorrect, must be in [1;%d], got: %d", maxPort, cfg.Admin.Port)
+		}
+		if used, ok := ports[cfg.Admin.Port]; ok {
+			return fmt.Errorf("admin.port collides with %s, got: %d", used, cfg.Admin.Port)
+		}
+		ports[cfg.Admin.Port] = "admin.port"
+	}
+	for _, rpc := range cfg.RPCs {
+		if rpc.Port == 0 {
+			continue
+		}
+		if rpc.Port < 0 || rpc.Port > maxPort {
+			return fmt.Errorf("rpc[%s].port incorrect, must be in [1;%d], got: %d", rpc.Name, maxPort, rpc.Port)
+		}
+		if used, ok := ports[rpc.Port]; ok {
+			return fmt.Errorf("rpc[%s].port collides with %s, got: %d", rpc.Name, used, rpc.Port)
+		}
+		ports[rpc.Port] = fmt.Sprintf("rpc[%s].port", rpc.Name)
+	}
+	return nil
+}
+
 func validateRPCsChainID(rpc RPC) error {
 	for _, provider := range rpc.Providers {
 		cli, err := ethclient.Dial(provider.ConnURL)
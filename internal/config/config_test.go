@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/goccy/go-yaml"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 )
@@ -13,7 +15,8 @@ func Test_ParseConfig(t *testing.T) {
 logger: 
   level: info
   format: json
-  out: stdout
+  writer: stdout
+allow_empty_rpcs: true
 `
 
 	path := t.TempDir() + "cfg.yml"
@@ -24,6 +27,39 @@ logger:
 	require.Equal(t, zerolog.InfoLevel, cfg.Logger.Level)
 }
 
+func Test_ParseConfig_unknownKey(t *testing.T) {
+	cfgRaw := `
+logger:
+  level: info
+  out: stdout
+allow_empty_rpcs: true
+`
+	path := t.TempDir() + "cfg.yml"
+	require.NoError(t, os.WriteFile(path, []byte(cfgRaw), os.ModePerm))
+	_, err := ParseConfig(path)
+	require.ErrorContains(t, err, "unknown key")
+	require.ErrorContains(t, err, `"out"`)
+
+	// unknown keys are ignored if strict config is disabled.
+	require.NoError(t, os.WriteFile(path, []byte(cfgRaw+"strict_config: false\n"), os.ModePerm))
+	cfg, err := ParseConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, zerolog.InfoLevel, cfg.Logger.Level)
+}
+
+func Test_exampleConfig_knownKeys(t *testing.T) {
+	yml, err := os.ReadFile("../../rpcgate.yaml")
+	require.NoError(t, err)
+	require.NoError(t, yaml.UnmarshalWithOptions(yml, &Config{}, yaml.DisallowUnknownField()))
+}
+
+func Test_validateRPCs_empty(t *testing.T) {
+	err := validateRPCs(&Config{})
+	require.ErrorContains(t, err, "no rpcs configured")
+
+	require.NoError(t, validateRPCs(&Config{AllowEmptyRPCs: true}))
+}
+
 func Test_Replace(t *testing.T) {
 	t.Setenv("test_env", "test")
 	cfgRaw := `
@@ -44,3 +80,658 @@ logger:
   one: more
 `), replaced)
 }
+
+func Test_validateFallbacks(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rpcs    []RPC
+		needErr bool
+	}{
+		{
+			name: "ok",
+			rpcs: []RPC{{Name: "mainnet", Fallback: "mainnet-backup"}, {Name: "mainnet-backup"}},
+		},
+		{
+			name:    "unknown fallback",
+			rpcs:    []RPC{{Name: "mainnet", Fallback: "unknown"}},
+			needErr: true,
+		},
+		{
+			name:    "self fallback",
+			rpcs:    []RPC{{Name: "mainnet", Fallback: "mainnet"}},
+			needErr: true,
+		},
+		{
+			name: "fallback loop",
+			rpcs: []RPC{
+				{Name: "a", Fallback: "b"},
+				{Name: "b", Fallback: "c"},
+				{Name: "c", Fallback: "a"},
+			},
+			needErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateFallbacks(tc.rpcs)
+			if tc.needErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_validateFailClosed(t *testing.T) {
+	failClosed := GlobalRPCConfig{
+		BalancerType: P2CEWMAName,
+		P2CEWMA:      P2CEWMAConfig{AllInCooldown: AllInCooldownFailClosed},
+	}
+	lone := []Provider{{Name: "node"}}
+	require.NoError(t, validateFailClosed([]RPC{
+		{Name: "mainnet", GlobalRPCConfig: failClosed, Providers: lone, Fallback: "backup"},
+		{Name: "polygon", GlobalRPCConfig: failClosed, Providers: []Provider{{Name: "a"}, {Name: "b"}}},
+		{Name: "backup", Providers: lone},
+	}))
+	require.Error(t, validateFailClosed([]RPC{{Name: "mainnet", GlobalRPCConfig: failClosed, Providers: lone}}))
+	require.Error(t, validateFailClosed([]RPC{{
+		Name:            "mainnet",
+		GlobalRPCConfig: GlobalRPCConfig{BalancerType: RRName, AllInCooldown: AllInCooldownFailClosed},
+		Providers:       lone,
+	}}))
+}
+
+func Test_validateAllowedRPCs(t *testing.T) {
+	rpcs := []RPC{{Name: "mainnet"}, {Name: "polygon"}}
+	require.NoError(t, validateAllowedRPCs([]Client{
+		{Login: "indexer", AllowedRPCs: []string{"mainnet"}},
+		{Login: "admin"},
+	}, rpcs))
+	require.Error(t, validateAllowedRPCs([]Client{
+		{Login: "indexer", AllowedRPCs: []string{"mainnet", "unknown"}},
+	}, rpcs))
+}
+
+func Test_validateResponseCacheNewHeads(t *testing.T) {
+	rpcs := []RPC{{Name: "mainnet"}}
+	cache := func(heads ResponseCacheNewHeads) ResponseCache {
+		return ResponseCache{Size: 10, Methods: []string{"eth_blockNumber", "eth_chainId"}, NewHeads: heads}
+	}
+	testCases := []struct {
+		name    string
+		cfg     ResponseCache
+		needErr bool
+	}{
+		{
+			name: "disabled",
+			cfg:  cache(ResponseCacheNewHeads{}),
+		},
+		{
+			name: "ok",
+			cfg: cache(ResponseCacheNewHeads{
+				URLs:    map[string]string{"mainnet": "wss://eth.example.com"},
+				Methods: []string{"eth_blockNumber"},
+			}),
+		},
+		{
+			name:    "methods without urls",
+			cfg:     cache(ResponseCacheNewHeads{Methods: []string{"eth_blockNumber"}}),
+			needErr: true,
+		},
+		{
+			name:    "urls without methods",
+			cfg:     cache(ResponseCacheNewHeads{URLs: map[string]string{"mainnet": "wss://eth.example.com"}}),
+			needErr: true,
+		},
+		{
+			name: "unknown rpc",
+			cfg: cache(ResponseCacheNewHeads{
+				URLs:    map[string]string{"polygon": "wss://polygon.example.com"},
+				Methods: []string{"eth_blockNumber"},
+			}),
+			needErr: true,
+		},
+		{
+			name: "http url",
+			cfg: cache(ResponseCacheNewHeads{
+				URLs:    map[string]string{"mainnet": "https://eth.example.com"},
+				Methods: []string{"eth_blockNumber"},
+			}),
+			needErr: true,
+		},
+		{
+			name: "method not cached",
+			cfg: cache(ResponseCacheNewHeads{
+				URLs:    map[string]string{"mainnet": "wss://eth.example.com"},
+				Methods: []string{"eth_getBalance"},
+			}),
+			needErr: true,
+		},
+		{
+			name: "cache disabled",
+			cfg: ResponseCache{NewHeads: ResponseCacheNewHeads{
+				URLs:    map[string]string{"mainnet": "wss://eth.example.com"},
+				Methods: []string{"eth_blockNumber"},
+			}},
+			needErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateResponseCacheNewHeads(tc.cfg, rpcs)
+			if tc.needErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_validateFaults(t *testing.T) {
+	cfg := Config{RPCs: []RPC{{Name: "mainnet", Providers: []Provider{
+		{Name: "slow", Fault: Fault{Latency: time.Second, ErrorRate: 0.5}},
+	}}}}
+	require.Error(t, validateFaults(&cfg))
+
+	cfg.Debug.FaultInjection = true
+	require.NoError(t, validateFaults(&cfg))
+
+	cfg.RPCs[0].Providers[0].Fault.ErrorRate = 2
+	require.Error(t, validateFaults(&cfg))
+}
+
+func Test_validateRPCs_providerWeight(t *testing.T) {
+	cfg := Config{RPCs: []RPC{{Name: "mainnet", Providers: []Provider{
+		{Name: "small", ConnURL: "http://small"},
+		{Name: "large", ConnURL: "http://large", Weight: 2.5},
+	}}}}
+	require.NoError(t, validateRPCs(&cfg))
+	require.InDelta(t, 1, cfg.RPCs[0].Providers[0].Weight, 0)
+	require.InDelta(t, 2.5, cfg.RPCs[0].Providers[1].Weight, 0)
+
+	cfg.RPCs[0].Providers[1].Weight = -1
+	require.Error(t, validateRPCs(&cfg))
+}
+
+func Test_validateProviderConnURL(t *testing.T) {
+	testCases := []struct {
+		name      string
+		providers []Provider
+		errText   string
+	}{
+		{
+			name:      "unique",
+			providers: []Provider{{Name: "a", ConnURL: "http://a"}, {Name: "b", ConnURL: "http://b"}},
+		},
+		{
+			name:      "duplicate name",
+			providers: []Provider{{Name: "a", ConnURL: "http://a"}, {Name: "a", ConnURL: "http://b"}},
+			errText:   "rpc[mainnet].provider[a].name is not unique",
+		},
+		{
+			name:      "duplicate conn_url",
+			providers: []Provider{{Name: "a", ConnURL: "http://a"}, {Name: "b", ConnURL: "http://a"}},
+			errText:   "rpc[mainnet].provider[b].conn_url is not unique",
+		},
+		{
+			name:      "empty name",
+			providers: []Provider{{Name: "a", ConnURL: "http://a"}, {ConnURL: "http://b?key=secret"}},
+			errText:   "rpc[mainnet].provider[1].name is empty",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateProviderConnURL(RPC{Name: "mainnet", Providers: tc.providers})
+			if tc.errText == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.errText)
+		})
+	}
+}
+
+func Test_validateRPCs_duplicateName(t *testing.T) {
+	rpc := RPC{Name: "mainnet", Providers: []Provider{{Name: "a", ConnURL: "http://a"}}}
+	require.NoError(t, validateRPCs(&Config{RPCs: []RPC{rpc}}))
+	require.EqualError(t, validateRPCs(&Config{RPCs: []RPC{rpc, rpc}}), "rpc[mainnet].name is not unique")
+	require.EqualError(t, validateRPCs(&Config{RPCs: []RPC{{Name: "empty"}}}), "rpc[empty] has no providers")
+}
+
+func Test_validateMetricsAuth(t *testing.T) {
+	require.NoError(t, validateMetricsAuth(MetricsAuth{}))
+	require.NoError(t, validateMetricsAuth(MetricsAuth{Type: MetricsAuthBasic, Login: "prometheus", Password: "secret"}))
+	require.NoError(t, validateMetricsAuth(MetricsAuth{Type: MetricsAuthBearer, Token: "token"}))
+
+	require.Error(t, validateMetricsAuth(MetricsAuth{Type: MetricsAuthBasic, Login: "prometheus"}))
+	require.Error(t, validateMetricsAuth(MetricsAuth{Type: MetricsAuthBasic, Login: "prometheus", Password: "$2a$bad"}))
+	require.Error(t, validateMetricsAuth(MetricsAuth{Type: MetricsAuthBearer}))
+	require.Error(t, validateMetricsAuth(MetricsAuth{Type: "digest"}))
+}
+
+func Test_validatePorts(t *testing.T) {
+	cfg := Config{Port: 8080, Metrics: Metrics{Enabled: true, Port: 9090}, RPCs: []RPC{
+		{Name: "mainnet", Port: 8545},
+		{Name: "base", Port: 8546},
+		{Name: "polygon"},
+		{Name: "arbitrum"},
+	}}
+	require.NoError(t, validatePorts(cfg))
+
+	cfg.RPCs[2].Port = 8545
+	require.EqualError(t, validatePorts(cfg), "rpc[polygon].port collides with rpc[mainnet].port, got: 8545")
+	cfg.RPCs[2].Port = 9090
+	require.EqualError(t, validatePorts(cfg), "rpc[polygon].port collides with metrics.port, got: 9090")
+	cfg.RPCs[2].Port = 8080
+	require.EqualError(t, validatePorts(cfg), "rpc[polygon].port collides with port, got: 8080")
+	cfg.RPCs[2].Port = 70000
+	require.Error(t, validatePorts(cfg))
+
+	// metrics port is free while metrics are disabled.
+	cfg.RPCs[2].Port = 9090
+	cfg.Metrics.Enabled = false
+	require.NoError(t, validatePorts(cfg))
+	cfg.Metrics = Metrics{Enabled: true, Port: 8080}
+	cfg.RPCs[2].Port = 0
+	require.EqualError(t, validatePorts(cfg), "metrics.port collides with port, got: 8080")
+
+	cfg.Metrics.Port = 9090
+	cfg.Admin = Admin{Enabled: true, Port: 9090}
+	require.EqualError(t, validatePorts(cfg), "admin.port collides with metrics.port, got: 9090")
+	cfg.Admin.Port = 9091
+	require.NoError(t, validatePorts(cfg))
+	cfg.RPCs[2].Port = 9091
+	require.EqualError(t, validatePorts(cfg), "rpc[polygon].port collides with admin.port, got: 9091")
+}
+
+func Test_validateAdmin(t *testing.T) {
+	cfg := Admin{Enabled: true}
+	require.NoError(t, validateAdmin(&cfg))
+	require.Equal(t, "127.0.0.1", cfg.Host)
+
+	cfg = Admin{Enabled: true, Host: "0.0.0.0", Auth: MetricsAuth{Type: MetricsAuthBearer, Token: "secret"}}
+	require.NoError(t, validateAdmin(&cfg))
+	require.Equal(t, "0.0.0.0", cfg.Host)
+
+	cfg.Auth = MetricsAuth{Type: MetricsAuthBasic, Login: "admin"}
+	require.Error(t, validateAdmin(&cfg))
+}
+
+func Test_validateRPCs_providerDefaults(t *testing.T) {
+	timeout := ProviderTimeout{Initial: 2 * time.Second}
+	cfg := Config{RPCs: []RPC{{
+		Name: "mainnet",
+		ProviderDefaults: ProviderDefaults{
+			Tags:    map[string]string{"region": "eu", "tier": "paid"},
+			Timeout: timeout,
+			Weight:  2,
+			Headers: map[string]string{"X-Api-Key": "shared"},
+		},
+		Providers: []Provider{
+			{Name: "inheriting", ConnURL: "http://a"},
+			{
+				Name:    "overriding",
+				ConnURL: "http://b",
+				Tags:    map[string]string{"tier": "free", "vendor": "infura"},
+				Timeout: ProviderTimeout{Initial: 30 * time.Second},
+				Weight:  5,
+				Headers: map[string]string{"X-Api-Key": "own", "Origin": "https://app.example"},
+			},
+		},
+	}}}
+	require.NoError(t, validateRPCs(&cfg))
+
+	inheriting, overriding := cfg.RPCs[0].Providers[0], cfg.RPCs[0].Providers[1]
+	require.Equal(t, map[string]string{"region": "eu", "tier": "paid"}, inheriting.Tags)
+	require.Equal(t, 2*time.Second, inheriting.Timeout.Initial)
+	require.InDelta(t, 2, inheriting.Weight, 0)
+	require.Equal(t, map[string]string{"X-Api-Key": "shared"}, inheriting.Headers)
+
+	require.Equal(t, map[string]string{"region": "eu", "tier": "free", "vendor": "infura"}, overriding.Tags)
+	require.Equal(t, 30*time.Second, overriding.Timeout.Initial)
+	require.InDelta(t, 5, overriding.Weight, 0)
+	require.Equal(t, map[string]string{"X-Api-Key": "own", "Origin": "https://app.example"}, overriding.Headers)
+
+	// defaults are copied, so providers do not share them.
+	inheriting.Tags["region"] = "us"
+	require.Equal(t, "eu", cfg.RPCs[0].ProviderDefaults.Tags["region"])
+	require.Equal(t, timeout, cfg.RPCs[0].ProviderDefaults.Timeout)
+}
+
+func Test_validateRPCs_headers(t *testing.T) {
+	newCfg := func(headers map[string]string, forward []string, multiplexing bool) *Config {
+		return &Config{RPCs: []RPC{{
+			Name:             "mainnet",
+			WSForwardHeaders: forward,
+			WSMultiplexing:   multiplexing,
+			Providers:        []Provider{{Name: "a", ConnURL: "http://a", Headers: headers}},
+		}}}
+	}
+	require.NoError(t, validateRPCs(newCfg(map[string]string{"X-Api-Key": "secret"}, []string{"Origin"}, false)))
+	require.NoError(t, validateRPCs(newCfg(map[string]string{"X-Api-Key": "secret"}, nil, true)))
+
+	require.Error(t, validateRPCs(newCfg(map[string]string{"": "secret"}, nil, false)))
+	require.Error(t, validateRPCs(newCfg(map[string]string{"content-type": "text/plain"}, nil, false)))
+	require.Error(t, validateRPCs(newCfg(nil, []string{"Sec-WebSocket-Protocol"}, false)))
+	require.Error(t, validateRPCs(newCfg(nil, []string{"Origin"}, true)))
+}
+
+func Test_validateRPCs_blockLag(t *testing.T) {
+	cfg := Config{RPCs: []RPC{{Name: "mainnet", MaxBlockLag: 5, Providers: []Provider{
+		{Name: "a", ConnURL: "http://a"},
+		{Name: "b", ConnURL: "http://b"},
+	}}}}
+	require.NoError(t, validateRPCs(&cfg))
+	require.Equal(t, defaultBlockLagInterval, cfg.RPCs[0].BlockLagInterval)
+
+	cfg.RPCs[0].BlockLagInterval = -time.Second
+	require.Error(t, validateRPCs(&cfg))
+	cfg.RPCs[0].BlockLagInterval = time.Second
+	cfg.RPCs[0].MaxBlockLag = -1
+	require.Error(t, validateRPCs(&cfg))
+}
+
+func Test_validateRPCs_sloTarget(t *testing.T) {
+	cfg := Config{RPCs: []RPC{{Name: "mainnet", SLOTargetSeconds: 0.5, Providers: []Provider{
+		{Name: "a", ConnURL: "http://a"},
+	}}}}
+	require.NoError(t, validateRPCs(&cfg))
+
+	cfg.RPCs[0].SLOTargetSeconds = -1
+	require.Error(t, validateRPCs(&cfg))
+}
+
+func Test_validateMetricLabels(t *testing.T) {
+	require.NoError(t, validateMetricLabels(nil))
+	require.NoError(t, validateMetricLabels(map[string]bool{"client": false, "method": false, "chain_id": true}))
+	require.Error(t, validateMetricLabels(map[string]bool{"provider": false}))
+	require.Error(t, validateMetricLabels(map[string]bool{"status_code": false}))
+}
+
+func Test_validateProviderTimeout(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cfg     ProviderTimeout
+		want    ProviderTimeout
+		needErr bool
+	}{
+		{name: "disabled"},
+		{
+			name: "fixed by default",
+			cfg:  ProviderTimeout{Initial: time.Second},
+			want: ProviderTimeout{Initial: time.Second, Limit: time.Second, Factor: 2, Threshold: 3},
+		},
+		{
+			name: "shrinking",
+			cfg:  ProviderTimeout{Initial: time.Second, Limit: 100 * time.Millisecond},
+			want: ProviderTimeout{Initial: time.Second, Limit: 100 * time.Millisecond, Factor: 0.5, Threshold: 3},
+		},
+		{name: "missing initial", cfg: ProviderTimeout{Limit: time.Second}, needErr: true},
+		{
+			name:    "factor does not reach limit",
+			cfg:     ProviderTimeout{Initial: time.Second, Limit: 5 * time.Second, Factor: 0.5},
+			needErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateProviderTimeout(&tc.cfg)
+			if tc.needErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, tc.cfg)
+		})
+	}
+}
+
+func Test_validateClients_passwordHash(t *testing.T) {
+	cfg := Clients{Clients: []Client{
+		{Login: "hashed", Password: "$2a$04$8gTs0O3hY/lTB58saftHy.rO6TwotMRqIqE4lPTbIzap0fxJsbOIC"},
+		{Login: "plain", Password: "plain-pass"},
+	}}
+	require.NoError(t, validateClients(&cfg))
+
+	cfg.Clients[0].Password = "$2b$04$malformed"
+	require.Error(t, validateClients(&cfg))
+}
+
+func Test_validateDeadline(t *testing.T) {
+	require.NoError(t, validateDeadline(&Deadline{}))
+	require.Error(t, validateDeadline(&Deadline{Header: "X-Request-Deadline"}))
+	require.Error(t, validateDeadline(&Deadline{Timeout: -time.Second}))
+	require.Error(t, validateDeadline(&Deadline{Timeout: time.Second, Format: "ns"}))
+
+	cfg := Deadline{Timeout: time.Second, Header: "X-Request-Deadline"}
+	require.NoError(t, validateDeadline(&cfg))
+	require.Equal(t, DeadlineFormatMS, cfg.Format)
+}
+
+func Test_validateConfig_methodRateLimits(t *testing.T) {
+	cfg := Config{
+		AllowEmptyRPCs:   true,
+		MethodRateLimits: map[string]RateLimit{"debug_traceCall": {RPS: 2.5}},
+	}
+	require.NoError(t, validateConfig(&cfg))
+	require.Equal(t, RateLimit{RPS: 2.5, Burst: 3}, cfg.MethodRateLimits["debug_traceCall"])
+
+	cfg.MethodRateLimits = map[string]RateLimit{"debug_traceCall": {RPS: -1}}
+	require.Error(t, validateConfig(&cfg))
+}
+
+func Test_validateBalancerSnapshot(t *testing.T) {
+	require.NoError(t, validateBalancerSnapshot(&BalancerSnapshot{}))
+	require.Error(t, validateBalancerSnapshot(&BalancerSnapshot{Interval: time.Second}))
+	require.Error(t, validateBalancerSnapshot(&BalancerSnapshot{Path: "stats.json", Interval: -time.Second}))
+	require.Error(t, validateBalancerSnapshot(&BalancerSnapshot{Path: "stats.json", MaxAge: -time.Second}))
+
+	cfg := BalancerSnapshot{Path: "stats.json"}
+	require.NoError(t, validateBalancerSnapshot(&cfg))
+	require.Equal(t, BalancerSnapshot{
+		Path:     "stats.json",
+		Interval: defaultBalancerSnapshotInterval,
+		MaxAge:   defaultBalancerSnapshotMaxAge,
+	}, cfg)
+}
+
+func Test_validateAdaptiveWeighted(t *testing.T) {
+	cfg := GlobalRPCConfig{BalancerType: AWName}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AdaptiveWeighted{
+		Interval:  adaptiveWeightedInterval,
+		Smooth:    adaptiveWeightedSmooth,
+		MinWeight: adaptiveWeightedMinWeight,
+	}, cfg.AdaptiveWeighted)
+
+	require.Error(t, validateAdaptiveWeighted(&AdaptiveWeighted{Interval: -time.Second}))
+	require.Error(t, validateAdaptiveWeighted(&AdaptiveWeighted{Smooth: 1.5}))
+	require.Error(t, validateAdaptiveWeighted(&AdaptiveWeighted{MinWeight: 2}))
+}
+
+func Test_validateGlobalRPCConfig_cooldown(t *testing.T) {
+	for _, balancerType := range []string{RRName, LCName} {
+		cfg := GlobalRPCConfig{BalancerType: balancerType, Cooldown: 5 * time.Second}
+		require.NoError(t, validateGlobalRPCConfig(&cfg))
+		require.Equal(t, 5*time.Second, cfg.Cooldown)
+
+		cfg = GlobalRPCConfig{BalancerType: balancerType, Cooldown: -time.Second}
+		require.Error(t, validateGlobalRPCConfig(&cfg))
+	}
+}
+
+func Test_validateGlobalRPCConfig_allInCooldown(t *testing.T) {
+	cfg := GlobalRPCConfig{}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AllInCooldownBestEffort, cfg.P2CEWMA.AllInCooldown)
+
+	// other options keep their defaults.
+	cfg = GlobalRPCConfig{P2CEWMA: P2CEWMAConfig{AllInCooldown: AllInCooldownFailClosed}}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AllInCooldownFailClosed, cfg.P2CEWMA.AllInCooldown)
+	require.Equal(t, ewmaCooldown, cfg.P2CEWMA.CooldownTimeout)
+
+	cfg = GlobalRPCConfig{P2CEWMA: P2CEWMAConfig{AllInCooldown: "fail_open"}}
+	require.Error(t, validateGlobalRPCConfig(&cfg))
+
+	// rpc level behavior is used by round-robin, least-connection and p2cewma without its own.
+	cfg = GlobalRPCConfig{BalancerType: RRName}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AllInCooldownBestEffort, cfg.AllInCooldown)
+	cfg = GlobalRPCConfig{BalancerType: LCName, AllInCooldown: AllInCooldownFailClosed}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AllInCooldownFailClosed, cfg.AllInCooldown)
+	cfg = GlobalRPCConfig{BalancerType: RRName, AllInCooldown: "fail_open"}
+	require.Error(t, validateGlobalRPCConfig(&cfg))
+	cfg = GlobalRPCConfig{AllInCooldown: AllInCooldownFailClosed}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AllInCooldownFailClosed, cfg.P2CEWMA.AllInCooldown)
+	cfg = GlobalRPCConfig{
+		AllInCooldown: AllInCooldownFailClosed,
+		P2CEWMA:       P2CEWMAConfig{AllInCooldown: AllInCooldownBestEffort},
+	}
+	require.NoError(t, validateGlobalRPCConfig(&cfg))
+	require.Equal(t, AllInCooldownBestEffort, cfg.P2CEWMA.AllInCooldown)
+}
+
+func Test_validateForwardClientIP(t *testing.T) {
+	require.NoError(t, validateForwardClientIP(ForwardClientIP{
+		Enabled:        true,
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"},
+	}))
+	require.Error(t, validateForwardClientIP(ForwardClientIP{TrustedProxies: []string{"10.0.0.0/33"}}))
+	require.Error(t, validateForwardClientIP(ForwardClientIP{TrustedProxies: []string{"proxy.local"}}))
+}
+
+func Test_validateUpstreamRequest(t *testing.T) {
+	cfg := UpstreamRequest{}
+	require.NoError(t, validateUpstreamRequest(&cfg))
+	require.Equal(t, UpstreamRequest{Method: UpstreamMethodPost, ContentType: DefaultUpstreamContentType}, cfg)
+
+	cfg = UpstreamRequest{Method: "get"}
+	require.NoError(t, validateUpstreamRequest(&cfg))
+	require.Equal(t, UpstreamRequest{Method: UpstreamMethodGet}, cfg)
+
+	cfg = UpstreamRequest{ContentType: "application/json-rpc; charset=utf-8"}
+	require.NoError(t, validateUpstreamRequest(&cfg))
+	require.Equal(t, UpstreamMethodPost, cfg.Method)
+
+	require.Error(t, validateUpstreamRequest(&UpstreamRequest{Method: "PUT"}))
+	require.Error(t, validateUpstreamRequest(&UpstreamRequest{Method: "GET", ContentType: "application/json"}))
+	require.Error(t, validateUpstreamRequest(&UpstreamRequest{ContentType: "json"}))
+}
+
+func Test_validateHTTPClient(t *testing.T) {
+	require.NoError(t, validateHTTPClient(HTTPClient{}))
+	require.NoError(t, validateHTTPClient(HTTPClient{Timeout: time.Minute, MaxConnsPerHost: 64}))
+	require.Error(t, validateHTTPClient(HTTPClient{Timeout: -time.Second}))
+	require.Error(t, validateHTTPClient(HTTPClient{MaxConnsPerHost: -1}))
+	require.Error(t, validateHTTPClient(HTTPClient{MaxIdleConnDuration: -time.Second}))
+	require.Error(t, validateHTTPClient(HTTPClient{StreamResponseBytes: -1}))
+}
+
+func Test_validateTLS_http2(t *testing.T) {
+	require.NoError(t, validateTLS(TLS{CertFile: "server.crt", KeyFile: "server.key", HTTP2: true}, ""))
+	require.Error(t, validateTLS(TLS{HTTP2: true}, ""))
+}
+
+func Test_validateUpstreamHeaders(t *testing.T) {
+	require.NoError(t, validateUpstreamHeaders(UpstreamHeaders{}))
+	require.NoError(t, validateUpstreamHeaders(UpstreamHeaders{Allowed: []string{"X-Ratelimit-Remaining"}}))
+	require.NoError(t, validateUpstreamHeaders(UpstreamHeaders{Denied: []string{"Server"}}))
+	require.Error(t, validateUpstreamHeaders(UpstreamHeaders{Allowed: []string{"A"}, Denied: []string{"B"}}))
+	require.Error(t, validateUpstreamHeaders(UpstreamHeaders{Denied: []string{" "}}))
+}
+
+func Test_validateLogger(t *testing.T) {
+	cfg := Logger{}
+	require.NoError(t, validateLogger(&cfg))
+	require.Equal(t, []string{
+		AccessFieldRequestID, AccessFieldConnID, AccessFieldRemoteIP, AccessFieldStatus,
+		AccessFieldLatency, AccessFieldPath, AccessFieldClient, AccessFieldProvider,
+	}, cfg.AccessFields)
+
+	cfg = Logger{AccessFields: []string{AccessFieldMethod, AccessFieldUserAgent}}
+	require.NoError(t, validateLogger(&cfg))
+	require.Equal(t, []string{AccessFieldMethod, AccessFieldUserAgent}, cfg.AccessFields)
+
+	require.Equal(t, defaultLogMaxBodyBytes, cfg.MaxBodyBytes)
+	require.InDelta(t, 1, cfg.LogBodiesSampleRatio, 0)
+
+	require.Error(t, validateLogger(&Logger{Format: "xml"}))
+	require.Error(t, validateLogger(&Logger{MaxBodyBytes: -1}))
+	require.Error(t, validateLogger(&Logger{LogBodiesSampleRatio: 1.5}))
+	require.Error(t, validateLogger(&Logger{AccessFields: []string{"referer"}}))
+	require.Error(t, validateLogger(&Logger{AccessFields: []string{AccessFieldPath, AccessFieldPath}}))
+}
+
+func Test_validateTracing(t *testing.T) {
+	cfg := Tracing{}
+	require.NoError(t, validateTracing(&cfg))
+	require.Empty(t, cfg.Sampler)
+
+	cfg = Tracing{Endpoint: "http://collector:4318/v1/traces"}
+	require.NoError(t, validateTracing(&cfg))
+	require.Equal(t, defaultTracingServiceName, cfg.ServiceName)
+	require.Equal(t, SamplerAlways, cfg.Sampler)
+
+	require.Error(t, validateTracing(&Tracing{Endpoint: "collector:4318"}))
+	require.Error(t, validateTracing(&Tracing{Endpoint: "http://collector", Sampler: "sometimes"}))
+	require.Error(t, validateTracing(&Tracing{Endpoint: "http://collector", Sampler: SamplerRatio}))
+	require.NoError(t, validateTracing(&Tracing{Endpoint: "http://collector", Sampler: SamplerRatio, SampleRatio: 0.1}))
+}
+
+func Test_validateLatencyBuckets(t *testing.T) {
+	require.NoError(t, validateLatencyBuckets(nil))
+	require.NoError(t, validateLatencyBuckets([]float64{0.001, 0.005, 30}))
+	require.Error(t, validateLatencyBuckets([]float64{0, 1}))
+	require.Error(t, validateLatencyBuckets([]float64{1, 0.5}))
+}
+
+func Test_validateProviderTagLabels(t *testing.T) {
+	testCases := []struct {
+		name    string
+		labels  []string
+		needErr bool
+	}{
+		{name: "ok", labels: []string{"region", "tier"}},
+		{name: "invalid name", labels: []string{"data-center"}, needErr: true},
+		{name: "reserved prefix", labels: []string{"__region"}, needErr: true},
+		{name: "clashes with metric label", labels: []string{"provider"}, needErr: true},
+		{name: "not unique", labels: []string{"region", "region"}, needErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateProviderTagLabels(tc.labels)
+			if tc.needErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_validateWSSubscriptionAffinity(t *testing.T) {
+	rpc := RPC{
+		Name: "mainnet",
+		Providers: []Provider{
+			{Name: "big", Tags: map[string]string{"tier": "high"}},
+			{Name: "small"},
+		},
+		WSSubscriptionAffinity: map[string]map[string]string{"logs": {"tier": "high"}},
+	}
+	require.NoError(t, validateWSSubscriptionAffinity(rpc))
+
+	rpc.WSSubscriptionAffinity["newHeads"] = map[string]string{"tier": "low"}
+	require.Error(t, validateWSSubscriptionAffinity(rpc))
+
+	rpc.WSSubscriptionAffinity = map[string]map[string]string{"logs": {}}
+	require.Error(t, validateWSSubscriptionAffinity(rpc))
+
+	rpc.WSSubscriptionAffinity = map[string]map[string]string{"logs": {"tier": "high"}}
+	rpc.WSMultiplexing = true
+	require.Error(t, validateWSSubscriptionAffinity(rpc))
+}
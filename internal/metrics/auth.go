@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// authHandler answers 401 to scrapers without credentials of cfg, next is returned as is if auth is disabled.
+func authHandler(cfg config.MetricsAuth, next http.Handler) http.Handler {
+	var authorized func(r *http.Request) bool
+	switch cfg.Type {
+	case config.MetricsAuthBasic:
+		authorized = func(r *http.Request) bool {
+			login, pass, ok := r.BasicAuth()
+			return ok && equal(login, cfg.Login) && checkPassword(cfg.Password, pass)
+		}
+	case config.MetricsAuthBearer:
+		authorized = func(r *http.Request) bool {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			return ok && equal(token, cfg.Token)
+		}
+	default:
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			if cfg.Type == config.MetricsAuthBasic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkPassword returns true if pass matches expected plaintext password or bcrypt hash.
+// Scrapes are rare, so unlike client passwords verified hash is not cached.
+func checkPassword(expected, pass string) bool {
+	if config.IsPasswordHash(expected) {
+		return bcrypt.CompareHashAndPassword([]byte(expected), []byte(pass)) == nil
+	}
+	return equal(expected, pass)
+}
+
+// equal compares secrets in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
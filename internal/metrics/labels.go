@@ -0,0 +1,83 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// CounterVec is counter vector of provider metric. Label values are passed for all labels of metric,
+// values of labels disabled in config are skipped.
+type CounterVec struct {
+	*prometheus.CounterVec
+
+	kept []bool
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *CounterVec {
+	labels, kept := keptLabels(labels)
+	return &CounterVec{CounterVec: prometheus.NewCounterVec(opts, labels), kept: kept}
+}
+
+func (v *CounterVec) WithLabelValues(values ...string) prometheus.Counter {
+	return v.CounterVec.WithLabelValues(keptValues(v.kept, values)...)
+}
+
+// HistogramVec is histogram vector of provider metric, see CounterVec.
+type HistogramVec struct {
+	*prometheus.HistogramVec
+
+	kept []bool
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *HistogramVec {
+	labels, kept := keptLabels(labels)
+	return &HistogramVec{HistogramVec: prometheus.NewHistogramVec(opts, labels), kept: kept}
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) prometheus.Observer {
+	return v.HistogramVec.WithLabelValues(keptValues(v.kept, values)...)
+}
+
+// SummaryVec is summary vector of provider metric, see CounterVec.
+type SummaryVec struct {
+	*prometheus.SummaryVec
+
+	kept []bool
+}
+
+func newSummaryVec(opts prometheus.SummaryOpts, labels []string) *SummaryVec {
+	labels, kept := keptLabels(labels)
+	return &SummaryVec{SummaryVec: prometheus.NewSummaryVec(opts, labels), kept: kept}
+}
+
+func (v *SummaryVec) WithLabelValues(values ...string) prometheus.Observer {
+	return v.SummaryVec.WithLabelValues(keptValues(v.kept, values)...)
+}
+
+// keptLabels returns labels without disabled ones and which of labels are kept,
+// nil if none is disabled.
+func keptLabels(labels []string) ([]string, []bool) {
+	filtered := make([]string, 0, len(labels))
+	kept := make([]bool, len(labels))
+	for i, label := range labels {
+		kept[i] = !disabledLabels[label]
+		if kept[i] {
+			filtered = append(filtered, label)
+		}
+	}
+	if len(filtered) == len(labels) {
+		return labels, nil
+	}
+	return filtered, kept
+}
+
+// keptValues returns values of kept labels.
+func keptValues(kept []bool, values []string) []string {
+	if kept == nil {
+		return values
+	}
+	filtered := make([]string, 0, len(values))
+	for i, value := range values {
+		if i >= len(kept) || kept[i] {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
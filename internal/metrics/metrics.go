@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,6 +13,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 
+	"github.com/BinaryArchaism/rpcgate/internal/buildinfo"
 	"github.com/BinaryArchaism/rpcgate/internal/config"
 )
 
@@ -21,67 +23,331 @@ const (
 
 	HTTPTransport      = "http"
 	WebsocketTransport = "websocket"
+
+	// WSClientToUpstream and WSUpstreamToClient are directions of websocket messages.
+	WSClientToUpstream = "client_to_upstream"
+	WSUpstreamToClient = "upstream_to_client"
+
+	// NoStatusCode is status code label of websocket requests, which have no http status.
+	NoStatusCode = ""
+	// NoErrorCode is error code label of client errors without json-rpc error, like websocket disconnects.
+	NoErrorCode = ""
+	// OtherErrorCode is error code label of json-rpc errors with codes outside of known set.
+	OtherErrorCode = "other"
 )
 
 //nolint:gochecknoglobals // metrics
 var (
-	RequestLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	// defaultLatencyBuckets are buckets of latency histograms used if they are not configured.
+	defaultLatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5}
+
+	RequestLatencySeconds  = newRequestLatencySeconds(nil, defaultLatencyBuckets)
+	UpstreamLatencySeconds = newUpstreamLatencySeconds(nil, defaultLatencyBuckets)
+	// BatchMethodLatencySeconds is observed only if batch method latency is enabled in config.
+	BatchMethodLatencySeconds = newBatchMethodLatencySeconds(nil, defaultLatencyBuckets)
+	RequestTotalCounter       = newRequestTotalCounter(nil)
+	RequestError              = newRequestError(nil)
+	ClientRequestError        = newClientRequestError(nil)
+	ResponseSizeBytes         = newResponseSizeBytes(nil)
+	WSConnTotalCounter        = newWSConnTotalCounter(nil)
+	UpstreamConcurrency       = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_concurrency",
+		Help:      "Current concurrent upstream requests per concurrency limit",
+	}, []string{"limit"})
+	ProviderInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "provider_in_flight",
+		Help:      "Current in-flight requests per provider tracked by balancer",
+	}, []string{"chain_id", "rpc_name", "provider", "balancer"})
+	ConcurrencyLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "concurrency_limit_rejected_total",
+		Help:      "Requests rejected by concurrency limit total",
+	}, []string{"limit"})
+	ProviderSelected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_selected_total",
+		Help:      "Providers borrowed from balancer total, counted before request is served",
+	}, []string{"rpc_name", "provider", "balancer"})
+	ProviderEjected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_ejected_total",
+		Help:      "Providers ejected from balancing because of responses of another chain total",
+	}, []string{"rpc_name", "provider"})
+	ProviderBlockLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "provider_block_lag",
+		Help:      "Blocks provider is behind the highest block among providers of rpc at last poll",
+	}, []string{"rpc_name", "provider"})
+	RateLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejected_total",
+		Help:      "Requests rejected by client rate limit total",
+	}, []string{"client"})
+	MethodRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "method_rate_limited_total",
+		Help:      "Requests rejected by method rate limit total",
+	}, []string{"client", "method"})
+	QuotaUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "client_quota_used",
+		Help:      "Requests counted against client quota in current period",
+	}, []string{"client"})
+	QuotaRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejected_total",
+		Help:      "Requests rejected because client quota is exceeded total",
+	}, []string{"client"})
+	ResponseCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "response_cache_requests_total",
+		Help:      "Requests of cacheable methods by cache result total",
+	}, []string{"rpc_name", "method", "result"})
+	RequestSLO = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_slo_total",
+		Help:      "Requests by whether their latency is within slo target of rpc total, counted only if target is set",
+	}, []string{"rpc_name", "within_slo"})
+	MethodDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "method_denied_total",
+		Help:      "Requests rejected because method is not allowed for client total",
+	}, []string{"client", "method"})
+	providerStats     = newProviderStatsCollector()
+	WSKeepaliveClosed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_keepalive_closed_total",
+		Help:      "Websocket connections closed by keepalive because pong was not received total",
+	}, []string{"rpc_name", "side"})
+	WSActiveConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_active_connections",
+		Help:      "Current websocket sessions per provider",
+	}, []string{"rpc_name", "provider"})
+	WSMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_total",
+		Help:      "Websocket messages proxied between client and provider by direction total",
+	}, []string{"rpc_name", "provider", "direction"})
+	ShutdownDurationSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "shutdown_duration_seconds",
+		Help:      "Time taken by component to stop during graceful shutdown in seconds",
+	}, []string{"component"})
+	ShutdownTimeoutExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shutdown_timeout_exceeded_total",
+		Help:      "Components not stopped within shutdown timeout total",
+	}, []string{"component"})
+	PanicTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panic_total",
+		Help:      "Panics recovered while serving requests total by middleware layer",
+	}, []string{"layer"})
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build of running rpcgate, value is always 1",
+	}, []string{"version", "commit", "date", "go_version"})
+
+	// providerTagLabels are provider tag keys appended to labels of provider metrics.
+	providerTagLabels []string
+	// disabledLabels are labels dropped from provider metrics to cut cardinality.
+	disabledLabels map[string]bool
+	// exactStatusCode labels metrics with exact status code instead of its family.
+	exactStatusCode bool
+	// knownErrorCodes are json-rpc error codes exported as is, others are exported as OtherErrorCode.
+	knownErrorCodes = map[int64]struct{}{
+		3:      {}, // execution reverted
+		-32000: {}, -32001: {}, -32002: {}, -32003: {}, -32004: {}, -32005: {}, -32006: {}, -32010: {}, -32015: {},
+		-32600: {}, -32601: {}, -32602: {}, -32603: {}, -32700: {},
+	}
+)
+
+// requestLabels are labels of provider request metrics.
+func requestLabels(tagLabels []string) []string {
+	return append([]string{"chain_id", "rpc_name", "transport", "provider", "balancer", "method", "client"}, tagLabels...)
+}
+
+func newRequestLatencySeconds(tagLabels []string, buckets []float64) *HistogramVec {
+	return newHistogramVec(prometheus.HistogramOpts{
 		Namespace: namespace,
 		Name:      "request_latency_seconds",
 		Help:      "Request latency distribution in seconds",
-		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5},
-	}, []string{"chain_id", "rpc_name", "provider", "balancer", "method", "client"})
-	RequestTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Buckets:   buckets,
+	}, append([]string{"chain_id", "rpc_name", "provider", "balancer", "method", "client"}, tagLabels...))
+}
+
+func newUpstreamLatencySeconds(tagLabels []string, buckets []float64) *HistogramVec {
+	return newHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_latency_seconds",
+		Help:      "Provider response latency distribution in seconds, excluding gateway processing",
+		Buckets:   buckets,
+	}, append([]string{"chain_id", "rpc_name", "provider", "balancer", "method", "client"}, tagLabels...))
+}
+
+func newBatchMethodLatencySeconds(tagLabels []string, buckets []float64) *HistogramVec {
+	return newHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_method_latency_seconds",
+		Help: "Estimated latency distribution in seconds of requests in batches by method. " +
+			"Provider serves batch as a single call, so latency of batch is divided equally between its requests; " +
+			"exact latency of whole batch is request_latency_seconds with method batch",
+		Buckets: buckets,
+	}, append([]string{"chain_id", "rpc_name", "provider", "balancer", "method", "client"}, tagLabels...))
+}
+
+func newRequestTotalCounter(tagLabels []string) *CounterVec {
+	return newCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "request_total",
 		Help:      "Request total",
-	}, []string{"chain_id", "rpc_name", "transport", "provider", "balancer", "method", "client"})
-	RequestError = prometheus.NewCounterVec(prometheus.CounterOpts{
+	}, append(requestLabels(tagLabels), "status_code"))
+}
+
+func newRequestError(tagLabels []string) *CounterVec {
+	return newCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "request_error_total",
 		Help:      "Request error total",
-	}, []string{"chain_id", "rpc_name", "transport", "provider", "balancer", "method", "client"})
-	ClientRequestError = prometheus.NewCounterVec(prometheus.CounterOpts{
+	}, append(requestLabels(tagLabels), "status_code"))
+}
+
+func newClientRequestError(tagLabels []string) *CounterVec {
+	return newCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "client_request_error_total",
 		Help:      "Client request error total",
-	}, []string{"chain_id", "rpc_name", "transport", "provider", "balancer", "method", "client"})
-	ResponseSizeBytes = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	}, append(requestLabels(tagLabels), "error_code"))
+}
+
+func newResponseSizeBytes(tagLabels []string) *SummaryVec {
+	return newSummaryVec(prometheus.SummaryOpts{
 		Namespace: namespace,
 		Name:      "response_size_bytes",
 		Help:      "Response size bytes gauge",
-	}, []string{"chain_id", "rpc_name", "transport", "provider", "balancer", "method", "client"})
-	WSConnTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	}, requestLabels(tagLabels))
+}
+
+func newWSConnTotalCounter(tagLabels []string) *CounterVec {
+	return newCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "ws_connection_total",
 		Help:      "Websocket Connection total",
-	}, []string{"chain_id", "rpc_name", "provider", "balancer", "client"})
-)
+	}, append([]string{"chain_id", "rpc_name", "provider", "balancer", "client"}, tagLabels...))
+}
+
+// setProviderMetrics recreates provider metrics from config: tag labels are appended to their labels,
+// disabled labels are dropped and latency histograms get configured buckets. Must be called before metrics
+// are registered and observed.
+func setProviderMetrics(cfg config.Metrics) {
+	tagLabels := cfg.ProviderTagLabels
+	buckets := cfg.LatencyBuckets
+	if len(buckets) == 0 {
+		buckets = defaultLatencyBuckets
+	}
+
+	providerTagLabels = tagLabels
+	disabledLabels = make(map[string]bool)
+	for label, enabled := range cfg.Labels {
+		if !enabled {
+			disabledLabels[label] = true
+		}
+	}
+	RequestLatencySeconds = newRequestLatencySeconds(tagLabels, buckets)
+	UpstreamLatencySeconds = newUpstreamLatencySeconds(tagLabels, buckets)
+	BatchMethodLatencySeconds = newBatchMethodLatencySeconds(tagLabels, buckets)
+	RequestTotalCounter = newRequestTotalCounter(tagLabels)
+	RequestError = newRequestError(tagLabels)
+	ClientRequestError = newClientRequestError(tagLabels)
+	ResponseSizeBytes = newResponseSizeBytes(tagLabels)
+	WSConnTotalCounter = newWSConnTotalCounter(tagLabels)
+}
+
+// ProviderLabels returns label values of provider metric with values of allowlisted provider tags appended.
+// Tags missing for provider have empty values.
+func ProviderLabels(tags map[string]string, values ...string) []string {
+	for _, label := range providerTagLabels {
+		values = append(values, tags[label])
+	}
+	return values
+}
+
+// StatusCodeLabel returns status code label value, status family like 2xx
+// unless exact status codes are enabled.
+func StatusCodeLabel(code int) string {
+	if exactStatusCode {
+		return strconv.Itoa(code)
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// ErrorCodeLabel returns error code label value, codes outside of known set are mapped to other,
+// so cardinality stays bounded whatever providers return.
+func ErrorCodeLabel(code int64) string {
+	const base = 10
+	if _, known := knownErrorCodes[code]; known {
+		return strconv.FormatInt(code, base)
+	}
+	return OtherErrorCode
+}
 
 type Server struct {
 	srv *http.Server
 }
 
 func New(cfg config.Config) *Server {
+	setProviderMetrics(cfg.Metrics)
+	exactStatusCode = cfg.Metrics.ExactStatusCode
+
 	reg := prometheus.NewRegistry()
 	reg.MustRegister(
 		collectors.NewGoCollector(),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		RequestLatencySeconds,
+		UpstreamLatencySeconds,
+		BatchMethodLatencySeconds,
 		RequestTotalCounter,
 		RequestError,
 		ClientRequestError,
 		ResponseSizeBytes,
+		RequestSLO,
+		UpstreamConcurrency,
+		ProviderInFlight,
+		ConcurrencyLimitRejected,
+		RateLimitRejected,
+		MethodRateLimited,
+		MethodDenied,
+		ResponseCacheRequests,
+		QuotaUsed,
+		QuotaRejected,
+		ProviderEjected,
+		ProviderBlockLag,
+		ProviderSelected,
+		providerStats,
+		WSKeepaliveClosed,
+		WSActiveConnections,
+		WSMessages,
+		ShutdownDurationSeconds,
+		ShutdownTimeoutExceeded,
+		PanicTotal,
+		BuildInfo,
 	)
+	build := buildinfo.Get()
+	BuildInfo.WithLabelValues(build.Version, build.Commit, build.Date, build.GoVersion).Set(1)
+
 	m := http.NewServeMux()
 
-	m.Handle(cfg.Metrics.Path, promhttp.HandlerFor(
+	m.Handle(cfg.Metrics.Path, authHandler(cfg.Metrics.Auth, promhttp.HandlerFor(
 		reg,
 		promhttp.HandlerOpts{
 			ErrorLog:          &promLogger{},
 			EnableOpenMetrics: true,
 		},
-	))
+	)))
 	return &Server{
 		srv: &http.Server{
 			Addr:              fmt.Sprintf(":%d", cfg.Metrics.Port),
@@ -105,6 +371,11 @@ func (s *Server) Start(ctx context.Context) {
 	log.Ctx(ctx).Info().Msg("Metrics server started")
 }
 
+// Name returns name of metrics server in shutdown logs and metrics.
+func (s *Server) Name() string {
+	return "metrics"
+}
+
 func (s *Server) Stop() {
 	err := s.srv.Shutdown(context.Background())
 	if err != nil {
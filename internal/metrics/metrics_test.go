@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_New_providerTagLabels(t *testing.T) {
+	t.Cleanup(func() { setProviderMetrics(config.Metrics{}) })
+
+	srv := New(config.Config{Metrics: config.Metrics{
+		Path:              "/metrics",
+		ProviderTagLabels: []string{"region"},
+	}})
+	tags := map[string]string{"region": "eu", "vendor": "infura"}
+	RequestTotalCounter.WithLabelValues(append(
+		ProviderLabels(tags, "1", "mainnet", HTTPTransport, "node", "rr", "eth_blockNumber", ""),
+		StatusCodeLabel(http.StatusOK),
+	)...).Inc()
+
+	rec := httptest.NewRecorder()
+	srv.srv.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `provider="node",region="eu"`)
+	require.Contains(t, string(body), `status_code="2xx"`)
+	require.NotContains(t, string(body), "vendor")
+}
+
+func Test_New_labels(t *testing.T) {
+	t.Cleanup(func() { setProviderMetrics(config.Metrics{}) })
+
+	srv := New(config.Config{Metrics: config.Metrics{
+		Path:              "/metrics",
+		ProviderTagLabels: []string{"region"},
+		Labels:            map[string]bool{"client": false, "method": false, "balancer": true},
+	}})
+	// values of disabled labels are still passed and skipped.
+	tags := map[string]string{"region": "eu"}
+	RequestTotalCounter.WithLabelValues(append(
+		ProviderLabels(tags, "1", "mainnet", HTTPTransport, "node", "rr", "eth_blockNumber", "alice"),
+		StatusCodeLabel(http.StatusOK),
+	)...).Inc()
+	RequestLatencySeconds.WithLabelValues(ProviderLabels(tags, "1", "mainnet", "node", "rr", "eth_call", "bob")...).
+		Observe(0.1)
+	ResponseSizeBytes.WithLabelValues(
+		ProviderLabels(tags, "1", "mainnet", HTTPTransport, "node", "rr", "eth_call", "bob")...,
+	).Observe(10)
+
+	rec := httptest.NewRecorder()
+	srv.srv.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body),
+		`rpcgate_request_total{balancer="rr",chain_id="1",provider="node",region="eu",rpc_name="mainnet",`+
+			`status_code="2xx",transport="http"} 1`)
+	require.Contains(t, string(body), `rpcgate_request_latency_seconds_count{balancer="rr",chain_id="1"`)
+	require.NotContains(t, string(body), "client=")
+	require.NotContains(t, string(body), "method=")
+}
+
+func Test_New_auth(t *testing.T) {
+	t.Cleanup(func() { setProviderMetrics(config.Metrics{}) })
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	testCases := []struct {
+		name  string
+		auth  config.MetricsAuth
+		valid func(r *http.Request)
+		wrong func(r *http.Request)
+	}{
+		{
+			name:  "basic",
+			auth:  config.MetricsAuth{Type: config.MetricsAuthBasic, Login: "prometheus", Password: "secret"},
+			valid: func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") },
+			wrong: func(r *http.Request) { r.SetBasicAuth("prometheus", "guess") },
+		},
+		{
+			name:  "basic with hash",
+			auth:  config.MetricsAuth{Type: config.MetricsAuthBasic, Login: "prometheus", Password: string(hash)},
+			valid: func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") },
+			wrong: func(r *http.Request) { r.SetBasicAuth("grafana", "secret") },
+		},
+		{
+			name:  "bearer",
+			auth:  config.MetricsAuth{Type: config.MetricsAuthBearer, Token: "token"},
+			valid: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			wrong: func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(config.Config{Metrics: config.Metrics{Path: "/metrics", Auth: tc.auth}})
+			scrape := func(auth func(r *http.Request)) int {
+				req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				if auth != nil {
+					auth(req)
+				}
+				rec := httptest.NewRecorder()
+				srv.srv.Handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+			require.Equal(t, http.StatusUnauthorized, scrape(nil))
+			require.Equal(t, http.StatusUnauthorized, scrape(tc.wrong))
+			require.Equal(t, http.StatusOK, scrape(tc.valid))
+		})
+	}
+
+	// metrics are open without auth.
+	srv := New(config.Config{Metrics: config.Metrics{Path: "/metrics"}})
+	rec := httptest.NewRecorder()
+	srv.srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_New_latencyBuckets(t *testing.T) {
+	t.Cleanup(func() { setProviderMetrics(config.Metrics{}) })
+
+	srv := New(config.Config{Metrics: config.Metrics{Path: "/metrics", LatencyBuckets: []float64{0.001, 0.005, 30}}})
+	RequestLatencySeconds.WithLabelValues("1", "mainnet", "node", "rr", "eth_call", "").Observe(0.002)
+	UpstreamLatencySeconds.WithLabelValues("1", "mainnet", "node", "rr", "eth_call", "").Observe(20)
+
+	rec := httptest.NewRecorder()
+	srv.srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	require.Contains(t, body, `rpcgate_request_latency_seconds_bucket{balancer="rr",chain_id="1",client="",`+
+		`method="eth_call",provider="node",rpc_name="mainnet",le="0.005"} 1`)
+	require.Contains(t, body, `rpcgate_upstream_latency_seconds_bucket{balancer="rr",chain_id="1",client="",`+
+		`method="eth_call",provider="node",rpc_name="mainnet",le="30"} 1`)
+	require.NotContains(t, body, `rpc_name="mainnet",le="0.25"`)
+}
+
+func Test_StatusCodeLabel(t *testing.T) {
+	t.Cleanup(func() { exactStatusCode = false })
+
+	New(config.Config{Metrics: config.Metrics{Path: "/metrics"}})
+	require.Equal(t, "2xx", StatusCodeLabel(http.StatusOK))
+	require.Equal(t, "4xx", StatusCodeLabel(http.StatusTooManyRequests))
+	require.Equal(t, "5xx", StatusCodeLabel(http.StatusBadGateway))
+
+	New(config.Config{Metrics: config.Metrics{Path: "/metrics", ExactStatusCode: true}})
+	require.Equal(t, "429", StatusCodeLabel(http.StatusTooManyRequests))
+	require.Equal(t, "502", StatusCodeLabel(http.StatusBadGateway))
+}
+
+func Test_ErrorCodeLabel(t *testing.T) {
+	require.Equal(t, "-32000", ErrorCodeLabel(-32000))
+	require.Equal(t, "-32601", ErrorCodeLabel(-32601))
+	require.Equal(t, "3", ErrorCodeLabel(3))
+	require.Equal(t, OtherErrorCode, ErrorCodeLabel(-32099))
+	require.Equal(t, OtherErrorCode, ErrorCodeLabel(12345))
+}
+
+func Test_RegisterProviderStats(t *testing.T) {
+	global := providerStats
+	providerStats = newProviderStatsCollector()
+	t.Cleanup(func() { providerStats = global })
+
+	RegisterProviderStats("1", "mainnet", func() []balancer.ProviderStats {
+		return []balancer.ProviderStats{
+			{Name: "fast", EWMAMS: 40, Healthy: true},
+			{Name: "failed", EWMAMS: 120, Penalty: 0.5, CooldownRemaining: 3 * time.Second, Drained: true},
+		}
+	})
+
+	expected := `
+# HELP rpcgate_provider_cooldown_remaining_seconds Time left until provider leaves cooldown of p2cewma balancer in seconds
+# TYPE rpcgate_provider_cooldown_remaining_seconds gauge
+rpcgate_provider_cooldown_remaining_seconds{chain_id="1",provider="failed",rpc_name="mainnet"} 3
+rpcgate_provider_cooldown_remaining_seconds{chain_id="1",provider="fast",rpc_name="mainnet"} 0
+# HELP rpcgate_provider_drained 1 if provider is drained from p2cewma balancer, 0 otherwise
+# TYPE rpcgate_provider_drained gauge
+rpcgate_provider_drained{chain_id="1",provider="failed",rpc_name="mainnet"} 1
+rpcgate_provider_drained{chain_id="1",provider="fast",rpc_name="mainnet"} 0
+# HELP rpcgate_provider_healthy 1 if provider is not in cooldown of p2cewma balancer, 0 otherwise
+# TYPE rpcgate_provider_healthy gauge
+rpcgate_provider_healthy{chain_id="1",provider="failed",rpc_name="mainnet"} 0
+rpcgate_provider_healthy{chain_id="1",provider="fast",rpc_name="mainnet"} 1
+# HELP rpcgate_provider_penalty Error penalty of provider used by p2cewma balancer
+# TYPE rpcgate_provider_penalty gauge
+rpcgate_provider_penalty{chain_id="1",provider="failed",rpc_name="mainnet"} 0.5
+rpcgate_provider_penalty{chain_id="1",provider="fast",rpc_name="mainnet"} 0
+# HELP rpcgate_provider_ewma_ms Latency EWMA of provider in milliseconds used by p2cewma balancer
+# TYPE rpcgate_provider_ewma_ms gauge
+rpcgate_provider_ewma_ms{chain_id="1",provider="failed",rpc_name="mainnet"} 120
+rpcgate_provider_ewma_ms{chain_id="1",provider="fast",rpc_name="mainnet"} 40
+`
+	require.NoError(t, testutil.CollectAndCompare(providerStats, strings.NewReader(expected)))
+}
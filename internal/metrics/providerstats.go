@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+)
+
+// ProviderStatsSource returns current stats of p2cewma providers of rpc.
+type ProviderStatsSource func() []balancer.ProviderStats
+
+type providerStatsSource struct {
+	chainID string
+	rpcName string
+	stats   ProviderStatsSource
+}
+
+// providerStatsCollector exports p2cewma view of providers, stats are read from balancers on every scrape,
+// so balancing itself does not update any metrics.
+type providerStatsCollector struct {
+	ewmaMS            *prometheus.Desc
+	penalty           *prometheus.Desc
+	healthy           *prometheus.Desc
+	cooldownRemaining *prometheus.Desc
+	drained           *prometheus.Desc
+
+	mutex   sync.Mutex
+	sources []providerStatsSource
+}
+
+func newProviderStatsCollector() *providerStatsCollector {
+	labels := []string{"chain_id", "rpc_name", "provider"}
+	return &providerStatsCollector{
+		ewmaMS: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "provider_ewma_ms"),
+			"Latency EWMA of provider in milliseconds used by p2cewma balancer", labels, nil),
+		penalty: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "provider_penalty"),
+			"Error penalty of provider used by p2cewma balancer", labels, nil),
+		healthy: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "provider_healthy"),
+			"1 if provider is not in cooldown of p2cewma balancer, 0 otherwise", labels, nil),
+		cooldownRemaining: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "provider_cooldown_remaining_seconds"),
+			"Time left until provider leaves cooldown of p2cewma balancer in seconds", labels, nil),
+		drained: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "provider_drained"),
+			"1 if provider is drained from p2cewma balancer, 0 otherwise", labels, nil),
+	}
+}
+
+// RegisterProviderStats adds source of provider stats of rpc collected on every scrape.
+func RegisterProviderStats(chainID, rpcName string, stats ProviderStatsSource) {
+	providerStats.mutex.Lock()
+	defer providerStats.mutex.Unlock()
+
+	providerStats.sources = append(providerStats.sources, providerStatsSource{
+		chainID: chainID,
+		rpcName: rpcName,
+		stats:   stats,
+	})
+}
+
+func (c *providerStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.ewmaMS
+	ch <- c.penalty
+	ch <- c.healthy
+	ch <- c.cooldownRemaining
+	ch <- c.drained
+}
+
+func (c *providerStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	sources := c.sources
+	c.mutex.Unlock()
+
+	for _, source := range sources {
+		for _, stats := range source.stats() {
+			labels := []string{source.chainID, source.rpcName, stats.Name}
+			healthy, drained := 0.0, 0.0
+			if stats.Healthy {
+				healthy = 1
+			}
+			if stats.Drained {
+				drained = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.ewmaMS, prometheus.GaugeValue, stats.EWMAMS, labels...)
+			ch <- prometheus.MustNewConstMetric(c.penalty, prometheus.GaugeValue, stats.Penalty, labels...)
+			ch <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, healthy, labels...)
+			ch <- prometheus.MustNewConstMetric(c.cooldownRemaining, prometheus.GaugeValue,
+				stats.CooldownRemaining.Seconds(), labels...)
+			ch <- prometheus.MustNewConstMetric(c.drained, prometheus.GaugeValue, drained, labels...)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// addAccessField adds field of access log with passed name to event, unknown fields are skipped.
+// Method of batch request is logged as batch, like name of its span.
+func addAccessField(
+	event *zerolog.Event,
+	field string,
+	ctx *fasthttp.RequestCtx,
+	reqctx *ReqCtx,
+	latency time.Duration,
+) *zerolog.Event {
+	switch field {
+	case config.AccessFieldRequestID:
+		return event.Uint64(field, ctx.ID())
+	case config.AccessFieldConnID:
+		return event.Uint64(field, ctx.ConnID())
+	case config.AccessFieldRemoteIP:
+		return event.Str(field, ctx.RemoteIP().String())
+	case config.AccessFieldStatus:
+		return event.Int(field, ctx.Response.StatusCode())
+	case config.AccessFieldLatency:
+		return event.Str(field, latency.String())
+	case config.AccessFieldPath:
+		return event.Str(field, string(ctx.Path()))
+	case config.AccessFieldClient:
+		return event.Str(field, reqctx.Client)
+	case config.AccessFieldProvider:
+		return event.Str(field, reqctx.Provider)
+	case config.AccessFieldRequestBytes:
+		return event.Int(field, len(ctx.Request.Body()))
+	case config.AccessFieldResponseBytes:
+		return event.Int(field, responseSize(ctx))
+	case config.AccessFieldMethod:
+		switch {
+		case reqctx.Batch:
+			return event.Str(field, batchSpanName)
+		case len(reqctx.Request) == 1:
+			return event.Str(field, reqctx.Request[0].Method)
+		}
+		return event
+	case config.AccessFieldUserAgent:
+		return event.Str(field, string(ctx.UserAgent()))
+	}
+	return event
+}
+
+const (
+	redactedParams = `"[redacted]"`
+	truncatedTail  = "...(truncated)"
+)
+
+// logBodies logs request and response bodies at debug level if it is enabled by logger config,
+// only requests served by configured providers are logged and they are sampled by configured ratio.
+func (srv *Server) logBodies(ctx *fasthttp.RequestCtx, reqctx *ReqCtx) {
+	if !srv.loggerCfg.LogBodies {
+		return
+	}
+	event := log.Debug()
+	if !event.Enabled() || !srv.bodiesLogged(reqctx.Provider) {
+		return
+	}
+	requestBody := srv.redactedRequestBody(ctx.Request.Body(), reqctx)
+	var responseBody []byte
+	if !ctx.Response.IsBodyStream() {
+		// streamed body is not logged, it would be buffered then.
+		responseBody = ctx.Response.Body()
+	}
+	event.
+		Uint64("request_id", ctx.ID()).
+		Str("request_body", truncateBody(requestBody, srv.loggerCfg.MaxBodyBytes)).
+		Str("response_body", truncateBody(responseBody, srv.loggerCfg.MaxBodyBytes)).
+		Msg("request bodies")
+}
+
+// bodiesLogged decides whether bodies of request served by provider are logged. Fallback provider,
+// named as fallback/<rpc>/<provider>, is matched by its own name.
+func (srv *Server) bodiesLogged(provider string) bool {
+	if providers := srv.loggerCfg.LogBodiesProviders; len(providers) > 0 {
+		if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+			_, provider, _ = strings.Cut(key, "/")
+		}
+		if !slices.Contains(providers, provider) {
+			return false
+		}
+	}
+	ratio := srv.loggerCfg.LogBodiesSampleRatio
+	return ratio <= 0 || ratio >= 1 || rand.Float64() < ratio //nolint:gosec // unnecessary
+}
+
+// redactedRequestBody returns request body with params of redacted methods replaced.
+// Body which was not fully parsed is redacted as a whole if it mentions any of redacted methods.
+func (srv *Server) redactedRequestBody(body []byte, reqctx *ReqCtx) []byte {
+	redacted := srv.loggerCfg.RedactedMethods
+	if len(redacted) == 0 {
+		return body
+	}
+	if len(reqctx.Request) == 0 || slices.ContainsFunc(reqctx.Request, func(req JSONRPCRequest) bool {
+		return req.Method == ""
+	}) {
+		for _, method := range redacted {
+			if bytes.Contains(body, []byte(method)) {
+				return []byte(redactedParams)
+			}
+		}
+		return body
+	}
+	if !slices.ContainsFunc(reqctx.Request, func(req JSONRPCRequest) bool {
+		return slices.Contains(redacted, req.Method)
+	}) {
+		return body
+	}
+
+	type request struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id,omitempty"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params,omitempty"`
+	}
+	requests := make([]request, 0, len(reqctx.Request))
+	for _, req := range reqctx.Request {
+		params := req.Params
+		if slices.Contains(redacted, req.Method) {
+			params = json.RawMessage(redactedParams)
+		}
+		requests = append(requests, request{JSONRPC: "2.0", ID: req.ID, Method: req.Method, Params: params})
+	}
+	var (
+		raw []byte
+		err error
+	)
+	if reqctx.Batch {
+		raw, err = json.Marshal(requests)
+	} else {
+		raw, err = json.Marshal(requests[0])
+	}
+	if err != nil {
+		return []byte(redactedParams)
+	}
+	return raw
+}
+
+// truncateBody returns body as string cut to limit bytes.
+func truncateBody(body []byte, limit int) string {
+	if limit <= 0 || len(body) <= limit {
+		return string(body)
+	}
+	return string(body[:limit]) + truncatedTail
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// adminProviderPath is prefix of provider admin endpoints: /admin/provider/{rpc}/{name}/{action}.
+const adminProviderPath = "/admin/provider/"
+
+const (
+	adminDrain   = "drain"
+	adminUndrain = "undrain"
+)
+
+// drainer is implemented by balancers which can stop borrowing of provider without removing it.
+type drainer interface {
+	Drain(name string) bool
+	Undrain(name string) bool
+}
+
+// drainResponse is response of drain and undrain endpoints, Changed is false if provider
+// already was in requested state.
+type drainResponse struct {
+	RPC      string `json:"rpc"`
+	Provider string `json:"provider"`
+	Drained  bool   `json:"drained"`
+	Changed  bool   `json:"changed"`
+}
+
+// newAdminServer returns server of admin endpoints and address it listens on, nil if admin is disabled.
+func (srv *Server) newAdminServer(cfg config.Admin) (*fasthttp.Server, string) {
+	if !cfg.Enabled {
+		return nil, ""
+	}
+	return &fasthttp.Server{
+		Handler: adminAuthMiddleware(cfg.Auth, srv.adminHandler),
+	}, net.JoinHostPort(cfg.Host, strconv.FormatInt(cfg.Port, 10))
+}
+
+// adminAuthMiddleware answers 401 to requests without credentials of cfg, checked like credentials of metrics
+// scrapers. Next is returned as is if auth is disabled.
+func adminAuthMiddleware(cfg config.MetricsAuth, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	var authorized func(header string) bool
+	switch cfg.Type {
+	case config.MetricsAuthBasic:
+		passwords := newPasswordChecker()
+		authorized = func(header string) bool {
+			if !strings.HasPrefix(header, "Basic ") {
+				return false
+			}
+			login, pass, err := GetBasicAuthDecoded(header)
+			return err == nil && subtle.ConstantTimeCompare([]byte(login), []byte(cfg.Login)) == 1 &&
+				passwords.check(login, cfg.Password, pass)
+		}
+	case config.MetricsAuthBearer:
+		authorized = func(header string) bool {
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			return ok && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) == 1
+		}
+	default:
+		return next
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		if !authorized(string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))) {
+			// error resets response, so challenge is set after it.
+			ctx.Error("unauthorized", fasthttp.StatusUnauthorized)
+			if cfg.Type == config.MetricsAuthBasic {
+				ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Basic realm="admin"`)
+			} else {
+				ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, "Bearer")
+			}
+			return
+		}
+		next(ctx)
+	}
+}
+
+// adminHandler serves POST /admin/provider/{rpc}/{name}/drain and .../undrain. Drained provider is skipped
+// by balancer for new requests, in-flight ones are finished and stats of provider are kept.
+func (srv *Server) adminHandler(ctx *fasthttp.RequestCtx) {
+	rest, ok := strings.CutPrefix(string(ctx.Path()), adminProviderPath)
+	if !ok {
+		ctx.Error("not found", fasthttp.StatusNotFound)
+		return
+	}
+	ix := strings.LastIndexByte(rest, '/')
+	rpcName, name, ok := strings.Cut(rest[:max(ix, 0)], "/")
+	action := rest[ix+1:]
+	if !ok || (action != adminDrain && action != adminUndrain) {
+		ctx.Error("not found", fasthttp.StatusNotFound)
+		return
+	}
+	if !ctx.IsPost() {
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, fasthttp.MethodPost)
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	lb, _ := srv.getBalancer("/" + rpcName)
+	d, ok := lb.(drainer)
+	if !ok || !srv.hasProvider(rpcName, name) {
+		ctx.Error("provider not found", fasthttp.StatusNotFound)
+		return
+	}
+
+	resp := drainResponse{RPC: rpcName, Provider: name, Drained: action == adminDrain}
+	if resp.Drained {
+		resp.Changed = d.Drain(name)
+	} else {
+		resp.Changed = d.Undrain(name)
+	}
+	if resp.Changed {
+		log.Info().Str("rpc", rpcName).Str("provider", name).Bool("drained", resp.Drained).
+			Msg("provider drain state changed")
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal drain response")
+		ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.SetBody(raw)
+}
+
+// hasProvider returns true if rpc has provider with passed name in config.
+func (srv *Server) hasProvider(rpcName, name string) bool {
+	ix := slices.IndexFunc(srv.rpcs, func(rpc config.RPC) bool { return rpc.Name == rpcName })
+	if ix == -1 {
+		return false
+	}
+	return slices.ContainsFunc(srv.rpcs[ix].Providers, func(p config.Provider) bool { return p.Name == name })
+}
+
+// serveAdmin starts admin server if it is enabled.
+func (srv *Server) serveAdmin(ctx context.Context) {
+	if srv.adminSrv == nil {
+		return
+	}
+	go func() {
+		if err := srv.adminSrv.ListenAndServe(srv.adminAddr); err != nil {
+			log.Ctx(ctx).Panic().Err(err).Msg("Admin server failed to start")
+		}
+	}()
+}
+
+// shutdownAdmin stops admin server if it is enabled.
+func (srv *Server) shutdownAdmin(ctx context.Context) error {
+	if srv.adminSrv == nil {
+		return nil
+	}
+	return srv.adminSrv.ShutdownWithContext(ctx)
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_adminHandler(t *testing.T) {
+	srv := New(config.Config{
+		Admin: config.Admin{Enabled: true, Port: 9091},
+		RPCs: []config.RPC{{
+			Name:            "mainnet",
+			GlobalRPCConfig: config.GlobalRPCConfig{BalancerType: config.RRName},
+			Providers: []config.Provider{
+				{Name: "alchemy", ConnURL: "http://alchemy"},
+				{Name: "infura", ConnURL: "http://infura"},
+			},
+		}},
+	})
+	require.NotNil(t, srv.adminSrv)
+	serve := func(method, path string) (int, string) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(path)
+		srv.adminHandler(ctx)
+		return ctx.Response.StatusCode(), string(ctx.Response.Body())
+	}
+
+	status, body := serve(fasthttp.MethodPost, "/admin/provider/mainnet/infura/drain")
+	require.Equal(t, fasthttp.StatusOK, status)
+	require.JSONEq(t, `{"rpc":"mainnet","provider":"infura","drained":true,"changed":true}`, body)
+	_, body = serve(fasthttp.MethodPost, "/admin/provider/mainnet/infura/drain")
+	require.JSONEq(t, `{"rpc":"mainnet","provider":"infura","drained":true,"changed":false}`, body)
+
+	lb, _ := srv.getBalancer("/mainnet")
+	for range 4 {
+		p, release := lb.Borrow()
+		require.Equal(t, "alchemy", p.Name)
+		release(true, 0)
+	}
+	require.Equal(t, []string{"infura"}, srv.detailedHealth().RPCs["mainnet"].Unhealthy)
+
+	status, body = serve(fasthttp.MethodPost, "/admin/provider/mainnet/infura/undrain")
+	require.Equal(t, fasthttp.StatusOK, status)
+	require.JSONEq(t, `{"rpc":"mainnet","provider":"infura","drained":false,"changed":true}`, body)
+	require.Equal(t, healthOK, srv.detailedHealth().RPCs["mainnet"].Status)
+
+	status, _ = serve(fasthttp.MethodGet, "/admin/provider/mainnet/infura/drain")
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, status)
+	for _, path := range []string{
+		"/admin/provider/mainnet/unknown/drain",
+		"/admin/provider/unknown/infura/drain",
+		"/admin/provider/mainnet/infura/eject",
+		"/admin/provider/mainnet",
+		"/metrics",
+	} {
+		status, _ = serve(fasthttp.MethodPost, path)
+		require.Equal(t, fasthttp.StatusNotFound, status, path)
+	}
+}
+
+func Test_newAdminServer(t *testing.T) {
+	srv := &Server{}
+	adminSrv, addr := srv.newAdminServer(config.Admin{Enabled: true, Port: 9091, Host: "127.0.0.1"})
+	require.NotNil(t, adminSrv)
+	require.Equal(t, "127.0.0.1:9091", addr)
+
+	adminSrv, _ = srv.newAdminServer(config.Admin{Port: 9091})
+	require.Nil(t, adminSrv)
+}
+
+func Test_adminAuthMiddleware(t *testing.T) {
+	basic := func(login, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(login+":"+pass))
+	}
+	testCases := []struct {
+		name       string
+		auth       config.MetricsAuth
+		header     string
+		authorized bool
+	}{
+		{name: "disabled", authorized: true},
+		{
+			name:       "basic",
+			auth:       config.MetricsAuth{Type: config.MetricsAuthBasic, Login: "admin", Password: "secret"},
+			header:     basic("admin", "secret"),
+			authorized: true,
+		},
+		{
+			name:   "basic wrong password",
+			auth:   config.MetricsAuth{Type: config.MetricsAuthBasic, Login: "admin", Password: "secret"},
+			header: basic("admin", "other"),
+		},
+		{
+			name:   "basic wrong login",
+			auth:   config.MetricsAuth{Type: config.MetricsAuthBasic, Login: "admin", Password: "secret"},
+			header: basic("other", "secret"),
+		},
+		{
+			name: "basic missing",
+			auth: config.MetricsAuth{Type: config.MetricsAuthBasic, Login: "admin", Password: "secret"},
+		},
+		{
+			name:       "bearer",
+			auth:       config.MetricsAuth{Type: config.MetricsAuthBearer, Token: "token"},
+			header:     "Bearer token",
+			authorized: true,
+		},
+		{
+			name:   "bearer wrong token",
+			auth:   config.MetricsAuth{Type: config.MetricsAuthBearer, Token: "token"},
+			header: "Bearer other",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &fasthttp.RequestCtx{}
+			if tc.header != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAuthorization, tc.header)
+			}
+			adminAuthMiddleware(tc.auth, func(ctx *fasthttp.RequestCtx) {
+				ctx.SetStatusCode(fasthttp.StatusNoContent)
+			})(ctx)
+			if tc.authorized {
+				require.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+				return
+			}
+			require.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+			require.NotEmpty(t, ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// translateMethods replaces aliased methods of parsed requests with their canonical names in place
+// and returns request body with methods replaced. Fields other than method are kept as sent by client.
+// Returns false if no request method is aliased.
+func translateMethods(
+	body []byte,
+	requests []JSONRPCRequest,
+	batch bool,
+	aliases map[string]string,
+) ([]byte, bool, error) {
+	translated := false
+	for _, req := range requests {
+		if _, ok := aliases[req.Method]; ok {
+			translated = true
+			break
+		}
+	}
+	if !translated {
+		return body, false, nil
+	}
+
+	var raw []map[string]json.RawMessage
+	if batch {
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, false, err
+		}
+	} else {
+		raw = make([]map[string]json.RawMessage, 1)
+		if err := json.Unmarshal(body, &raw[0]); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(raw) != len(requests) {
+		return nil, false, errors.New("request count mismatched")
+	}
+	for i := range raw {
+		var method string
+		if err := json.Unmarshal(raw[i]["method"], &method); err != nil {
+			continue
+		}
+		canonical, ok := aliases[method]
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(canonical)
+		if err != nil {
+			return nil, false, err
+		}
+		raw[i]["method"] = encoded
+		requests[i].Method = canonical
+	}
+
+	var (
+		result []byte
+		err    error
+	)
+	if batch {
+		result, err = json.Marshal(raw)
+	} else {
+		result, err = json.Marshal(raw[0])
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return result, true, nil
+}
+
+// methodAliasMiddleware translates aliased methods to canonical names configured for rpc,
+// so providers, method acl and metrics see canonical names only.
+func (srv *Server) methodAliasMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if len(srv.nameToMethodAliases) == 0 {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		aliases, exist := srv.nameToMethodAliases[string(ctx.Path())]
+		if !exist {
+			next(ctx)
+			return
+		}
+
+		reqctx := GetReqCtx(ctx)
+		body, translated, err := translateMethods(ctx.Request.Body(), reqctx.Request, reqctx.Batch, aliases)
+		if err != nil {
+			// unparsable request is forwarded as is, provider answers it with parse error.
+			log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not translate method aliases")
+		}
+		if translated {
+			ctx.Request.SetBody(body)
+		}
+
+		next(ctx)
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_translateMethods(t *testing.T) {
+	aliases := map[string]string{"rpcgate_status": "eth_syncing"}
+	testCases := []struct {
+		name       string
+		body       string
+		batch      bool
+		want       string
+		translated bool
+	}{
+		{
+			name:       "single",
+			body:       `{"jsonrpc":"2.0","id":1,"method":"rpcgate_status","params":[]}`,
+			want:       `{"id":1,"jsonrpc":"2.0","method":"eth_syncing","params":[]}`,
+			translated: true,
+		},
+		{
+			name:  "batch",
+			body:  `[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"rpcgate_status"}]`,
+			batch: true,
+			want: `[{"id":1,"jsonrpc":"2.0","method":"eth_blockNumber"},` +
+				`{"id":2,"jsonrpc":"2.0","method":"eth_syncing"}]`,
+			translated: true,
+		},
+		{
+			name: "not aliased",
+			body: `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`,
+			want: `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBodyString(tc.body)
+			(&Server{}).requestParserMiddleware(func(*fasthttp.RequestCtx) {})(ctx)
+			requests := GetReqCtx(ctx).Request
+
+			body, translated, err := translateMethods([]byte(tc.body), requests, tc.batch, aliases)
+			require.NoError(t, err)
+			require.Equal(t, tc.translated, translated)
+			require.JSONEq(t, tc.want, string(body))
+			for _, req := range requests {
+				require.NotEqual(t, "rpcgate_status", req.Method)
+			}
+		})
+	}
+}
+
+func Test_Server_methodAliasMiddleware(t *testing.T) {
+	var forwarded []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":false}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:                 &fasthttp.Client{},
+		nameToChainID:       map[string]int64{"/test": 1},
+		nameToLBAlgo:        map[string]string{"/test": config.RRName},
+		nameToMethodAliases: map[string]map[string]string{"/test": {"rpcgate_status": "eth_syncing"}},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/test": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+	}
+	handler := srv.routerHandler(srv.requestParserMiddleware(srv.methodAliasMiddleware(
+		srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/test")
+	ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"rpcgate_status","params":[]}`)
+	handler(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"method":"eth_syncing","params":[]}`, string(forwarded))
+	// metrics are labeled with canonical method.
+	require.Equal(t, "eth_syncing", GetReqCtx(ctx).Request[0].Method)
+}
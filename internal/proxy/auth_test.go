@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_authMiddleware_apiKey(t *testing.T) {
+	srv := &Server{clients: config.Clients{
+		Type:         "api_key",
+		APIKeyHeader: "X-API-Key",
+		Clients: []config.Client{
+			{Login: "indexer", APIKey: "indexer-key"},
+			{Login: "wallet", APIKey: "wallet-key"},
+		},
+	}}
+	testCases := []struct {
+		name   string
+		key    string
+		client string
+	}{
+		{name: "known key", key: "indexer-key", client: "indexer"},
+		{name: "another known key", key: "wallet-key", client: "wallet"},
+		{name: "unknown key", key: "other-key"},
+		{name: "missing key"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &fasthttp.RequestCtx{}
+			if tc.key != "" {
+				ctx.Request.Header.Set("X-API-Key", tc.key)
+			}
+			var client string
+			srv.authMiddleware(func(ctx *fasthttp.RequestCtx) {
+				client = GetReqCtx(ctx).Client
+			})(ctx)
+
+			if tc.client == "" {
+				require.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+				require.Empty(t, client)
+				return
+			}
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+			require.Equal(t, tc.client, client)
+		})
+	}
+}
+
+func Test_Server_authMiddleware_query(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		allowUnlisted bool
+		client        string
+	}{
+		{name: "listed client", query: "client=indexer", client: "indexer"},
+		{name: "unlisted client", query: "client=random-1234", client: "_unknown_"},
+		{name: "missing client", client: "_unknown_"},
+		{name: "unlisted client allowed", query: "client=random-1234", allowUnlisted: true, client: "random-1234"},
+		{name: "missing client allowed", allowUnlisted: true, client: "_unknown_"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &Server{clients: config.Clients{
+				Type:          "query",
+				AllowUnlisted: tc.allowUnlisted,
+				Clients:       []config.Client{{Login: "indexer"}},
+			}}
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI("/eth?" + tc.query)
+			var client string
+			srv.authMiddleware(func(ctx *fasthttp.RequestCtx) {
+				client = GetReqCtx(ctx).Client
+			})(ctx)
+
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+			require.Equal(t, tc.client, client)
+		})
+	}
+}
+
+func Test_Server_authMiddleware_bcrypt(t *testing.T) {
+	// bcrypt hash of "s3cret".
+	const hash = "$2a$04$8gTs0O3hY/lTB58saftHy.rO6TwotMRqIqE4lPTbIzap0fxJsbOIC"
+
+	srv := &Server{clients: config.Clients{
+		AuthRequired: true,
+		Clients: []config.Client{
+			{Login: "hashed", Password: hash},
+			{Login: "plain", Password: "plain-pass"},
+		},
+	}}
+	handler := srv.authMiddleware(func(*fasthttp.RequestCtx) {})
+	testCases := []struct {
+		name   string
+		login  string
+		pass   string
+		status int
+	}{
+		{name: "correct password of hash", login: "hashed", pass: "s3cret", status: fasthttp.StatusOK},
+		{name: "repeated correct password of hash", login: "hashed", pass: "s3cret", status: fasthttp.StatusOK},
+		{name: "incorrect password of hash", login: "hashed", pass: "wrong", status: fasthttp.StatusUnauthorized},
+		{name: "hash as password", login: "hashed", pass: hash, status: fasthttp.StatusUnauthorized},
+		{name: "correct plaintext password", login: "plain", pass: "plain-pass", status: fasthttp.StatusOK},
+		{name: "incorrect plaintext password", login: "plain", pass: "wrong", status: fasthttp.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.Set("Authorization",
+				"Basic "+base64.StdEncoding.EncodeToString([]byte(tc.login+":"+tc.pass)))
+			handler(ctx)
+
+			require.Equal(t, tc.status, ctx.Response.StatusCode())
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// batchLimitMiddleware rejects batches larger than max batch size of client.
+// Client limit of zero inherits default of all clients, zero default means unlimited.
+func (srv *Server) batchLimitMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	defaultLimit := srv.clients.MaxBatchSize
+	clientToLimit := make(map[string]int, len(srv.clients.Clients))
+	for _, client := range srv.clients.Clients {
+		if client.MaxBatchSize > 0 {
+			clientToLimit[client.Login] = client.MaxBatchSize
+		}
+	}
+	if defaultLimit == 0 && len(clientToLimit) == 0 {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reqctx := GetReqCtx(ctx)
+		limit, ok := clientToLimit[reqctx.Client]
+		if !ok {
+			limit = defaultLimit
+		}
+		if !reqctx.Batch || limit == 0 || len(reqctx.Request) <= limit {
+			next(ctx)
+			return
+		}
+
+		log.Info().
+			Uint64("request_id", ctx.ID()).
+			Str("client", reqctx.Client).
+			Int("batch_size", len(reqctx.Request)).
+			Int("max_batch_size", limit).
+			Msg("batch size limit exceeded")
+		writeJSONRPCError(ctx, fasthttp.StatusBadRequest,
+			jsonRPCInvalidRequestCode, "batch size exceeds limit of "+strconv.Itoa(limit))
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_batchLimitMiddleware(t *testing.T) {
+	srv := &Server{clients: config.Clients{
+		MaxBatchSize: 2,
+		Clients: []config.Client{
+			{Login: "premium", MaxBatchSize: 5},
+			{Login: "standard"},
+		},
+	}}
+	batch := func(size int) string {
+		requests := make([]string, 0, size)
+		for range size {
+			requests = append(requests, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+		}
+		return "[" + strings.Join(requests, ",") + "]"
+	}
+	testCases := []struct {
+		name   string
+		client string
+		body   string
+		served bool
+	}{
+		{name: "premium large batch", client: "premium", body: batch(5), served: true},
+		{name: "premium batch over limit", client: "premium", body: batch(6), served: false},
+		{name: "standard large batch", client: "standard", body: batch(5), served: false},
+		{name: "standard small batch", client: "standard", body: batch(2), served: true},
+		{name: "unknown client large batch", client: "_unknown_", body: batch(3), served: false},
+		{
+			name:   "single request",
+			client: "standard",
+			body:   `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`,
+			served: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var served bool
+			handler := srv.requestParserMiddleware(srv.batchLimitMiddleware(func(*fasthttp.RequestCtx) {
+				served = true
+			}))
+			ctx := &fasthttp.RequestCtx{}
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = tc.client })
+			ctx.Request.SetBody([]byte(tc.body))
+			handler(ctx)
+
+			require.Equal(t, tc.served, served)
+			if tc.served {
+				return
+			}
+			require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+			require.Contains(t, string(ctx.Response.Body()), `"code":-32600`)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// newProviderToMaxBatchSize returns batch size limits of providers keyed by rpc and provider name.
+func newProviderToMaxBatchSize(rpcs []config.RPC) map[string]int {
+	providerToMaxBatchSize := make(map[string]int)
+	for _, rpc := range rpcs {
+		for _, provider := range rpc.Providers {
+			if provider.MaxBatchSize > 0 {
+				providerToMaxBatchSize[rpc.Name+"/"+provider.Name] = provider.MaxBatchSize
+			}
+		}
+	}
+	return providerToMaxBatchSize
+}
+
+// providerMaxBatchSize returns batch size limit of provider serving rpc, zero if it has no limit.
+// Fallback provider is named as fallback/<rpc>/<provider> and has limit of its own rpc.
+func (srv *Server) providerMaxBatchSize(rpcName, provider string) int {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		return srv.providerToMaxBatchSize[key]
+	}
+	return srv.providerToMaxBatchSize[rpcName+"/"+provider]
+}
+
+// splitBatchHandler sends batch to provider in chunks of at most limit requests one by one
+// and answers client with responses of all chunks in one batch. Response of chunk which is not
+// a successful batch is passed to client as is and remaining chunks are not sent.
+func (srv *Server) splitBatchHandler(ctx *fasthttp.RequestCtx, limit int) {
+	start := time.Now()
+	defer SetToReqCtx(ctx, func(rc *ReqCtx) { rc.UpstreamLatency = time.Since(start) })
+
+	var requests []json.RawMessage
+	if err := json.Unmarshal(ctx.Request.Body(), &requests); err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not split batch")
+		writeJSONRPCError(ctx, fasthttp.StatusBadRequest, jsonRPCInvalidRequestCode, "invalid batch")
+		return
+	}
+	log.Debug().
+		Uint64("request_id", ctx.ID()).
+		Str("provider", GetReqCtx(ctx).Provider).
+		Int("batch_size", len(requests)).
+		Int("max_batch_size", limit).
+		Msg("splitting batch for provider")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	responses := make([]json.RawMessage, 0, len(requests))
+	for chunk := range slices.Chunk(requests, limit) {
+		body, err := json.Marshal(chunk)
+		if err != nil {
+			log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal batch chunk")
+			ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+			return
+		}
+		resp.Reset()
+		decoded, ok := srv.forward(ctx, body, resp)
+		if !ok {
+			return
+		}
+		var chunkResponses []json.RawMessage
+		if resp.StatusCode() != fasthttp.StatusOK || json.Unmarshal(decoded, &chunkResponses) != nil {
+			srv.writeUpstreamResponse(ctx, resp, decoded)
+			return
+		}
+		responses = append(responses, chunkResponses...)
+	}
+
+	body, err := json.Marshal(responses)
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal batch response")
+		ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+		return
+	}
+	srv.writeUpstreamResponse(ctx, resp, body)
+}
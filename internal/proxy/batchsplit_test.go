@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_newProviderToMaxBatchSize(t *testing.T) {
+	require.Equal(t, map[string]int{"mainnet/infura": 2}, newProviderToMaxBatchSize([]config.RPC{{
+		Name: "mainnet",
+		Providers: []config.Provider{
+			{Name: "infura", MaxBatchSize: 2},
+			{Name: "alchemy"},
+		},
+	}}))
+}
+
+func Test_Server_handler_splitBatch(t *testing.T) {
+	const limit = 2
+
+	var (
+		mutex sync.Mutex
+		sizes []int
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []JSONRPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		sizes = append(sizes, len(requests))
+		mutex.Unlock()
+		if len(requests) > limit {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		responses := make([]string, 0, len(requests))
+		for _, req := range requests {
+			responses = append(responses, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"%s"}`, req.ID, req.Method))
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(responses, ",") + "]"))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:          &fasthttp.Client{},
+		nameToLBAlgo: map[string]string{"/mainnet": config.RRName},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/mainnet": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+		providerToMaxBatchSize: map[string]int{"mainnet/node": limit},
+	}
+	handler := srv.requestParserMiddleware(srv.loadBalancerMiddleware(srv.handler))
+	serve := func(body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/mainnet")
+		ctx.Request.SetBody([]byte(body))
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = "mainnet" })
+		handler(ctx)
+		return ctx
+	}
+
+	ctx := serve(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},` +
+		`{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"},` +
+		`{"jsonrpc":"2.0","id":3,"method":"eth_gasPrice"},` +
+		`{"jsonrpc":"2.0","id":4,"method":"net_version"},` +
+		`{"jsonrpc":"2.0","id":5,"method":"eth_syncing"}]`)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `[{"jsonrpc":"2.0","id":1,"result":"eth_chainId"},`+
+		`{"jsonrpc":"2.0","id":2,"result":"eth_blockNumber"},`+
+		`{"jsonrpc":"2.0","id":3,"result":"eth_gasPrice"},`+
+		`{"jsonrpc":"2.0","id":4,"result":"net_version"},`+
+		`{"jsonrpc":"2.0","id":5,"result":"eth_syncing"}]`, string(ctx.Response.Body()))
+	require.Equal(t, []int{2, 2, 1}, sizes)
+
+	// batch within limit is sent as is.
+	sizes = nil
+	ctx = serve(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"net_version"}]`)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, []int{2}, sizes)
+
+	// provider error of chunk is passed to client and remaining chunks are not sent.
+	sizes = nil
+	srv.providerToMaxBatchSize["mainnet/node"] = limit + 1
+	ctx = serve(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"net_version"},` +
+		`{"jsonrpc":"2.0","id":3,"method":"eth_gasPrice"},{"jsonrpc":"2.0","id":4,"method":"eth_syncing"}]`)
+	require.Equal(t, fasthttp.StatusRequestEntityTooLarge, ctx.Response.StatusCode())
+	require.Equal(t, []int{3}, sizes)
+}
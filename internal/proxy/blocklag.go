@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+const blockNumberRequest = `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`
+
+// blockLagPoller is poller of providers of rpc started with server.
+type blockLagPoller struct {
+	poller   *balancer.BlockLagPoller
+	interval time.Duration
+}
+
+// newBlockLagPoller returns poller of block lag of rpc providers balanced by lb,
+// false if lag is not polled for rpc or balancer can not skip lagging providers.
+func (srv *Server) newBlockLagPoller(rpc config.RPC, providers []balancer.Payload, lb Balancer) (blockLagPoller, bool) {
+	marker, ok := lb.(balancer.LagMarker)
+	if rpc.MaxBlockLag <= 0 || !ok {
+		return blockLagPoller{}, false
+	}
+	poller := balancer.NewBlockLagPoller(providers, lagLogger{LagMarker: marker, rpcName: rpc.Name},
+		srv.blockNumberFetcher(rpc.Name), uint64(rpc.MaxBlockLag))
+	if srv.metricsCfg.Enabled {
+		poller.SetObserver(func(provider string, lag uint64) {
+			metrics.ProviderBlockLag.WithLabelValues(rpc.Name, provider).Set(float64(lag))
+		})
+	}
+	return blockLagPoller{poller: poller, interval: rpc.BlockLagInterval}, true
+}
+
+// blockNumberFetcher returns fetcher of latest block of providers of rpc. Request is sent
+// with http client and upstream request of rpc, like requests of clients.
+func (srv *Server) blockNumberFetcher(rpcName string) balancer.BlockFetcher {
+	return func(ctx context.Context, provider balancer.Payload) (uint64, error) {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		req.SetRequestURI(provider.URL)
+		if err := srv.upstreamRequest(rpcName, provider.Name).set(req, []byte(blockNumberRequest)); err != nil {
+			return 0, err
+		}
+		srv.setProviderHeaders(req, rpcName, provider.Name)
+		cli, _ := srv.httpClient(rpcName, provider.Name)
+		var err error
+		if deadline, ok := ctx.Deadline(); ok {
+			err = cli.DoDeadline(req, resp, deadline)
+		} else {
+			err = cli.Do(req, resp)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode() != fasthttp.StatusOK {
+			return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode())
+		}
+		body, err := getDecodedBody(resp)
+		if err != nil {
+			return 0, err
+		}
+		return parseBlockNumber(body)
+	}
+}
+
+// parseBlockNumber returns block number from response to eth_blockNumber request.
+func parseBlockNumber(body []byte) (uint64, error) {
+	var response struct {
+		Result string        `json:"result"`
+		Error  *JSONRPCError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, err
+	}
+	if response.Error != nil {
+		return 0, fmt.Errorf("provider answered with error: %s", response.Error.Message)
+	}
+	hex, ok := strings.CutPrefix(response.Result, "0x")
+	if !ok {
+		return 0, errors.New("block number is not hex")
+	}
+	return strconv.ParseUint(hex, 16, 64)
+}
+
+// lagLogger logs providers marked as lagging and caught up.
+type lagLogger struct {
+	balancer.LagMarker
+
+	rpcName string
+}
+
+func (l lagLogger) SetLagging(name string, lagging bool) bool {
+	changed := l.LagMarker.SetLagging(name, lagging)
+	switch {
+	case changed && lagging:
+		log.Warn().Str("rpc", l.rpcName).Str("provider", name).Msg("provider lags behind other providers, marked unhealthy")
+	case changed:
+		log.Info().Str("rpc", l.rpcName).Str("provider", name).Msg("provider caught up with other providers")
+	}
+	return changed
+}
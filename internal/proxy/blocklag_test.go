@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_parseBlockNumber(t *testing.T) {
+	block, err := parseBlockNumber([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10d4f2a"}`))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x10d4f2a), block)
+
+	_, err = parseBlockNumber([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"internal error"}}`))
+	require.Error(t, err)
+	_, err = parseBlockNumber([]byte(`{"jsonrpc":"2.0","id":1,"result":"17621802"}`))
+	require.Error(t, err)
+	_, err = parseBlockNumber([]byte(`<html>bad gateway</html>`))
+	require.Error(t, err)
+}
+
+func Test_New_blockLag(t *testing.T) {
+	newNode := func(result string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + result + `"}`))
+		}))
+	}
+	leader, lagging := newNode("0x64"), newNode("0x50")
+	defer leader.Close()
+	defer lagging.Close()
+
+	srv := New(config.Config{
+		Metrics: config.Metrics{Enabled: true},
+		RPCs: []config.RPC{
+			{
+				Name:            "block-lag",
+				GlobalRPCConfig: config.GlobalRPCConfig{BalancerType: config.RRName},
+				MaxBlockLag:     10,
+				Providers: []config.Provider{
+					{Name: "leader", ConnURL: leader.URL},
+					{Name: "lagging", ConnURL: lagging.URL},
+				},
+			},
+			{
+				Name:            "no-block-lag",
+				GlobalRPCConfig: config.GlobalRPCConfig{BalancerType: config.RRName},
+				Providers:       []config.Provider{{Name: "node", ConnURL: leader.URL}},
+			},
+		},
+	})
+	require.Len(t, srv.blockLagPollers, 1)
+
+	srv.blockLagPollers[0].poller.Poll(context.Background())
+	require.InDelta(t, 20, testutil.ToFloat64(metrics.ProviderBlockLag.WithLabelValues("block-lag", "lagging")), 0)
+	require.InDelta(t, 0, testutil.ToFloat64(metrics.ProviderBlockLag.WithLabelValues("block-lag", "leader")), 0)
+
+	lb, _ := srv.getBalancer("/block-lag")
+	for range 10 {
+		p, release := lb.Borrow()
+		require.Equal(t, "leader", p.Name)
+		release(true, 0)
+	}
+	rr, ok := lb.(*balancer.RoundRobin)
+	require.True(t, ok)
+	require.Equal(t, []balancer.ProviderHealth{{Name: "leader", Healthy: true}, {Name: "lagging", Healthy: false}},
+		rr.Health())
+}
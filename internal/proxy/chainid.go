@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+const ethChainID = "eth_chainId"
+
+// providerEjector is implemented by balancers which can remove provider from balancing.
+type providerEjector interface {
+	Eject(name string) bool
+}
+
+// reportedChainID returns chain id from provider response to eth_chainId request,
+// returns false if there is no such request or response can not be parsed.
+func reportedChainID(reqctx *ReqCtx, body []byte) (int64, bool) {
+	var id json.RawMessage
+	found := false
+	for _, req := range reqctx.Request {
+		if req.Method == ethChainID {
+			id, found = req.ID, true
+			break
+		}
+	}
+	if !found {
+		return 0, false
+	}
+
+	type response struct {
+		ID     json.RawMessage `json:"id"`
+		Result string          `json:"result"`
+	}
+	var responses []response
+	if reqctx.Batch {
+		if err := json.Unmarshal(body, &responses); err != nil {
+			return 0, false
+		}
+	} else {
+		responses = append(responses, response{})
+		if err := json.Unmarshal(body, &responses[0]); err != nil {
+			return 0, false
+		}
+	}
+	for _, resp := range responses {
+		if string(resp.ID) != string(id) || resp.Result == "" {
+			continue
+		}
+		chainID, err := strconv.ParseInt(strings.TrimPrefix(resp.Result, "0x"), 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		return chainID, true
+	}
+	return 0, false
+}
+
+// validateChainID ejects provider from balancer if it reported chain id different from configured for rpc.
+// Returns false if provider belongs to another chain.
+func (srv *Server) validateChainID(
+	reqctx *ReqCtx, body []byte, lb Balancer, rpcPath, provider string,
+) bool {
+	if _, enabled := srv.chainIDValidated[rpcPath]; !enabled {
+		return true
+	}
+	reported, ok := reportedChainID(reqctx, body)
+	expected := srv.nameToChainID[rpcPath]
+	if !ok || reported == expected {
+		return true
+	}
+
+	ejector, isEjector := lb.(providerEjector)
+	if isEjector && ejector.Eject(provider) {
+		log.Error().
+			Str("rpc", strings.TrimPrefix(rpcPath, "/")).
+			Str("provider", provider).
+			Int64("expected_chain_id", expected).
+			Int64("reported_chain_id", reported).
+			Msg("provider reported another chain, ejected")
+		metrics.ProviderEjected.WithLabelValues(strings.TrimPrefix(rpcPath, "/"), provider).Inc()
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_validateChainID(t *testing.T) {
+	srv := &Server{
+		nameToLBAlgo:     map[string]string{"/mainnet": config.RRName},
+		nameToChainID:    map[string]int64{"/mainnet": 1},
+		chainIDValidated: map[string]struct{}{"/mainnet": {}},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/mainnet": balancer.NewRoundRobin([]balancer.Payload{
+				{Name: "mainnet-node", URL: "http://mainnet"},
+				{Name: "sepolia-node", URL: "http://sepolia"},
+			}),
+		},
+	}
+	// fake provider answers eth_chainId with chain of its url.
+	provider := func(ctx *fasthttp.RequestCtx) {
+		result := `"0x1"`
+		if GetReqCtx(ctx).ConnURL == "http://sepolia" {
+			result = `"0xaa36a7"`
+		}
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBodyString(`{"jsonrpc":"2.0","id":1,"result":` + result + `}`)
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Response = []JSONRPCResponse{{}} })
+	}
+	serve := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/mainnet")
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.Request = []JSONRPCRequest{{ID: []byte("1"), Method: ethChainID}}
+		})
+		srv.loadBalancerMiddleware(provider)(ctx)
+		return ctx
+	}
+
+	ejected := testutil.ToFloat64(metrics.ProviderEjected.WithLabelValues("mainnet", "sepolia-node"))
+	ctx := serve()
+	require.Equal(t, "mainnet-node", GetReqCtx(ctx).Provider)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// provider of another chain is ejected and its response is not returned to client.
+	ctx = serve()
+	require.Equal(t, "sepolia-node", GetReqCtx(ctx).Provider)
+	require.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	require.NotContains(t, string(ctx.Response.Body()), "0xaa36a7")
+	require.InDelta(t, ejected+1, testutil.ToFloat64(metrics.ProviderEjected.WithLabelValues("mainnet", "sepolia-node")), 0)
+
+	for range 4 {
+		ctx = serve()
+		require.Equal(t, "mainnet-node", GetReqCtx(ctx).Provider)
+		require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, string(ctx.Response.Body()))
+	}
+}
+
+func Test_reportedChainID(t *testing.T) {
+	testCases := []struct {
+		name    string
+		reqctx  *ReqCtx
+		body    string
+		chainID int64
+		found   bool
+	}{
+		{
+			name:    "single",
+			reqctx:  &ReqCtx{Request: []JSONRPCRequest{{ID: []byte("1"), Method: ethChainID}}},
+			body:    `{"jsonrpc":"2.0","id":1,"result":"0x2105"}`,
+			chainID: 8453,
+			found:   true,
+		},
+		{
+			name: "batch",
+			reqctx: &ReqCtx{Batch: true, Request: []JSONRPCRequest{
+				{ID: []byte(`"a"`), Method: "eth_blockNumber"},
+				{ID: []byte(`"b"`), Method: ethChainID},
+			}},
+			body:    `[{"jsonrpc":"2.0","id":"b","result":"0x1"},{"jsonrpc":"2.0","id":"a","result":"0x10"}]`,
+			chainID: 1,
+			found:   true,
+		},
+		{
+			name:   "other method",
+			reqctx: &ReqCtx{Request: []JSONRPCRequest{{ID: []byte("1"), Method: "eth_blockNumber"}}},
+			body:   `{"jsonrpc":"2.0","id":1,"result":"0x10"}`,
+		},
+		{
+			name:   "error response",
+			reqctx: &ReqCtx{Request: []JSONRPCRequest{{ID: []byte("1"), Method: ethChainID}}},
+			body:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"oops"}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chainID, found := reportedChainID(tc.reqctx, []byte(tc.body))
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.chainID, chainID)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+const (
+	headerXForwardedFor = "X-Forwarded-For"
+	headerXRealIP       = "X-Real-IP"
+)
+
+// clientIPForwarder sets X-Forwarded-For and X-Real-IP headers of requests to providers.
+// Nil forwarder sets none of them.
+type clientIPForwarder struct {
+	trustedProxies []netip.Prefix
+}
+
+// newClientIPForwarder returns client ip forwarder, nil if forwarding is disabled.
+func newClientIPForwarder(cfg config.ForwardClientIP) *clientIPForwarder {
+	if !cfg.Enabled {
+		return nil
+	}
+	f := &clientIPForwarder{trustedProxies: make([]netip.Prefix, 0, len(cfg.TrustedProxies))}
+	for _, proxy := range cfg.TrustedProxies {
+		// trusted proxies are validated with config.
+		if prefix, err := config.ParseTrustedProxy(proxy); err == nil {
+			f.trustedProxies = append(f.trustedProxies, prefix)
+		}
+	}
+	return f
+}
+
+// isTrusted returns true if addr belongs to trusted proxy.
+func (f *clientIPForwarder) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range f.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeaders sets headers of req to provider. Client ip is appended to X-Forwarded-For of request
+// from trusted proxy, X-Forwarded-For of other requests is replaced as it can be spoofed.
+func (f *clientIPForwarder) setHeaders(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	if f == nil {
+		return
+	}
+	remote, _ := netip.AddrFromSlice(ctx.RemoteIP())
+	remote = remote.Unmap()
+	forwardedFor := strings.TrimSpace(string(ctx.Request.Header.Peek(headerXForwardedFor)))
+	if forwardedFor == "" || !f.isTrusted(remote) {
+		req.Header.Set(headerXForwardedFor, remote.String())
+		req.Header.Set(headerXRealIP, remote.String())
+		return
+	}
+	req.Header.Set(headerXForwardedFor, forwardedFor+", "+remote.String())
+	req.Header.Set(headerXRealIP, f.clientIP(forwardedFor, remote))
+}
+
+// clientIP returns the rightmost address of X-Forwarded-For chain which is not a trusted proxy,
+// the leftmost one if all of them are trusted. Chain is trusted up to the first malformed address.
+func (f *clientIPForwarder) clientIP(forwardedFor string, remote netip.Addr) string {
+	client := remote.String()
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return client
+		}
+		client = addr.Unmap().String()
+		if !f.isTrusted(addr) {
+			return client
+		}
+	}
+	return client
+}
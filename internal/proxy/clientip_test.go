@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_clientIPForwarder_setHeaders(t *testing.T) {
+	testCases := []struct {
+		name             string
+		remote           string
+		forwardedFor     string
+		wantForwardedFor string
+		wantRealIP       string
+	}{
+		{
+			name:             "direct client",
+			remote:           "203.0.113.7",
+			wantForwardedFor: "203.0.113.7",
+			wantRealIP:       "203.0.113.7",
+		},
+		{
+			name:             "spoofed by untrusted client",
+			remote:           "203.0.113.7",
+			forwardedFor:     "198.51.100.1",
+			wantForwardedFor: "203.0.113.7",
+			wantRealIP:       "203.0.113.7",
+		},
+		{
+			name:             "trusted proxy without header",
+			remote:           "10.0.0.2",
+			wantForwardedFor: "10.0.0.2",
+			wantRealIP:       "10.0.0.2",
+		},
+		{
+			name:             "trusted proxy",
+			remote:           "10.0.0.2",
+			forwardedFor:     "203.0.113.7",
+			wantForwardedFor: "203.0.113.7, 10.0.0.2",
+			wantRealIP:       "203.0.113.7",
+		},
+		{
+			name:             "trusted proxy chain",
+			remote:           "10.0.0.2",
+			forwardedFor:     "198.51.100.1, 203.0.113.7, 192.168.1.1",
+			wantForwardedFor: "198.51.100.1, 203.0.113.7, 192.168.1.1, 10.0.0.2",
+			wantRealIP:       "203.0.113.7",
+		},
+		{
+			name:             "all trusted",
+			remote:           "10.0.0.2",
+			forwardedFor:     "10.0.0.5, 192.168.1.1",
+			wantForwardedFor: "10.0.0.5, 192.168.1.1, 10.0.0.2",
+			wantRealIP:       "10.0.0.5",
+		},
+		{
+			name:             "malformed hop",
+			remote:           "10.0.0.2",
+			forwardedFor:     "203.0.113.7, unknown, 192.168.1.1",
+			wantForwardedFor: "203.0.113.7, unknown, 192.168.1.1, 10.0.0.2",
+			wantRealIP:       "192.168.1.1",
+		},
+	}
+	forwarder := newClientIPForwarder(config.ForwardClientIP{
+		Enabled:        true,
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+	})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var clientReq fasthttp.Request
+			if tc.forwardedFor != "" {
+				clientReq.Header.Set(headerXForwardedFor, tc.forwardedFor)
+			}
+			var ctx fasthttp.RequestCtx
+			ctx.Init(&clientReq, &net.TCPAddr{IP: net.ParseIP(tc.remote), Port: 1234}, nil)
+
+			var req fasthttp.Request
+			forwarder.setHeaders(&ctx, &req)
+			require.Equal(t, tc.wantForwardedFor, string(req.Header.Peek(headerXForwardedFor)))
+			require.Equal(t, tc.wantRealIP, string(req.Header.Peek(headerXRealIP)))
+		})
+	}
+}
+
+func Test_clientIPForwarder_disabled(t *testing.T) {
+	forwarder := newClientIPForwarder(config.ForwardClientIP{TrustedProxies: []string{"10.0.0.0/8"}})
+	require.Nil(t, forwarder)
+
+	var clientReq fasthttp.Request
+	clientReq.Header.Set(headerXForwardedFor, "203.0.113.7")
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&clientReq, &net.TCPAddr{IP: net.ParseIP("10.0.0.2")}, nil)
+
+	var req fasthttp.Request
+	forwarder.setHeaders(&ctx, &req)
+	require.Empty(t, req.Header.Peek(headerXForwardedFor))
+	require.Empty(t, req.Header.Peek(headerXRealIP))
+}
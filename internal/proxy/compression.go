@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// shouldCompress returns true if response of size bytes must be compressed by policy.
+func shouldCompress(policy config.Compression, size int) bool {
+	switch policy.Mode {
+	case config.CompressionAlways:
+		return true
+	case config.CompressionThreshold:
+		return size >= policy.Threshold
+	default:
+		return false
+	}
+}
+
+// compressionMiddleware gzips responses for clients accepting gzip encoding by per-client policy.
+// Client policy with empty mode inherits default of all clients.
+func (srv *Server) compressionMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	const gzipEncoding = "gzip"
+
+	defaultPolicy := srv.clients.Compression
+	clientToPolicy := make(map[string]config.Compression, len(srv.clients.Clients))
+	for _, client := range srv.clients.Clients {
+		if client.Compression.Mode != "" {
+			clientToPolicy[client.Login] = client.Compression
+		}
+	}
+	isDisabled := defaultPolicy.Mode == "" || defaultPolicy.Mode == config.CompressionNever
+	if isDisabled && len(clientToPolicy) == 0 {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		// streamed body is sent as is, compressing it would buffer it.
+		if !ctx.Request.Header.HasAcceptEncoding(gzipEncoding) || len(ctx.Response.Header.ContentEncoding()) > 0 ||
+			ctx.Response.IsBodyStream() {
+			return
+		}
+		policy, ok := clientToPolicy[GetReqCtx(ctx).Client]
+		if !ok {
+			policy = defaultPolicy
+		}
+		body := ctx.Response.Body()
+		if len(body) == 0 || !shouldCompress(policy, len(body)) {
+			return
+		}
+		ctx.Response.SetBody(fasthttp.AppendGzipBytes(nil, body))
+		ctx.Response.Header.SetContentEncoding(gzipEncoding)
+		ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+	}
+}
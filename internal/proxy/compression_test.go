@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_compressionMiddleware(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":1,"result":"0x` + strings.Repeat("ab", 1024) + `"}`
+
+	srv := &Server{
+		clients: config.Clients{
+			Compression: config.Compression{Mode: config.CompressionThreshold, Threshold: 4096},
+			Clients: []config.Client{
+				{Login: "metered", Compression: config.Compression{Mode: config.CompressionAlways}},
+				{Login: "latency", Compression: config.Compression{Mode: config.CompressionNever}},
+				{Login: "default"},
+			},
+		},
+	}
+	testCases := []struct {
+		name           string
+		client         string
+		acceptEncoding string
+		compressed     bool
+	}{
+		{name: "always", client: "metered", acceptEncoding: "gzip", compressed: true},
+		{name: "never", client: "latency", acceptEncoding: "gzip", compressed: false},
+		{name: "default threshold not reached", client: "default", acceptEncoding: "gzip", compressed: false},
+		{name: "unknown client inherits default", client: "unknown", acceptEncoding: "gzip", compressed: false},
+		{name: "gzip not accepted", client: "metered", acceptEncoding: "", compressed: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &fasthttp.RequestCtx{}
+			if tc.acceptEncoding != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAcceptEncoding, tc.acceptEncoding)
+			}
+			srv.compressionMiddleware(func(ctx *fasthttp.RequestCtx) {
+				SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = tc.client })
+				ctx.Response.SetBodyString(body)
+			})(ctx)
+
+			if !tc.compressed {
+				require.Empty(t, ctx.Response.Header.ContentEncoding())
+				require.Equal(t, body, string(ctx.Response.Body()))
+				return
+			}
+			require.Equal(t, "gzip", string(ctx.Response.Header.ContentEncoding()))
+			require.Less(t, len(ctx.Response.Body()), len(body))
+			decoded, err := ctx.Response.BodyGunzip()
+			require.NoError(t, err)
+			require.Equal(t, body, string(decoded))
+		})
+	}
+
+	t.Run("threshold reached", func(t *testing.T) {
+		srv := &Server{
+			clients: config.Clients{
+				Compression: config.Compression{Mode: config.CompressionThreshold, Threshold: 1024},
+			},
+		}
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.Set(fasthttp.HeaderAcceptEncoding, "gzip, deflate")
+		srv.compressionMiddleware(func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.SetBodyString(body)
+		})(ctx)
+		require.Equal(t, "gzip", string(ctx.Response.Header.ContentEncoding()))
+	})
+}
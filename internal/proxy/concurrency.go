@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// globalConcurrencyLimit is a label of global concurrency limiter.
+const globalConcurrencyLimit = "_global_"
+
+// concurrencyLimiter caps concurrent upstream requests with weighted semaphore.
+type concurrencyLimiter struct {
+	name    string
+	sem     *semaphore.Weighted
+	queue   bool
+	timeout time.Duration
+}
+
+// newConcurrencyLimiter returns nil if limit is not configured.
+func newConcurrencyLimiter(name string, cfg config.ConcurrencyLimit) *concurrencyLimiter {
+	if cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{
+		name:    name,
+		sem:     semaphore.NewWeighted(cfg.MaxConcurrent),
+		queue:   cfg.Mode == config.ConcurrencyLimitQueue,
+		timeout: cfg.QueueTimeout,
+	}
+}
+
+// acquire takes a slot, in queue mode waits for it up to timeout.
+// Returns false if slot was not acquired.
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	if !l.queue {
+		return l.sem.TryAcquire(1)
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	return l.sem.Acquire(ctx, 1) == nil
+}
+
+// release returns slot back to limiter.
+func (l *concurrencyLimiter) release() {
+	l.sem.Release(1)
+}
+
+// concurrencyLimitMiddleware limits concurrent upstream requests by per-rpc and global limits.
+// Slots are released when request completes, including panics.
+func (srv *Server) concurrencyLimitMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		// per-rpc limit is acquired first, so waiting requests of one rpc don't hold global slots.
+		for _, limiter := range []*concurrencyLimiter{srv.rpcLimiters[string(ctx.Path())], srv.globalLimiter} {
+			if limiter == nil {
+				continue
+			}
+			if !limiter.acquire(ctx) {
+				log.Info().
+					Uint64("request_id", ctx.ID()).
+					Str("limit", limiter.name).
+					Msg("concurrency limit exceeded")
+				metrics.ConcurrencyLimitRejected.WithLabelValues(limiter.name).Inc()
+				writeJSONRPCError(ctx, fasthttp.StatusServiceUnavailable,
+					jsonRPCLimitExceededCode, "too many concurrent requests")
+				return
+			}
+			metrics.UpstreamConcurrency.WithLabelValues(limiter.name).Inc()
+			defer func() {
+				limiter.release()
+				metrics.UpstreamConcurrency.WithLabelValues(limiter.name).Dec()
+			}()
+		}
+
+		next(ctx)
+	}
+}
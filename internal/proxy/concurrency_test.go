@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_concurrencyLimitMiddleware(t *testing.T) {
+	newRequestCtx := func() *fasthttp.RequestCtx {
+		var req fasthttp.Request
+		req.SetRequestURI("/limited")
+		// initialized ctx is bound to fake server, so it can be used as context.Context.
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&req, nil, nil)
+		return ctx
+	}
+	// serveBlocked starts request which holds its slot until returned func is called.
+	serveBlocked := func(handler func(next fasthttp.RequestHandler) fasthttp.RequestHandler) func() {
+		entered, unblock, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			handler(func(*fasthttp.RequestCtx) {
+				close(entered)
+				<-unblock
+			})(newRequestCtx())
+		}()
+		<-entered
+		return func() {
+			close(unblock)
+			<-done
+		}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		srv := &Server{
+			rpcLimiters: map[string]*concurrencyLimiter{
+				"/limited": newConcurrencyLimiter("limited", config.ConcurrencyLimit{
+					MaxConcurrent: 1,
+					Mode:          config.ConcurrencyLimitReject,
+				}),
+			},
+		}
+		unblock := serveBlocked(srv.concurrencyLimitMiddleware)
+		require.InDelta(t, 1.0, testutil.ToFloat64(metrics.UpstreamConcurrency.WithLabelValues("limited")), 0)
+
+		var served bool
+		rejected := testutil.ToFloat64(metrics.ConcurrencyLimitRejected.WithLabelValues("limited"))
+		ctx := newRequestCtx()
+		srv.concurrencyLimitMiddleware(func(*fasthttp.RequestCtx) { served = true })(ctx)
+		require.False(t, served)
+		require.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		require.InDelta(t, rejected+1, testutil.ToFloat64(metrics.ConcurrencyLimitRejected.WithLabelValues("limited")), 0)
+
+		unblock()
+		require.InDelta(t, 0.0, testutil.ToFloat64(metrics.UpstreamConcurrency.WithLabelValues("limited")), 0)
+
+		srv.concurrencyLimitMiddleware(func(*fasthttp.RequestCtx) { served = true })(newRequestCtx())
+		require.True(t, served)
+	})
+	t.Run("queue", func(t *testing.T) {
+		srv := &Server{
+			globalLimiter: newConcurrencyLimiter(globalConcurrencyLimit, config.ConcurrencyLimit{
+				MaxConcurrent: 1,
+				Mode:          config.ConcurrencyLimitQueue,
+				QueueTimeout:  time.Second,
+			}),
+		}
+		unblock := serveBlocked(srv.concurrencyLimitMiddleware)
+		time.AfterFunc(50*time.Millisecond, unblock)
+
+		var served bool
+		srv.concurrencyLimitMiddleware(func(*fasthttp.RequestCtx) { served = true })(newRequestCtx())
+		require.True(t, served)
+	})
+	t.Run("queue timeout", func(t *testing.T) {
+		srv := &Server{
+			globalLimiter: newConcurrencyLimiter(globalConcurrencyLimit, config.ConcurrencyLimit{
+				MaxConcurrent: 1,
+				Mode:          config.ConcurrencyLimitQueue,
+				QueueTimeout:  10 * time.Millisecond,
+			}),
+		}
+		unblock := serveBlocked(srv.concurrencyLimitMiddleware)
+		defer unblock()
+
+		var served bool
+		ctx := newRequestCtx()
+		srv.concurrencyLimitMiddleware(func(*fasthttp.RequestCtx) { served = true })(ctx)
+		require.False(t, served)
+		require.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	})
+	t.Run("release on panic", func(t *testing.T) {
+		srv := &Server{
+			globalLimiter: newConcurrencyLimiter(globalConcurrencyLimit, config.ConcurrencyLimit{
+				MaxConcurrent: 1,
+				Mode:          config.ConcurrencyLimitReject,
+			}),
+		}
+		require.Panics(t, func() {
+			srv.concurrencyLimitMiddleware(func(*fasthttp.RequestCtx) { panic("test") })(newRequestCtx())
+		})
+		var served bool
+		srv.concurrencyLimitMiddleware(func(*fasthttp.RequestCtx) { served = true })(newRequestCtx())
+		require.True(t, served)
+	})
+}
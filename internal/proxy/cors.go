@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// corsMiddleware sets Access-Control headers for allowed origins.
+// Preflight requests are answered before routing, so unknown paths get correct headers too.
+func (srv *Server) corsMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	const (
+		wildcard       = "*"
+		allowedMethods = "POST, OPTIONS"
+		defaultHeaders = "Content-Type, Authorization"
+	)
+
+	if len(srv.cors.AllowedOrigins) == 0 {
+		return next
+	}
+
+	allowAll := slices.Contains(srv.cors.AllowedOrigins, wildcard)
+	origins := make(map[string]struct{}, len(srv.cors.AllowedOrigins))
+	for _, origin := range srv.cors.AllowedOrigins {
+		origins[origin] = struct{}{}
+	}
+	allowedHeaders := defaultHeaders
+	if len(srv.cors.AllowedHeaders) > 0 {
+		allowedHeaders = strings.Join(srv.cors.AllowedHeaders, ", ")
+	}
+	maxAge := strconv.Itoa(int(srv.cors.MaxAge.Seconds()))
+
+	getAllowedOrigin := func(origin string) string {
+		if origin == "" {
+			return ""
+		}
+		if allowAll {
+			return wildcard
+		}
+		if _, ok := origins[origin]; ok {
+			return origin
+		}
+		return ""
+	}
+	setOriginHeaders := func(ctx *fasthttp.RequestCtx, allowedOrigin string) {
+		ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowOrigin, allowedOrigin)
+		if allowedOrigin != wildcard {
+			ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderOrigin)
+		}
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		allowedOrigin := getAllowedOrigin(string(ctx.Request.Header.Peek(fasthttp.HeaderOrigin)))
+
+		isPreflight := ctx.IsOptions() &&
+			len(ctx.Request.Header.Peek(fasthttp.HeaderAccessControlRequestMethod)) > 0
+		if isPreflight {
+			if allowedOrigin != "" {
+				setOriginHeaders(ctx, allowedOrigin)
+				ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowMethods, allowedMethods)
+				ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowHeaders, allowedHeaders)
+				ctx.Response.Header.Set(fasthttp.HeaderAccessControlMaxAge, maxAge)
+			}
+			ctx.SetStatusCode(fasthttp.StatusNoContent)
+			return
+		}
+
+		next(ctx)
+
+		// headers are set after next, because upstream headers overwrite response headers.
+		if allowedOrigin != "" {
+			setOriginHeaders(ctx, allowedOrigin)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_corsMiddleware(t *testing.T) {
+	notFound := func(ctx *fasthttp.RequestCtx) {
+		ctx.Error("not found", fasthttp.StatusNotFound)
+	}
+	ok := func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderContentType, "application/json")
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	}
+	newRequestCtx := func(method, path, origin string, preflight bool) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.Set(fasthttp.HeaderOrigin, origin)
+		if preflight {
+			ctx.Request.Header.Set(fasthttp.HeaderAccessControlRequestMethod, fasthttp.MethodPost)
+		}
+		return ctx
+	}
+
+	t.Run("preflight to unknown path with wildcard", func(t *testing.T) {
+		srv := &Server{cors: config.CORS{AllowedOrigins: []string{"*"}, MaxAge: time.Minute}}
+		ctx := newRequestCtx(fasthttp.MethodOptions, "/unknown", "https://dapp.example", true)
+		srv.corsMiddleware(notFound)(ctx)
+
+		require.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+		require.Equal(t, "*", string(ctx.Response.Header.Peek(fasthttp.HeaderAccessControlAllowOrigin)))
+		require.Equal(t, "POST, OPTIONS", string(ctx.Response.Header.Peek(fasthttp.HeaderAccessControlAllowMethods)))
+		require.Equal(t, "Content-Type, Authorization",
+			string(ctx.Response.Header.Peek(fasthttp.HeaderAccessControlAllowHeaders)))
+		require.Equal(t, "60", string(ctx.Response.Header.Peek(fasthttp.HeaderAccessControlMaxAge)))
+	})
+	t.Run("explicit origin echo", func(t *testing.T) {
+		srv := &Server{cors: config.CORS{
+			AllowedOrigins: []string{"https://dapp.example"},
+			AllowedHeaders: []string{"Content-Type"},
+		}}
+		ctx := newRequestCtx(fasthttp.MethodPost, "/mainnet", "https://dapp.example", false)
+		srv.corsMiddleware(ok)(ctx)
+
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		require.Equal(t, "https://dapp.example",
+			string(ctx.Response.Header.Peek(fasthttp.HeaderAccessControlAllowOrigin)))
+		require.Equal(t, fasthttp.HeaderOrigin, string(ctx.Response.Header.Peek(fasthttp.HeaderVary)))
+	})
+	t.Run("not allowed origin", func(t *testing.T) {
+		srv := &Server{cors: config.CORS{AllowedOrigins: []string{"https://dapp.example"}}}
+		ctx := newRequestCtx(fasthttp.MethodOptions, "/mainnet", "https://evil.example", true)
+		srv.corsMiddleware(ok)(ctx)
+
+		require.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+		require.Empty(t, ctx.Response.Header.Peek(fasthttp.HeaderAccessControlAllowOrigin))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		srv := &Server{}
+		ctx := newRequestCtx(fasthttp.MethodOptions, "/unknown", "https://dapp.example", true)
+		srv.corsMiddleware(notFound)(ctx)
+
+		require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+		require.Empty(t, ctx.Response.Header.Peek(fasthttp.HeaderAccessControlAllowOrigin))
+	})
+}
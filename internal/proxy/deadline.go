@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// deadlineExceeded returns true if request has deadline and it has passed.
+func deadlineExceeded(reqctx *ReqCtx) bool {
+	return !reqctx.Deadline.IsZero() && !time.Now().Before(reqctx.Deadline)
+}
+
+// propagateDeadline sets time left until request deadline to upstream request header.
+// Returns false and answers with gateway timeout and json-rpc error if deadline has already passed.
+func (srv *Server) propagateDeadline(ctx *fasthttp.RequestCtx, req *fasthttp.Request) bool {
+	reqctx := GetReqCtx(ctx)
+	if reqctx.Deadline.IsZero() {
+		return true
+	}
+	remaining := time.Until(reqctx.Deadline)
+	if remaining <= 0 {
+		log.Error().Uint64("request_id", ctx.ID()).Msg("request deadline exceeded")
+		writeJSONRPCError(ctx, fasthttp.StatusGatewayTimeout, jsonRPCInternalErrorCode, "request deadline exceeded")
+		return false
+	}
+	if srv.deadline.Header != "" {
+		req.Header.Set(srv.deadline.Header, deadlineHeaderValue(srv.deadline.Format, reqctx.Deadline, remaining))
+	}
+	return true
+}
+
+// deadlineHeaderValue returns deadline encoded in format, remaining is time left until deadline.
+func deadlineHeaderValue(format string, deadline time.Time, remaining time.Duration) string {
+	const (
+		base      = 10
+		precision = 3
+		bitSize   = 64
+	)
+
+	switch format {
+	case config.DeadlineFormatSeconds:
+		return strconv.FormatFloat(remaining.Seconds(), 'f', precision, bitSize)
+	case config.DeadlineFormatUnixMS:
+		return strconv.FormatInt(deadline.UnixMilli(), base)
+	default:
+		return strconv.FormatInt(remaining.Milliseconds(), base)
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_handler_deadlineHeader(t *testing.T) {
+	const header = "X-Request-Deadline"
+
+	remaining := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remaining <- r.Header.Get(header)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:      &fasthttp.Client{},
+		deadline: config.Deadline{Timeout: time.Second, Header: header, Format: config.DeadlineFormatMS},
+	}
+	deadline := time.Now().Add(time.Second)
+	request := func() int64 {
+		t.Helper()
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`))
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.ConnURL = upstream.URL
+			rc.Deadline = deadline
+		})
+		srv.handler(ctx)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		ms, err := strconv.ParseInt(<-remaining, 10, 64)
+		require.NoError(t, err)
+		return ms
+	}
+
+	first := request()
+	require.LessOrEqual(t, first, int64(1000))
+	require.Positive(t, first)
+
+	time.Sleep(100 * time.Millisecond)
+	second := request()
+	require.LessOrEqual(t, second, first-100)
+}
+
+func Test_Server_handler_deadlineExceeded(t *testing.T) {
+	srv := &Server{cli: &fasthttp.Client{}}
+
+	ctx := &fasthttp.RequestCtx{}
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		// provider is never requested once deadline has passed.
+		rc.ConnURL = "http://127.0.0.1:1"
+		rc.Deadline = time.Now().Add(-time.Millisecond)
+	})
+	srv.handler(ctx)
+	require.Equal(t, fasthttp.StatusGatewayTimeout, ctx.Response.StatusCode())
+}
+
+func Test_deadlineHeaderValue(t *testing.T) {
+	deadline := time.UnixMilli(1_700_000_001_500)
+	remaining := 1500 * time.Millisecond
+
+	require.Equal(t, "1500", deadlineHeaderValue(config.DeadlineFormatMS, deadline, remaining))
+	require.Equal(t, "1.500", deadlineHeaderValue(config.DeadlineFormatSeconds, deadline, remaining))
+	require.Equal(t, "1700000001500", deadlineHeaderValue(config.DeadlineFormatUnixMS, deadline, remaining))
+}
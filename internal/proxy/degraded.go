@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// SetDegraded enables or disables degraded mode.
+// In degraded mode only configured safe subset of methods is served.
+func (srv *Server) SetDegraded(enabled bool) {
+	if srv.degraded.Swap(enabled) != enabled {
+		log.Warn().Bool("degraded", enabled).Msg("degraded mode switched")
+	}
+}
+
+// ToggleDegraded switches degraded mode to opposite state and returns new state.
+func (srv *Server) ToggleDegraded() bool {
+	for {
+		current := srv.degraded.Load()
+		if srv.degraded.CompareAndSwap(current, !current) {
+			log.Warn().Bool("degraded", !current).Msg("degraded mode switched")
+			return !current
+		}
+	}
+}
+
+// IsDegraded reports whether degraded mode is enabled.
+func (srv *Server) IsDegraded() bool {
+	return srv.degraded.Load()
+}
+
+// degradedModeMiddleware rejects requests with methods outside of allowed set while degraded mode is enabled.
+// Batch is rejected entirely if any of its requests is not allowed.
+func (srv *Server) degradedModeMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !srv.degraded.Load() {
+			next(ctx)
+			return
+		}
+
+		for _, req := range GetReqCtx(ctx).Request {
+			if _, ok := srv.degradedAllowedMethods[req.Method]; ok {
+				continue
+			}
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("method", req.Method).
+				Msg("method rejected in degraded mode")
+			writeJSONRPCError(ctx, fasthttp.StatusServiceUnavailable,
+				jsonRPCMethodNotSupportedCode, "method is unavailable in degraded mode")
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// rejectedWSDegraded returns json-rpc error response and true if msg calls method outside of allowed set
+// while degraded mode is enabled. Batch is rejected entirely if any of its requests is not allowed.
+func (srv *Server) rejectedWSDegraded(ctx *WSContext, msg json.RawMessage) (any, bool) {
+	if !srv.degraded.Load() {
+		return nil, false
+	}
+
+	rejection, req, rejected := rejectedWSRequest(msg, func(req wsRequest) bool {
+		_, ok := srv.degradedAllowedMethods[req.Method]
+		return !ok
+	}, jsonRPCMethodNotSupportedCode, "method is unavailable in degraded mode")
+	if rejected {
+		log.Info().
+			Uint64("request_id", ctx.requestID).
+			Str("method", req.Method).
+			Msg("method rejected in degraded mode")
+	}
+	return rejection, rejected
+}
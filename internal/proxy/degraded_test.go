@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_degradedModeMiddleware(t *testing.T) {
+	srv := &Server{
+		degradedAllowedMethods: map[string]struct{}{
+			"eth_call":       {},
+			"eth_getBalance": {},
+		},
+	}
+	testCases := []struct {
+		name     string
+		degraded bool
+		body     string
+		served   bool
+	}{
+		{
+			name:     "read in degraded mode",
+			degraded: true,
+			body:     `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`,
+			served:   true,
+		},
+		{
+			name:     "write in degraded mode",
+			degraded: true,
+			body:     `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`,
+			served:   false,
+		},
+		{
+			name:     "batch with write in degraded mode",
+			degraded: true,
+			body: `[{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":[]},` +
+				`{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction","params":["0x00"]}]`,
+			served: false,
+		},
+		{
+			name:     "write in normal mode",
+			degraded: false,
+			body:     `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`,
+			served:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv.SetDegraded(tc.degraded)
+
+			var served bool
+			handler := srv.requestParserMiddleware(srv.degradedModeMiddleware(func(*fasthttp.RequestCtx) {
+				served = true
+			}))
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBody([]byte(tc.body))
+			handler(ctx)
+
+			require.Equal(t, tc.served, served)
+			if tc.served {
+				return
+			}
+			require.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+			require.True(t, json.Valid(ctx.Response.Body()))
+			require.Contains(t, string(ctx.Response.Body()), `"code":-32004`)
+			for _, resp := range GetReqCtx(ctx).Response {
+				require.True(t, resp.HasError())
+			}
+		})
+	}
+}
+
+func Test_Server_ToggleDegraded(t *testing.T) {
+	var srv Server
+	require.False(t, srv.IsDegraded())
+	require.True(t, srv.ToggleDegraded())
+	require.True(t, srv.IsDegraded())
+	require.False(t, srv.ToggleDegraded())
+	require.False(t, srv.IsDegraded())
+}
+
+func Test_Server_wsHandler_degradedMode(t *testing.T) {
+	upstream := &fakeWSUpstream{}
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	srv := &Server{
+		nameToLBAlgo:           map[string]string{"/mainnet": config.RRName, "/mux": config.RRName},
+		nameToChainID:          map[string]int64{"/mainnet": 1, "/mux": 1},
+		wsMultiplexed:          map[string]struct{}{"/mux": {}},
+		upgrader:               websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		degradedAllowedMethods: map[string]struct{}{"eth_call": {}},
+	}
+	srv.wsMuxes = newWSMuxPool(srv.initWSConnWithProvider)
+	addr := serveWS(t, srv, "ws://"+strings.TrimPrefix(server.URL, "http://"), "")
+	const sendTx = `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`
+
+	for _, path := range []string{"/mainnet", "/mux"} {
+		t.Run(path, func(t *testing.T) {
+			conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+path, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			var resp map[string]any
+			sent := upstream.received("eth_sendRawTransaction")
+
+			srv.SetDegraded(true)
+			defer srv.SetDegraded(false)
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(sendTx)))
+			var rejection jsonRPCErrorResponse
+			require.NoError(t, conn.ReadJSON(&rejection))
+			require.Equal(t, int64(jsonRPCMethodNotSupportedCode), rejection.Error.Code)
+
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage,
+				[]byte(`{"jsonrpc":"2.0","id":2,"method":"eth_call","params":[]}`)))
+			require.NoError(t, conn.ReadJSON(&resp))
+			require.Equal(t, "eth_call", resp["result"])
+			require.Equal(t, sent, upstream.received("eth_sendRawTransaction"))
+
+			// connection opened in degraded mode serves every method once it is disabled.
+			srv.SetDegraded(false)
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(sendTx)))
+			require.NoError(t, conn.ReadJSON(&resp))
+			require.Equal(t, "eth_sendRawTransaction", resp["result"])
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+const dnsDialTimeout = 5 * time.Second
+
+// resolver resolves host to ip addresses, implemented by net.Resolver.
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsError reports failed provider host resolution.
+type dnsError struct {
+	host string
+	err  error
+}
+
+func (e *dnsError) Error() string {
+	return fmt.Sprintf("can not resolve provider host '%s': %v", e.host, e.err)
+}
+
+func (e *dnsError) Unwrap() error {
+	return e.err
+}
+
+// dnsCache caches resolved provider hosts for ttl and failed resolutions for negativeTTL,
+// so intermittent dns failures don't add lookup latency to every request.
+type dnsCache struct {
+	resolver    resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(r resolver, cfg config.DNSCache) *dnsCache {
+	return &dnsCache{
+		resolver:    r,
+		ttl:         cfg.TTL,
+		negativeTTL: cfg.NegativeTTL,
+		entries:     make(map[string]dnsEntry),
+	}
+}
+
+// lookup returns cached addresses or error of host, resolving it when cache entry is expired.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+
+	c.mutex.Lock()
+	entry, ok := c.entries[host]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.addrs, entry.err
+	}
+
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses found")
+	}
+	entry = dnsEntry{addrs: addrs, err: err, expires: now.Add(c.ttl)}
+	if err != nil {
+		entry = dnsEntry{err: &dnsError{host: host, err: err}, expires: now.Add(c.negativeTTL)}
+	}
+
+	c.mutex.Lock()
+	c.entries[host] = entry
+	c.mutex.Unlock()
+
+	return entry.addrs, entry.err
+}
+
+// dial connects to addr resolving its host through cache, used as fasthttp.Client dial func.
+func (c *dnsCache) dial(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s': %w", addr, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsDialTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialErr error
+	for _, ip := range addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = err
+	}
+	return nil, fmt.Errorf("can not dial provider host '%s': %w", host, dialErr)
+}
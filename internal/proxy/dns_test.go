@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// testResolver resolves hosts from static map and counts lookups.
+type testResolver struct {
+	hosts   map[string]string
+	lookups atomic.Int64
+}
+
+func (r *testResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups.Add(1)
+	ip, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func Test_dnsCache(t *testing.T) {
+	r := &testResolver{hosts: map[string]string{"provider.test": "127.0.0.1"}}
+
+	t.Run("positive caching", func(t *testing.T) {
+		r.lookups.Store(0)
+		c := newDNSCache(r, config.DNSCache{TTL: time.Minute})
+		for range 3 {
+			addrs, err := c.lookup(context.Background(), "provider.test")
+			require.NoError(t, err)
+			require.Len(t, addrs, 1)
+		}
+		require.Equal(t, int64(1), r.lookups.Load())
+	})
+	t.Run("negative caching", func(t *testing.T) {
+		r.lookups.Store(0)
+		c := newDNSCache(r, config.DNSCache{NegativeTTL: time.Minute})
+		for range 3 {
+			_, err := c.lookup(context.Background(), "unknown.test")
+			var dnsErr *dnsError
+			require.ErrorAs(t, err, &dnsErr)
+		}
+		require.Equal(t, int64(1), r.lookups.Load())
+	})
+	t.Run("expired entry", func(t *testing.T) {
+		r.lookups.Store(0)
+		c := newDNSCache(r, config.DNSCache{NegativeTTL: time.Millisecond})
+		_, err := c.lookup(context.Background(), "unknown.test")
+		require.Error(t, err)
+		time.Sleep(5 * time.Millisecond)
+		_, err = c.lookup(context.Background(), "unknown.test")
+		require.Error(t, err)
+		require.Equal(t, int64(2), r.lookups.Load())
+	})
+}
+
+func Test_Server_dnsFailureCooldown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	r := &testResolver{hosts: map[string]string{"healthy.test": "127.0.0.1"}}
+	cache := newDNSCache(r, config.DNSCache{TTL: time.Minute, NegativeTTL: time.Minute})
+
+	_, err = cache.dial("broken.test:" + upstreamURL.Port())
+	require.True(t, errors.As(err, new(*dnsError)))
+
+	srv := &Server{
+		cli:          &fasthttp.Client{Dial: cache.dial},
+		nameToLBAlgo: map[string]string{"/test": config.P2CEWMAName},
+		chainToP2CEWMA: map[string]*balancer.P2CEWMA{
+			"/test": balancer.NewP2CEWMADefault([]balancer.Payload{
+				{Name: "broken", URL: "http://broken.test:" + upstreamURL.Port()},
+				{Name: "healthy", URL: "http://healthy.test:" + upstreamURL.Port()},
+			}),
+		},
+	}
+	handler := srv.requestParserMiddleware(srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))
+
+	providers := make(map[string]int)
+	for range 20 {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/test")
+		ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+		handler(ctx)
+		providers[GetReqCtx(ctx).Provider]++
+	}
+	// broken provider is put in cooldown after first dns failure.
+	require.LessOrEqual(t, providers["broken"], 1)
+	require.GreaterOrEqual(t, providers["healthy"], 19)
+}
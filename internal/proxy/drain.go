@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// wsCloseTimeout bounds writing of close frame to client on shutdown.
+const wsCloseTimeout = time.Second
+
+// wsRegistry tracks active client websocket connections, so they are closed on shutdown.
+// Nil registry tracks nothing.
+type wsRegistry struct {
+	mutex    sync.Mutex
+	conns    map[*websocket.Conn]struct{}
+	wg       sync.WaitGroup
+	draining bool
+}
+
+func newWSRegistry() *wsRegistry {
+	return &wsRegistry{conns: make(map[*websocket.Conn]struct{})}
+}
+
+// add registers connection, false is returned if registry is draining and connection must be closed.
+func (r *wsRegistry) add(conn *websocket.Conn) bool {
+	if r == nil {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.draining {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	r.wg.Add(1)
+	return true
+}
+
+// remove unregisters connection closed by its handler.
+func (r *wsRegistry) remove(conn *websocket.Conn) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.conns[conn]; ok {
+		delete(r.conns, conn)
+		r.wg.Done()
+	}
+}
+
+// drain sends going away close frame to every connection and waits until their handlers return
+// or ctx is done, connections left open are closed then. New connections are not registered anymore.
+func (r *wsRegistry) drain(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	r.draining = true
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn := range r.conns {
+		// write control is safe to call concurrently with writes of handler.
+		_ = conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(wsCloseTimeout))
+	}
+	r.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return
+	case <-ctx.Done():
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for conn := range r.conns {
+		// close of hijacked connection is deferred by fasthttp until handler returns,
+		// so blocked read is interrupted by deadline.
+		_ = conn.SetReadDeadline(time.Now())
+		_ = conn.Close()
+	}
+}
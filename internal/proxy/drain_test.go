@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// newDrainServer returns started server proxying websocket sessions to echo upstream
+// and answering http requests after delay.
+func newDrainServer(t *testing.T, timeout, delay time.Duration) (*Server, string) {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(messageType, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	srv := &Server{
+		nameToLBAlgo:    map[string]string{"/ws": config.RRName},
+		nameToChainID:   map[string]int64{"/ws": 1},
+		upgrader:        websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		wsConns:         newWSRegistry(),
+		shutdownTimeout: timeout,
+		done:            make(chan struct{}),
+	}
+	wsHandler := srv.wsUpgrader(func(ctx *WSContext) {
+		ctx.providerName = "node"
+		ctx.providerURL = "ws://" + strings.TrimPrefix(upstream.URL, "http://")
+		srv.wsHandler(ctx)
+	})
+	srv.srv = &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/ws" {
+			wsHandler(ctx)
+			return
+		}
+		time.Sleep(delay)
+		ctx.SetBodyString("ok")
+	}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.srv.Serve(ln) }()
+
+	return srv, ln.Addr().String()
+}
+
+func Test_Server_Stop_drain(t *testing.T) {
+	srv, addr := newDrainServer(t, 5*time.Second, 200*time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	closeErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closeErr <- err
+				return
+			}
+		}
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/mainnet") //nolint:noctx // test request
+		if err != nil {
+			status <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond) // request is in-flight when shutdown starts
+
+	start := time.Now()
+	srv.Stop()
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// in-flight request is served and websocket client is asked to leave.
+	require.Equal(t, http.StatusOK, <-status)
+	require.True(t, websocket.IsCloseError(<-closeErr, websocket.CloseGoingAway))
+
+	// new connections are not accepted.
+	_, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	require.Error(t, err)
+}
+
+func Test_Server_Stop_drainTimeout(t *testing.T) {
+	srv, addr := newDrainServer(t, 100*time.Millisecond, 0)
+
+	// client never reads, so close handshake is not completed and session is closed by timeout.
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		srv.wsConns.mutex.Lock()
+		defer srv.wsConns.mutex.Unlock()
+		return len(srv.wsConns.conns) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	start := time.Now()
+	srv.Stop()
+	require.Less(t, time.Since(start), time.Second)
+	require.Eventually(t, func() bool {
+		srv.wsConns.mutex.Lock()
+		defer srv.wsConns.mutex.Unlock()
+		return len(srv.wsConns.conns) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// session registered while draining is closed at once.
+	require.False(t, srv.wsConns.add(conn))
+}
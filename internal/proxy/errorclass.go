@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"slices"
+	"strings"
+)
+
+// defaultUserErrorCodes are codes of errors always caused by user call in isUserCallError.
+var defaultUserErrorCodes = []int64{-32003, -32004, -32006, -32010, -32600, -32601, -32602, -32700} //nolint:gochecknoglobals // constant set
+
+// defaultNonUserErrorPatterns are messages of provider failures reported with user error code in isUserCallError.
+var defaultNonUserErrorPatterns = []string{"block range limit exceeded"} //nolint:gochecknoglobals // constant set
+
+// errorClassifier tells json-rpc errors caused by user call from provider failures for rpc
+// with own classification, chains report reverts and limits differently. Nil classifier uses isUserCallError.
+type errorClassifier struct {
+	userCodes       map[int64]struct{}
+	nonUserPatterns []string // lower case
+}
+
+// newErrorClassifier returns classifier of rpc, nil if rpc uses default classification.
+// Codes and patterns which are not set are taken from defaults. If serverErrorsAsUser is set,
+// every -32000 error is user one unless its message matches non-user pattern.
+func newErrorClassifier(userCodes []int64, nonUserPatterns []string, serverErrorsAsUser bool) *errorClassifier {
+	if len(userCodes) == 0 && len(nonUserPatterns) == 0 && !serverErrorsAsUser {
+		return nil
+	}
+	if len(userCodes) == 0 {
+		userCodes = defaultUserErrorCodes
+	}
+	if serverErrorsAsUser {
+		userCodes = append(slices.Clip(userCodes), -32000)
+	}
+	if len(nonUserPatterns) == 0 {
+		nonUserPatterns = defaultNonUserErrorPatterns
+	}
+	c := &errorClassifier{userCodes: make(map[int64]struct{}, len(userCodes))}
+	for _, code := range userCodes {
+		c.userCodes[code] = struct{}{}
+	}
+	for _, pattern := range nonUserPatterns {
+		c.nonUserPatterns = append(c.nonUserPatterns, strings.ToLower(pattern))
+	}
+	return c
+}
+
+// isUserError returns true if error is caused by user call and does not count as provider failure.
+// Message matching any non-user pattern is provider failure whatever its code. Error with -32000 code,
+// which is not listed in user codes, is user one only for reverts and underpriced replacements.
+func (c *errorClassifier) isUserError(code int64, msg string) bool {
+	if c == nil {
+		return isUserCallError(code, msg)
+	}
+	m := strings.ToLower(msg)
+	for _, pattern := range c.nonUserPatterns {
+		if strings.Contains(m, pattern) {
+			return false
+		}
+	}
+	if _, ok := c.userCodes[code]; ok {
+		return true
+	}
+	return code == -32000 && isUserCallMessage(m)
+}
+
+// errorClassifier returns classifier of provider serving rpc. Fallback provider is named
+// as fallback/<rpc>/<provider> and its errors are classified by its own rpc.
+func (srv *Server) errorClassifier(rpcName, provider string) *errorClassifier {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		rpcName, _, _ = strings.Cut(key, "/")
+	}
+	return srv.nameToErrorClassifier["/"+rpcName]
+}
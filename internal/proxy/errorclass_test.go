@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_newErrorClassifier(t *testing.T) {
+	require.Nil(t, newErrorClassifier(nil, nil, false))
+	require.NotNil(t, newErrorClassifier(nil, nil, true))
+
+	testCases := []struct {
+		name       string
+		classifier *errorClassifier
+		code       int64
+		msg        string
+		user       bool
+	}{
+		{name: "default revert", code: -32000, msg: "execution reverted", user: true},
+		{name: "default block range", code: -32602, msg: "Block range limit exceeded", user: false},
+		{name: "default internal error", code: -32603, msg: "internal error", user: false},
+		{
+			name:       "custom code",
+			classifier: newErrorClassifier([]int64{3, -32000}, nil, false),
+			code:       3, msg: "execution reverted: ERC20: transfer amount exceeds balance", user: true,
+		},
+		{
+			name:       "custom code replaces defaults",
+			classifier: newErrorClassifier([]int64{3}, nil, false),
+			code:       -32602, msg: "invalid argument", user: false,
+		},
+		{
+			name:       "custom listed -32000 with any message",
+			classifier: newErrorClassifier([]int64{-32000}, nil, false),
+			code:       -32000, msg: "VM Exception while processing transaction: revert", user: true,
+		},
+		{
+			name:       "custom unlisted -32000 revert",
+			classifier: newErrorClassifier([]int64{3}, nil, false),
+			code:       -32000, msg: "execution reverted", user: true,
+		},
+		{
+			name:       "custom pattern",
+			classifier: newErrorClassifier([]int64{-32000}, []string{"Header not found"}, false),
+			code:       -32000, msg: "header not found", user: false,
+		},
+		{
+			name:       "custom pattern replaces defaults",
+			classifier: newErrorClassifier(nil, []string{"header not found"}, false),
+			code:       -32602, msg: "block range limit exceeded", user: true,
+		},
+		{
+			name:       "default codes with custom pattern",
+			classifier: newErrorClassifier(nil, []string{"rate limited"}, false),
+			code:       -32005, msg: "rate limited", user: false,
+		},
+		{
+			name:       "server errors as user",
+			classifier: newErrorClassifier(nil, nil, true),
+			code:       -32000, msg: "insufficient funds for gas * price + value", user: true,
+		},
+		{
+			name:       "server errors as user keep default codes",
+			classifier: newErrorClassifier(nil, nil, true),
+			code:       -32602, msg: "invalid argument", user: true,
+		},
+		{
+			name:       "server errors as user with pattern",
+			classifier: newErrorClassifier(nil, []string{"header not found"}, true),
+			code:       -32000, msg: "header not found", user: false,
+		},
+		{
+			name:       "server errors as user do not change other codes",
+			classifier: newErrorClassifier([]int64{3}, nil, true),
+			code:       -32603, msg: "internal error", user: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.user, tc.classifier.isUserError(tc.code, tc.msg))
+		})
+	}
+}
+
+func Test_Server_errorClassifier(t *testing.T) {
+	polygon := newErrorClassifier([]int64{-32000}, nil, false)
+	srv := &Server{nameToErrorClassifier: map[string]*errorClassifier{"/polygon": polygon}}
+
+	require.Same(t, polygon, srv.errorClassifier("polygon", "node"))
+	require.Nil(t, srv.errorClassifier("optimism", "node"))
+	// errors of fallback provider are classified by its own rpc.
+	require.Same(t, polygon, srv.errorClassifier("optimism", "fallback/polygon/node"))
+	require.Nil(t, srv.errorClassifier("polygon", "fallback/optimism/node"))
+}
+
+func Test_Server_proxyToProvider_errorClassifier(t *testing.T) {
+	srv := &Server{nameToErrorClassifier: map[string]*errorClassifier{
+		"/polygon": newErrorClassifier([]int64{-32000}, nil, false),
+	}}
+	failed := func(rpcName string) int64 {
+		lb := &countingBalancer{}
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/" + rpcName)
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = rpcName })
+		srv.proxyToProvider(ctx, lb, config.LCName, func(ctx *fasthttp.RequestCtx) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) {
+				rc.Response = []JSONRPCResponse{{Error: JSONRPCError{Code: -32000, Message: "VM Exception: revert"}}}
+			})
+		})
+		return lb.failed.Load()
+	}
+
+	// revert with unusual message is user error only for rpc classifying every -32000 as user one.
+	require.Zero(t, failed("polygon"))
+	require.Equal(t, int64(1), failed("optimism"))
+}
+
+func Test_Server_proxyToProvider_serverErrorsAsUser(t *testing.T) {
+	srv := &Server{nameToErrorClassifier: map[string]*errorClassifier{
+		"/geth": newErrorClassifier(nil, nil, true),
+	}}
+	failed := func(rpcName string) int64 {
+		lb := &countingBalancer{}
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/" + rpcName)
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = rpcName })
+		srv.proxyToProvider(ctx, lb, config.LCName, func(ctx *fasthttp.RequestCtx) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) {
+				rc.Response = []JSONRPCResponse{{Error: JSONRPCError{
+					Code: -32000, Message: "insufficient funds for gas * price + value",
+				}}}
+			})
+		})
+		return lb.failed.Load()
+	}
+
+	// insufficient funds penalizes provider only if rpc does not treat server errors as user ones.
+	require.Zero(t, failed("geth"))
+	require.Equal(t, int64(1), failed("mainnet"))
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// providerFault returns fault injected into provider serving rpc, fallback provider
+// is named as fallback/<rpc>/<provider> and has fault of its own rpc.
+func (srv *Server) providerFault(rpcName, provider string) (config.Fault, bool) {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		fault, exist := srv.providerToFault[key]
+		return fault, exist
+	}
+	fault, exist := srv.providerToFault[rpcName+"/"+provider]
+	return fault, exist
+}
+
+// faultInjectionMiddleware delays requests to providers with injected fault and fails
+// share of them with bad gateway, the same way as transport errors are answered.
+// Balancer observes injected latency and failures as if provider was really degraded.
+func (srv *Server) faultInjectionMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if len(srv.providerToFault) == 0 {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reqctx := GetReqCtx(ctx)
+		fault, exist := srv.providerFault(reqctx.RPCName, reqctx.Provider)
+		if !exist {
+			next(ctx)
+			return
+		}
+
+		time.Sleep(fault.Latency)
+		if rand.Float64() < fault.ErrorRate { //nolint:gosec // unnecessary
+			writeJSONRPCError(ctx, fasthttp.StatusBadGateway, jsonRPCInternalErrorCode, "upstream unavailable")
+			return
+		}
+		next(ctx)
+		// injected latency is accounted as provider latency, so balancer observes it.
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.UpstreamLatency += fault.Latency })
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_faultInjectionMiddleware(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	testCases := []struct {
+		name  string
+		fault config.Fault
+	}{
+		{name: "latency", fault: config.Fault{Latency: 100 * time.Millisecond}},
+		{name: "errors", fault: config.Fault{ErrorRate: 1}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &Server{
+				cli:             &fasthttp.Client{},
+				nameToChainID:   map[string]int64{"/test": 1},
+				nameToLBAlgo:    map[string]string{"/test": config.P2CEWMAName},
+				providerToFault: map[string]config.Fault{"test/faulty": tc.fault},
+				chainToP2CEWMA: map[string]*balancer.P2CEWMA{
+					"/test": balancer.NewP2CEWMADefault([]balancer.Payload{
+						{Name: "faulty", URL: upstream.URL},
+						{Name: "healthy", URL: upstream.URL},
+					}),
+				},
+			}
+			handler := srv.routerHandler(srv.requestParserMiddleware(srv.loadBalancerMiddleware(
+				srv.responseParserMiddleware(srv.faultInjectionMiddleware(srv.handler)))))
+
+			providers := make(map[string]int)
+			for range 20 {
+				ctx := &fasthttp.RequestCtx{}
+				ctx.Request.SetRequestURI("/test")
+				ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+				handler(ctx)
+				providers[GetReqCtx(ctx).Provider]++
+			}
+			// balancer avoids faulty provider after it observed injected fault,
+			// latency is above 75ms score p2cewma gives to providers without observations.
+			require.LessOrEqual(t, providers["faulty"], 2)
+			require.GreaterOrEqual(t, providers["healthy"], 18)
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_handler_gzip(t *testing.T) {
+	const respBody = `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted"}}`
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(respBody))
+		_ = gz.Close()
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:        &fasthttp.Client{},
+		metricsCfg: config.Metrics{Enabled: true},
+	}
+	handler := srv.metricsMiddleware(srv.requestParserMiddleware(srv.responseParserMiddleware(srv.handler)))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`))
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.ConnURL = upstream.URL
+		rc.ChainID = 1
+		rc.RPCName = "gzip-test"
+		rc.Provider = "gzip-provider"
+		rc.Balancer = config.RRName
+		rc.Client = "gzip-client"
+	})
+
+	observer := metrics.ResponseSizeBytes.WithLabelValues(
+		"1", "gzip-test", metrics.HTTPTransport, "gzip-provider", config.RRName, "eth_call", "gzip-client",
+	)
+	summary, ok := observer.(prometheus.Metric)
+	require.True(t, ok)
+	var before dto.Metric
+	require.NoError(t, summary.Write(&before))
+
+	handler(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, respBody, string(ctx.Response.Body()))
+	require.Empty(t, ctx.Response.Header.ContentEncoding())
+
+	reqctx := GetReqCtx(ctx)
+	require.Len(t, reqctx.Response, 1)
+	require.True(t, reqctx.Response[0].HasError())
+	require.Equal(t, int64(-32000), reqctx.Response[0].Error.Code)
+
+	var m dto.Metric
+	require.NoError(t, summary.Write(&m))
+	require.Equal(t, before.GetSummary().GetSampleCount()+1, m.GetSummary().GetSampleCount())
+	require.InDelta(t, float64(len(respBody)), m.GetSummary().GetSampleSum()-before.GetSummary().GetSampleSum(), 0)
+}
+
+func Test_Server_handler_contentEncoding(t *testing.T) {
+	const respBody = `{"jsonrpc":"2.0","id":1,"result":"0x1"}`
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		_, _ = zw.Write([]byte(respBody))
+		_ = zw.Close()
+
+		w.Header().Set("Content-Encoding", r.URL.Query().Get("encoding"))
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer upstream.Close()
+
+	testCases := []struct {
+		name     string
+		encoding string
+		status   int
+		body     string
+	}{
+		{
+			name:     "deflate is decoded",
+			encoding: "deflate",
+			status:   fasthttp.StatusOK,
+			body:     respBody,
+		},
+		{
+			name:     "unsupported encoding",
+			encoding: "compress",
+			status:   fasthttp.StatusBadGateway,
+			body:     `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"invalid upstream response"}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &Server{cli: &fasthttp.Client{}}
+			handler := srv.requestParserMiddleware(srv.responseParserMiddleware(srv.handler))
+
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`))
+			SetToReqCtx(ctx, func(rc *ReqCtx) {
+				rc.ConnURL = upstream.URL + "?encoding=" + tc.encoding
+			})
+			handler(ctx)
+
+			require.Equal(t, tc.status, ctx.Response.StatusCode())
+			require.JSONEq(t, tc.body, string(ctx.Response.Body()))
+			require.Empty(t, ctx.Response.Header.ContentEncoding())
+		})
+	}
+}
+
+func Test_Server_handler_batchErrorCodes(t *testing.T) {
+	const respBody = `[
+		{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted"}},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"method not found"}},
+		{"jsonrpc":"2.0","id":3,"error":{"code":-31999,"message":"custom"}},
+		{"jsonrpc":"2.0","id":4,"result":"0x1"}
+	]`
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(respBody))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:        &fasthttp.Client{},
+		metricsCfg: config.Metrics{Enabled: true},
+	}
+	handler := srv.metricsMiddleware(srv.requestParserMiddleware(srv.responseParserMiddleware(srv.handler)))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetBody([]byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_foo","params":[]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_call","params":[]},
+		{"jsonrpc":"2.0","id":4,"method":"eth_call","params":[]}
+	]`))
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.ConnURL = upstream.URL
+		rc.ChainID = 1
+		rc.RPCName = "error-code-test"
+		rc.Provider = "error-code-provider"
+		rc.Balancer = config.RRName
+		rc.Client = "error-code-client"
+	})
+
+	counter := func(method, code string) prometheus.Counter {
+		return metrics.ClientRequestError.WithLabelValues("1", "error-code-test", metrics.HTTPTransport,
+			"error-code-provider", config.RRName, method, "error-code-client", code)
+	}
+	reverted := testutil.ToFloat64(counter("eth_call", "-32000"))
+	notFound := testutil.ToFloat64(counter("eth_foo", "-32601"))
+	other := testutil.ToFloat64(counter("eth_call", metrics.OtherErrorCode))
+
+	handler(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.InDelta(t, reverted+1, testutil.ToFloat64(counter("eth_call", "-32000")), 0)
+	require.InDelta(t, notFound+1, testutil.ToFloat64(counter("eth_foo", "-32601")), 0)
+	require.InDelta(t, other+1, testutil.ToFloat64(counter("eth_call", metrics.OtherErrorCode)), 0)
+}
+
+func Test_Server_handler_reorderedBatch(t *testing.T) {
+	// provider answers batch in reverse order.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"jsonrpc":"2.0","id":2,"result":"0x1"},
+			{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted"}}
+		]`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:        &fasthttp.Client{},
+		metricsCfg: config.Metrics{Enabled: true},
+	}
+	handler := srv.metricsMiddleware(srv.requestParserMiddleware(srv.responseParserMiddleware(srv.handler)))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetBodyString(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}
+	]`)
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.ConnURL = upstream.URL
+		rc.ChainID = 1
+		rc.RPCName = "reorder-test"
+		rc.Provider = "reorder-provider"
+		rc.Balancer = config.RRName
+		rc.Client = "reorder-client"
+	})
+
+	counter := func(method string) prometheus.Counter {
+		return metrics.ClientRequestError.WithLabelValues("1", "reorder-test", metrics.HTTPTransport,
+			"reorder-provider", config.RRName, method, "reorder-client", "-32000")
+	}
+	call := testutil.ToFloat64(counter("eth_call"))
+	blockNumber := testutil.ToFloat64(counter("eth_blockNumber"))
+
+	handler(ctx)
+
+	// error of id 1 is attributed to eth_call although it is the second response.
+	require.InDelta(t, call+1, testutil.ToFloat64(counter("eth_call")), 0)
+	require.InDelta(t, blockNumber, testutil.ToFloat64(counter("eth_blockNumber")), 0)
+}
+
+func Test_Server_metricsMiddleware_batchMethodLatency(t *testing.T) {
+	histogram := func(method string) *dto.Histogram {
+		observer := metrics.BatchMethodLatencySeconds.WithLabelValues(
+			"1", "batch-latency-test", "batch-latency-provider", config.RRName, method, "batch-latency-client",
+		)
+		metric, ok := observer.(prometheus.Metric)
+		require.True(t, ok)
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		return m.GetHistogram()
+	}
+	serve := func(enabled bool) {
+		srv := &Server{metricsCfg: config.Metrics{Enabled: true, BatchMethodLatency: enabled}}
+		handler := srv.metricsMiddleware(func(ctx *fasthttp.RequestCtx) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) {
+				rc.ChainID = 1
+				rc.RPCName = "batch-latency-test"
+				rc.Provider = "batch-latency-provider"
+				rc.Balancer = config.RRName
+				rc.Client = "batch-latency-client"
+				rc.Latency = 0.3
+				rc.Request = []JSONRPCRequest{{Method: "eth_call"}, {Method: "eth_getLogs"}, {Method: "eth_call"}}
+				rc.Response = []JSONRPCResponse{{}, {}, {}}
+			})
+		})
+		handler(&fasthttp.RequestCtx{})
+	}
+	call, logs := histogram("eth_call"), histogram("eth_getLogs")
+
+	serve(false)
+	require.Equal(t, call.GetSampleCount(), histogram("eth_call").GetSampleCount())
+
+	// latency of batch is divided equally between its three requests.
+	serve(true)
+	require.Equal(t, call.GetSampleCount()+2, histogram("eth_call").GetSampleCount())
+	require.InDelta(t, 0.2, histogram("eth_call").GetSampleSum()-call.GetSampleSum(), 1e-9)
+	require.Equal(t, logs.GetSampleCount()+1, histogram("eth_getLogs").GetSampleCount())
+	require.InDelta(t, 0.1, histogram("eth_getLogs").GetSampleSum()-logs.GetSampleSum(), 1e-9)
+}
+
+func Test_Server_metricsMiddleware_requestSLO(t *testing.T) {
+	srv := &Server{
+		metricsCfg:      config.Metrics{Enabled: true},
+		nameToSLOTarget: map[string]float64{"/slo-test": 0.5},
+	}
+	serve := func(rpcName string, latency float64) {
+		handler := srv.metricsMiddleware(func(ctx *fasthttp.RequestCtx) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) {
+				rc.RPCName = rpcName
+				rc.Latency = latency
+				rc.Request = []JSONRPCRequest{{Method: "eth_call"}}
+				rc.Response = []JSONRPCResponse{{}}
+			})
+		})
+		handler(&fasthttp.RequestCtx{})
+	}
+	within := metrics.RequestSLO.WithLabelValues("slo-test", "true")
+	over := metrics.RequestSLO.WithLabelValues("slo-test", "false")
+	withinBefore, overBefore := testutil.ToFloat64(within), testutil.ToFloat64(over)
+
+	serve("slo-test", 0.1)
+	serve("slo-test", 0.5)
+	serve("slo-test", 0.7)
+	require.InDelta(t, withinBefore+2, testutil.ToFloat64(within), 0)
+	require.InDelta(t, overBefore+1, testutil.ToFloat64(over), 0)
+
+	// rpc without target is not counted.
+	series := testutil.CollectAndCount(metrics.RequestSLO)
+	serve("slo-disabled-test", 0.1)
+	require.Equal(t, series, testutil.CollectAndCount(metrics.RequestSLO))
+}
+
+func Test_Server_handler_requestIDHeader(t *testing.T) {
+	const header = "X-Request-Id"
+
+	testCases := []struct {
+		name   string
+		header string
+	}{
+		{name: "header configured", header: header},
+		{name: "header not configured"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requestID string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestID = r.Header.Get(header)
+				_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+			}))
+			defer upstream.Close()
+
+			srv := &Server{cli: &fasthttp.Client{}, requestIDHdr: tc.header}
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Init(&fasthttp.Request{}, nil, nil)
+			ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`))
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.ConnURL = upstream.URL })
+
+			srv.handler(ctx)
+
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+			if tc.header == "" {
+				require.Empty(t, requestID)
+				return
+			}
+			require.Equal(t, strconv.FormatUint(ctx.ID(), 10), requestID)
+		})
+	}
+}
+
+func Test_isBatch(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "batch", body: ` [{"id":1}]`, want: true},
+		{name: "single", body: `{"id":1}`},
+		{name: "bom batch", body: "\xef\xbb\xbf[{\"id\":1}]", want: true},
+		{name: "bom single", body: "\xef\xbb\xbf{\"id\":1}"},
+		{name: "empty"},
+		{name: "whitespace only", body: " \n\t\r"},
+		{name: "malformed", body: `x[`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, isBatch([]byte(tc.body)))
+		})
+	}
+}
+
+func Test_Server_requestParserMiddleware(t *testing.T) {
+	testCases := []struct {
+		name        string
+		body        string
+		wantMethods []string
+		wantBody    string // body forwarded to next
+		wantError   string // message of parse error answered without calling next
+	}{
+		{
+			name:        "bom single",
+			body:        "\xef\xbb\xbf{\"id\":1,\"method\":\"eth_chainId\"}",
+			wantMethods: []string{"eth_chainId"},
+			wantBody:    `{"id":1,"method":"eth_chainId"}`,
+		},
+		{
+			name:        "bom batch",
+			body:        "\xef\xbb\xbf[{\"id\":1,\"method\":\"eth_chainId\"},{\"id\":2,\"method\":\"eth_blockNumber\"}]",
+			wantMethods: []string{"eth_chainId", "eth_blockNumber"},
+			wantBody:    `[{"id":1,"method":"eth_chainId"},{"id":2,"method":"eth_blockNumber"}]`,
+		},
+		{name: "empty", wantError: "parse error: empty body"},
+		{name: "whitespace only", body: " \n\t\r", wantError: "parse error: empty body"},
+		{name: "bom only", body: "\xef\xbb\xbf", wantError: "parse error: empty body"},
+		{name: "malformed", body: `{"id":1,"method":`, wantError: "parse error"},
+		{name: "malformed batch", body: `[{"id":1,"method":"eth_chainId"},`, wantError: "parse error"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				called  bool
+				methods []string
+				body    string
+			)
+			handler := (&Server{}).requestParserMiddleware(func(ctx *fasthttp.RequestCtx) {
+				called = true
+				body = string(ctx.Request.Body())
+				for _, req := range GetReqCtx(ctx).Request {
+					methods = append(methods, req.Method)
+				}
+			})
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBodyString(tc.body)
+			handler(ctx)
+
+			if tc.wantError != "" {
+				require.False(t, called)
+				require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+				require.JSONEq(t,
+					`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"`+tc.wantError+`"}}`,
+					string(ctx.Response.Body()))
+				return
+			}
+			require.True(t, called)
+			require.Equal(t, tc.wantMethods, methods)
+			require.Equal(t, tc.wantBody, body)
+		})
+	}
+}
+
+func Test_Server_handler_parseError(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls.Add(1)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	lb := balancer.NewRoundRobin([]balancer.Payload{{Name: "parse-error-provider", URL: upstream.URL}})
+	srv := &Server{
+		cli:           &fasthttp.Client{},
+		metricsCfg:    config.Metrics{Enabled: true},
+		nameToLBAlgo:  map[string]string{"/parse-error-test": config.RRName},
+		nameToChainID: map[string]int64{"/parse-error-test": 1},
+		chainToRR:     map[string]*balancer.RoundRobin{"/parse-error-test": lb},
+	}
+	handler := srv.routerHandler(srv.metricsMiddleware(srv.requestParserMiddleware(
+		srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))))
+	counter := metrics.ClientRequestError.WithLabelValues("1", "parse-error-test", metrics.HTTPTransport,
+		"", "", "", "", strconv.Itoa(jsonRPCParseErrorCode))
+	parseErrors := testutil.ToFloat64(counter)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/parse-error-test")
+	ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"to":"0x1"`)
+	handler(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`,
+		string(ctx.Response.Body()))
+	require.Zero(t, upstreamCalls.Load())
+	require.InDelta(t, parseErrors+1, testutil.ToFloat64(counter), 0)
+}
+
+func Test_Server_handler_unreachableUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	upstream.Close()
+
+	srv := &Server{cli: &fasthttp.Client{}}
+	handler := srv.requestParserMiddleware(srv.handler)
+	serve := func(body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetBodyString(body)
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.ConnURL = upstream.URL
+			rc.RPCName = "unreachable-test"
+			rc.Provider = "unreachable-provider"
+		})
+		handler(ctx)
+		return ctx
+	}
+
+	ctx := serve(`{"jsonrpc":"2.0","id":7,"method":"eth_chainId"}`)
+	require.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	require.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":7,"error":{"code":-32603,"message":"upstream unavailable"}}`,
+		string(ctx.Response.Body()))
+
+	ctx = serve(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":"b","method":"eth_blockNumber"}]`)
+	require.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	require.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"upstream unavailable"}},
+		{"jsonrpc":"2.0","id":"b","error":{"code":-32603,"message":"upstream unavailable"}}
+	]`, string(ctx.Response.Body()))
+}
+
+func Test_Server_handler_notifications(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte(`"id"`)) {
+			_, _ = w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"}]`))
+		}
+		// notifications are answered with empty body.
+	}))
+	defer upstream.Close()
+
+	lb := balancer.NewRoundRobin([]balancer.Payload{{Name: "notification-provider", URL: upstream.URL}})
+	srv := &Server{
+		cli:           &fasthttp.Client{},
+		metricsCfg:    config.Metrics{Enabled: true},
+		nameToLBAlgo:  map[string]string{"/notification-test": config.RRName},
+		nameToChainID: map[string]int64{"/notification-test": 1},
+		chainToRR:     map[string]*balancer.RoundRobin{"/notification-test": lb},
+	}
+	handler := srv.routerHandler(srv.metricsMiddleware(srv.requestParserMiddleware(
+		srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))))
+	total := func(method string, status int) prometheus.Counter {
+		return metrics.RequestTotalCounter.WithLabelValues("1", "notification-test", metrics.HTTPTransport,
+			"notification-provider", config.RRName, method, "", metrics.StatusCodeLabel(status))
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantTotal  map[string]int
+	}{
+		{
+			name:       "single notification",
+			body:       `{"jsonrpc":"2.0","method":"eth_subscribeNotify"}`,
+			wantStatus: fasthttp.StatusNoContent,
+			wantTotal:  map[string]int{"eth_subscribeNotify": fasthttp.StatusNoContent},
+		},
+		{
+			name:       "batch of notifications",
+			body:       `[{"jsonrpc":"2.0","method":"eth_batchNotify"},{"jsonrpc":"2.0","method":"eth_batchNotify"}]`,
+			wantStatus: fasthttp.StatusNoContent,
+			wantTotal:  map[string]int{"eth_batchNotify": fasthttp.StatusNoContent},
+		},
+		{
+			name:       "batch of notification and call",
+			body:       `[{"jsonrpc":"2.0","method":"eth_mixedNotify"},{"jsonrpc":"2.0","id":1,"method":"eth_mixedCall"}]`,
+			wantStatus: fasthttp.StatusOK,
+			wantBody:   `[{"jsonrpc":"2.0","id":1,"result":"0x1"}]`,
+			wantTotal: map[string]int{
+				"eth_mixedNotify": fasthttp.StatusOK,
+				"eth_mixedCall":   fasthttp.StatusOK,
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := make(map[string]float64, len(tc.wantTotal))
+			for method, status := range tc.wantTotal {
+				before[method] = testutil.ToFloat64(total(method, status))
+			}
+
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI("/notification-test")
+			ctx.Request.SetBodyString(tc.body)
+			handler(ctx)
+
+			require.Equal(t, tc.wantStatus, ctx.Response.StatusCode())
+			if tc.wantBody == "" {
+				require.Empty(t, ctx.Response.Body())
+			} else {
+				require.JSONEq(t, tc.wantBody, string(ctx.Response.Body()))
+			}
+			for method, status := range tc.wantTotal {
+				require.Greater(t, testutil.ToFloat64(total(method, status)), before[method], method)
+			}
+		})
+	}
+}
+
+func Test_Server_proxyToProvider_notifications(t *testing.T) {
+	srv := &Server{}
+	failed := func(status int, request ...JSONRPCRequest) int64 {
+		lb := &countingBalancer{}
+		ctx := &fasthttp.RequestCtx{}
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Request = request })
+		srv.proxyToProvider(ctx, lb, config.RRName, func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(status)
+		})
+		return lb.failed.Load()
+	}
+
+	// provider answering notifications with no content is not penalized.
+	require.Zero(t, failed(fasthttp.StatusNoContent, JSONRPCRequest{Method: "eth_notify"}))
+	// but call without response is.
+	require.Equal(t, int64(1), failed(fasthttp.StatusNoContent, JSONRPCRequest{ID: json.RawMessage("1")}))
+}
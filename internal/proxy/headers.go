@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// droppedUpstreamHeaders are headers of provider response never forwarded to client: hop-by-hop headers
+// describe connection to provider and body length and encoding are set by gateway, body is always sent decoded.
+var droppedUpstreamHeaders = map[string]struct{}{ //nolint:gochecknoglobals // constant set
+	"connection":          {},
+	"keep-alive":          {},
+	"proxy-authenticate":  {},
+	"proxy-authorization": {},
+	"proxy-connection":    {},
+	"te":                  {},
+	"trailer":             {},
+	"transfer-encoding":   {},
+	"upgrade":             {},
+	"content-length":      {},
+	"content-encoding":    {},
+}
+
+// keptUpstreamHeaders are headers of provider response always forwarded to client.
+var keptUpstreamHeaders = map[string]struct{}{ //nolint:gochecknoglobals // constant set
+	"content-type": {},
+	"retry-after":  {},
+}
+
+// headerFilter selects headers of provider response forwarded to client by configured allowlist or denylist.
+// Nil filter forwards every header but dropped ones.
+type headerFilter struct {
+	allowed map[string]struct{}
+	denied  map[string]struct{}
+}
+
+// newHeaderFilter returns header filter, nil if neither allowlist nor denylist is configured.
+func newHeaderFilter(cfg config.UpstreamHeaders) *headerFilter {
+	if len(cfg.Allowed) == 0 && len(cfg.Denied) == 0 {
+		return nil
+	}
+	toSet := func(headers []string) map[string]struct{} {
+		if len(headers) == 0 {
+			return nil
+		}
+		set := make(map[string]struct{}, len(headers))
+		for _, header := range headers {
+			set[strings.ToLower(strings.TrimSpace(header))] = struct{}{}
+		}
+		return set
+	}
+	return &headerFilter{allowed: toSet(cfg.Allowed), denied: toSet(cfg.Denied)}
+}
+
+// forwarded returns true if header with lowercase name is forwarded to client.
+func (f *headerFilter) forwarded(name string, connectionTokens [][]byte) bool {
+	if _, ok := keptUpstreamHeaders[name]; ok {
+		return true
+	}
+	if _, ok := droppedUpstreamHeaders[name]; ok {
+		return false
+	}
+	// headers named by Connection header are hop-by-hop too.
+	for _, token := range connectionTokens {
+		if string(token) == name {
+			return false
+		}
+	}
+	if f == nil {
+		return true
+	}
+	if f.allowed != nil {
+		_, ok := f.allowed[name]
+		return ok
+	}
+	_, ok := f.denied[name]
+	return !ok
+}
+
+// copy adds forwarded headers of src to dst.
+func (f *headerFilter) copy(dst, src *fasthttp.ResponseHeader) {
+	var connectionTokens [][]byte
+	for token := range bytes.SplitSeq(src.Peek(fasthttp.HeaderConnection), []byte(",")) {
+		if token = bytes.ToLower(bytes.TrimSpace(token)); len(token) > 0 {
+			connectionTokens = append(connectionTokens, token)
+		}
+	}
+	for key, value := range src.All() {
+		if f.forwarded(strings.ToLower(string(key)), connectionTokens) {
+			dst.AddBytesKV(key, value)
+		}
+	}
+}
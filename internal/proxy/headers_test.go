@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_headerFilter_copy(t *testing.T) {
+	var src fasthttp.ResponseHeader
+	src.SetContentType("application/json")
+	src.Set("Connection", "X-Hop, keep-alive")
+	src.Set("X-Hop", "1")
+	src.Set("Keep-Alive", "timeout=5")
+	src.Set("Transfer-Encoding", "chunked")
+	src.Set("Content-Encoding", "gzip")
+	src.Set("Retry-After", "10")
+	src.Set("X-Ratelimit-Remaining", "0")
+	src.Set("X-Backend", "node-1")
+
+	testCases := []struct {
+		name string
+		cfg  config.UpstreamHeaders
+		want []string
+	}{
+		{
+			name: "no lists",
+			want: []string{"Content-Type", "Retry-After", "X-Ratelimit-Remaining", "X-Backend"},
+		},
+		{
+			name: "allowed",
+			cfg:  config.UpstreamHeaders{Allowed: []string{"x-ratelimit-remaining", "Keep-Alive"}},
+			want: []string{"Content-Type", "Retry-After", "X-Ratelimit-Remaining"},
+		},
+		{
+			name: "denied",
+			cfg:  config.UpstreamHeaders{Denied: []string{"X-Backend", "Retry-After"}},
+			want: []string{"Content-Type", "Retry-After", "X-Ratelimit-Remaining"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var dst fasthttp.ResponseHeader
+			newHeaderFilter(tc.cfg).copy(&dst, &src)
+
+			var got []string
+			for key := range dst.All() {
+				got = append(got, string(key))
+			}
+			require.ElementsMatch(t, tc.want, got)
+			require.Equal(t, "application/json", string(dst.ContentType()))
+		})
+	}
+}
+
+func Test_Server_handler_upstreamHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Retry-After", "10")
+		w.Header().Set("X-Backend", "node-1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:          &fasthttp.Client{},
+		nameToLBAlgo: map[string]string{"/mainnet": config.RRName},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/mainnet": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+		upstreamHeaders: newHeaderFilter(config.UpstreamHeaders{Denied: []string{"X-Backend"}}),
+	}
+	handler := srv.requestParserMiddleware(srv.loadBalancerMiddleware(srv.handler))
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/mainnet")
+	ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_call"}`))
+	SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = "mainnet" })
+	handler(ctx)
+
+	require.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	require.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+	require.Equal(t, "10", string(ctx.Response.Header.Peek("Retry-After")))
+	require.Empty(t, ctx.Response.Header.Peek("X-Hop"))
+	require.Empty(t, ctx.Response.Header.Peek("Keep-Alive"))
+	require.Empty(t, ctx.Response.Header.Peek("X-Backend"))
+	require.False(t, ctx.Response.ConnectionClose())
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+)
+
+// healthzDetailedPath is path of endpoint reporting healthy providers of every rpc.
+const healthzDetailedPath = "/healthz/detailed"
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// healthReporter is implemented by balancers which report health of their providers.
+type healthReporter interface {
+	Health() []balancer.ProviderHealth
+}
+
+// weightReporter is implemented by balancers which tune weights of their providers.
+type weightReporter interface {
+	Weights() []balancer.ProviderWeight
+}
+
+// rpcHealth is health of rpc providers returned by detailed health endpoint.
+type rpcHealth struct {
+	Status    string   `json:"status"`
+	Healthy   int      `json:"healthy"`
+	Total     int      `json:"total"`
+	Unhealthy []string `json:"unhealthy,omitempty"`
+	// Weights are current weights of providers of adaptive-weighted balancer.
+	Weights []balancer.ProviderWeight `json:"weights,omitempty"`
+}
+
+// detailedHealth is response of detailed health endpoint.
+type detailedHealth struct {
+	Status string               `json:"status"`
+	RPCs   map[string]rpcHealth `json:"rpcs"`
+}
+
+// newRPCHealth returns rpc status by health of its providers:
+// ok if all providers are healthy, down if none of them is healthy and degraded otherwise.
+func newRPCHealth(providers []balancer.ProviderHealth) rpcHealth {
+	health := rpcHealth{Total: len(providers)}
+	for _, provider := range providers {
+		if provider.Healthy {
+			health.Healthy++
+		} else {
+			health.Unhealthy = append(health.Unhealthy, provider.Name)
+		}
+	}
+	switch health.Healthy {
+	case health.Total:
+		health.Status = healthOK
+	case 0:
+		health.Status = healthDown
+	default:
+		health.Status = healthDegraded
+	}
+	return health
+}
+
+// detailedHealth returns health of every rpc, overall status is the worst status of rpcs.
+func (srv *Server) detailedHealth() detailedHealth {
+	result := detailedHealth{Status: healthOK, RPCs: make(map[string]rpcHealth, len(srv.rpcs))}
+	for _, rpc := range srv.rpcs {
+		lb, _ := srv.getBalancer("/" + rpc.Name)
+		reporter, ok := lb.(healthReporter)
+		if !ok {
+			continue
+		}
+		health := newRPCHealth(reporter.Health())
+		if weights, ok := lb.(weightReporter); ok {
+			health.Weights = weights.Weights()
+		}
+		result.RPCs[rpc.Name] = health
+		if health.Status == healthDown || (health.Status == healthDegraded && result.Status == healthOK) {
+			result.Status = health.Status
+		}
+	}
+	return result
+}
+
+// writeDetailedHealth answers with health of every rpc, service unavailable is returned
+// if any rpc has no healthy providers, so it can be used by monitoring as is.
+func (srv *Server) writeDetailedHealth(ctx *fasthttp.RequestCtx) {
+	health := srv.detailedHealth()
+	raw, err := json.Marshal(health)
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal detailed health")
+		ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+		return
+	}
+	if health.Status == healthDown {
+		ctx.Response.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.SetBody(raw)
+}
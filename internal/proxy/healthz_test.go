@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_healthzProbeMiddleware_detailed(t *testing.T) {
+	mainnet := balancer.NewP2CEWMA([]balancer.Payload{
+		{Name: "infura", URL: "http://infura"},
+		{Name: "alchemy", URL: "http://alchemy"},
+	}, 0.3, 8, 0.8, time.Hour)
+	sepolia := balancer.NewRoundRobin([]balancer.Payload{{Name: "public", URL: "http://public"}})
+	srv := &Server{
+		rpcs:           []config.RPC{{Name: "mainnet"}, {Name: "sepolia"}},
+		healthzCfg:     config.Healthz{Detailed: true},
+		nameToLBAlgo:   map[string]string{"/mainnet": config.P2CEWMAName, "/sepolia": config.RRName},
+		chainToP2CEWMA: map[string]*balancer.P2CEWMA{"/mainnet": mainnet},
+		chainToRR:      map[string]*balancer.RoundRobin{"/sepolia": sepolia},
+	}
+	handler := srv.healthzProbeMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+	})
+	request := func() (int, detailedHealth) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(healthzDetailedPath)
+		handler(ctx)
+		var health detailedHealth
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &health))
+		return ctx.Response.StatusCode(), health
+	}
+
+	status, health := request()
+	require.Equal(t, fasthttp.StatusOK, status)
+	require.Equal(t, detailedHealth{Status: healthOK, RPCs: map[string]rpcHealth{
+		"mainnet": {Status: healthOK, Healthy: 2, Total: 2},
+		"sepolia": {Status: healthOK, Healthy: 1, Total: 1},
+	}}, health)
+
+	// failed provider is in cooldown, so rpc is degraded.
+	provider, release := mainnet.Borrow()
+	release(false, time.Millisecond)
+	status, health = request()
+	require.Equal(t, fasthttp.StatusOK, status)
+	require.Equal(t, healthDegraded, health.Status)
+	require.Equal(t, rpcHealth{Status: healthDegraded, Healthy: 1, Total: 2, Unhealthy: []string{provider.Name}},
+		health.RPCs["mainnet"])
+
+	sepolia.Eject("public")
+	status, health = request()
+	require.Equal(t, fasthttp.StatusServiceUnavailable, status)
+	require.Equal(t, healthDown, health.Status)
+	require.Equal(t, rpcHealth{Status: healthDown, Total: 1, Unhealthy: []string{"public"}}, health.RPCs["sepolia"])
+
+	// detailed endpoint is disabled by default.
+	srv.healthzCfg.Detailed = false
+	handler = srv.healthzProbeMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+	})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(healthzDetailedPath)
+	handler(ctx)
+	require.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func Test_Server_healthzProbeMiddleware_detailedWeights(t *testing.T) {
+	mainnet := balancer.NewAdaptiveWeighted([]balancer.Payload{
+		{Name: "fast", URL: "http://fast"},
+		{Name: "slow", URL: "http://slow"},
+	}, 1, 0.1)
+	srv := &Server{
+		rpcs:         []config.RPC{{Name: "mainnet"}},
+		healthzCfg:   config.Healthz{Detailed: true},
+		nameToLBAlgo: map[string]string{"/mainnet": config.AWName},
+		chainToAW:    map[string]*balancer.AdaptiveWeighted{"/mainnet": mainnet},
+	}
+	handler := srv.healthzProbeMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+	})
+
+	for range 2 {
+		provider, release := mainnet.Borrow()
+		if provider.Name == "fast" {
+			release(true, time.Millisecond)
+		} else {
+			release(true, 3*time.Millisecond)
+		}
+	}
+	mainnet.Adjust()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(healthzDetailedPath)
+	handler(ctx)
+	var health detailedHealth
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &health))
+	require.Equal(t, rpcHealth{
+		Status: healthOK, Healthy: 2, Total: 2,
+		Weights: []balancer.ProviderWeight{{Name: "fast", Weight: 1.5}, {Name: "slow", Weight: 0.5}},
+	}, health.RPCs["mainnet"])
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	alpnHTTP2  = "h2"
+	alpnHTTP11 = "http/1.1"
+	// alpnHandshakeTimeout bounds tls handshake of connection before it is passed to server of its protocol
+	// and reading of h2 request headers.
+	alpnHandshakeTimeout = 10 * time.Second
+)
+
+// serveALPN serves http/1.1 and h2 clients on ln, protocol is negotiated during tls handshake.
+// Http/1.1 connections, including websocket ones, are served by fasthttp server. H2 connections are served
+// by net/http server passing requests to the same handler, so every middleware applies to them too.
+func (srv *Server) serveALPN(ln net.Listener) error {
+	tlsCfg := srv.srv.TLSConfig.Clone()
+	tlsCfg.NextProtos = []string{alpnHTTP2, alpnHTTP11}
+	alpn := newALPNListener(tls.NewListener(ln, tlsCfg))
+	go alpn.serve()
+
+	errs := make(chan error, 2)
+	go func() { errs <- srv.srv.Serve(alpn.http11) }()
+	go func() { errs <- srv.h2srv.Serve(alpn.http2) }()
+	var err error
+	for range 2 {
+		e := <-errs
+		// listeners are closed on shutdown of either server, so closed listener is not an error.
+		if e == nil || errors.Is(e, http.ErrServerClosed) || errors.Is(e, net.ErrClosed) || err != nil {
+			continue
+		}
+		err = e
+		alpn.close()
+	}
+	return err
+}
+
+// shutdownHTTP2 closes h2 connections once their in-flight requests are done, if h2 is served.
+func (srv *Server) shutdownHTTP2(ctx context.Context) error {
+	if srv.h2srv == nil {
+		return nil
+	}
+	return srv.h2srv.Shutdown(ctx)
+}
+
+// alpnListener accepts tls connections and passes them to listener of their negotiated protocol.
+// Closing any of listeners closes all of them.
+type alpnListener struct {
+	ln     net.Listener
+	http11 *connListener
+	http2  *connListener
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newALPNListener(ln net.Listener) *alpnListener {
+	l := &alpnListener{ln: ln, done: make(chan struct{})}
+	l.http11 = &connListener{alpn: l, conns: make(chan net.Conn)}
+	l.http2 = &connListener{alpn: l, conns: make(chan net.Conn)}
+	return l
+}
+
+// serve accepts connections until listener is closed, handshakes are done concurrently,
+// so slow client does not delay others.
+func (l *alpnListener) serve() {
+	defer l.close()
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return
+		}
+		go l.route(conn)
+	}
+}
+
+// route completes tls handshake of conn and passes it to listener of negotiated protocol.
+func (l *alpnListener) route(conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Now().Add(alpnHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Debug().Err(err).Str("remote_ip", conn.RemoteAddr().String()).Msg("tls handshake failed")
+		_ = conn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	target := l.http11
+	if tlsConn.ConnectionState().NegotiatedProtocol == alpnHTTP2 {
+		target = l.http2
+	}
+	select {
+	case target.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *alpnListener) close() {
+	l.once.Do(func() {
+		close(l.done)
+		_ = l.ln.Close()
+	})
+}
+
+// connListener is listener of connections of one protocol routed by alpnListener.
+type connListener struct {
+	alpn  *alpnListener
+	conns chan net.Conn
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.alpn.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.alpn.close()
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.alpn.ln.Addr()
+}
+
+// h2Handler adapts handler to net/http. Request is copied to fasthttp request context
+// and its response is copied back once handler returns.
+func (srv *Server) h2Handler(handler fasthttp.RequestHandler) http.Handler {
+	maxBodySize := srv.srv.MaxRequestBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = fasthttp.DefaultMaxRequestBodySize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBodySize)))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init2(newH2Conn(r), h2Logger{}, true)
+		ctx.Request.Header.SetMethod(r.Method)
+		ctx.Request.SetRequestURI(r.URL.RequestURI())
+		ctx.Request.Header.SetHost(r.Host)
+		for key, values := range r.Header {
+			for _, value := range values {
+				ctx.Request.Header.Add(key, value)
+			}
+		}
+		ctx.Request.SetBody(body)
+
+		handler(ctx)
+
+		for key, value := range ctx.Response.Header.All() {
+			switch strings.ToLower(string(key)) {
+			// connection specific headers are not allowed in h2, length is set by net/http.
+			case "connection", "keep-alive", "transfer-encoding", "upgrade", "content-length":
+				continue
+			}
+			w.Header().Add(string(key), string(value))
+		}
+		w.WriteHeader(ctx.Response.StatusCode())
+		if err = ctx.Response.BodyWriteTo(w); err != nil {
+			log.Debug().Uint64("request_id", ctx.ID()).Err(err).Msg("can not write h2 response")
+		}
+	})
+}
+
+// h2Conn is connection of h2 request as seen by fasthttp handlers: addresses and tls state of client
+// connection. Reading and writing is done by net/http, so embedded net.Conn is never used.
+type h2Conn struct {
+	net.Conn
+
+	local  net.Addr
+	remote net.Addr
+	state  tls.ConnectionState
+}
+
+func newH2Conn(r *http.Request) *h2Conn {
+	conn := &h2Conn{local: zeroAddr, remote: zeroAddr}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.local = local
+	}
+	if remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		conn.remote = remote
+	}
+	if r.TLS != nil {
+		conn.state = *r.TLS
+	}
+	return conn
+}
+
+//nolint:gochecknoglobals // constant address
+var zeroAddr = &net.TCPAddr{IP: net.IPv4zero}
+
+func (c *h2Conn) LocalAddr() net.Addr  { return c.local }
+func (c *h2Conn) RemoteAddr() net.Addr { return c.remote }
+
+// Handshake and ConnectionState make fasthttp treat request as received over tls,
+// so mtls clients are identified by their certificates.
+func (c *h2Conn) Handshake() error                     { return nil }
+func (c *h2Conn) ConnectionState() tls.ConnectionState { return c.state }
+
+// h2Logger is logger of fasthttp request contexts of h2 requests.
+type h2Logger struct{}
+
+func (h2Logger) Printf(format string, args ...any) {
+	log.Debug().Msgf(format, args...)
+}
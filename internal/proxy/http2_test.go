@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+
+	accepted atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// newHTTP2Server returns server serving http/1.1 and h2 clients with handler wrapped into test layer,
+// client trusting its certificate and listener counting connections.
+func newHTTP2Server(tb testing.TB, handler fasthttp.RequestHandler) (*Server, *tls.Config, *countingListener) {
+	tb.Helper()
+
+	cert := newTestCert(tb, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rpcgate"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.cert)
+
+	srv := &Server{shutdownTimeout: time.Second, done: make(chan struct{}), wsConns: newWSRegistry()}
+	withHeader := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			next(ctx)
+			ctx.Response.Header.Set("X-Layer", "applied")
+		}
+	}
+	chained := srv.recoverHandler(srv.chain(srv.recoverMiddleware("test_h2_handler", handler),
+		layer{"test_h2", withHeader},
+	))
+	srv.srv = &fasthttp.Server{
+		Handler:   chained,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}, MinVersion: tls.VersionTLS12},
+	}
+	srv.h2srv = &http.Server{
+		Handler:           srv.h2Handler(chained),
+		ReadHeaderTimeout: alpnHandshakeTimeout,
+		ErrorLog:          stdlog.New(io.Discard, "", 0),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	counting := &countingListener{Listener: ln}
+	go func() { _ = srv.serveALPN(counting) }()
+
+	return srv, &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}, counting
+}
+
+func Test_Server_serveALPN(t *testing.T) {
+	srv, clientTLS, ln := newHTTP2Server(t, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/panic" {
+			panic("h2")
+		}
+		ctx.Response.Header.Set("X-Remote-Ip", ctx.RemoteIP().String())
+		ctx.Response.Header.Set("X-Tls", map[bool]string{true: "yes", false: "no"}[ctx.IsTLS()])
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(string(ctx.Method()) + " " + string(ctx.Path()) + " " +
+			string(ctx.Request.Header.Peek("X-Client")) + " " + string(ctx.Request.Body()))
+	})
+	url := "https://" + ln.Addr().String()
+
+	// transport adds h2 to protocols of its tls config, so clients do not share it.
+	h2Client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS.Clone(), ForceAttemptHTTP2: true}}
+	req, err := http.NewRequest(http.MethodPost, url+"/mainnet", bytes.NewReader([]byte(`{"id":1}`)))
+	require.NoError(t, err)
+	req.Header.Set("X-Client", "indexer")
+	resp, err := h2Client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// h2 request passes through the same handler and layers.
+	require.Equal(t, 2, resp.ProtoMajor)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `POST /mainnet indexer {"id":1}`, string(body))
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	require.Equal(t, "applied", resp.Header.Get("X-Layer"))
+	require.Equal(t, "127.0.0.1", resp.Header.Get("X-Remote-Ip"))
+	require.Equal(t, "yes", resp.Header.Get("X-Tls"))
+
+	resp, err = h2Client.Post(url+"/panic", "application/json", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 2, resp.ProtoMajor)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	// http/1.1 clients are served on the same port.
+	h1Client := &fasthttp.Client{TLSConfig: clientTLS.Clone()}
+	status, h1Body, err := h1Client.Post(nil, url+"/mainnet", nil)
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusOK, status)
+	require.Equal(t, "POST /mainnet  ", string(h1Body))
+
+	srv.Stop()
+	_, err = net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	require.Error(t, err)
+}
+
+func Benchmark_Server_serveALPN(b *testing.B) {
+	testCases := []struct {
+		name      string
+		transport func(*tls.Config) *http.Transport
+	}{
+		{
+			name: "http1.1",
+			transport: func(clientTLS *tls.Config) *http.Transport {
+				return &http.Transport{
+					TLSClientConfig:     clientTLS,
+					TLSNextProto:        map[string]func(string, *tls.Conn) http.RoundTripper{},
+					MaxIdleConnsPerHost: 64,
+				}
+			},
+		},
+		{
+			name: "h2",
+			transport: func(clientTLS *tls.Config) *http.Transport {
+				return &http.Transport{TLSClientConfig: clientTLS, ForceAttemptHTTP2: true}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		b.Run(tc.name, func(b *testing.B) {
+			srv, clientTLS, ln := newHTTP2Server(b, func(ctx *fasthttp.RequestCtx) {
+				ctx.SetBodyString(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+			})
+			defer srv.Stop()
+			client := &http.Client{Transport: tc.transport(clientTLS)}
+			defer client.CloseIdleConnections()
+			url := "https://" + ln.Addr().String() + "/mainnet"
+			post := func() error {
+				resp, err := client.Post(url, "application/json", bytes.NewReader([]byte(`{"id":1}`)))
+				if err != nil {
+					return err
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				return resp.Body.Close()
+			}
+			// the first connection is established before concurrent requests,
+			// so h2 client reuses it instead of racing to dial.
+			require.NoError(b, post())
+
+			b.ResetTimer()
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := post(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			// connections opened by client, one for h2 and up to one per concurrent request for http/1.1.
+			b.ReportMetric(float64(ln.accepted.Load()), "conns")
+		})
+	}
+}
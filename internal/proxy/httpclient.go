@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// httpClient is own client of http requests to providers of rpc, so connection pool
+// and timeout of one rpc do not affect others.
+type httpClient struct {
+	cli     *fasthttp.Client
+	timeout time.Duration // bounds requests to providers without their own timeout, 0 means no timeout
+	// streamThreshold is size of responses above which they are streamed, 0 means they are buffered.
+	streamThreshold int
+}
+
+// newHTTPClient returns client of rpc dialing with dial, nil if rpc uses default client.
+func newHTTPClient(cfg config.HTTPClient, dial fasthttp.DialFunc) *httpClient {
+	if cfg == (config.HTTPClient{}) {
+		return nil
+	}
+	return &httpClient{
+		cli: &fasthttp.Client{
+			Dial:                dial,
+			MaxConnsPerHost:     cfg.MaxConnsPerHost,
+			MaxIdleConnDuration: cfg.MaxIdleConnDuration,
+			// bodies up to threshold are read at once, larger ones are left in stream.
+			StreamResponseBody:  cfg.StreamResponseBytes > 0,
+			MaxResponseBodySize: cfg.StreamResponseBytes,
+		},
+		timeout:         cfg.Timeout,
+		streamThreshold: cfg.StreamResponseBytes,
+	}
+}
+
+// httpClient returns client of provider serving rpc and its timeout, default client without timeout
+// if rpc has no own client. Fallback provider is named as fallback/<rpc>/<provider> and is requested
+// with client of its own rpc.
+func (srv *Server) httpClient(rpcName, provider string) (*fasthttp.Client, time.Duration) {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		rpcName, _, _ = strings.Cut(key, "/")
+	}
+	if c, ok := srv.nameToHTTPClient["/"+rpcName]; ok {
+		return c.cli, c.timeout
+	}
+	return srv.cli, 0
+}
+
+// streamThreshold returns size of responses of provider serving rpc above which they are streamed
+// to client, 0 if rpc buffers responses.
+func (srv *Server) streamThreshold(rpcName, provider string) int {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		rpcName, _, _ = strings.Cut(key, "/")
+	}
+	if c, ok := srv.nameToHTTPClient["/"+rpcName]; ok {
+		return c.streamThreshold
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_newHTTPClient(t *testing.T) {
+	require.Nil(t, newHTTPClient(config.HTTPClient{}, nil))
+
+	c := newHTTPClient(config.HTTPClient{Timeout: time.Minute, MaxConnsPerHost: 16}, nil)
+	require.NotNil(t, c)
+	require.Equal(t, time.Minute, c.timeout)
+	require.Equal(t, 16, c.cli.MaxConnsPerHost)
+}
+
+func Test_Server_handler_httpClient(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli: &fasthttp.Client{},
+		nameToHTTPClient: map[string]*httpClient{
+			"/hot": newHTTPClient(config.HTTPClient{Timeout: 50 * time.Millisecond}, nil),
+		},
+	}
+	serve := func(rpcName, provider string) int {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, nil, nil)
+		ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.ConnURL = upstream.URL
+			rc.RPCName = rpcName
+			rc.Provider = provider
+		})
+		srv.handler(ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	// hot rpc times out with its own client, archive rpc waits with default one.
+	require.Equal(t, fasthttp.StatusGatewayTimeout, serve("hot", "node"))
+	require.Equal(t, fasthttp.StatusOK, serve("archive", "node"))
+	// fallback provider is requested with client of its own rpc.
+	require.Equal(t, fasthttp.StatusGatewayTimeout, serve("archive", "fallback/hot/node"))
+	require.Equal(t, fasthttp.StatusOK, serve("hot", "fallback/archive/node"))
+
+	// clients are reused between requests.
+	cli, _ := srv.httpClient("hot", "node")
+	require.Same(t, srv.nameToHTTPClient["/hot"].cli, cli)
+	cli, _ = srv.httpClient("archive", "node")
+	require.Same(t, srv.cli, cli)
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// json-rpc error codes returned by rpcgate itself.
+const (
+	jsonRPCParseErrorCode         = -32700
+	jsonRPCInvalidRequestCode     = -32600
+	jsonRPCMethodNotFoundCode     = -32601
+	jsonRPCInternalErrorCode      = -32603
+	jsonRPCMethodNotSupportedCode = -32004
+	jsonRPCLimitExceededCode      = -32005
+	jsonRPCVersion                = "2.0"
+)
+
+// jsonRPCErrorResponse json-rpc response spec struct returned by rpcgate on rejected requests.
+type jsonRPCErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   JSONRPCError    `json:"error"`
+}
+
+// newJSONRPCErrorResponse returns json-rpc error response for request with passed id.
+// Nil id is encoded as null.
+func newJSONRPCErrorResponse(id json.RawMessage, code int64, msg string) jsonRPCErrorResponse {
+	return jsonRPCErrorResponse{
+		JSONRPC: jsonRPCVersion,
+		ID:      id,
+		Error: JSONRPCError{
+			Code:    code,
+			Message: msg,
+		},
+	}
+}
+
+// writeJSONRPCError writes json-rpc error response for every request from ReqCtx to client
+// and stores errors in ReqCtx, so they are observed by metrics as client errors.
+func writeJSONRPCError(ctx *fasthttp.RequestCtx, status int, code int64, msg string) {
+	reqctx := GetReqCtx(ctx)
+
+	var (
+		body     any
+		response = make([]JSONRPCResponse, 0, len(reqctx.Request))
+	)
+	if reqctx.Batch {
+		batch := make([]jsonRPCErrorResponse, 0, len(reqctx.Request))
+		for _, req := range reqctx.Request {
+			batch = append(batch, newJSONRPCErrorResponse(req.ID, code, msg))
+			response = append(response, JSONRPCResponse{Error: JSONRPCError{Code: code, Message: msg}})
+		}
+		body = batch
+	} else {
+		var id json.RawMessage
+		if len(reqctx.Request) > 0 {
+			id = reqctx.Request[0].ID
+		}
+		body = newJSONRPCErrorResponse(id, code, msg)
+		response = append(response, JSONRPCResponse{Error: JSONRPCError{Code: code, Message: msg}})
+	}
+	SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Response = response })
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal json-rpc error")
+		ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.SetStatusCode(status)
+	ctx.Response.SetBody(raw)
+}
+
+// calls returns requests answered by provider, notifications are skipped.
+func calls(requests []JSONRPCRequest) []JSONRPCRequest {
+	if !slices.ContainsFunc(requests, JSONRPCRequest.IsNotification) {
+		return requests
+	}
+	answered := make([]JSONRPCRequest, 0, len(requests))
+	for _, req := range requests {
+		if !req.IsNotification() {
+			answered = append(answered, req)
+		}
+	}
+	return answered
+}
+
+// onlyNotifications returns true if every request is notification, so provider answers with empty body.
+func onlyNotifications(requests []JSONRPCRequest) bool {
+	return len(requests) > 0 && !slices.ContainsFunc(requests, func(req JSONRPCRequest) bool {
+		return !req.IsNotification()
+	})
+}
+
+// alignResponses returns batch responses in order of their requests, as providers may reorder them.
+// Responses are matched by id, if any id is missing, duplicated or unknown responses are returned
+// as is and false is returned, so they are matched by position.
+func alignResponses(requests []JSONRPCRequest, responses []JSONRPCResponse) ([]JSONRPCResponse, bool) {
+	if len(requests) != len(responses) {
+		return responses, false
+	}
+	isMissing := func(id json.RawMessage) bool {
+		return len(id) == 0 || string(id) == "null"
+	}
+
+	idToResponse := make(map[string]int, len(responses))
+	for i, resp := range responses {
+		if isMissing(resp.ID) {
+			return responses, false
+		}
+		if _, duplicated := idToResponse[string(resp.ID)]; duplicated {
+			return responses, false
+		}
+		idToResponse[string(resp.ID)] = i
+	}
+	aligned := make([]JSONRPCResponse, len(requests))
+	for i, req := range requests {
+		j, ok := idToResponse[string(req.ID)]
+		if isMissing(req.ID) || !ok {
+			return responses, false
+		}
+		// every response is taken once, so duplicated request ids fall back too.
+		delete(idToResponse, string(req.ID))
+		aligned[i] = responses[j]
+	}
+	return aligned, true
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_alignResponses(t *testing.T) {
+	requests := func(ids ...string) []JSONRPCRequest {
+		result := make([]JSONRPCRequest, 0, len(ids))
+		for _, id := range ids {
+			result = append(result, JSONRPCRequest{ID: json.RawMessage(id)})
+		}
+		return result
+	}
+	responses := func(ids ...string) []JSONRPCResponse {
+		result := make([]JSONRPCResponse, 0, len(ids))
+		for i, id := range ids {
+			result = append(result, JSONRPCResponse{ID: json.RawMessage(id), Error: JSONRPCError{Code: int64(i)}})
+		}
+		return result
+	}
+
+	testCases := []struct {
+		name      string
+		requests  []JSONRPCRequest
+		responses []JSONRPCResponse
+		wantIDs   []string
+		aligned   bool
+	}{
+		{
+			name:      "in order",
+			requests:  requests(`1`, `"a"`),
+			responses: responses(`1`, `"a"`),
+			wantIDs:   []string{`1`, `"a"`},
+			aligned:   true,
+		},
+		{
+			name:      "reordered",
+			requests:  requests(`1`, `2`, `3`),
+			responses: responses(`3`, `1`, `2`),
+			wantIDs:   []string{`1`, `2`, `3`},
+			aligned:   true,
+		},
+		{name: "missing id", requests: requests(`1`, `2`), responses: responses(`2`, `null`), wantIDs: []string{`2`, `null`}},
+		{name: "duplicated request id", requests: requests(`1`, `1`), responses: responses(`1`, `2`), wantIDs: []string{`1`, `2`}},
+		{name: "duplicated response id", requests: requests(`1`, `2`), responses: responses(`2`, `2`), wantIDs: []string{`2`, `2`}},
+		{name: "unknown id", requests: requests(`1`, `2`), responses: responses(`2`, `3`), wantIDs: []string{`2`, `3`}},
+		{name: "count mismatch", requests: requests(`1`, `2`), responses: responses(`2`), wantIDs: []string{`2`}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, aligned := alignResponses(tc.requests, tc.responses)
+			require.Equal(t, tc.aligned, aligned)
+			ids := make([]string, 0, len(got))
+			for _, resp := range got {
+				ids = append(ids, string(resp.ID))
+			}
+			require.Equal(t, tc.wantIDs, ids)
+		})
+	}
+}
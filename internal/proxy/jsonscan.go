@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// errJSONSyntax is returned by jsonScanner on malformed json.
+var errJSONSyntax = errors.New("invalid json")
+
+// jsonScanner extracts fields of json-rpc messages without decoding whole message. Values of fields
+// which are not needed, like large results, are skipped by matching strings and brackets only.
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+// skipSpace skips insignificant whitespace and returns next byte, 0 at the end of data.
+func (s *jsonScanner) skipSpace() byte {
+	for ; s.pos < len(s.data); s.pos++ {
+		switch c := s.data[s.pos]; c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// value returns raw next value and moves past it.
+func (s *jsonScanner) value() ([]byte, error) {
+	c := s.skipSpace()
+	start := s.pos
+	switch c {
+	case '"':
+		if err := s.skipString(); err != nil {
+			return nil, err
+		}
+	case '{', '[':
+		if err := s.skipNested(); err != nil {
+			return nil, err
+		}
+	default:
+		if err := s.skipLiteral(); err != nil {
+			return nil, err
+		}
+	}
+	return s.data[start:s.pos], nil
+}
+
+// skipString moves past string starting at current position.
+func (s *jsonScanner) skipString() error {
+	for s.pos++; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+			return nil
+		}
+	}
+	return errJSONSyntax
+}
+
+// skipNested moves past object or array starting at current position.
+func (s *jsonScanner) skipNested() error {
+	var depth int
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '"':
+			if err := s.skipString(); err != nil {
+				return err
+			}
+			continue
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				s.pos++
+				return nil
+			}
+		}
+		s.pos++
+	}
+	return errJSONSyntax
+}
+
+// skipLiteral moves past number, true, false or null.
+func (s *jsonScanner) skipLiteral() error {
+	start := s.pos
+	for ; s.pos < len(s.data); s.pos++ {
+		c := s.data[s.pos]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '+' && c != '.' && c != 'E' {
+			break
+		}
+	}
+	switch literal := s.data[start:s.pos]; {
+	case len(literal) == 0:
+		return errJSONSyntax
+	case literal[0] == '-' || (literal[0] >= '0' && literal[0] <= '9'):
+		return nil
+	case string(literal) == "true", string(literal) == "false", string(literal) == "null":
+		return nil
+	}
+	return errJSONSyntax
+}
+
+// object calls fn with raw key and value of every field of object at current position.
+func (s *jsonScanner) object(fn func(key, value []byte) error) error {
+	if s.skipSpace() != '{' {
+		return errJSONSyntax
+	}
+	s.pos++
+	if s.skipSpace() == '}' {
+		s.pos++
+		return nil
+	}
+	for {
+		if s.skipSpace() != '"' {
+			return errJSONSyntax
+		}
+		start := s.pos
+		if err := s.skipString(); err != nil {
+			return err
+		}
+		key := s.data[start+1 : s.pos-1]
+		if s.skipSpace() != ':' {
+			return errJSONSyntax
+		}
+		s.pos++
+		value, err := s.value()
+		if err != nil {
+			return err
+		}
+		if err = fn(key, value); err != nil {
+			return err
+		}
+		switch s.skipSpace() {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return errJSONSyntax
+		}
+	}
+}
+
+// array calls fn for every element of array at current position, fn must move past element.
+func (s *jsonScanner) array(fn func() error) error {
+	if s.skipSpace() != '[' {
+		return errJSONSyntax
+	}
+	s.pos++
+	if s.skipSpace() == ']' {
+		s.pos++
+		return nil
+	}
+	for {
+		if err := fn(); err != nil {
+			return err
+		}
+		switch s.skipSpace() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return errJSONSyntax
+		}
+	}
+}
+
+// end returns error if anything but whitespace is left.
+func (s *jsonScanner) end() error {
+	if s.skipSpace() != 0 {
+		return errJSONSyntax
+	}
+	return nil
+}
+
+// scanRequest reads id, method and params of request at current position, raw values are copied.
+func (s *jsonScanner) scanRequest(req *JSONRPCRequest) error {
+	return s.object(func(key, value []byte) error {
+		switch {
+		case bytes.EqualFold(key, []byte("id")):
+			req.ID = slices.Clone(value)
+		case bytes.EqualFold(key, []byte("method")):
+			method, err := unquote(value)
+			if err != nil {
+				return err
+			}
+			req.Method = method
+		case bytes.EqualFold(key, []byte("params")):
+			req.Params = slices.Clone(value)
+		}
+		return nil
+	})
+}
+
+// scanResponse reads id and error of response at current position, result is skipped.
+func (s *jsonScanner) scanResponse(resp *JSONRPCResponse) error {
+	return s.object(func(key, value []byte) error {
+		switch {
+		case bytes.EqualFold(key, []byte("id")):
+			resp.ID = slices.Clone(value)
+		case bytes.EqualFold(key, []byte("error")) && string(value) != "null":
+			return json.Unmarshal(value, &resp.Error)
+		}
+		return nil
+	})
+}
+
+// unquote returns json string value, strings without escapes are not decoded.
+func unquote(value []byte) (string, error) {
+	if string(value) == "null" {
+		return "", nil
+	}
+	if len(value) < 2 || value[0] != '"' {
+		return "", errJSONSyntax
+	}
+	if bytes.IndexByte(value, '\\') < 0 {
+		return string(value[1 : len(value)-1]), nil
+	}
+	var str string
+	err := json.Unmarshal(value, &str)
+	return str, err
+}
+
+// parseRequests parses json-rpc request or batch of requests. Request which can't be parsed
+// is returned as a single empty request, batch which can't be parsed is returned as nil.
+func parseRequests(body []byte, batch bool) ([]JSONRPCRequest, error) {
+	s := jsonScanner{data: body}
+	if !batch {
+		request := make([]JSONRPCRequest, 1)
+		err := s.scanRequest(&request[0])
+		if err == nil {
+			err = s.end()
+		}
+		if err != nil {
+			request[0] = JSONRPCRequest{}
+		}
+		return request, err
+	}
+
+	request := make([]JSONRPCRequest, 0)
+	err := s.array(func() error {
+		request = append(request, JSONRPCRequest{})
+		return s.scanRequest(&request[len(request)-1])
+	})
+	if err == nil {
+		err = s.end()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// parseResponses parses ids and errors of json-rpc response or batch of responses. Response which can't be parsed
+// is returned as a single response without error, batch which can't be parsed is returned as nil.
+func parseResponses(body []byte, batch bool) ([]JSONRPCResponse, error) {
+	s := jsonScanner{data: body}
+	if !batch {
+		response := make([]JSONRPCResponse, 1)
+		err := s.scanResponse(&response[0])
+		if err == nil {
+			err = s.end()
+		}
+		if err != nil {
+			response[0] = JSONRPCResponse{}
+		}
+		return response, err
+	}
+
+	response := make([]JSONRPCResponse, 0)
+	err := s.array(func() error {
+		response = append(response, JSONRPCResponse{})
+		return s.scanResponse(&response[len(response)-1])
+	})
+	if err == nil {
+		err = s.end()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// parseMethod returns method of json-rpc request, other fields are skipped.
+func parseMethod(body []byte) (string, error) {
+	s := jsonScanner{data: body}
+	var method string
+	err := s.object(func(key, value []byte) error {
+		if !bytes.EqualFold(key, []byte("method")) {
+			return nil
+		}
+		var err error
+		method, err = unquote(value)
+		return err
+	})
+	if err == nil {
+		err = s.end()
+	}
+	if err != nil {
+		return "", err
+	}
+	return method, nil
+}
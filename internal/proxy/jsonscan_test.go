@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRequests(t *testing.T) {
+	testCases := []struct {
+		name    string
+		body    string
+		batch   bool
+		needErr bool
+	}{
+		{name: "request", body: `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"to":"0x1"},"latest"]}`},
+		{name: "notification", body: `{"jsonrpc":"2.0","method":"eth_subscription","params":["0x1",{"a":1}]}`},
+		{name: "no params", body: ` { "id" : "a\"b" , "method" : "eth_chainId" } `},
+		{name: "escaped method", body: `{"id":null,"method":"eth_\u0063all","params":null}`},
+		{name: "key case", body: `{"ID":1,"Method":"eth_call","PARAMS":[true,false,null,-1.5e+3]}`},
+		{
+			name:  "batch",
+			body:  `[{"id":1,"method":"eth_chainId"},{"id":2,"method":"eth_call","params":[{"a":"]}"}]}]`,
+			batch: true,
+		},
+		{name: "empty batch", body: `[]`, batch: true},
+		{name: "truncated", body: `{"id":1,"method":"eth_call","params":[`, needErr: true},
+		{name: "trailing data", body: `{"id":1,"method":"eth_call"}x`, needErr: true},
+		{name: "method not string", body: `{"id":1,"method":5}`, needErr: true},
+		{name: "bad literal", body: `{"id":tru,"method":"eth_call"}`, needErr: true},
+		{name: "malformed batch", body: `[{"id":1,"method":"eth_call"},`, batch: true, needErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRequests([]byte(tc.body), tc.batch)
+
+			var want []JSONRPCRequest
+			var wantErr error
+			if tc.batch {
+				wantErr = json.Unmarshal([]byte(tc.body), &want)
+			} else {
+				want = make([]JSONRPCRequest, 1)
+				wantErr = json.Unmarshal([]byte(tc.body), &want[0])
+			}
+			if tc.needErr {
+				require.Error(t, err)
+				require.Error(t, wantErr)
+				if !tc.batch {
+					require.Equal(t, []JSONRPCRequest{{}}, got)
+				} else {
+					require.Nil(t, got)
+				}
+				return
+			}
+			require.NoError(t, wantErr)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func Test_parseResponses(t *testing.T) {
+	testCases := []struct {
+		name    string
+		body    string
+		batch   bool
+		needErr bool
+	}{
+		{name: "result", body: `{"jsonrpc":"2.0","id":1,"result":{"logs":[{"data":"0x\"}"}]}}`},
+		{name: "error", body: `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted","data":"0x"}}`},
+		{name: "null error", body: `{"id":1,"result":"0x1","error":null}`},
+		{
+			name:  "batch",
+			body:  `[{"id":1,"result":"0x1"},{"id":2,"error":{"code":-32005,"message":"limit exceeded"}}]`,
+			batch: true,
+		},
+		{name: "html", body: `<html>bad gateway</html>`, needErr: true},
+		{name: "truncated", body: `{"id":1,"result":{"logs":[`, needErr: true},
+		{name: "batch expected", body: `{"id":1,"result":"0x1"}`, batch: true, needErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseResponses([]byte(tc.body), tc.batch)
+
+			var want []JSONRPCResponse
+			var wantErr error
+			if tc.batch {
+				wantErr = json.Unmarshal([]byte(tc.body), &want)
+			} else {
+				want = make([]JSONRPCResponse, 1)
+				wantErr = json.Unmarshal([]byte(tc.body), &want[0])
+			}
+			if tc.needErr {
+				require.Error(t, err)
+				require.Error(t, wantErr)
+				return
+			}
+			require.NoError(t, wantErr)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func Test_parseMethod(t *testing.T) {
+	method, err := parseMethod([]byte(`{"id":1,"params":[{"method":"nested"}],"method":"eth_subscribe"}`))
+	require.NoError(t, err)
+	require.Equal(t, "eth_subscribe", method)
+
+	_, err = parseMethod([]byte(`{"id":1,"method":`))
+	require.Error(t, err)
+}
+
+// newBenchmarkBatch returns batch of 100 eth_getLogs requests and responses with large results.
+func newBenchmarkBatch() ([]byte, []byte) {
+	const size = 100
+
+	var requests, responses []string
+	log := `{"address":"0xdac17f958d2ee523a2206206994597c13d831ec7",` +
+		`"topics":["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"],` +
+		`"data":"0x00000000000000000000000000000000000000000000000000000000000f4240",` +
+		`"blockNumber":"0x10d4f2a","transactionHash":"0x8f1d7c4b5c2e","logIndex":"0x1","removed":false}`
+	result := "[" + strings.Repeat(log+",", 49) + log + "]"
+	for i := range size {
+		id := strconv.Itoa(i)
+		requests = append(requests,
+			`{"jsonrpc":"2.0","id":`+id+`,"method":"eth_getLogs","params":[{"fromBlock":"0x10d4f2a","toBlock":"latest"}]}`)
+		responses = append(responses, `{"jsonrpc":"2.0","id":`+id+`,"result":`+result+`}`)
+	}
+	return []byte("[" + strings.Join(requests, ",") + "]"), []byte("[" + strings.Join(responses, ",") + "]")
+}
+
+func Benchmark_parseRequests(b *testing.B) {
+	body, _ := newBenchmarkBatch()
+	b.Run("unmarshal", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			var request []JSONRPCRequest
+			_ = json.Unmarshal(body, &request)
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			_, _ = parseRequests(body, true)
+		}
+	})
+}
+
+func Benchmark_parseResponses(b *testing.B) {
+	_, body := newBenchmarkBatch()
+	b.Run("unmarshal", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			var response []JSONRPCResponse
+			_ = json.Unmarshal(body, &response)
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			_, _ = parseResponses(body, true)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// jwksRefreshInterval is a min interval between refetches of key set on unknown key id.
+const jwksRefreshInterval = time.Minute
+
+// jwks is a json web key set fetched lazily and refetched when token is signed by unknown key.
+type jwks struct {
+	url   string
+	cli   *fasthttp.Client
+	group singleflight.Group
+
+	mutex     sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+func newJWKS(url string, cli *fasthttp.Client) *jwks {
+	return &jwks{url: url, cli: cli}
+}
+
+// key returns public key with kid, key set is refetched at most once per jwksRefreshInterval.
+// Concurrent lookups of unknown key share one fetch, lookups of known keys don't wait for it.
+func (s *jwks) key(kid string) (any, error) {
+	s.mutex.Lock()
+	key, ok := s.keys[kid]
+	fetchedAt := s.fetchedAt
+	s.mutex.Unlock()
+
+	if ok {
+		return key, nil
+	}
+	if time.Since(fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	keys, err, _ := s.group.Do(s.url, func() (any, error) {
+		return s.refresh()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not fetch jwks: %w", err)
+	}
+	if key, ok := keys.(map[string]any)[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id '%s'", kid)
+}
+
+// refresh refetches key set unless it was refetched recently, lock is not held while fetching.
+func (s *jwks) refresh() (map[string]any, error) {
+	s.mutex.Lock()
+	if time.Since(s.fetchedAt) < jwksRefreshInterval {
+		keys := s.keys
+		s.mutex.Unlock()
+		return keys, nil
+	}
+	s.fetchedAt = time.Now()
+	s.mutex.Unlock()
+
+	keys, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+	return keys, nil
+}
+
+// fetch downloads key set, keys of unsupported types are skipped.
+func (s *jwks) fetch() (map[string]any, error) {
+	const fetchTimeout = 5 * time.Second
+
+	status, body, err := s.cli.GetTimeout(nil, s.url, fetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if status != fasthttp.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("can not unmarshal jwks: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", k.Kid).Msg("skipping jwk")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// jwk is a public json web key.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("can not decode n: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("can not decode e: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("can not decode x: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("can not decode y: %w", err)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+// newJWTKeyfunc returns func resolving key which verifies token signature.
+func newJWTKeyfunc(cfg config.JWT, cli *fasthttp.Client) jwt.Keyfunc {
+	if strings.HasPrefix(cfg.Algorithm, "HS") {
+		secret := []byte(cfg.Secret)
+		return func(*jwt.Token) (any, error) { return secret, nil }
+	}
+	set := newJWKS(cfg.JWKSURL, cli)
+	return func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return set.key(kid)
+	}
+}
+
+// jwtAuthMiddleware validates bearer token and stores client from configured claim in ReqCtx.
+// Missing, expired, invalid tokens and tokens without expiration are rejected with 401.
+func (srv *Server) jwtAuthMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	const bearerPrefix = "Bearer "
+
+	cfg := srv.clients.JWT
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{cfg.Algorithm}), jwt.WithExpirationRequired())
+	keyfunc := newJWTKeyfunc(cfg, srv.cli)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		client, err := func() (string, error) {
+			header := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+			raw, ok := strings.CutPrefix(header, bearerPrefix)
+			if !ok {
+				return "", errors.New("bearer token is missing")
+			}
+			var claims jwt.MapClaims
+			if _, err := parser.ParseWithClaims(raw, &claims, keyfunc); err != nil {
+				return "", err
+			}
+			client, _ := claims[cfg.ClientClaim].(string)
+			if client == "" {
+				return "", fmt.Errorf("claim '%s' is missing", cfg.ClientClaim)
+			}
+			return client, nil
+		}()
+		if err != nil {
+			log.Info().Uint64("request_id", ctx.ID()).Err(err).Msg("invalid jwt")
+			ctx.Error("", fasthttp.StatusUnauthorized)
+			return
+		}
+
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = client })
+		next(ctx)
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_jwtAuthMiddleware(t *testing.T) {
+	const secret = "secret"
+
+	sign := func(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = "key-1"
+		raw, err := token.SignedString(key)
+		require.NoError(t, err)
+		return raw
+	}
+	serve := func(srv *Server, header string) (*fasthttp.RequestCtx, string) {
+		ctx := &fasthttp.RequestCtx{}
+		if header != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, header)
+		}
+		var client string
+		srv.authMiddleware(func(ctx *fasthttp.RequestCtx) {
+			client = GetReqCtx(ctx).Client
+		})(ctx)
+		return ctx, client
+	}
+	valid := jwt.MapClaims{"sub": "indexer", "exp": time.Now().Add(time.Hour).Unix()}
+
+	srv := &Server{clients: config.Clients{
+		Type: "jwt",
+		JWT:  config.JWT{Algorithm: "HS256", Secret: secret, ClientClaim: "sub"},
+	}}
+	testCases := []struct {
+		name   string
+		header string
+		client string
+	}{
+		{
+			name:   "valid token",
+			header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte(secret), valid),
+			client: "indexer",
+		},
+		{
+			name: "expired token",
+			header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte(secret),
+				jwt.MapClaims{"sub": "indexer", "exp": time.Now().Add(-time.Minute).Unix()}),
+		},
+		{
+			name:   "missing expiration",
+			header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte(secret), jwt.MapClaims{"sub": "indexer"}),
+		},
+		{
+			name:   "wrong signature",
+			header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("other"), valid),
+		},
+		{
+			name:   "wrong algorithm",
+			header: "Bearer " + sign(t, jwt.SigningMethodHS512, []byte(secret), valid),
+		},
+		{
+			name:   "missing claim",
+			header: "Bearer " + sign(t, jwt.SigningMethodHS256, []byte(secret), jwt.MapClaims{"name": "indexer"}),
+		},
+		{
+			name: "missing token",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, client := serve(srv, tc.header)
+			if tc.client == "" {
+				require.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+				require.Empty(t, client)
+				return
+			}
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+			require.Equal(t, tc.client, client)
+		})
+	}
+
+	t.Run("jwks", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		// uncompressed point is 0x04 followed by x and y.
+		point, err := ecKey.PublicKey.Bytes()
+		require.NoError(t, err)
+		keys := map[string]any{"keys": []map[string]string{
+			{
+				"kid": "key-1",
+				"kty": "RSA",
+				"n":   encode(rsaKey.N.Bytes()),
+				"e":   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kid": "key-2",
+				"kty": "EC",
+				"crv": "P-256",
+				"x":   encode(point[1:33]),
+				"y":   encode(point[33:]),
+			},
+		}}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_ = json.NewEncoder(w).Encode(keys)
+		}))
+		defer server.Close()
+
+		rsaSrv := &Server{cli: &fasthttp.Client{}, clients: config.Clients{
+			Type: "jwt",
+			JWT:  config.JWT{Algorithm: "RS256", JWKSURL: server.URL, ClientClaim: "sub"},
+		}}
+		ctx, client := serve(rsaSrv, "Bearer "+sign(t, jwt.SigningMethodRS256, rsaKey, valid))
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		require.Equal(t, "indexer", client)
+
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		ctx, _ = serve(rsaSrv, "Bearer "+sign(t, jwt.SigningMethodRS256, otherKey, valid))
+		require.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+
+		key, err := newJWKS(server.URL, &fasthttp.Client{}).key("key-2")
+		require.NoError(t, err)
+		require.True(t, ecKey.PublicKey.Equal(key))
+	})
+}
+
+func Test_jwks_key_concurrentFetch(t *testing.T) {
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		<-release
+		_, _ = w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	set := newJWKS(server.URL, &fasthttp.Client{})
+	set.keys = map[string]any{"known": "key"}
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := set.key("unknown")
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// known key is resolved while key set is being fetched.
+	key, err := set.key("known")
+	require.NoError(t, err)
+	require.Equal(t, "key", key)
+
+	close(release)
+	require.ErrorContains(t, <-errs, "unknown key id")
+	require.ErrorContains(t, <-errs, "unknown key id")
+	require.Equal(t, int32(1), fetches.Load())
+}
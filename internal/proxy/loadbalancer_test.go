@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_loadBalancerMiddleware_fallback(t *testing.T) {
+	newServer := func(fallback map[string]string) *Server {
+		return &Server{
+			nameToLBAlgo: map[string]string{
+				"/primary": config.LCName,
+				"/backup":  config.RRName,
+			},
+			chainToLC: map[string]*balancer.LeastConnection{
+				// no providers, so Borrow always returns empty payload.
+				"/primary": balancer.NewLeastConnection(nil),
+			},
+			chainToRR: map[string]*balancer.RoundRobin{
+				"/backup": balancer.NewRoundRobin([]balancer.Payload{{Name: "backup-node", URL: "http://backup"}}),
+			},
+			nameToFallback: fallback,
+		}
+	}
+
+	t.Run("fallback used", func(t *testing.T) {
+		srv := newServer(map[string]string{"/primary": "backup"})
+
+		var served bool
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/primary")
+		srv.loadBalancerMiddleware(func(*fasthttp.RequestCtx) { served = true })(ctx)
+
+		require.True(t, served)
+		reqctx := GetReqCtx(ctx)
+		require.Equal(t, "http://backup", reqctx.ConnURL)
+		require.Equal(t, "fallback/backup/backup-node", reqctx.Provider)
+		require.Equal(t, config.RRName, reqctx.Balancer)
+	})
+	t.Run("no fallback", func(t *testing.T) {
+		srv := newServer(nil)
+
+		var served bool
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/primary")
+		srv.loadBalancerMiddleware(func(*fasthttp.RequestCtx) { served = true })(ctx)
+
+		require.False(t, served)
+		require.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	})
+}
+
+type countingBalancer struct {
+	inFlight atomic.Int64
+	failed   atomic.Int64
+	latency  atomic.Int64 // latency passed to the last release
+}
+
+func (b *countingBalancer) Borrow() (balancer.Payload, balancer.Release) {
+	b.inFlight.Add(1)
+	return balancer.Payload{Name: "node", URL: "http://node"}, func(ok bool, latency time.Duration) {
+		b.inFlight.Add(-1)
+		b.latency.Store(int64(latency))
+		if !ok {
+			b.failed.Add(1)
+		}
+	}
+}
+
+func Test_Server_proxyToProvider_releaseOnCancel(t *testing.T) {
+	const requests = 100
+
+	srv := &Server{}
+	lb := &countingBalancer{}
+
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Go(func() {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI("/rpc")
+			if i%2 == 0 {
+				// cancelled request leaves response empty.
+				srv.proxyToProvider(ctx, lb, config.LCName, func(*fasthttp.RequestCtx) {})
+				return
+			}
+			defer func() { _ = recover() }()
+			srv.proxyToProvider(ctx, lb, config.LCName, func(*fasthttp.RequestCtx) {
+				panic("request aborted")
+			})
+		})
+	}
+	wg.Wait()
+
+	require.Zero(t, lb.inFlight.Load())
+	require.Equal(t, int64(requests), lb.failed.Load())
+}
+
+func Test_Server_proxyToProvider_upstreamLatency(t *testing.T) {
+	srv := &Server{}
+	lb := &countingBalancer{}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/rpc")
+	srv.proxyToProvider(ctx, lb, config.LCName, func(ctx *fasthttp.RequestCtx) {
+		// slow gateway processing around fast provider.
+		time.Sleep(50 * time.Millisecond)
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.UpstreamLatency = 5 * time.Millisecond })
+	})
+
+	require.Equal(t, int64(5*time.Millisecond), lb.latency.Load())
+	require.GreaterOrEqual(t, GetReqCtx(ctx).Latency, 0.05)
+}
+
+func Test_Server_proxyToProvider_providerSelected(t *testing.T) {
+	srv := &Server{}
+	lb := &countingBalancer{}
+	selected := metrics.ProviderSelected.WithLabelValues("selected", "node", config.LCName)
+	before := testutil.ToFloat64(selected)
+
+	for _, status := range []int{fasthttp.StatusOK, fasthttp.StatusBadGateway} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/selected")
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = "selected" })
+		srv.proxyToProvider(ctx, lb, config.LCName, func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.SetStatusCode(status)
+		})
+	}
+
+	// selection is counted whatever the result of request.
+	require.InDelta(t, before+2, testutil.ToFloat64(selected), 0)
+}
+
+func Test_providerTags(t *testing.T) {
+	srv := New(config.Config{RPCs: []config.RPC{
+		{
+			Name:      "mainnet",
+			Providers: []config.Provider{{Name: "node", Tags: map[string]string{"region": "eu"}}},
+		},
+		{
+			Name:      "backup",
+			Providers: []config.Provider{{Name: "node", Tags: map[string]string{"region": "us"}}},
+		},
+	}})
+
+	require.Equal(t, "eu", srv.providerTags("mainnet", "node")["region"])
+	require.Equal(t, "us", srv.providerTags("mainnet", "fallback/backup/node")["region"])
+	require.Nil(t, srv.providerTags("mainnet", "unknown"))
+}
+
+func Test_New_providerInFlight(t *testing.T) {
+	srv := New(config.Config{
+		Metrics: config.Metrics{Enabled: true},
+		RPCs: []config.RPC{{
+			Name:            "inflight",
+			ChainID:         1,
+			GlobalRPCConfig: config.GlobalRPCConfig{BalancerType: config.LCName},
+			Providers:       []config.Provider{{Name: "node", ConnURL: "http://node"}},
+		}},
+	})
+	lb, _ := srv.getBalancer("/inflight")
+	gauge := metrics.ProviderInFlight.WithLabelValues("1", "inflight", "node", config.LCName)
+
+	_, release := lb.Borrow()
+	require.InDelta(t, 1, testutil.ToFloat64(gauge), 0)
+	release(true, time.Millisecond)
+	require.InDelta(t, 0, testutil.ToFloat64(gauge), 0)
+}
+
+func Test_New_adaptiveWeighted(t *testing.T) {
+	srv := New(config.Config{RPCs: []config.RPC{{
+		Name: "adaptive",
+		GlobalRPCConfig: config.GlobalRPCConfig{
+			BalancerType:     config.AWName,
+			AdaptiveWeighted: config.AdaptiveWeighted{Interval: time.Millisecond, Smooth: 1, MinWeight: 0.1},
+		},
+		Providers: []config.Provider{
+			{Name: "fast", ConnURL: "http://fast"},
+			{Name: "slow", ConnURL: "http://slow"},
+		},
+	}}})
+	aw := srv.chainToAW["/adaptive"]
+	require.Equal(t, []weightAdjuster{{balancer: aw, interval: time.Millisecond}}, srv.weightAdjusters)
+
+	for range 2 {
+		provider, release := aw.Borrow()
+		if provider.Name == "fast" {
+			release(true, time.Millisecond)
+		} else {
+			release(true, 3*time.Millisecond)
+		}
+	}
+	// weights are not adjusted until server is started.
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, []balancer.ProviderWeight{{Name: "fast", Weight: 1}, {Name: "slow", Weight: 1}}, aw.Weights())
+}
+
+func Test_New_allInCooldown(t *testing.T) {
+	for _, balancerType := range []string{config.RRName, config.LCName} {
+		t.Run(balancerType, func(t *testing.T) {
+			srv := New(config.Config{RPCs: []config.RPC{{
+				Name: "mainnet",
+				GlobalRPCConfig: config.GlobalRPCConfig{
+					BalancerType:  balancerType,
+					Cooldown:      time.Minute,
+					AllInCooldown: config.AllInCooldownFailClosed,
+				},
+				Providers: []config.Provider{
+					{Name: "first", ConnURL: "http://first"},
+					{Name: "second", ConnURL: "http://second"},
+				},
+			}}})
+			lb, _ := srv.getBalancer("/mainnet")
+			for range 2 {
+				_, release := lb.Borrow()
+				release(false, 0)
+			}
+
+			// no provider is borrowed, so request can be routed to fallback rpc.
+			p, _ := lb.Borrow()
+			require.Equal(t, balancer.Payload{}, p)
+		})
+	}
+}
+
+func Test_isProviderFailure(t *testing.T) {
+	var (
+		success      = JSONRPCResponse{}
+		userError    = JSONRPCResponse{Error: JSONRPCError{Code: -32000, Message: "execution reverted"}}
+		providerFail = JSONRPCResponse{Error: JSONRPCError{Code: -32603, Message: "internal error"}}
+	)
+	oneFailed := []JSONRPCResponse{success, userError, providerFail, success}
+	mostFailed := []JSONRPCResponse{providerFail, providerFail, providerFail, success}
+	allFailed := []JSONRPCResponse{providerFail, providerFail}
+
+	testCases := []struct {
+		name      string
+		policy    string
+		responses []JSONRPCResponse
+		failure   bool
+	}{
+		{name: "default with one failed", policy: "", responses: oneFailed, failure: true},
+		{name: "any with one failed", policy: config.BatchFailureAny, responses: oneFailed, failure: true},
+		{name: "any with only user errors", policy: config.BatchFailureAny,
+			responses: []JSONRPCResponse{success, userError}, failure: false},
+		{name: "majority with one failed", policy: config.BatchFailureMajority, responses: oneFailed, failure: false},
+		{name: "majority with most failed", policy: config.BatchFailureMajority, responses: mostFailed, failure: true},
+		{name: "majority with half failed", policy: config.BatchFailureMajority,
+			responses: []JSONRPCResponse{providerFail, success}, failure: false},
+		{name: "all with most failed", policy: config.BatchFailureAll, responses: mostFailed, failure: false},
+		{name: "all with all failed", policy: config.BatchFailureAll, responses: allFailed, failure: true},
+		{name: "all with single failed", policy: config.BatchFailureAll,
+			responses: []JSONRPCResponse{providerFail}, failure: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.failure, isProviderFailure(tc.responses, tc.policy, nil))
+		})
+	}
+}
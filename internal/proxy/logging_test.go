@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// captureLogs redirects global logger to buffer until test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = logger })
+
+	return &buf
+}
+
+func Test_Server_loggingMiddleware_paramsHash(t *testing.T) {
+	serve := func(srv *Server, body string) map[string]any {
+		buf := captureLogs(t)
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetBody([]byte(body))
+		srv.loggingMiddleware(srv.requestParserMiddleware(func(*fasthttp.RequestCtx) {}))(ctx)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		return entry
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		srv := &Server{loggerCfg: config.Logger{ParamsHash: true}}
+
+		first := serve(srv, `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0xabc","latest"]}`)
+		second := serve(srv, `{"jsonrpc":"2.0","id":2,"method":"eth_getBalance","params":[ "0xabc", "latest" ]}`)
+		other := serve(srv, `{"jsonrpc":"2.0","id":3,"method":"eth_getBalance","params":["0xdef","latest"]}`)
+
+		require.NotEmpty(t, first["params_hash"])
+		require.Equal(t, first["params_hash"], second["params_hash"])
+		require.NotEqual(t, first["params_hash"], other["params_hash"])
+		require.InDelta(t, float64(len(`["0xabc","latest"]`)), first["params_size"], 0)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		srv := &Server{}
+
+		entry := serve(srv, `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0xabc","latest"]}`)
+		require.NotContains(t, entry, "params_hash")
+		require.NotContains(t, entry, "params_size")
+	})
+}
+
+func Test_Server_loggingMiddleware_accessFields(t *testing.T) {
+	buf := captureLogs(t)
+	srv := &Server{loggerCfg: config.Logger{AccessFields: []string{
+		config.AccessFieldStatus,
+		config.AccessFieldMethod,
+		config.AccessFieldRequestBytes,
+		config.AccessFieldResponseBytes,
+		config.AccessFieldUserAgent,
+	}}}
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetBody([]byte(body))
+	ctx.Request.Header.SetUserAgent("web3.py/7.0")
+	srv.loggingMiddleware(srv.requestParserMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+	}))(ctx)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, map[string]any{
+		"level":          "info",
+		"message":        "request completed",
+		"status":         float64(fasthttp.StatusOK),
+		"method":         "eth_chainId",
+		"request_bytes":  float64(len(body)),
+		"response_bytes": float64(len(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)),
+		"user_agent":     "web3.py/7.0",
+	}, entry)
+}
+
+func Test_Server_loggingMiddleware_logBodies(t *testing.T) {
+	serve := func(srv *Server, body string) []map[string]any {
+		buf := captureLogs(t)
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetBody([]byte(body))
+		srv.loggingMiddleware(srv.requestParserMiddleware(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString(`{"jsonrpc":"2.0","id":1,"result":"0x1234567890"}`)
+		}))(ctx)
+
+		var entries []map[string]any
+		decoder := json.NewDecoder(buf)
+		for decoder.More() {
+			var entry map[string]any
+			require.NoError(t, decoder.Decode(&entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	cfg := config.Logger{LogBodies: true, RedactedMethods: []string{"eth_sendRawTransaction"}, MaxBodyBytes: 30}
+
+	t.Run("redacted", func(t *testing.T) {
+		cfg := cfg
+		cfg.MaxBodyBytes = 1024
+		entries := serve(&Server{loggerCfg: cfg}, `[{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction",`+
+			`"params":["0xsecret"]},{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}]`)
+		require.Len(t, entries, 2)
+		require.Equal(t, "debug", entries[1]["level"])
+		require.Equal(t, `[{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":"[redacted]"},`+
+			`{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}]`, entries[1]["request_body"])
+	})
+	t.Run("malformed", func(t *testing.T) {
+		entries := serve(&Server{loggerCfg: cfg}, `{"method":"eth_sendRawTransaction","params":["0xsecret"]`)
+		require.Len(t, entries, 3) // parse error is logged too
+		require.Equal(t, `"[redacted]"`, entries[2]["request_body"])
+	})
+	t.Run("truncated", func(t *testing.T) {
+		entries := serve(&Server{loggerCfg: cfg}, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+		require.Len(t, entries, 2)
+		require.Equal(t, `{"jsonrpc":"2.0","id":1,"metho`+truncatedTail, entries[1]["request_body"])
+		require.Equal(t, `{"jsonrpc":"2.0","id":1,"resul`+truncatedTail, entries[1]["response_body"])
+	})
+	t.Run("disabled", func(t *testing.T) {
+		cfg := cfg
+		cfg.LogBodies = false
+		entries := serve(&Server{loggerCfg: cfg}, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+		require.Len(t, entries, 1)
+		require.NotContains(t, entries[0], "request_body")
+	})
+	t.Run("debug level disabled", func(t *testing.T) {
+		level := zerolog.GlobalLevel()
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+		entries := serve(&Server{loggerCfg: cfg}, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+		require.Len(t, entries, 1)
+	})
+}
+
+func Test_Server_loggingMiddleware_logBodiesScope(t *testing.T) {
+	// serve returns how many of n requests served by provider had their bodies logged.
+	serve := func(cfg config.Logger, provider string, n int) int {
+		buf := captureLogs(t)
+		srv := &Server{loggerCfg: cfg}
+		handler := srv.loggingMiddleware(func(ctx *fasthttp.RequestCtx) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Provider = provider })
+			ctx.SetBodyString(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+		})
+		for range n {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+			handler(ctx)
+		}
+		return strings.Count(buf.String(), `"message":"request bodies"`)
+	}
+
+	t.Run("provider", func(t *testing.T) {
+		cfg := config.Logger{LogBodies: true, LogBodiesProviders: []string{"archive"}, LogBodiesSampleRatio: 1}
+		require.Equal(t, 1, serve(cfg, "archive", 1))
+		require.Equal(t, 1, serve(cfg, "fallback/mainnet/archive", 1))
+		require.Zero(t, serve(cfg, "infura", 1))
+	})
+	t.Run("sampled", func(t *testing.T) {
+		const n = 2000
+		cfg := config.Logger{LogBodies: true, LogBodiesSampleRatio: 0.25}
+		require.InDelta(t, n/4, serve(cfg, "infura", n), n/20)
+	})
+	t.Run("provider sampled", func(t *testing.T) {
+		cfg := config.Logger{LogBodies: true, LogBodiesProviders: []string{"archive"}, LogBodiesSampleRatio: 0.5}
+		require.Zero(t, serve(cfg, "infura", 100))
+		require.Positive(t, serve(cfg, "archive", 100))
+	})
+}
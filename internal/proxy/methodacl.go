@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// methodACL restricts methods client can call, either by allow list or by deny list.
+type methodACL struct {
+	methods map[string]struct{}
+	allow   bool
+}
+
+// allowed returns true if method can be called by client.
+func (acl methodACL) allowed(method string) bool {
+	_, listed := acl.methods[method]
+	return listed == acl.allow
+}
+
+// newClientToACL returns method acl of every client with allowed or denied methods configured.
+func newClientToACL(cfg config.Clients) map[string]methodACL {
+	clientToACL := make(map[string]methodACL)
+	for _, client := range cfg.Clients {
+		acl := methodACL{allow: len(client.AllowedMethods) > 0}
+		methods := client.DeniedMethods
+		if acl.allow {
+			methods = client.AllowedMethods
+		}
+		if len(methods) == 0 {
+			continue
+		}
+		acl.methods = make(map[string]struct{}, len(methods))
+		for _, method := range methods {
+			acl.methods[method] = struct{}{}
+		}
+		clientToACL[client.Login] = acl
+	}
+
+	return clientToACL
+}
+
+// methodACLMiddleware rejects requests with methods client is not allowed to call.
+// Batch is rejected entirely if any of its requests is not allowed.
+func (srv *Server) methodACLMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if len(srv.clientToACL) == 0 {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reqctx := GetReqCtx(ctx)
+		acl, ok := srv.clientToACL[reqctx.Client]
+		if !ok {
+			next(ctx)
+			return
+		}
+
+		for _, req := range reqctx.Request {
+			if acl.allowed(req.Method) {
+				continue
+			}
+			metrics.MethodDenied.WithLabelValues(reqctx.Client, req.Method).Inc()
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("client", reqctx.Client).
+				Str("method", req.Method).
+				Msg("method is not allowed for client")
+			writeJSONRPCError(ctx, fasthttp.StatusForbidden,
+				jsonRPCMethodNotFoundCode, "method is not allowed for client")
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// rejectedWSMethod returns json-rpc error response and true if msg calls method client is not allowed to call.
+// Batch is rejected entirely if any of its requests is not allowed.
+func (srv *Server) rejectedWSMethod(ctx *WSContext, msg json.RawMessage) (any, bool) {
+	acl, ok := srv.clientToACL[ctx.client]
+	if !ok {
+		return nil, false
+	}
+
+	rejection, req, rejected := rejectedWSRequest(msg, func(req wsRequest) bool {
+		return !acl.allowed(req.Method)
+	}, jsonRPCMethodNotFoundCode, "method is not allowed for client")
+	if rejected {
+		metrics.MethodDenied.WithLabelValues(ctx.client, req.Method).Inc()
+		log.Info().
+			Uint64("request_id", ctx.requestID).
+			Str("client", ctx.client).
+			Str("method", req.Method).
+			Msg("method is not allowed for client")
+	}
+	return rejection, rejected
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_methodACLMiddleware(t *testing.T) {
+	srv := &Server{clientToACL: newClientToACL(config.Clients{Clients: []config.Client{
+		{Login: "reader", AllowedMethods: []string{"eth_call", "eth_getBalance"}},
+		{Login: "restricted", DeniedMethods: []string{"eth_sendRawTransaction"}},
+		{Login: "admin"},
+	}})}
+	testCases := []struct {
+		name   string
+		client string
+		body   string
+		served bool
+	}{
+		{
+			name:   "allowed method",
+			client: "reader",
+			body:   `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`,
+			served: true,
+		},
+		{
+			name:   "method missing in allowed list",
+			client: "reader",
+			body:   `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`,
+			served: false,
+		},
+		{
+			name:   "batch with method missing in allowed list",
+			client: "reader",
+			body: `[{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":[]},` +
+				`{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction","params":["0x00"]}]`,
+			served: false,
+		},
+		{
+			name:   "denied method",
+			client: "restricted",
+			body:   `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`,
+			served: false,
+		},
+		{
+			name:   "method missing in denied list",
+			client: "restricted",
+			body:   `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`,
+			served: true,
+		},
+		{
+			name:   "client without acl",
+			client: "admin",
+			body:   `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`,
+			served: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			denied := metrics.MethodDenied.WithLabelValues(tc.client, "eth_sendRawTransaction")
+			before := testutil.ToFloat64(denied)
+
+			var served bool
+			handler := srv.requestParserMiddleware(srv.methodACLMiddleware(func(*fasthttp.RequestCtx) {
+				served = true
+			}))
+			ctx := &fasthttp.RequestCtx{}
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = tc.client })
+			ctx.Request.SetBody([]byte(tc.body))
+			handler(ctx)
+
+			require.Equal(t, tc.served, served)
+			if tc.served {
+				require.InDelta(t, before, testutil.ToFloat64(denied), 0)
+				return
+			}
+			require.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode())
+			require.True(t, json.Valid(ctx.Response.Body()))
+			require.Contains(t, string(ctx.Response.Body()), `"code":-32601`)
+			require.InDelta(t, before+1, testutil.ToFloat64(denied), 0)
+		})
+	}
+}
+
+func Test_Server_wsHandler_methodACL(t *testing.T) {
+	upstream := &fakeWSUpstream{}
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	srv := &Server{
+		nameToLBAlgo:  map[string]string{"/mainnet": config.RRName, "/mux": config.RRName},
+		nameToChainID: map[string]int64{"/mainnet": 1, "/mux": 1},
+		wsMultiplexed: map[string]struct{}{"/mux": {}},
+		upgrader:      websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		clientToACL: newClientToACL(config.Clients{Clients: []config.Client{
+			{Login: "reader", AllowedMethods: []string{"eth_call", "eth_getBalance"}},
+		}}),
+	}
+	srv.wsMuxes = newWSMuxPool(srv.initWSConnWithProvider)
+	addr := serveWS(t, srv, "ws://"+strings.TrimPrefix(server.URL, "http://"), "reader")
+
+	for _, path := range []string{"/mainnet", "/mux"} {
+		t.Run(path, func(t *testing.T) {
+			conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+path, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			denied := metrics.MethodDenied.WithLabelValues("reader", "eth_sendRawTransaction")
+			deniedBefore := testutil.ToFloat64(denied)
+
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage,
+				[]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`)))
+			var resp jsonRPCErrorResponse
+			require.NoError(t, conn.ReadJSON(&resp))
+			require.JSONEq(t, `1`, string(resp.ID))
+			require.Equal(t, int64(jsonRPCMethodNotFoundCode), resp.Error.Code)
+
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage,
+				[]byte(`[{"jsonrpc":"2.0","id":2,"method":"eth_call","params":[]},`+
+					`{"jsonrpc":"2.0","id":3,"method":"eth_sendRawTransaction","params":["0x00"]}]`)))
+			var batch []jsonRPCErrorResponse
+			require.NoError(t, conn.ReadJSON(&batch))
+			require.Len(t, batch, 2)
+			for _, resp := range batch {
+				require.Equal(t, int64(jsonRPCMethodNotFoundCode), resp.Error.Code)
+			}
+
+			// allowed method is still proxied.
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage,
+				[]byte(`{"jsonrpc":"2.0","id":4,"method":"eth_call","params":[]}`)))
+			var served map[string]any
+			require.NoError(t, conn.ReadJSON(&served))
+			require.Equal(t, "eth_call", served["result"])
+
+			require.InDelta(t, deniedBefore+2, testutil.ToFloat64(denied), 0)
+			require.Zero(t, upstream.received("eth_sendRawTransaction"))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// methodRateLimitMiddleware rejects requests with methods exceeding their rate limit with 429 and Retry-After
+// header. Every request of batch takes a token of its method and batch is rejected entirely if any method
+// is exceeded, tokens of rejected batch are not taken. Batch with more requests of method than its burst
+// is rejected with 400 instead, as it would never pass.
+func (srv *Server) methodRateLimitMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if srv.methodRateLimiter == nil {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reqctx := GetReqCtx(ctx)
+		methods := make([]string, 0, len(reqctx.Request))
+		for _, req := range reqctx.Request {
+			methods = append(methods, req.Method)
+		}
+		if method, exceeded := srv.methodRateLimiter.exceedsBurst(methods); exceeded {
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("client", reqctx.Client).
+				Str("method", method).
+				Msg("batch exceeds method burst")
+			metrics.MethodRateLimited.WithLabelValues(reqctx.Client, method).Inc()
+			writeJSONRPCError(ctx, fasthttp.StatusBadRequest, jsonRPCInvalidRequestCode,
+				"batch exceeds burst of method "+method)
+			return
+		}
+		allowed, method, wait := srv.methodRateLimiter.allowAll(methods)
+		if !allowed {
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("client", reqctx.Client).
+				Str("method", method).
+				Msg("method rate limit exceeded")
+			metrics.MethodRateLimited.WithLabelValues(reqctx.Client, method).Inc()
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONRPCError(ctx, fasthttp.StatusTooManyRequests, jsonRPCLimitExceededCode,
+				"rate limit exceeded for method "+method)
+			return
+		}
+
+		next(ctx)
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_rateLimiter_allowAll(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newMethodRateLimiter(map[string]config.RateLimit{
+		"debug_traceTransaction": {RPS: 1, Burst: 2},
+		"eth_getLogs":            {RPS: 10, Burst: 10},
+		"eth_call":               {},
+	})
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+
+	// methods without limit are not limited.
+	ok, _, _ := limiter.allowAll([]string{"eth_call", "eth_call", "eth_blockNumber"})
+	require.True(t, ok)
+
+	// every request takes a token of its method, exceeded batch takes none.
+	ok, _, _ = limiter.allowAll([]string{"eth_getLogs", "debug_traceTransaction"})
+	require.True(t, ok)
+	ok, method, wait := limiter.allowAll([]string{"eth_getLogs", "debug_traceTransaction", "debug_traceTransaction"})
+	require.False(t, ok)
+	require.Equal(t, "debug_traceTransaction", method)
+	require.Equal(t, time.Second, wait)
+	require.InDelta(t, 9, limiter.buckets["eth_getLogs"].tokens, 0)
+
+	now = now.Add(time.Second)
+	ok, _, _ = limiter.allowAll([]string{"debug_traceTransaction", "debug_traceTransaction"})
+	require.True(t, ok)
+
+	method, exceeded := limiter.exceedsBurst([]string{"eth_call", "eth_call", "eth_call", "debug_traceTransaction"})
+	require.False(t, exceeded)
+	require.Empty(t, method)
+	method, exceeded = limiter.exceedsBurst([]string{"debug_traceTransaction", "eth_getLogs", "debug_traceTransaction",
+		"debug_traceTransaction"})
+	require.True(t, exceeded)
+	require.Equal(t, "debug_traceTransaction", method)
+
+	require.Nil(t, newMethodRateLimiter(map[string]config.RateLimit{"eth_call": {}}))
+}
+
+func Test_Server_methodRateLimitMiddleware(t *testing.T) {
+	srv := &Server{methodRateLimiter: newMethodRateLimiter(map[string]config.RateLimit{
+		"debug_traceCall": {RPS: 0.5, Burst: 1},
+	})}
+	serve := func(methods ...string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.Client = "tracer"
+			for i, method := range methods {
+				rc.Request = append(rc.Request, JSONRPCRequest{ID: []byte{byte('1' + i)}, Method: method})
+			}
+			rc.Batch = len(methods) > 1
+		})
+		srv.methodRateLimitMiddleware(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(fasthttp.StatusOK)
+		})(ctx)
+		return ctx
+	}
+	counter := metrics.MethodRateLimited.WithLabelValues("tracer", "debug_traceCall")
+
+	require.Equal(t, fasthttp.StatusOK, serve("debug_traceCall").Response.StatusCode())
+	rejected := testutil.ToFloat64(counter)
+	ctx := serve("eth_call", "debug_traceCall")
+	require.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	require.Equal(t, "2", string(ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter)))
+	require.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"rate limit exceeded for method debug_traceCall"}},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32005,"message":"rate limit exceeded for method debug_traceCall"}}
+	]`, string(ctx.Response.Body()))
+	require.InDelta(t, rejected+1, testutil.ToFloat64(counter), 0)
+
+	// batch over burst never passes, so it is not retryable.
+	rejected = testutil.ToFloat64(counter)
+	ctx = serve("debug_traceCall", "debug_traceCall")
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	require.Empty(t, ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter))
+	require.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"batch exceeds burst of method debug_traceCall"}},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"batch exceeds burst of method debug_traceCall"}}
+	]`, string(ctx.Response.Body()))
+	require.InDelta(t, rejected+1, testutil.ToFloat64(counter), 0)
+
+	// other methods are not affected.
+	for range 3 {
+		require.Equal(t, fasthttp.StatusOK, serve("eth_call").Response.StatusCode())
+	}
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+)
+
+// methodTimeout returns timeout of request by its methods in rpc of provider, the longest one for batch.
+// Method without own timeout is bounded by regular timeout of provider, which is passed as regular,
+// 0 means it is not bounded. False is returned if no method of request has own timeout or regular timeout is longer.
+// Fallback provider is named as fallback/<rpc>/<provider> and uses method timeouts of its own rpc.
+func (srv *Server) methodTimeout(reqctx *ReqCtx, regular time.Duration) (time.Duration, bool) {
+	rpcName := reqctx.RPCName
+	if key, ok := strings.CutPrefix(reqctx.Provider, "fallback/"); ok {
+		rpcName, _, _ = strings.Cut(key, "/")
+	}
+	timeouts, exist := srv.nameToMethodTimeouts["/"+rpcName]
+	if !exist {
+		return 0, false
+	}
+	var (
+		longest  time.Duration
+		unlisted bool
+	)
+	for _, req := range reqctx.Request {
+		if timeout, ok := timeouts[req.Method]; ok {
+			longest = max(longest, timeout)
+		} else {
+			unlisted = true
+		}
+	}
+	if longest == 0 || (unlisted && (regular == 0 || regular >= longest)) {
+		return 0, false
+	}
+	return longest, true
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_methodTimeout(t *testing.T) {
+	srv := &Server{nameToMethodTimeouts: map[string]map[string]time.Duration{
+		"/mainnet": {"eth_getLogs": 30 * time.Second, "eth_blockNumber": time.Second},
+	}}
+	request := func(provider string, methods ...string) *ReqCtx {
+		rc := &ReqCtx{RPCName: "mainnet", Provider: provider}
+		for _, method := range methods {
+			rc.Request = append(rc.Request, JSONRPCRequest{Method: method})
+		}
+		return rc
+	}
+	testCases := []struct {
+		name    string
+		reqctx  *ReqCtx
+		regular time.Duration
+		timeout time.Duration
+		ok      bool
+	}{
+		{name: "long method", reqctx: request("node", "eth_getLogs"), regular: 5 * time.Second,
+			timeout: 30 * time.Second, ok: true},
+		{name: "short method", reqctx: request("node", "eth_blockNumber"), regular: 5 * time.Second,
+			timeout: time.Second, ok: true},
+		{name: "unlisted method", reqctx: request("node", "eth_call"), regular: 5 * time.Second},
+		{name: "batch of listed methods", reqctx: request("node", "eth_blockNumber", "eth_getLogs"),
+			timeout: 30 * time.Second, ok: true},
+		{name: "batch with unlisted method and shorter regular timeout",
+			reqctx: request("node", "eth_call", "eth_getLogs"), regular: 5 * time.Second,
+			timeout: 30 * time.Second, ok: true},
+		{name: "batch with unlisted method and longer regular timeout",
+			reqctx: request("node", "eth_call", "eth_blockNumber"), regular: 5 * time.Second},
+		{name: "batch with unlisted method and no regular timeout",
+			reqctx: request("node", "eth_call", "eth_getLogs")},
+		{name: "rpc without method timeouts", reqctx: &ReqCtx{RPCName: "base", Provider: "node",
+			Request: []JSONRPCRequest{{Method: "eth_getLogs"}}}},
+		{name: "fallback provider", reqctx: &ReqCtx{RPCName: "base", Provider: "fallback/mainnet/node",
+			Request: []JSONRPCRequest{{Method: "eth_getLogs"}}}, timeout: 30 * time.Second, ok: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			timeout, ok := srv.methodTimeout(tc.reqctx, tc.regular)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.timeout, timeout)
+		})
+	}
+}
+
+func Test_Server_handler_methodTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli: &fasthttp.Client{},
+		providerToTimeout: newProviderToTimeout([]config.RPC{{
+			Name: "mainnet",
+			Providers: []config.Provider{{Name: "node", Timeout: config.ProviderTimeout{
+				Initial: 50 * time.Millisecond, Limit: 50 * time.Millisecond, Factor: 2, Threshold: 3,
+			}}},
+		}}),
+		nameToMethodTimeouts: map[string]map[string]time.Duration{
+			"/mainnet": {"eth_getLogs": time.Second, "eth_blockNumber": 20 * time.Millisecond},
+		},
+	}
+	serve := func(methods ...string) int {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, nil, nil)
+		ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.ConnURL = upstream.URL
+			rc.RPCName = "mainnet"
+			rc.Provider = "node"
+			for _, method := range methods {
+				rc.Request = append(rc.Request, JSONRPCRequest{Method: method})
+			}
+		})
+		srv.handler(ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	// slow method is waited for longer than provider timeout, other methods are bounded by it.
+	require.Equal(t, fasthttp.StatusOK, serve("eth_getLogs"))
+	require.Equal(t, fasthttp.StatusGatewayTimeout, serve("eth_blockNumber"))
+	require.Equal(t, fasthttp.StatusGatewayTimeout, serve("eth_call"))
+	require.Equal(t, fasthttp.StatusOK, serve("eth_call", "eth_getLogs"))
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// passwordChecker compares client passwords with configured plaintext passwords or bcrypt hashes.
+// Bcrypt is slow by design, so digest of last verified password of every login is kept
+// and repeated requests with the same password are not compared with hash again.
+type passwordChecker struct {
+	mutex    sync.RWMutex
+	verified map[string][sha256.Size]byte
+}
+
+func newPasswordChecker() *passwordChecker {
+	return &passwordChecker{verified: make(map[string][sha256.Size]byte)}
+}
+
+// check returns true if pass matches expected password or hash of login.
+func (c *passwordChecker) check(login, expected, pass string) bool {
+	if !config.IsPasswordHash(expected) {
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(pass)) == 1
+	}
+
+	digest := sha256.Sum256([]byte(pass))
+	c.mutex.RLock()
+	verified, exist := c.verified[login]
+	c.mutex.RUnlock()
+	if exist && subtle.ConstantTimeCompare(verified[:], digest[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(expected), []byte(pass)) != nil {
+		return false
+	}
+	c.mutex.Lock()
+	c.verified[login] = digest
+	c.mutex.Unlock()
+
+	return true
+}
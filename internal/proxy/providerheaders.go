@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// newProviderToHeaders returns headers sent to providers keyed by rpc and provider name.
+func newProviderToHeaders(rpcs []config.RPC) map[string]http.Header {
+	providerToHeaders := make(map[string]http.Header)
+	for _, rpc := range rpcs {
+		for _, provider := range rpc.Providers {
+			if len(provider.Headers) == 0 {
+				continue
+			}
+			header := make(http.Header, len(provider.Headers))
+			for name, value := range provider.Headers {
+				header.Set(name, value)
+			}
+			providerToHeaders[rpc.Name+"/"+provider.Name] = header
+		}
+	}
+	return providerToHeaders
+}
+
+// providerHeaders returns headers sent to provider serving rpc, nil if it has none.
+// Fallback provider is named as fallback/<rpc>/<provider> and has headers of its own rpc.
+func (srv *Server) providerHeaders(rpcName, provider string) http.Header {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		return srv.providerToHeaders[key]
+	}
+	return srv.providerToHeaders[rpcName+"/"+provider]
+}
+
+// setProviderHeaders sets headers of provider serving rpc to http request.
+func (srv *Server) setProviderHeaders(req *fasthttp.Request, rpcName, provider string) {
+	for name, values := range srv.providerHeaders(rpcName, provider) {
+		req.Header.Set(name, values[0])
+	}
+}
+
+// forwardedWSHeaders returns headers of client websocket handshake forwarded to providers of rpc,
+// nil if rpc forwards none or client did not send them.
+func (srv *Server) forwardedWSHeaders(ctx *fasthttp.RequestCtx, rpcPath string) http.Header {
+	var header http.Header
+	for _, name := range srv.nameToWSForwardHeaders[rpcPath] {
+		value := ctx.Request.Header.Peek(name)
+		if len(value) == 0 {
+			continue
+		}
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set(name, string(value))
+	}
+	return header
+}
+
+// wsHandshakeHeaders returns headers of websocket handshake with provider serving client:
+// headers forwarded from client handshake overridden by headers of provider.
+func (srv *Server) wsHandshakeHeaders(ctx *WSContext, provider string) http.Header {
+	header := ctx.forwardedHeaders.Clone()
+	for name, values := range srv.providerHeaders(ctx.rpcName, provider) {
+		if header == nil {
+			header = make(http.Header)
+		}
+		header[name] = values
+	}
+	return header
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_newProviderToHeaders(t *testing.T) {
+	providerToHeaders := newProviderToHeaders([]config.RPC{{
+		Name: "mainnet",
+		Providers: []config.Provider{
+			{Name: "infura", Headers: map[string]string{"x-api-key": "secret"}},
+			{Name: "node"},
+		},
+	}})
+	require.Equal(t, map[string]http.Header{"mainnet/infura": {"X-Api-Key": {"secret"}}}, providerToHeaders)
+
+	srv := &Server{providerToHeaders: providerToHeaders}
+	require.Equal(t, "secret", srv.providerHeaders("mainnet", "infura").Get("X-Api-Key"))
+	require.Nil(t, srv.providerHeaders("mainnet", "node"))
+	// fallback provider has headers of its own rpc.
+	require.Equal(t, "secret", srv.providerHeaders("sepolia", "fallback/mainnet/infura").Get("X-Api-Key"))
+}
+
+func Test_Server_handler_providerHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:               &fasthttp.Client{},
+		providerToHeaders: map[string]http.Header{"mainnet/infura": {"X-Api-Key": {"secret"}}},
+	}
+	serve := func(provider string) int {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.ConnURL = upstream.URL
+			rc.RPCName = "mainnet"
+			rc.Provider = provider
+		})
+		srv.handler(ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	require.Equal(t, fasthttp.StatusOK, serve("infura"))
+	require.Equal(t, fasthttp.StatusUnauthorized, serve("node"))
+}
+
+func Test_Server_wsHandler_handshakeHeaders(t *testing.T) {
+	// upstream accepts handshake only with api key and origin of client.
+	var origin string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("Origin") != origin {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+			if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL := "ws://" + strings.TrimPrefix(upstream.URL, "http://")
+
+	srv := &Server{
+		nameToLBAlgo:           map[string]string{"/mainnet": config.RRName},
+		nameToChainID:          map[string]int64{"/mainnet": 1},
+		nameToWSForwardHeaders: map[string][]string{"/mainnet": {"Origin"}},
+		providerToHeaders:      map[string]http.Header{"mainnet/infura": {"X-Api-Key": {"secret"}}},
+		upgrader:               websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		wsConns:                newWSRegistry(),
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	origin = "http://" + ln.Addr().String()
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(func(ctx *WSContext) {
+		ctx.providerURL = upstreamURL
+		ctx.providerName = "infura"
+		srv.wsHandler(ctx)
+	})}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	dial := func(t *testing.T, header http.Header) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/mainnet", header)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		return conn
+	}
+
+	t.Run("provider and forwarded headers", func(t *testing.T) {
+		conn := dial(t, http.Header{"Origin": {origin}})
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"eth_chainId"}`)))
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, string(msg))
+	})
+	t.Run("missing forwarded header", func(t *testing.T) {
+		conn := dial(t, nil)
+		_, _, err := conn.ReadMessage()
+		require.Error(t, err)
+	})
+	t.Run("rejected handshake", func(t *testing.T) {
+		_, err := srv.initWSConnWithProvider(upstreamURL, nil)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		require.ErrorContains(t, err, "status code 401")
+	})
+}
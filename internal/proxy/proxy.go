@@ -2,15 +2,20 @@ package proxy
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fasthttp/websocket"
@@ -20,65 +25,253 @@ import (
 	"github.com/BinaryArchaism/rpcgate/internal/balancer"
 	"github.com/BinaryArchaism/rpcgate/internal/config"
 	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+	"github.com/BinaryArchaism/rpcgate/internal/tracing"
 )
 
 type Balancer interface {
 	Borrow() (balancer.Payload, balancer.Release)
 }
 
+// inFlightObservable is implemented by balancers which track in-flight requests of providers.
+type inFlightObservable interface {
+	SetInFlightObserver(observer balancer.InFlightObserver)
+}
+
+// rateLimitObserver is implemented by balancers which back off from providers answered with 429.
+type rateLimitObserver interface {
+	RateLimited(name string, retryAfter time.Duration)
+}
+
+// responseSizeObserver is implemented by balancers which account response sizes of providers.
+type responseSizeObserver interface {
+	ObserveResponseSize(name string, size int)
+}
+
 type Server struct {
-	srv            *fasthttp.Server
-	cli            *fasthttp.Client
-	port           int64
-	rpcs           []config.RPC
-	clients        config.Clients
-	metricsCfg     config.Metrics
-	chainToP2CEWMA map[string]*balancer.P2CEWMA
-	chainToRR      map[string]*balancer.RoundRobin
-	chainToLC      map[string]*balancer.LeastConnection
-	nameToLBAlgo   map[string]string
-	nameToChainID  map[string]int64
-	done           chan struct{}
+	srv          *fasthttp.Server
+	cli          *fasthttp.Client
+	port         int64
+	rpcs         []config.RPC
+	clients      config.Clients
+	cors         config.CORS
+	metricsCfg   config.Metrics
+	loggerCfg    config.Logger
+	requestIDHdr string
+	healthzCfg   config.Healthz
+	tlsCfg       config.TLS
+	tracer       *tracing.Tracer // nil if tracing is disabled
+	deadline     config.Deadline
+	// snapshotCfg configures saving of p2cewma provider stats restored on startup, empty path disables it.
+	snapshotCfg config.BalancerSnapshot
+	// upstreamHeaders selects headers of provider responses forwarded to clients.
+	upstreamHeaders *headerFilter
+	// h2srv serves h2 clients next to srv, nil if http2 is disabled.
+	h2srv *http.Server
+	// rpcSrvs serve rpcs with own port next to srv.
+	rpcSrvs []rpcServer
+	// adminSrv serves admin endpoints on adminAddr, nil if admin is disabled.
+	adminSrv  *fasthttp.Server
+	adminAddr string
+	// rethrowPanics rethrows recovered panics once they are reported.
+	rethrowPanics bool
+	// shutdownTimeout bounds draining of in-flight requests and websocket sessions on Stop.
+	shutdownTimeout time.Duration
+	chainToP2CEWMA  map[string]*balancer.P2CEWMA
+	chainToRR       map[string]*balancer.RoundRobin
+	chainToLC       map[string]*balancer.LeastConnection
+	chainToLPB      map[string]*balancer.LeastPendingBytes
+	chainToAW       map[string]*balancer.AdaptiveWeighted
+	nameToLBAlgo    map[string]string
+	nameToChainID   map[string]int64
+	done            chan struct{}
+
+	nameToWSSubscriptions map[string]wsSubscriptionPolicy
+	wsMultiplexed         map[string]struct{}
+	nameToWSReconnect     map[string]config.WSReconnect
+	nameToWSKeepalive     map[string]config.WSKeepalive
+	nameToWSMaxMessage    map[string]int64
+	wsCloseOnEmpty        map[string]struct{}
+	nameToWSConnLimits    map[string]wsConnLimits
+	nameToWSAffinity      map[string]map[string]map[string]string
+	wsConns               *wsRegistry // active client connections closed on shutdown
+	upgrader              websocket.FastHTTPUpgrader
+	wsHandshakeTimeout    time.Duration
+	wsMuxes               *wsMuxPool
+
+	degraded               atomic.Bool
+	degradedAllowedMethods map[string]struct{}
+
+	globalLimiter *concurrencyLimiter
+	rpcLimiters   map[string]*concurrencyLimiter
+	rateLimiter   *rateLimiter
+	quotaTracker  *quotaTracker
+	responseCache *responseCache
+	clientToACL   map[string]methodACL
+	clientToRPCs  map[string]map[string]struct{}
+
+	nameToFallback   map[string]string
+	nameToRetry      map[string]retryPolicy
+	chainIDValidated map[string]struct{}
+	// nameToBatchFailure are batch failure policies of rpcs other than any.
+	nameToBatchFailure map[string]string
+	// nameToSLOTarget are latency slo targets in seconds of rpcs counting requests within slo.
+	nameToSLOTarget map[string]float64
+	// nameToErrorClassifier are classifiers of user errors of rpcs with own user error codes or patterns.
+	nameToErrorClassifier map[string]*errorClassifier
+	// nameToMethodAliases are canonical method names keyed by aliases of rpcs.
+	nameToMethodAliases map[string]map[string]string
+	// nameToMethodTimeouts are timeouts of provider requests by method of rpcs.
+	nameToMethodTimeouts map[string]map[string]time.Duration
+	// nameToClientIP are client ip forwarders of rpcs forwarding it to providers.
+	nameToClientIP map[string]*clientIPForwarder
+	// nameToUpstreamRequest are methods and content types of requests to providers of rpcs other than POST of json.
+	nameToUpstreamRequest map[string]*upstreamRequest
+	// nameToHTTPClient are own clients of requests to providers of rpcs, others use cli.
+	nameToHTTPClient map[string]*httpClient
+	// methodRateLimiter limits requests by method across clients, nil if no method is limited.
+	methodRateLimiter *rateLimiter
+	// nameToWSForwardHeaders are headers of client handshakes forwarded to providers of rpcs.
+	nameToWSForwardHeaders map[string][]string
+	// blockLagPollers are pollers of block lag of rpc providers, started with server.
+	blockLagPollers []blockLagPoller
+	// weightAdjusters are adaptive-weighted balancers adjusting weights of rpc providers, started with server.
+	weightAdjusters []weightAdjuster
+
+	// providerToTags are tags of providers keyed by rpc and provider name.
+	providerToTags map[string]map[string]string
+	// providerToFault are injected faults of providers keyed by rpc and provider name.
+	providerToFault map[string]config.Fault
+	// providerToTimeout are request timeouts of providers keyed by rpc and provider name.
+	providerToTimeout map[string]*adaptiveTimeout
+	// providerToMaxBatchSize are batch size limits of providers keyed by rpc and provider name.
+	providerToMaxBatchSize map[string]int
+	// providerToHeaders are headers sent to providers keyed by rpc and provider name.
+	providerToHeaders map[string]http.Header
 }
 
 func New(cfg config.Config) *Server {
 	srv := Server{
-		cli:            &fasthttp.Client{},
-		rpcs:           cfg.RPCs,
-		port:           cfg.Port,
-		done:           make(chan struct{}),
-		chainToP2CEWMA: make(map[string]*balancer.P2CEWMA),
-		chainToRR:      make(map[string]*balancer.RoundRobin),
-		chainToLC:      make(map[string]*balancer.LeastConnection),
-		clients:        cfg.Clients,
-		metricsCfg:     cfg.Metrics,
-	}
-
-	handler := srv.recoverHandler(
-		srv.transportRouter(
-			srv.healthzProbeMiddleware(
-				srv.loggingMiddleware(
-					srv.metricsMiddleware(
-						srv.authMiddleware(
-							srv.routerHandler(
-								srv.loadBalancerMiddleware(
-									srv.requestResponseParserMiddleware(
-										srv.handler)),
-							))))),
-			srv.wsLoggingMiddleware(
-				srv.authMiddleware(
-					srv.routerHandler(
-						srv.wsUpgrader(
-							srv.wsLoadBalancerMiddleware(
-								srv.wsHandler)))))))
+		cli:             &fasthttp.Client{},
+		rpcs:            cfg.RPCs,
+		port:            cfg.Port,
+		done:            make(chan struct{}),
+		chainToP2CEWMA:  make(map[string]*balancer.P2CEWMA),
+		chainToRR:       make(map[string]*balancer.RoundRobin),
+		chainToLC:       make(map[string]*balancer.LeastConnection),
+		chainToLPB:      make(map[string]*balancer.LeastPendingBytes),
+		chainToAW:       make(map[string]*balancer.AdaptiveWeighted),
+		clients:         cfg.Clients,
+		cors:            cfg.CORS,
+		upstreamHeaders: newHeaderFilter(cfg.UpstreamHeaders),
+		metricsCfg:      cfg.Metrics,
+		loggerCfg:       cfg.Logger,
+		requestIDHdr:    cfg.UpstreamRequestIDHeader,
+		healthzCfg:      cfg.Healthz,
+		tlsCfg:          cfg.TLS,
+		tracer:          tracing.New(cfg.Tracing),
+		deadline:        cfg.Deadline,
+		snapshotCfg:     cfg.BalancerSnapshot,
+		shutdownTimeout: cfg.ShutdownTimeout,
+		rethrowPanics:   cfg.Debug.RethrowPanics,
+
+		degradedAllowedMethods: make(map[string]struct{}, len(cfg.DegradedMode.AllowedMethods)),
+
+		globalLimiter: newConcurrencyLimiter(globalConcurrencyLimit, cfg.ConcurrencyLimit),
+		rpcLimiters:   make(map[string]*concurrencyLimiter),
+		rateLimiter:   newRateLimiter(cfg.Clients),
+		quotaTracker:  newQuotaTracker(cfg.Clients),
+		responseCache: newResponseCache(cfg.ResponseCache),
+		clientToACL:   newClientToACL(cfg.Clients),
+		clientToRPCs:  newClientToRPCs(cfg.Clients),
+
+		methodRateLimiter:      newMethodRateLimiter(cfg.MethodRateLimits),
+		nameToFallback:         make(map[string]string),
+		nameToRetry:            make(map[string]retryPolicy),
+		chainIDValidated:       make(map[string]struct{}),
+		nameToBatchFailure:     make(map[string]string),
+		nameToSLOTarget:        make(map[string]float64),
+		nameToErrorClassifier:  make(map[string]*errorClassifier),
+		nameToMethodAliases:    make(map[string]map[string]string),
+		nameToMethodTimeouts:   make(map[string]map[string]time.Duration),
+		nameToClientIP:         make(map[string]*clientIPForwarder),
+		nameToUpstreamRequest:  make(map[string]*upstreamRequest),
+		nameToHTTPClient:       make(map[string]*httpClient),
+		nameToWSForwardHeaders: make(map[string][]string),
+		providerToTags:         make(map[string]map[string]string),
+		providerToFault:        make(map[string]config.Fault),
+		providerToTimeout:      newProviderToTimeout(cfg.RPCs),
+		providerToMaxBatchSize: newProviderToMaxBatchSize(cfg.RPCs),
+		providerToHeaders:      newProviderToHeaders(cfg.RPCs),
+
+		wsMultiplexed:      make(map[string]struct{}),
+		nameToWSReconnect:  make(map[string]config.WSReconnect),
+		nameToWSKeepalive:  make(map[string]config.WSKeepalive),
+		nameToWSMaxMessage: make(map[string]int64),
+		wsCloseOnEmpty:     make(map[string]struct{}),
+		nameToWSConnLimits: newNameToWSConnLimits(cfg.RPCs),
+		nameToWSAffinity:   make(map[string]map[string]map[string]string),
+		wsConns:            newWSRegistry(),
+		upgrader: websocket.FastHTTPUpgrader{
+			ReadBufferSize:  cfg.Websocket.ReadBufferSize,
+			WriteBufferSize: cfg.Websocket.WriteBufferSize,
+		},
+		wsHandshakeTimeout: cfg.Websocket.HandshakeTimeout,
+	}
+	srv.wsMuxes = newWSMuxPool(srv.initWSConnWithProvider)
+	for _, method := range cfg.DegradedMode.AllowedMethods {
+		srv.degradedAllowedMethods[method] = struct{}{}
+	}
+	srv.degraded.Store(cfg.DegradedMode.Enabled)
+
+	httpHandler := srv.chain(srv.recoverMiddleware("handler", srv.handler),
+		layer{"cors", srv.corsMiddleware},
+		layer{"compression", srv.compressionMiddleware},
+		layer{"healthz", srv.healthzProbeMiddleware},
+		layer{"version", srv.versionMiddleware},
+		layer{"logging", srv.loggingMiddleware},
+		layer{"metrics", srv.metricsMiddleware},
+		layer{"auth", srv.authMiddleware},
+		layer{"rate_limit", srv.rateLimitMiddleware},
+		layer{"quota", srv.quotaMiddleware},
+		layer{"router", srv.routerHandler},
+		layer{"rpc_access", srv.rpcAccessMiddleware},
+		layer{"request_parser", srv.requestParserMiddleware},
+		layer{"method_alias", srv.methodAliasMiddleware},
+		layer{"batch_limit", srv.batchLimitMiddleware},
+		layer{"method_acl", srv.methodACLMiddleware},
+		layer{"method_rate_limit", srv.methodRateLimitMiddleware},
+		layer{"degraded_mode", srv.degradedModeMiddleware},
+		layer{"response_cache", srv.responseCacheMiddleware},
+		layer{"concurrency_limit", srv.concurrencyLimitMiddleware},
+		layer{"load_balancer", srv.loadBalancerMiddleware},
+		layer{"response_parser", srv.responseParserMiddleware},
+		layer{"fault_injection", srv.faultInjectionMiddleware},
+	)
+	wsHandler := srv.chain(
+		srv.recoverMiddleware("ws_upgrader", srv.wsUpgrader(srv.wsLoadBalancerMiddleware(srv.wsHandler))),
+		layer{"ws_logging", srv.wsLoggingMiddleware},
+		layer{"auth", srv.authMiddleware},
+		layer{"rate_limit", srv.rateLimitMiddleware},
+		layer{"quota", srv.quotaMiddleware},
+		layer{"router", srv.routerHandler},
+		layer{"rpc_access", srv.rpcAccessMiddleware},
+	)
+	handler := srv.recoverHandler(srv.transportRouter(httpHandler, wsHandler))
 
 	for _, rpc := range cfg.RPCs {
 		providers := make([]balancer.Payload, 0, len(rpc.Providers))
 		for _, provider := range rpc.Providers {
 			providers = append(providers, balancer.Payload{
-				URL:  provider.ConnURL,
-				Name: provider.Name,
+				URL:    provider.ConnURL,
+				Name:   provider.Name,
+				Weight: provider.Weight,
 			})
+			if len(provider.Tags) > 0 {
+				srv.providerToTags[rpc.Name+"/"+provider.Name] = provider.Tags
+			}
+			if cfg.Debug.FaultInjection && provider.Fault != (config.Fault{}) {
+				srv.providerToFault[rpc.Name+"/"+provider.Name] = provider.Fault
+			}
 		}
 		key := "/" + rpc.Name
 		switch rpc.BalancerType {
@@ -90,32 +283,182 @@ func New(cfg config.Config) *Server {
 				rpc.P2CEWMA.PenaltyDecay,
 				rpc.P2CEWMA.CooldownTimeout,
 			)
+			if rpc.P2CEWMA.StreakBonus > 0 {
+				srv.chainToP2CEWMA[key].SetStreakBonus(rpc.P2CEWMA.StreakBonus, rpc.P2CEWMA.StreakLength)
+			}
+			srv.chainToP2CEWMA[key].SetFailClosed(rpc.P2CEWMA.AllInCooldown == config.AllInCooldownFailClosed)
+			if srv.metricsCfg.Enabled {
+				metrics.RegisterProviderStats(strconv.FormatInt(rpc.ChainID, 10), rpc.Name,
+					srv.chainToP2CEWMA[key].Stats)
+			}
 		case config.RRName:
 			srv.chainToRR[key] = balancer.NewRoundRobin(providers)
+			srv.chainToRR[key].SetCooldown(rpc.Cooldown)
+			srv.chainToRR[key].SetFailClosed(rpc.AllInCooldown == config.AllInCooldownFailClosed)
 		case config.LCName:
 			srv.chainToLC[key] = balancer.NewLeastConnection(providers)
+			srv.chainToLC[key].SetCooldown(rpc.Cooldown)
+			srv.chainToLC[key].SetFailClosed(rpc.AllInCooldown == config.AllInCooldownFailClosed)
+		case config.LPBName:
+			srv.chainToLPB[key] = balancer.NewLeastPendingBytes(providers)
+		case config.AWName:
+			srv.chainToAW[key] = balancer.NewAdaptiveWeighted(
+				providers, rpc.AdaptiveWeighted.Smooth, rpc.AdaptiveWeighted.MinWeight)
+			srv.weightAdjusters = append(srv.weightAdjusters,
+				weightAdjuster{balancer: srv.chainToAW[key], interval: rpc.AdaptiveWeighted.Interval})
+		}
+		lb, _ := srv.balancerOfType(key, rpc.BalancerType)
+		if srv.metricsCfg.Enabled {
+			if observable, ok := lb.(inFlightObservable); ok {
+				observable.SetInFlightObserver(providerInFlightObserver(rpc))
+			}
 		}
+		if poller, ok := srv.newBlockLagPoller(rpc, providers, lb); ok {
+			srv.blockLagPollers = append(srv.blockLagPollers, poller)
+		}
+	}
+	if srv.snapshotCfg.Path != "" {
+		srv.restoreBalancers()
 	}
 
 	nameToLBAlgo := make(map[string]string)
 	nameToChainID := make(map[string]int64)
+	nameToWSSubscriptions := make(map[string]wsSubscriptionPolicy)
 	for _, rpc := range srv.rpcs {
 		nameToLBAlgo["/"+rpc.Name] = rpc.BalancerType
 		nameToChainID["/"+rpc.Name] = rpc.ChainID
+		nameToWSSubscriptions["/"+rpc.Name] = newWSSubscriptionPolicy(rpc.WSSubscriptions)
+		if limiter := newConcurrencyLimiter(rpc.Name, rpc.ConcurrencyLimit); limiter != nil {
+			srv.rpcLimiters["/"+rpc.Name] = limiter
+		}
+		if rpc.Fallback != "" {
+			srv.nameToFallback["/"+rpc.Name] = rpc.Fallback
+		}
+		if rpc.Retry.Attempts > 0 {
+			srv.nameToRetry["/"+rpc.Name] = newRetryPolicy(rpc.Retry)
+		}
+		if rpc.WSMultiplexing {
+			srv.wsMultiplexed["/"+rpc.Name] = struct{}{}
+		}
+		if rpc.ValidateChainID {
+			srv.chainIDValidated["/"+rpc.Name] = struct{}{}
+		}
+		if rpc.BatchFailurePolicy != "" && rpc.BatchFailurePolicy != config.BatchFailureAny {
+			srv.nameToBatchFailure["/"+rpc.Name] = rpc.BatchFailurePolicy
+		}
+		if rpc.SLOTargetSeconds > 0 {
+			srv.nameToSLOTarget["/"+rpc.Name] = rpc.SLOTargetSeconds
+		}
+		if len(rpc.MethodAliases) > 0 {
+			srv.nameToMethodAliases["/"+rpc.Name] = rpc.MethodAliases
+		}
+		if len(rpc.MethodTimeouts) > 0 {
+			srv.nameToMethodTimeouts["/"+rpc.Name] = rpc.MethodTimeouts
+		}
+		if classifier := newErrorClassifier(rpc.UserErrorCodes, rpc.NonUserErrorPatterns, rpc.TreatServerErrorsAsUser); classifier != nil {
+			srv.nameToErrorClassifier["/"+rpc.Name] = classifier
+		}
+		if forwarder := newClientIPForwarder(rpc.ForwardClientIP); forwarder != nil {
+			srv.nameToClientIP["/"+rpc.Name] = forwarder
+		}
+		if upstreamReq := newUpstreamRequest(rpc.UpstreamRequest); upstreamReq != nil {
+			srv.nameToUpstreamRequest["/"+rpc.Name] = upstreamReq
+		}
+		if rpc.WSReconnect.Attempts > 0 {
+			srv.nameToWSReconnect["/"+rpc.Name] = rpc.WSReconnect
+		}
+		if rpc.WSKeepalive.Interval > 0 {
+			srv.nameToWSKeepalive["/"+rpc.Name] = rpc.WSKeepalive
+		}
+		if rpc.WSMaxMessageBytes > 0 {
+			srv.nameToWSMaxMessage["/"+rpc.Name] = rpc.WSMaxMessageBytes
+		}
+		if rpc.WSEmptyMessages == config.WSEmptyMessagesClose {
+			srv.wsCloseOnEmpty["/"+rpc.Name] = struct{}{}
+		}
+		if len(rpc.WSSubscriptionAffinity) > 0 {
+			srv.nameToWSAffinity["/"+rpc.Name] = rpc.WSSubscriptionAffinity
+		}
+		if len(rpc.WSForwardHeaders) > 0 {
+			srv.nameToWSForwardHeaders["/"+rpc.Name] = rpc.WSForwardHeaders
+		}
+	}
+
+	if cfg.DNSCache.TTL > 0 || cfg.DNSCache.NegativeTTL > 0 {
+		srv.cli.Dial = newDNSCache(net.DefaultResolver, cfg.DNSCache).dial
+	}
+	for _, rpc := range cfg.RPCs {
+		// clients of rpcs share dns cache with default client.
+		if cli := newHTTPClient(rpc.HTTPClient, srv.cli.Dial); cli != nil {
+			srv.nameToHTTPClient["/"+rpc.Name] = cli
+		}
 	}
 
 	srv.nameToLBAlgo = nameToLBAlgo
 	srv.nameToChainID = nameToChainID
+	srv.nameToWSSubscriptions = nameToWSSubscriptions
 	srv.srv = &fasthttp.Server{
 		Handler: handler,
 	}
+	if srv.wsHandshakeTimeout > 0 {
+		srv.srv.ConnState = handshakeConnState(srv.wsHandshakeTimeout)
+	}
+	srv.rpcSrvs = srv.newRPCServers(cfg.RPCs, handler)
+	srv.adminSrv, srv.adminAddr = srv.newAdminServer(cfg.Admin)
+	if cfg.TLS.HTTP2 {
+		srv.h2srv = &http.Server{
+			Handler:           srv.h2Handler(handler),
+			ReadHeaderTimeout: alpnHandshakeTimeout,
+		}
+	}
 
 	return &srv
 }
 
+// weightAdjuster is adaptive-weighted balancer of rpc adjusting weights every interval once server is started.
+type weightAdjuster struct {
+	balancer *balancer.AdaptiveWeighted
+	interval time.Duration
+}
+
 func (srv *Server) Start(ctx context.Context) {
+	if srv.tlsCfg.CertFile != "" {
+		tlsCfg, err := newTLSConfig(srv.tlsCfg, srv.clients.Type == "mtls")
+		if err != nil {
+			log.Ctx(ctx).Panic().Err(err).Msg("Proxy server failed to configure tls")
+		}
+		srv.srv.TLSConfig = tlsCfg
+	}
+	for _, p := range srv.blockLagPollers {
+		go p.poller.Run(p.interval, srv.done)
+	}
+	for _, a := range srv.weightAdjusters {
+		go a.balancer.Run(a.interval, srv.done)
+	}
+	if srv.responseCache != nil {
+		for rpcPath, url := range srv.responseCache.newHeadsURLs {
+			go srv.followNewHeads(rpcPath, url, srv.done)
+		}
+	}
+	if srv.snapshotCfg.Path != "" {
+		go srv.runBalancerSnapshots(srv.done)
+	}
+	srv.serveRPCPorts(ctx)
+	srv.serveAdmin(ctx)
 	go func() {
-		err := srv.srv.ListenAndServe(fmt.Sprintf(":%d", srv.port))
+		var err error
+		switch {
+		case srv.h2srv != nil:
+			var ln net.Listener
+			if ln, err = net.Listen("tcp4", fmt.Sprintf(":%d", srv.port)); err == nil {
+				err = srv.serveALPN(ln)
+			}
+		case srv.srv.TLSConfig != nil:
+			// certificate is already in tls config, so files are not passed.
+			err = srv.srv.ListenAndServeTLS(fmt.Sprintf(":%d", srv.port), "", "")
+		default:
+			err = srv.srv.ListenAndServe(fmt.Sprintf(":%d", srv.port))
+		}
 		if err != nil {
 			log.Ctx(ctx).Panic().Err(err).Msg("Proxy server failed to start")
 		}
@@ -123,59 +466,254 @@ func (srv *Server) Start(ctx context.Context) {
 	log.Ctx(ctx).Info().Msg("Proxy server started")
 }
 
+// Name returns name of proxy server in shutdown logs and metrics.
+func (srv *Server) Name() string {
+	return "proxy"
+}
+
+// Stop stops accepting connections, asks websocket clients to leave with going away close frame
+// and waits for in-flight requests and websocket sessions up to shutdown timeout, then closes them.
 func (srv *Server) Stop() {
-	err := srv.srv.Shutdown()
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+
+	var (
+		wg                         sync.WaitGroup
+		h2Err, rpcSrvErr, adminErr error
+	)
+	wg.Go(func() { srv.wsConns.drain(ctx) })
+	wg.Go(func() { h2Err = srv.shutdownHTTP2(ctx) })
+	wg.Go(func() { rpcSrvErr = srv.shutdownRPCPorts(ctx) })
+	wg.Go(func() { adminErr = srv.shutdownAdmin(ctx) })
+	err := srv.srv.ShutdownWithContext(ctx)
+	wg.Wait()
+	err = cmp.Or(err, h2Err, rpcSrvErr, adminErr)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Warn().Dur("timeout", srv.shutdownTimeout).Msg("In-flight requests not drained within shutdown timeout")
+	case err != nil:
 		log.Panic().Err(err).Msg("Proxy server failed to stop")
 	}
+	close(srv.done)
+	// stats learned until shutdown are restored on next start.
+	if srv.snapshotCfg.Path != "" {
+		if err := srv.saveBalancers(); err != nil {
+			log.Error().Err(err).Str("path", srv.snapshotCfg.Path).Msg("can not save balancer snapshot")
+		}
+	}
+	// spans of requests finished during shutdown are exported too.
+	srv.tracer.Shutdown()
 	log.Info().Msg("Proxy server stopped")
 }
 
 func (srv *Server) handler(ctx *fasthttp.RequestCtx) {
 	reqctx := GetReqCtx(ctx)
+	if limit := srv.providerMaxBatchSize(reqctx.RPCName, reqctx.Provider); reqctx.Batch && limit > 0 &&
+		len(reqctx.Request) > limit {
+		srv.splitBatchHandler(ctx, limit)
+		return
+	}
+
+	resp := fasthttp.AcquireResponse()
+	streamed := false
+	defer func() {
+		if !streamed {
+			fasthttp.ReleaseResponse(resp)
+		}
+	}()
+
+	if !srv.send(ctx, ctx.Request.Body(), resp) {
+		return
+	}
+	streamed, err := srv.streamResponse(ctx, resp)
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not stream response body")
+		writeJSONRPCError(ctx, fasthttp.StatusBadGateway, jsonRPCInternalErrorCode, "invalid upstream response")
+		return
+	}
+	if streamed {
+		return
+	}
+	body, ok := decodeResponse(ctx, resp)
+	if !ok {
+		return
+	}
+	if isEmptyBody(body) && onlyNotifications(reqctx.Request) &&
+		(resp.StatusCode() == fasthttp.StatusOK || resp.StatusCode() == fasthttp.StatusNoContent) {
+		// provider answers nothing to notifications, so client gets no content.
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+	srv.writeUpstreamResponse(ctx, resp, body)
+}
+
+// forward sends body to provider of request and returns decoded response body.
+// If provider was not reached, client is answered with error and false is returned.
+func (srv *Server) forward(ctx *fasthttp.RequestCtx, body []byte, resp *fasthttp.Response) ([]byte, bool) {
+	if !srv.send(ctx, body, resp) {
+		return nil, false
+	}
+	return decodeResponse(ctx, resp)
+}
+
+// send sends body to provider of request, response body is left undecoded.
+// If provider was not reached, client is answered with error and false is returned.
+func (srv *Server) send(ctx *fasthttp.RequestCtx, body []byte, resp *fasthttp.Response) bool {
+	reqctx := GetReqCtx(ctx)
 
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 
 	req.SetRequestURI(reqctx.ConnURL)
-	req.SetBody(ctx.Request.Body())
-	req.Header.SetMethod(fasthttp.MethodPost)
-	req.Header.SetContentType("application/json")
+	if err := srv.upstreamRequest(reqctx.RPCName, reqctx.Provider).set(req, body); err != nil {
+		log.Debug().Uint64("request_id", ctx.ID()).Err(err).Msg("can not build provider request")
+		writeJSONRPCError(ctx, fasthttp.StatusBadRequest, jsonRPCInvalidRequestCode,
+			"request can not be sent to provider with GET")
+		return false
+	}
+	if srv.requestIDHdr != "" {
+		// request id is the same as in gateway logs, so provider logs can be correlated with them.
+		req.Header.Set(srv.requestIDHdr, strconv.FormatUint(ctx.ID(), 10))
+	}
+	srv.nameToClientIP[string(ctx.Path())].setHeaders(ctx, req)
+	srv.setProviderHeaders(req, reqctx.RPCName, reqctx.Provider)
+	if !srv.propagateDeadline(ctx, req) {
+		return false
+	}
 
-	resp := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(resp)
+	span := srv.startUpstreamSpan(ctx, req)
+	start := time.Now()
+	err := srv.doRequest(ctx, req, resp)
+	SetToReqCtx(ctx, func(rc *ReqCtx) { rc.UpstreamLatency = time.Since(start) })
+	endUpstreamSpan(span, err, resp)
+	if errors.Is(err, fasthttp.ErrTimeout) {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("provider request timed out")
+		writeJSONRPCError(ctx, fasthttp.StatusGatewayTimeout, jsonRPCInternalErrorCode, "upstream timeout")
+		return false
+	}
+	if err != nil {
+		// transport errors, including dns failures, are answered with bad gateway and json-rpc error,
+		// so balancer treats them as provider failure and applies cooldown, and clients see a real error.
+		var dnsErr *dnsError
+		if errors.As(err, &dnsErr) {
+			log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("provider dns resolution failed")
+		} else {
+			log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("error while request")
+		}
+		writeJSONRPCError(ctx, fasthttp.StatusBadGateway, jsonRPCInternalErrorCode, "upstream unavailable")
+		return false
+	}
+	if resp.StatusCode() == fasthttp.StatusTooManyRequests {
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.UpstreamRateLimited = true
+			rc.UpstreamRetryAfter = parseRetryAfter(resp.Header.Peek(fasthttp.HeaderRetryAfter), time.Now())
+		})
+	}
+
+	return true
+}
 
-	err := srv.cli.Do(req, resp)
+// decodeResponse returns decoded body of provider response.
+// If it can not be decoded, client is answered with error and false is returned.
+func decodeResponse(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) ([]byte, bool) {
+	decoded, err := getDecodedBody(resp)
 	if err != nil {
-		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("error while request")
-		return
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not decode response body")
+		writeJSONRPCError(ctx, fasthttp.StatusBadGateway, jsonRPCInternalErrorCode, "invalid upstream response")
+		return nil, false
 	}
+	return decoded, true
+}
 
-	_, err = io.Copy(ctx, bytes.NewReader(resp.Body()))
+// writeUpstreamResponse answers client with status, filtered headers and decoded body of provider response.
+func (srv *Server) writeUpstreamResponse(ctx *fasthttp.RequestCtx, resp *fasthttp.Response, body []byte) {
+	_, err := io.Copy(ctx, bytes.NewReader(body))
 	if err != nil {
 		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("error while request")
 		return
 	}
 	ctx.Response.SetStatusCode(resp.StatusCode())
-	resp.Header.CopyTo(&ctx.Response.Header)
+	srv.upstreamHeaders.copy(&ctx.Response.Header, &resp.Header)
 }
 
-func (srv *Server) recoverHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
-	return func(ctx *fasthttp.RequestCtx) {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Error().
-					// TODO this doesnt print stack
-					Stack().
-					Err(errors.New("panic")).
-					Uint64("request_id", ctx.ID()).
-					Any("recover", r).
-					Msg("panic at handler")
-				ctx.Error("internal server error", fasthttp.StatusInternalServerError)
-			}
-		}()
-		next(ctx)
+// doRequest sends request to provider with client of its rpc, bounded by timeout of its methods if it is configured,
+// by provider timeout or by timeout of rpc client otherwise. Request deadline bounds all timeouts,
+// requests cut by deadline do not adjust provider timeout.
+func (srv *Server) doRequest(ctx *fasthttp.RequestCtx, req *fasthttp.Request, resp *fasthttp.Response) error {
+	reqctx := GetReqCtx(ctx)
+	cli, clientTimeout := srv.httpClient(reqctx.RPCName, reqctx.Provider)
+	timeout, exist := srv.providerTimeout(reqctx.RPCName, reqctx.Provider)
+	regular := clientTimeout
+	if exist {
+		regular = timeout.get()
 	}
+	if methodTimeout, ok := srv.methodTimeout(reqctx, regular); ok {
+		// slow methods are expected to be slow, so their timeouts do not adjust provider timeout.
+		if !reqctx.Deadline.IsZero() {
+			methodTimeout = min(methodTimeout, time.Until(reqctx.Deadline))
+		}
+		return cli.DoTimeout(req, resp, methodTimeout)
+	}
+	if !exist {
+		switch {
+		case clientTimeout > 0 && reqctx.Deadline.IsZero():
+			return cli.DoTimeout(req, resp, clientTimeout)
+		case clientTimeout > 0:
+			return cli.DoTimeout(req, resp, min(clientTimeout, time.Until(reqctx.Deadline)))
+		case reqctx.Deadline.IsZero():
+			return cli.Do(req, resp)
+		}
+		return cli.DoDeadline(req, resp, reqctx.Deadline)
+	}
+
+	providerTimeout := timeout.get()
+	requestTimeout := providerTimeout
+	if !reqctx.Deadline.IsZero() {
+		requestTimeout = min(requestTimeout, time.Until(reqctx.Deadline))
+	}
+	err := cli.DoTimeout(req, resp, requestTimeout)
+	if requestTimeout < providerTimeout && errors.Is(err, fasthttp.ErrTimeout) {
+		return err
+	}
+	prev, next := timeout.observe(errors.Is(err, fasthttp.ErrTimeout))
+	if prev != next {
+		log.Info().
+			Uint64("request_id", ctx.ID()).
+			Str("provider", reqctx.Provider).
+			Dur("timeout", prev).
+			Dur("next_timeout", next).
+			Msg("provider timeout adjusted")
+	}
+	return err
+}
+
+// getDecodedBody returns upstream response body, decompressed if upstream used gzip, deflate, br or zstd
+// encoding. Body of other encodings can not be sent to client decoded, so error is returned for it.
+func getDecodedBody(resp *fasthttp.Response) ([]byte, error) {
+	var (
+		body     []byte
+		err      error
+		encoding = strings.ToLower(string(bytes.TrimSpace(resp.Header.ContentEncoding())))
+	)
+	switch encoding {
+	case "", "identity":
+		return resp.Body(), nil
+	case "gzip":
+		body, err = resp.BodyGunzip()
+	case "deflate":
+		body, err = resp.BodyInflate()
+	case "br":
+		body, err = resp.BodyUnbrotli()
+	case "zstd":
+		body, err = resp.BodyUnzstd()
+	default:
+		return nil, fmt.Errorf("unsupported response encoding: %s", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not decode %s response body: %w", encoding, err)
+	}
+
+	return body, nil
 }
 
 func (srv *Server) loggingMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
@@ -183,17 +721,18 @@ func (srv *Server) loggingMiddleware(next fasthttp.RequestHandler) fasthttp.Requ
 		start := time.Now()
 		next(ctx)
 
+		latency := time.Since(start)
 		reqctx := GetReqCtx(ctx)
-		log.Info().
-			Uint64("request_id", ctx.ID()).
-			Uint64("conn_id", ctx.ConnID()).
-			Str("remote_ip", ctx.RemoteIP().String()).
-			Int("status", ctx.Response.StatusCode()).
-			Str("latency", time.Since(start).String()).
-			Str("path", string(ctx.Path())).
-			Str("client", reqctx.Client).
-			Str("provider", reqctx.Provider).
-			Msg("request completed")
+		event := log.Info()
+		for _, field := range srv.loggerCfg.AccessFields {
+			event = addAccessField(event, field, ctx, reqctx, latency)
+		}
+		if srv.loggerCfg.ParamsHash && len(reqctx.Request) > 0 {
+			hash, size := ParamsHash(reqctx.Request)
+			event = event.Str("params_hash", hash).Int("params_size", size)
+		}
+		event.Msg("request completed")
+		srv.logBodies(ctx, reqctx)
 	}
 }
 
@@ -211,20 +750,27 @@ func (srv *Server) metricsMiddleware(next fasthttp.RequestHandler) fasthttp.Requ
 
 		reqctx := GetReqCtx(ctx)
 		chainID := strconv.FormatInt(reqctx.ChainID, base)
+		tags := srv.providerTags(reqctx.RPCName, reqctx.Provider)
+		statusCode := metrics.StatusCodeLabel(ctx.Response.StatusCode())
 
 		observeLatency := func(method string) {
-			metrics.RequestLatencySeconds.WithLabelValues(
-				chainID, reqctx.RPCName, reqctx.Provider, reqctx.Balancer, method, reqctx.Client).
+			metrics.RequestLatencySeconds.WithLabelValues(metrics.ProviderLabels(tags,
+				chainID, reqctx.RPCName, reqctx.Provider, reqctx.Balancer, method, reqctx.Client)...).
 				Observe(reqctx.Latency)
+			if reqctx.UpstreamLatency > 0 {
+				metrics.UpstreamLatencySeconds.WithLabelValues(metrics.ProviderLabels(tags,
+					chainID, reqctx.RPCName, reqctx.Provider, reqctx.Balancer, method, reqctx.Client)...).
+					Observe(reqctx.UpstreamLatency.Seconds())
+			}
 		}
 		observeTotal := func(method string) {
-			metrics.RequestTotalCounter.WithLabelValues(
+			metrics.RequestTotalCounter.WithLabelValues(append(metrics.ProviderLabels(tags,
 				chainID, reqctx.RPCName, metrics.HTTPTransport, reqctx.Provider, reqctx.Balancer, method, reqctx.Client,
-			).Inc()
+			), statusCode)...).Inc()
 		}
-		observeClientError := func(hasErr bool, method string) {
-			if hasErr {
-				metrics.ClientRequestError.WithLabelValues(
+		observeClientError := func(resp JSONRPCResponse, method string) {
+			if resp.HasError() {
+				metrics.ClientRequestError.WithLabelValues(append(metrics.ProviderLabels(tags,
 					chainID,
 					reqctx.RPCName,
 					metrics.HTTPTransport,
@@ -232,12 +778,12 @@ func (srv *Server) metricsMiddleware(next fasthttp.RequestHandler) fasthttp.Requ
 					reqctx.Balancer,
 					method,
 					reqctx.Client,
-				).Inc()
+				), metrics.ErrorCodeLabel(resp.Error.Code))...).Inc()
 			}
 		}
 		observeRequestError := func(method string) {
-			if ctx.Response.StatusCode() != fasthttp.StatusOK {
-				metrics.RequestError.WithLabelValues(
+			if status := ctx.Response.StatusCode(); status != fasthttp.StatusOK && status != fasthttp.StatusNoContent {
+				metrics.RequestError.WithLabelValues(append(metrics.ProviderLabels(tags,
 					chainID,
 					reqctx.RPCName,
 					metrics.HTTPTransport,
@@ -245,19 +791,33 @@ func (srv *Server) metricsMiddleware(next fasthttp.RequestHandler) fasthttp.Requ
 					reqctx.Balancer,
 					method,
 					reqctx.Client,
-				).Inc()
+				), statusCode)...).Inc()
 			}
 		}
 		observeResponseSizeBytes := func(method string) {
-			metrics.ResponseSizeBytes.WithLabelValues(
+			size := responseSize(ctx)
+			if size < 0 {
+				return
+			}
+			metrics.ResponseSizeBytes.WithLabelValues(metrics.ProviderLabels(tags,
 				chainID, reqctx.RPCName, metrics.HTTPTransport, reqctx.Provider, reqctx.Balancer, method, reqctx.Client,
-			).Observe(float64(len(ctx.Response.Body())))
+			)...).Observe(float64(size))
+		}
+
+		if target, ok := srv.nameToSLOTarget["/"+reqctx.RPCName]; ok {
+			metrics.RequestSLO.WithLabelValues(reqctx.RPCName, strconv.FormatBool(reqctx.Latency <= target)).Inc()
 		}
 
-		if len(reqctx.Request) == 1 && len(reqctx.Response) == 1 {
+		// streamed response is not parsed.
+		streamed := ctx.Response.IsBodyStream()
+		if len(reqctx.Request) == 1 && (len(reqctx.Response) == 1 ||
+			len(reqctx.Response) == 0 && (reqctx.Request[0].IsNotification() || streamed)) {
 			observeLatency(reqctx.Request[0].Method)
 			observeTotal(reqctx.Request[0].Method)
-			observeClientError(reqctx.Response[0].HasError(), reqctx.Request[0].Method)
+			// notification is not answered.
+			for _, resp := range reqctx.Response {
+				observeClientError(resp, reqctx.Request[0].Method)
+			}
 			observeRequestError(reqctx.Request[0].Method)
 			observeResponseSizeBytes(reqctx.Request[0].Method)
 			return
@@ -266,18 +826,79 @@ func (srv *Server) metricsMiddleware(next fasthttp.RequestHandler) fasthttp.Requ
 		observeLatency("batch")
 		observeRequestError("batch")
 		observeResponseSizeBytes("batch")
-		if len(reqctx.Request) != len(reqctx.Response) {
+		if srv.metricsCfg.BatchMethodLatency && len(reqctx.Request) > 0 {
+			// provider serves batch as a single call, so latency of its requests can only be estimated.
+			latency := reqctx.Latency / float64(len(reqctx.Request))
+			for _, req := range reqctx.Request {
+				metrics.BatchMethodLatencySeconds.WithLabelValues(metrics.ProviderLabels(tags,
+					chainID, reqctx.RPCName, reqctx.Provider, reqctx.Balancer, req.Method, reqctx.Client)...).
+					Observe(latency)
+			}
+		}
+		if streamed {
+			for _, req := range reqctx.Request {
+				observeTotal(req.Method)
+			}
+			return
+		}
+		// notifications are not answered, so only requests with id are matched to responses.
+		answered := calls(reqctx.Request)
+		if len(answered) != len(reqctx.Response) {
 			log.Debug().
-				Int("len(reqctx.Request)", len(reqctx.Request)).
+				Int("len(reqctx.Request)", len(answered)).
 				Int("len(reqctx.Response)", len(reqctx.Response)).
 				Msg("count mismatched")
 			return
 		}
-		for i := range len(reqctx.Request) {
-			observeTotal(reqctx.Request[i].Method)
-			observeClientError(reqctx.Response[i].HasError(), reqctx.Request[i].Method)
+		responses, aligned := alignResponses(answered, reqctx.Response)
+		if !aligned {
+			log.Debug().Uint64("request_id", ctx.ID()).Msg("batch responses matched to requests by position")
+		}
+		for i := range len(answered) {
+			observeTotal(answered[i].Method)
+			observeClientError(responses[i], answered[i].Method)
 		}
+		for _, req := range reqctx.Request {
+			if req.IsNotification() {
+				observeTotal(req.Method)
+			}
+		}
+	}
+}
+
+// providerInFlightObserver returns observer exporting in-flight requests of rpc providers to metrics.
+func providerInFlightObserver(rpc config.RPC) balancer.InFlightObserver {
+	const base = 10
+
+	chainID := strconv.FormatInt(rpc.ChainID, base)
+	return func(provider string, inFlight int64) {
+		metrics.ProviderInFlight.WithLabelValues(chainID, rpc.Name, provider, rpc.BalancerType).Set(float64(inFlight))
+	}
+}
+
+// providerTags returns tags of provider serving rpc, fallback provider
+// is named as fallback/<rpc>/<provider> and has tags of its own rpc.
+func (srv *Server) providerTags(rpcName, provider string) map[string]string {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		return srv.providerToTags[key]
 	}
+	return srv.providerToTags[rpcName+"/"+provider]
+}
+
+// wsMetricLabels returns label values of provider metric for websocket connection.
+func (srv *Server) wsMetricLabels(ctx *WSContext, method string) []string {
+	return metrics.ProviderLabels(srv.providerTags(ctx.rpcName, ctx.providerName),
+		ctx.chainID, ctx.rpcName, metrics.WebsocketTransport, ctx.providerName, ctx.loadBalanacer, method, ctx.client)
+}
+
+// wsStatusMetricLabels returns label values of provider metric labeled by status code for websocket request.
+func (srv *Server) wsStatusMetricLabels(ctx *WSContext) []string {
+	return append(srv.wsMetricLabels(ctx, ctx.method), metrics.NoStatusCode)
+}
+
+// wsErrorMetricLabels returns label values of client error metric for websocket request failed with code.
+func (srv *Server) wsErrorMetricLabels(ctx *WSContext, code int64) []string {
+	return append(srv.wsMetricLabels(ctx, ctx.method), metrics.ErrorCodeLabel(code))
 }
 
 func (srv *Server) routerHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
@@ -300,14 +921,37 @@ func (srv *Server) routerHandler(next fasthttp.RequestHandler) fasthttp.RequestH
 func (srv *Server) authMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	const authHeaderName = "Authorization"
 	loginToPass := make(map[string]string)
+	keyToLogin := make(map[string]string)
 	for _, c := range srv.clients.Clients {
 		loginToPass[c.Login] = c.Password
+		if c.APIKey != "" {
+			keyToLogin[c.APIKey] = c.Login
+		}
 	}
 
+	if srv.clients.Type == "jwt" {
+		return srv.jwtAuthMiddleware(next)
+	}
+	if srv.clients.Type == "mtls" {
+		return srv.mtlsAuthMiddleware(next)
+	}
+	if srv.clients.Type == "api_key" {
+		return func(ctx *fasthttp.RequestCtx) {
+			login, exist := keyToLogin[string(ctx.Request.Header.Peek(srv.clients.APIKeyHeader))]
+			if !exist {
+				log.Info().Uint64("request_id", ctx.ID()).Msg("invalid api key")
+				ctx.Error("", fasthttp.StatusUnauthorized)
+				return
+			}
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = login })
+			next(ctx)
+		}
+	}
 	if srv.clients.Type == "query" {
 		return func(ctx *fasthttp.RequestCtx) {
 			c := string(ctx.QueryArgs().Peek("client"))
-			if c == "" {
+			// client name is a metric label, so unlisted names are collapsed to bound its cardinality.
+			if _, listed := loginToPass[c]; c == "" || !listed && !srv.clients.AllowUnlisted {
 				c = "_unknown_"
 			}
 			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = c })
@@ -315,6 +959,7 @@ func (srv *Server) authMiddleware(next fasthttp.RequestHandler) fasthttp.Request
 		}
 	}
 
+	passwords := newPasswordChecker()
 	return func(ctx *fasthttp.RequestCtx) {
 		header := ctx.Request.Header.Peek(authHeaderName)
 		login, pass, err := GetBasicAuthDecoded(string(header))
@@ -338,7 +983,7 @@ func (srv *Server) authMiddleware(next fasthttp.RequestHandler) fasthttp.Request
 			ctx.Error("", fasthttp.StatusUnauthorized)
 			return
 		}
-		if expectedPass != pass {
+		if !passwords.check(login, expectedPass, pass) {
 			log.Info().
 				Uint64("request_id", ctx.ID()).
 				Err(err).Msg("invalid pass")
@@ -371,55 +1016,80 @@ func (srv *Server) healthzProbeMiddleware(next fasthttp.RequestHandler) fasthttp
 	const healthzProbePath = "/healthz"
 
 	return func(ctx *fasthttp.RequestCtx) {
-		if string(ctx.Path()) == healthzProbePath {
+		switch string(ctx.Path()) {
+		case healthzProbePath:
 			ctx.Response.SetStatusCode(fasthttp.StatusOK)
 			ctx.Response.SetBodyString("ok")
 			return
+		case healthzDetailedPath:
+			if srv.healthzCfg.Detailed {
+				srv.writeDetailedHealth(ctx)
+				return
+			}
 		}
 		next(ctx)
 	}
 }
 
-func (srv *Server) requestResponseParserMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+// requestParserMiddleware parses json-rpc request from client before provider is borrowed,
+// so following middlewares can inspect requested methods. Request which can't be parsed
+// is answered with parse error.
+func (srv *Server) requestParserMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
+		if body, ok := bytes.CutPrefix(ctx.Request.Body(), []byte(utf8BOM)); ok {
+			ctx.Request.SetBody(bytes.Clone(body))
+		}
+		if isEmptyBody(ctx.Request.Body()) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Request = []JSONRPCRequest{{}} })
+			writeJSONRPCError(ctx, fasthttp.StatusOK, jsonRPCParseErrorCode, "parse error: empty body")
+			return
+		}
 		isBatched := isBatch(ctx.Request.Body())
 
-		var request []JSONRPCRequest
-		if isBatched {
-			err := json.Unmarshal(ctx.Request.Body(), &request)
-			if err != nil {
-				log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not parse request")
-			}
-		} else {
-			request = append(request, JSONRPCRequest{})
-			err := json.Unmarshal(ctx.Request.Body(), &request[0])
-			if err != nil {
-				log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not parse request")
-			}
+		request, err := parseRequests(ctx.Request.Body(), isBatched)
+		if err != nil {
+			// malformed body is answered without provider call, json-rpc requires null id then.
+			log.Info().Uint64("request_id", ctx.ID()).Err(err).Msg("can not parse request")
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Request = []JSONRPCRequest{{}} })
+			writeJSONRPCError(ctx, fasthttp.StatusOK, jsonRPCParseErrorCode, "parse error")
+			return
 		}
-		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Request = request })
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.Request = request
+			rc.Batch = isBatched
+		})
 
 		next(ctx)
+	}
+}
 
-		var response []JSONRPCResponse
-		if isBatched {
-			err := json.Unmarshal(ctx.Response.Body(), &response)
-			if err != nil {
-				log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not parse response")
-			}
-		} else {
-			response = append(response, JSONRPCResponse{})
-			err := json.Unmarshal(ctx.Response.Body(), &response[0])
-			if err != nil {
-				log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not parse response")
-			}
+// responseParserMiddleware parses json-rpc response from provider,
+// so balancer can judge provider health by response errors.
+func (srv *Server) responseParserMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		reqctx := GetReqCtx(ctx)
+		// streamed body is not parsed, it would be buffered then.
+		if ctx.Response.IsBodyStream() ||
+			ctx.Response.StatusCode() == fasthttp.StatusNoContent && onlyNotifications(reqctx.Request) {
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Response = nil })
+			return
+		}
+		response, err := parseResponses(ctx.Response.Body(), reqctx.Batch)
+		if err != nil {
+			log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not parse response")
 		}
 		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Response = response })
 	}
 }
 
+// utf8BOM is byte order mark some clients put before json body.
+const utf8BOM = "\xef\xbb\xbf"
+
+// isBatch returns true if raw is json array, leading byte order mark is skipped.
 func isBatch(raw json.RawMessage) bool {
-	for _, c := range raw {
+	for _, c := range bytes.TrimPrefix(raw, []byte(utf8BOM)) {
 		// skip insignificant whitespace (http://www.ietf.org/rfc/rfc4627.txt)
 		if c == 0x20 || c == 0x09 || c == 0x0a || c == 0x0d {
 			continue
@@ -429,19 +1099,14 @@ func isBatch(raw json.RawMessage) bool {
 	return false
 }
 
+// isEmptyBody returns true if body has nothing but whitespace.
+func isEmptyBody(body []byte) bool {
+	return len(bytes.Trim(body, " \t\n\r")) == 0
+}
+
 func (srv *Server) loadBalancerMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		balancerType := srv.nameToLBAlgo[string(ctx.Path())]
-
-		var lb Balancer
-		switch balancerType {
-		case config.P2CEWMAName:
-			lb = srv.chainToP2CEWMA[string(ctx.Path())]
-		case config.RRName:
-			lb = srv.chainToRR[string(ctx.Path())]
-		case config.LCName:
-			lb = srv.chainToLC[string(ctx.Path())]
-		}
+		lb, balancerType := srv.getBalancer(string(ctx.Path()))
 		if lb == nil {
 			log.Error().
 				Uint64("request_id", ctx.ID()).
@@ -451,38 +1116,205 @@ func (srv *Server) loadBalancerMiddleware(next fasthttp.RequestHandler) fasthttp
 			ctx.Error("internal server error", fasthttp.StatusInternalServerError)
 			return
 		}
+		span := srv.startRequestSpan(ctx)
+		defer srv.endRequestSpan(ctx, span)
+		if srv.deadline.Timeout > 0 {
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Deadline = time.Now().Add(srv.deadline.Timeout) })
+		}
 
-		provider, release := lb.Borrow()
+		attempts := 1
+		if policy, exist := srv.nameToRetry[string(ctx.Path())]; exist && policy.isRetrySafe(GetReqCtx(ctx).Request) {
+			attempts += policy.attempts
+		}
+		for attempt := range attempts {
+			if attempt > 0 {
+				if deadlineExceeded(GetReqCtx(ctx)) {
+					// response of last attempt is kept, retry has no time left anyway.
+					return
+				}
+				log.Debug().
+					Uint64("request_id", ctx.ID()).
+					Int("attempt", attempt).
+					Str("failed_provider", GetReqCtx(ctx).Provider).
+					Msg("retrying request")
+				ctx.Response.Reset()
+			}
+			if srv.proxyToProvider(ctx, lb, balancerType, next) {
+				return
+			}
+		}
+	}
+}
 
-		SetToReqCtx(ctx, func(rc *ReqCtx) {
-			rc.Balancer = balancerType
-			rc.Provider = provider.Name
-			rc.ConnURL = provider.URL
-		})
+// proxyToProvider borrows provider from lb, serves request with next and releases provider.
+// Returns true if request was served successfully or must not be retried.
+func (srv *Server) proxyToProvider(
+	ctx *fasthttp.RequestCtx,
+	lb Balancer,
+	balancerType string,
+	next fasthttp.RequestHandler,
+) bool {
+	provider, release := lb.Borrow()
+	providerName := provider.Name
+	rpcPath := string(ctx.Path())
+
+	if provider == (balancer.Payload{}) {
+		if fallbackLB, fallbackType, fallback := srv.getFallbackBalancer(string(ctx.Path())); fallbackLB != nil {
+			lb, balancerType, rpcPath = fallbackLB, fallbackType, "/"+fallback
+			provider, release = lb.Borrow()
+			// provider name is tagged with fallback rpc name, so metrics show fallback usage.
+			providerName = "fallback/" + fallback + "/" + provider.Name
+			log.Debug().
+				Uint64("request_id", ctx.ID()).
+				Str("fallback", fallback).
+				Str("provider", provider.Name).
+				Msg("request routed to fallback rpc")
+		}
+	}
+	if provider.URL == "" {
+		log.Error().
+			Uint64("request_id", ctx.ID()).
+			Str("path", string(ctx.Path())).
+			Msg("no healthy providers for rpc")
+		writeJSONRPCError(ctx, fasthttp.StatusServiceUnavailable,
+			jsonRPCInternalErrorCode, "no healthy providers available")
+		return true
+	}
 
-		start := time.Now()
-		next(ctx)
-		latency := time.Since(start)
+	metrics.ProviderSelected.WithLabelValues(GetReqCtx(ctx).RPCName, providerName, balancerType).Inc()
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.Balancer = balancerType
+		rc.Provider = providerName
+		rc.ConnURL = provider.URL
+		rc.UpstreamLatency = 0
+		rc.UpstreamRateLimited = false
+		rc.UpstreamRetryAfter = 0
+	})
 
-		ok := ctx.Response.StatusCode() == fasthttp.StatusOK
-		reqctx := GetReqCtx(ctx)
+	var (
+		ok       bool
+		latency  time.Duration
+		start    = time.Now()
+		streamed *upstreamBodyStream
+	)
+	// release is deferred, so provider is returned to balancer as failed
+	// even if request is cancelled or next panics.
+	defer func() {
+		if latency == 0 {
+			latency = time.Since(start)
+		}
+		if streamed != nil {
+			// provider connection is busy until streamed body is sent, so provider is released after it.
+			streamed.onClose = func(failed bool) { release(ok && !failed, latency) }
+			return
+		}
+		release(ok, latency)
+	}()
 
-		if len(reqctx.Response) == 0 {
-			ok = false
+	next(ctx)
+	total := time.Since(start)
+	reqctx := GetReqCtx(ctx)
+	// balancer scores provider by its own latency, gateway processing is excluded.
+	latency = total
+	if reqctx.UpstreamLatency > 0 {
+		latency = reqctx.UpstreamLatency
+	}
+
+	// notifications are answered with no content and streamed responses are not parsed,
+	// so there are no responses to judge provider by.
+	notified := ctx.Response.StatusCode() == fasthttp.StatusNoContent && onlyNotifications(reqctx.Request)
+	unparsed := notified || ctx.Response.IsBodyStream()
+	streamed, _ = ctx.Response.BodyStream().(*upstreamBodyStream)
+	ok = ctx.Response.StatusCode() == fasthttp.StatusOK || notified
+	if reqctx.UpstreamRateLimited {
+		ok = false
+		if limited, isLimited := lb.(rateLimitObserver); isLimited {
+			limited.RateLimited(provider.Name, reqctx.UpstreamRetryAfter)
 		}
-		for _, resp := range reqctx.Response {
-			if !resp.HasError() {
-				continue
-			}
-			if !isUserCallError(resp.Error.Code, resp.Error.Message) {
-				ok = false
-				break
-			}
+	}
+
+	if !unparsed && (len(reqctx.Response) == 0 || isProviderFailure(reqctx.Response, srv.nameToBatchFailure[rpcPath],
+		srv.errorClassifier(reqctx.RPCName, providerName))) {
+		ok = false
+	}
+
+	if ok && !unparsed && !srv.validateChainID(reqctx, ctx.Response.Body(), lb, rpcPath, provider.Name) {
+		ok = false
+		writeJSONRPCError(ctx, fasthttp.StatusBadGateway, jsonRPCInternalErrorCode, "provider reported another chain")
+	}
+
+	SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Latency = total.Seconds() })
+
+	if observer, isObserver := lb.(responseSizeObserver); isObserver {
+		if size := responseSize(ctx); size >= 0 {
+			observer.ObserveResponseSize(provider.Name, size)
 		}
+	}
 
-		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Latency = latency.Seconds() })
+	return ok
+}
 
-		release(ok, latency)
+// getBalancer returns balancer and its type configured for rpc path.
+func (srv *Server) getBalancer(path string) (Balancer, string) {
+	return srv.balancerOfType(path, srv.nameToLBAlgo[path])
+}
+
+// balancerOfType returns balancer of passed type configured for rpc path.
+func (srv *Server) balancerOfType(path, balancerType string) (Balancer, string) {
+	var lb Balancer
+	switch balancerType {
+	case config.P2CEWMAName:
+		if b, ok := srv.chainToP2CEWMA[path]; ok {
+			lb = b
+		}
+	case config.RRName:
+		if b, ok := srv.chainToRR[path]; ok {
+			lb = b
+		}
+	case config.LCName:
+		if b, ok := srv.chainToLC[path]; ok {
+			lb = b
+		}
+	case config.LPBName:
+		if b, ok := srv.chainToLPB[path]; ok {
+			lb = b
+		}
+	case config.AWName:
+		if b, ok := srv.chainToAW[path]; ok {
+			lb = b
+		}
+	}
+	return lb, balancerType
+}
+
+// getFallbackBalancer returns balancer, its type and name of fallback rpc for rpc path.
+// Only one fallback hop is made, fallback of fallback rpc is never used.
+func (srv *Server) getFallbackBalancer(path string) (Balancer, string, string) {
+	fallback, ok := srv.nameToFallback[path]
+	if !ok {
+		return nil, "", ""
+	}
+	lb, balancerType := srv.getBalancer("/" + fallback)
+	return lb, balancerType, fallback
+}
+
+// isProviderFailure returns true if share of responses failed by provider fault matches policy,
+// errors caused by user call are not counted. Empty policy is the same as any.
+func isProviderFailure(responses []JSONRPCResponse, policy string, classifier *errorClassifier) bool {
+	var failures int
+	for _, resp := range responses {
+		if resp.HasError() && !classifier.isUserError(resp.Error.Code, resp.Error.Message) {
+			failures++
+		}
+	}
+
+	switch policy {
+	case config.BatchFailureMajority:
+		return failures*2 > len(responses)
+	case config.BatchFailureAll:
+		return failures > 0 && failures == len(responses)
+	default:
+		return failures > 0
 	}
 }
 
@@ -500,15 +1332,17 @@ func isUserCallError(code int64, msg string) bool {
 		}
 		return true
 	case -32000:
-		m := strings.ToLower(msg)
-		if strings.Contains(m, "execution reverted") ||
-			strings.Contains(m, "replacement transaction underpriced") {
-			return true
-		}
+		return isUserCallMessage(strings.ToLower(msg))
 	}
 	return false
 }
 
+// isUserCallMessage returns true if lower case message of -32000 error reports failure of user call.
+func isUserCallMessage(m string) bool {
+	return strings.Contains(m, "execution reverted") ||
+		strings.Contains(m, "replacement transaction underpriced")
+}
+
 func (srv *Server) transportRouter(httpFn, wsFn fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		if websocket.FastHTTPIsWebSocketUpgrade(ctx) {
@@ -537,15 +1371,14 @@ func (srv *Server) wsLoggingMiddleware(next fasthttp.RequestHandler) fasthttp.Re
 	}
 }
 
-const bufferSize = 1024
-
-var upgrader = websocket.FastHTTPUpgrader{ //nolint:gochecknoglobals
-	ReadBufferSize:  bufferSize,
-	WriteBufferSize: bufferSize,
-}
-
-func (srv *Server) initWSConnWithProvider(connURL string) (*websocket.Conn, error) {
-	providerConn, resp, err := websocket.DefaultDialer.Dial(connURL, nil)
+// initWSConnWithProvider dials websocket connection to provider with handshake headers.
+// Provider rejecting handshake, like for missing api key or Origin header, is reported with its status code.
+func (srv *Server) initWSConnWithProvider(connURL string, header http.Header) (*websocket.Conn, error) {
+	providerConn, resp, err := websocket.DefaultDialer.Dial(connURL, header)
+	if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+		return nil, fmt.Errorf("provider rejected websocket handshake with status code %d, "+
+			"check headers required by provider: %w", resp.StatusCode, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("can not dial websocket connection to provider: %w", err)
 	}
@@ -563,21 +1396,25 @@ func nonBlockingChanSend(errChan chan error, err error) {
 	}
 }
 
+// wsPipe reads messages from readConn and writes them to writeConn.
+// onMessage is called for every message, it returns message to forward or false if message must not be forwarded.
 func (srv *Server) wsPipe(ctx *WSContext,
-	readConn, writeConn *websocket.Conn,
+	readConn *websocket.Conn, writeConn jsonWriter,
 	readErrChan, writeErrChan chan error,
-	observeMetrics func(ctx *WSContext, msg json.RawMessage),
+	onMessage func(ctx *WSContext, msg json.RawMessage) (json.RawMessage, bool),
 ) {
-	var err error
+	_, closeOnEmpty := srv.wsCloseOnEmpty[ctx.requestPath]
 	for {
-		var msg json.RawMessage
-		err = readConn.ReadJSON(&msg)
+		msg, err := readWSMessage(readConn, closeOnEmpty)
 		if err != nil {
 			nonBlockingChanSend(readErrChan, err)
 			return
 		}
 
-		observeMetrics(ctx, msg)
+		var forward bool
+		if msg, forward = onMessage(ctx, msg); !forward {
+			continue
+		}
 
 		err = writeConn.WriteJSON(msg)
 		if err != nil {
@@ -605,8 +1442,19 @@ func (srv *Server) wsLoadBalancerMiddleware(next WSHandler) WSHandler {
 				websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "no balancer configured for rpc"))
 			return
 		}
-		payload, release := lb.Borrow()
+		payload, release := srv.borrowWSProvider(ctx.requestPath, lb, nil)
 		defer release(true, 0)
+		if payload.URL == "" {
+			log.Error().
+				Uint64("request_id", ctx.requestID).
+				Str("path", ctx.requestPath).
+				Msg("no providers available for websocket session")
+			_ = ctx.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "no providers available"))
+			return
+		}
+
+		metrics.ProviderSelected.WithLabelValues(ctx.rpcName, payload.Name, ctx.loadBalanacer).Inc()
 
 		ctx.providerName = payload.Name
 		ctx.providerURL = payload.URL
@@ -621,17 +1469,91 @@ func (srv *Server) extractMethodFromBody(msg json.RawMessage) string {
 		return batchMethod
 	}
 
-	var req JSONRPCRequest
-	err := json.Unmarshal(msg, &req)
+	method, err := parseMethod(msg)
 	if err != nil {
 		return ""
 	}
 
-	return req.Method
+	return method
+}
+
+// rejectedWSMessage returns json-rpc error response, its code and true if msg is rejected
+// by method acl of client, by degraded mode or by subscription policy of rpc.
+func (srv *Server) rejectedWSMessage(ctx *WSContext, msg json.RawMessage) (any, int64, bool) {
+	if rejection, rejected := srv.rejectedWSMethod(ctx, msg); rejected {
+		return rejection, jsonRPCMethodNotFoundCode, true
+	}
+	if rejection, rejected := srv.rejectedWSDegraded(ctx, msg); rejected {
+		return rejection, jsonRPCMethodNotSupportedCode, true
+	}
+	if rejection, rejected := srv.rejectedWSSubscription(ctx, msg); rejected {
+		return rejection, jsonRPCInvalidRequestCode, true
+	}
+	return nil, 0, false
+}
+
+// rejectedWSSubscription returns json-rpc error response and true if msg
+// subscribes to subscription type which is not allowed for rpc.
+// Batch is rejected entirely if any of its requests is rejected.
+func (srv *Server) rejectedWSSubscription(ctx *WSContext, msg json.RawMessage) (any, bool) {
+	policy := srv.nameToWSSubscriptions[ctx.requestPath]
+	rejection, _, rejected := rejectedWSRequest(msg, func(req wsRequest) bool {
+		subscription := req.subscription()
+		return subscription != "" && !policy.isAllowed(subscription)
+	}, jsonRPCInvalidRequestCode, "subscription type is not allowed")
+	return rejection, rejected
+}
+
+// rejectedWSRequest returns json-rpc error response with code and errMsg, the first denied request and true
+// if msg has request isDenied returns true for. Batch is rejected entirely if any of its requests is denied.
+func rejectedWSRequest(
+	msg json.RawMessage,
+	isDenied func(wsRequest) bool,
+	code int64,
+	errMsg string,
+) (any, wsRequest, bool) {
+	if !isBatch(msg) {
+		var req wsRequest
+		if err := json.Unmarshal(msg, &req); err != nil || !isDenied(req) {
+			return nil, wsRequest{}, false
+		}
+		return newJSONRPCErrorResponse(req.ID, code, errMsg), req, true
+	}
+
+	var batch []wsRequest
+	if err := json.Unmarshal(msg, &batch); err != nil {
+		return nil, wsRequest{}, false
+	}
+	denied := slices.IndexFunc(batch, isDenied)
+	if denied < 0 {
+		return nil, wsRequest{}, false
+	}
+	resp := make([]jsonRPCErrorResponse, 0, len(batch))
+	for _, req := range batch {
+		resp = append(resp, newJSONRPCErrorResponse(req.ID, code, errMsg))
+	}
+	return resp, batch[denied], true
 }
 
 func (srv *Server) wsHandler(ctx *WSContext) {
-	providerConn, err := srv.initWSConnWithProvider(ctx.providerURL)
+	if !srv.wsConns.add(ctx.conn) {
+		_ = ctx.conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		return
+	}
+	defer srv.wsConns.remove(ctx.conn)
+
+	// provider label is fixed at connect, so gauge is decremented by the same labels whatever closes session.
+	active := metrics.WSActiveConnections.WithLabelValues(ctx.rpcName, ctx.providerName)
+	active.Inc()
+	defer active.Dec()
+
+	if _, multiplexed := srv.wsMultiplexed[ctx.requestPath]; multiplexed {
+		srv.wsMuxHandler(ctx)
+		return
+	}
+
+	providerConn, err := srv.initWSConnWithProvider(ctx.providerURL, srv.wsHandshakeHeaders(ctx, ctx.providerName))
 	if err != nil {
 		_ = ctx.conn.WriteMessage(websocket.CloseMessage, nil)
 		log.Error().
@@ -641,56 +1563,148 @@ func (srv *Server) wsHandler(ctx *WSContext) {
 			Msg("can not init connection to provider")
 		return
 	}
-	defer providerConn.Close()
+	reconnect := srv.nameToWSReconnect[ctx.requestPath]
+	if reconnect.Attempts > 0 {
+		ctx.subscriptions = newWSSubscriptions()
+	}
+	upstream := &wsUpstreamConn{conn: providerConn, subscriptions: ctx.subscriptions}
+	defer upstream.Close()
+
+	keepalive, keepaliveEnabled := srv.nameToWSKeepalive[ctx.requestPath]
+	if keepaliveEnabled {
+		defer startWSKeepalive(ctx.conn, keepalive).stop()
+	}
+	// exceeding read limit closes client connection with message too big code.
+	maxMessageBytes := srv.nameToWSMaxMessage[ctx.requestPath]
+	ctx.conn.SetReadLimit(maxMessageBytes)
 
 	var (
 		upstreamError = make(chan error, 1)
 		clientError   = make(chan error, 1)
 	)
 
-	var wg sync.WaitGroup
+	clientConn := &wsLockedWriter{conn: ctx.conn}
+
+	var (
+		wg       sync.WaitGroup
+		affinity *wsAffinity
+	)
+	affinity = srv.newWSAffinity(ctx, func(route *wsAffinityRoute) {
+		wg.Go(func() {
+			route.conn.SetReadLimit(maxMessageBytes)
+			srv.wsPipe(ctx, route.conn, clientConn, upstreamError, clientError, func(ctx *WSContext, msg json.RawMessage) (json.RawMessage, bool) {
+				metrics.WSMessages.WithLabelValues(ctx.rpcName, route.provider, metrics.WSUpstreamToClient).Inc()
+				affinity.trackResponse(route, msg)
+				return msg, true
+			})
+		})
+	})
+	defer affinity.release()
 	wg.Go(func() {
-		srv.wsPipe(ctx, ctx.conn, providerConn, clientError, upstreamError, func(ctx *WSContext, msg json.RawMessage) {
+		srv.wsPipe(ctx, ctx.conn, upstream, clientError, upstreamError, func(ctx *WSContext, msg json.RawMessage) (json.RawMessage, bool) {
+			metrics.WSMessages.WithLabelValues(ctx.rpcName, ctx.providerName, metrics.WSClientToUpstream).Inc()
 			method := srv.extractMethodFromBody(msg)
 			if method == "" {
 				log.Error().Uint64("request_id", ctx.requestID).Msg("can not parse request")
 			}
 			ctx.method = method
-			metrics.RequestTotalCounter.WithLabelValues(ctx.chainID, ctx.rpcName, metrics.WebsocketTransport, ctx.providerName, ctx.loadBalanacer, ctx.method, ctx.client).
+			metrics.RequestTotalCounter.WithLabelValues(srv.wsStatusMetricLabels(ctx)...).
 				Inc()
+
+			rejection, code, rejected := srv.rejectedWSMessage(ctx, msg)
+			if !rejected {
+				routed, err := affinity.route(msg)
+				if err != nil {
+					log.Err(err).Uint64("request_id", ctx.requestID).Msg("can not route subscription")
+					nonBlockingChanSend(upstreamError, err)
+				}
+				return msg, !routed
+			}
+			log.Info().Uint64("request_id", ctx.requestID).Str("client", ctx.client).Msg("request rejected")
+			metrics.ClientRequestError.WithLabelValues(srv.wsErrorMetricLabels(ctx, code)...).
+				Inc()
+			if err := clientConn.WriteJSON(rejection); err != nil {
+				nonBlockingChanSend(clientError, err)
+			}
+			return nil, false
 		})
 	})
-	wg.Go(func() {
-		srv.wsPipe(ctx, providerConn, ctx.conn, upstreamError, clientError, func(ctx *WSContext, msg json.RawMessage) {
-			metrics.ResponseSizeBytes.WithLabelValues(ctx.chainID, ctx.rpcName, metrics.WebsocketTransport, ctx.providerName, ctx.loadBalanacer, "websocket", ctx.client).
-				Observe(float64(len(msg)))
+	pipeUpstream := func(conn *websocket.Conn) {
+		wg.Go(func() {
+			if keepaliveEnabled {
+				defer startWSKeepalive(conn, keepalive).stop()
+			}
+			conn.SetReadLimit(maxMessageBytes)
+			srv.wsPipe(ctx, conn, clientConn, upstreamError, clientError, func(ctx *WSContext, msg json.RawMessage) (json.RawMessage, bool) {
+				metrics.WSMessages.WithLabelValues(ctx.rpcName, ctx.providerName, metrics.WSUpstreamToClient).Inc()
+				metrics.ResponseSizeBytes.WithLabelValues(srv.wsMetricLabels(ctx, "websocket")...).
+					Observe(float64(len(msg)))
+				if ctx.subscriptions != nil {
+					return ctx.subscriptions.trackResponse(msg)
+				}
+				return msg, true
+			})
 		})
-	})
+	}
+	pipeUpstream(providerConn)
 	wg.Go(func() {
+		defer affinity.close()
 		var (
 			msg    string
 			status int
 		)
-		select {
-		case err = <-upstreamError:
-			if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
-				log.Err(err).Uint64("request_id", ctx.requestID).Str("provider", ctx.providerName).Msg("upstream error")
-				status = websocket.CloseGoingAway
-				msg = fmt.Sprintf("upstream [%s] error: %v", ctx.providerName, err)
-				metrics.RequestError.WithLabelValues(ctx.chainID, ctx.rpcName, metrics.WebsocketTransport, ctx.providerName, ctx.loadBalanacer, ctx.method, ctx.client).
-					Inc()
-			} else {
-				status = websocket.CloseNormalClosure
-				msg = fmt.Sprintf("upstream [%s] closed connection", ctx.providerName)
+		for {
+			select {
+			case err = <-upstreamError:
+				if isWSKeepaliveTimeout(err) {
+					metrics.WSKeepaliveClosed.WithLabelValues(ctx.rpcName, wsKeepaliveUpstream).Inc()
+				}
+				if errors.Is(err, websocket.ErrReadLimit) {
+					log.Warn().Uint64("request_id", ctx.requestID).Str("provider", ctx.providerName).Msg("upstream message too big")
+					msg := fmt.Sprintf("upstream [%s] message exceeds %d bytes", ctx.providerName, maxMessageBytes)
+					_ = clientConn.WriteMessage(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, msg))
+					return
+				}
+				if reconnect.Attempts > 0 && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					log.Warn().Err(err).Uint64("request_id", ctx.requestID).Str("provider", ctx.providerName).Msg("upstream dropped, reconnecting")
+					metrics.RequestError.WithLabelValues(srv.wsStatusMetricLabels(ctx)...).
+						Inc()
+					conn, reconnectErr := srv.wsReconnect(ctx, reconnect, upstream)
+					if reconnectErr == nil {
+						pipeUpstream(conn)
+						continue
+					}
+					log.Err(reconnectErr).Uint64("request_id", ctx.requestID).Msg("can not reconnect to upstream")
+					status = websocket.CloseGoingAway
+					msg = fmt.Sprintf("upstream [%s] error: %v", ctx.providerName, err)
+				} else if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
+					log.Err(err).Uint64("request_id", ctx.requestID).Str("provider", ctx.providerName).Msg("upstream error")
+					status = websocket.CloseGoingAway
+					msg = fmt.Sprintf("upstream [%s] error: %v", ctx.providerName, err)
+					metrics.RequestError.WithLabelValues(srv.wsStatusMetricLabels(ctx)...).
+						Inc()
+				} else {
+					status = websocket.CloseNormalClosure
+					msg = fmt.Sprintf("upstream [%s] closed connection", ctx.providerName)
+				}
+				_ = clientConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(status, msg))
+			case err = <-clientError:
+				if isWSKeepaliveTimeout(err) {
+					metrics.WSKeepaliveClosed.WithLabelValues(ctx.rpcName, wsKeepaliveClient).Inc()
+				}
+				_ = upstream.WriteMessage(websocket.CloseMessage, nil)
+				if errors.Is(err, errWSEmptyMessage) {
+					_ = clientConn.WriteMessage(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, err.Error()))
+				}
+				if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
+					log.Err(err).Uint64("request_id", ctx.requestID).Str("client", ctx.client).Msg("client error")
+				}
+				metrics.ClientRequestError.WithLabelValues(append(srv.wsMetricLabels(ctx, ctx.method),
+					metrics.NoErrorCode)...).Inc()
 			}
-			_ = ctx.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(status, msg))
-		case err = <-clientError:
-			_ = providerConn.WriteMessage(websocket.CloseMessage, nil)
-			if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
-				log.Err(err).Uint64("request_id", ctx.requestID).Str("client", ctx.client).Msg("client error")
-			}
-			metrics.ClientRequestError.WithLabelValues(ctx.chainID, ctx.rpcName, metrics.WebsocketTransport, ctx.providerName, ctx.loadBalanacer, ctx.method, ctx.client).
-				Inc()
+			return
 		}
 	})
 	wg.Wait()
@@ -723,9 +1737,18 @@ func (srv *Server) wsUpgrader(next WSHandler) fasthttp.RequestHandler {
 			return
 		}
 		rpcName := strings.TrimPrefix(string(ctx.Path()), "/")
+		// request is released once connection is upgraded, so forwarded headers are copied before.
+		forwardedHeaders := srv.forwardedWSHeaders(ctx, path)
+		if srv.wsHandshakeTimeout > 0 {
+			// client not reading handshake response must not hold connection,
+			// deadline is cleared by fasthttp when connection is hijacked.
+			_ = ctx.Conn().SetWriteDeadline(time.Now().Add(srv.wsHandshakeTimeout))
+		}
 
-		upgradeErr := upgrader.Upgrade(ctx, func(clientConn *websocket.Conn) {
+		upgradeErr := srv.upgrader.Upgrade(ctx, func(clientConn *websocket.Conn) {
 			defer clientConn.Close()
+			// session is served after handler returned, so its panics are not seen by recoverHandler.
+			defer srv.recoverWSPanic(requestID, clientConn)
 
 			next(&WSContext{
 				conn:          clientConn,
@@ -735,6 +1758,8 @@ func (srv *Server) wsUpgrader(next WSHandler) fasthttp.RequestHandler {
 				requestPath:   path,
 				chainID:       strconv.FormatInt(chainID, base),
 				rpcName:       rpcName,
+
+				forwardedHeaders: forwardedHeaders,
 			})
 		})
 		if upgradeErr != nil {
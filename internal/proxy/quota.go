@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// quotaPath is path of endpoint returning quota usage of requesting client.
+const quotaPath = "/debug/quota"
+
+// quotaUsage is usage of client quota in current period.
+type quotaUsage struct {
+	used    int64
+	resetAt time.Time
+}
+
+// quotaStatus is quota usage of client returned by quota endpoint.
+type quotaStatus struct {
+	Client   string    `json:"client"`
+	Requests int64     `json:"requests"`
+	Used     int64     `json:"used"`
+	Period   string    `json:"period"`
+	ResetAt  time.Time `json:"reset_at"`
+}
+
+// quotaTracker counts requests of clients with quota configured, usage is kept in memory only.
+type quotaTracker struct {
+	clientToQuota map[string]config.Quota
+	now           func() time.Time
+
+	mutex sync.Mutex
+	usage map[string]*quotaUsage
+}
+
+// newQuotaTracker returns nil if no client has quota configured.
+func newQuotaTracker(cfg config.Clients) *quotaTracker {
+	clientToQuota := make(map[string]config.Quota)
+	for _, client := range cfg.Clients {
+		if client.Quota.Requests > 0 {
+			clientToQuota[client.Login] = client.Quota
+		}
+	}
+	if len(clientToQuota) == 0 {
+		return nil
+	}
+	return &quotaTracker{
+		clientToQuota: clientToQuota,
+		now:           time.Now,
+		usage:         make(map[string]*quotaUsage),
+	}
+}
+
+// nextQuotaReset returns start of the next day or month in UTC.
+func nextQuotaReset(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == config.QuotaMonth {
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// current returns usage of client in current period, resetting it if period is over.
+// Must be called with mutex held.
+func (t *quotaTracker) current(client string, quota config.Quota) *quotaUsage {
+	now := t.now()
+	usage, exist := t.usage[client]
+	if !exist || !now.Before(usage.resetAt) {
+		usage = &quotaUsage{resetAt: nextQuotaReset(quota.Period, now)}
+		t.usage[client] = usage
+	}
+	return usage
+}
+
+// consume counts request of client against its quota and returns false if quota is exhausted.
+// Time left until quota reset is returned for rejected request.
+func (t *quotaTracker) consume(client string) (bool, time.Duration) {
+	quota, limited := t.clientToQuota[client]
+	if !limited {
+		return true, 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	usage := t.current(client, quota)
+	if usage.used >= quota.Requests {
+		return false, usage.resetAt.Sub(t.now())
+	}
+	usage.used++
+	metrics.QuotaUsed.WithLabelValues(client).Set(float64(usage.used))
+
+	return true, 0
+}
+
+// status returns quota usage of client, false if client has no quota.
+func (t *quotaTracker) status(client string) (quotaStatus, bool) {
+	quota, limited := t.clientToQuota[client]
+	if !limited {
+		return quotaStatus{}, false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	usage := t.current(client, quota)
+	return quotaStatus{
+		Client:   client,
+		Requests: quota.Requests,
+		Used:     usage.used,
+		Period:   quota.Period,
+		ResetAt:  usage.resetAt,
+	}, true
+}
+
+// quotaMiddleware rejects requests of clients exhausted their quota with 429 and Retry-After header.
+// GET /debug/quota returns quota usage of requesting client and is not counted against quota.
+func (srv *Server) quotaMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if srv.quotaTracker == nil {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		client := GetReqCtx(ctx).Client
+		if ctx.IsGet() && string(ctx.Path()) == quotaPath {
+			srv.writeQuotaStatus(ctx, client)
+			return
+		}
+
+		allowed, wait := srv.quotaTracker.consume(client)
+		if !allowed {
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("client", client).
+				Msg("quota exceeded")
+			metrics.QuotaRejected.WithLabelValues(client).Inc()
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONRPCError(ctx, fasthttp.StatusTooManyRequests, jsonRPCLimitExceededCode, "quota exceeded")
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+func (srv *Server) writeQuotaStatus(ctx *fasthttp.RequestCtx, client string) {
+	status, limited := srv.quotaTracker.status(client)
+	if !limited {
+		ctx.Error("quota is not configured for client", fasthttp.StatusNotFound)
+		return
+	}
+	raw, err := json.Marshal(status)
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal quota status")
+		ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.SetBody(raw)
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_quotaTracker(t *testing.T) {
+	now := time.Date(2025, time.January, 31, 23, 0, 0, 0, time.UTC)
+	tracker := newQuotaTracker(config.Clients{Clients: []config.Client{
+		{Login: "daily", Quota: config.Quota{Requests: 2, Period: config.QuotaDay}},
+		{Login: "monthly", Quota: config.Quota{Requests: 1, Period: config.QuotaMonth}},
+		{Login: "unlimited"},
+	}})
+	tracker.now = func() time.Time { return now }
+
+	consumeN := func(client string, n int) int {
+		allowed := 0
+		for range n {
+			if ok, _ := tracker.consume(client); ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	require.Equal(t, 2, consumeN("daily", 5))
+	ok, wait := tracker.consume("daily")
+	require.False(t, ok)
+	require.Equal(t, time.Hour, wait)
+	require.Equal(t, 1, consumeN("monthly", 5))
+	require.Equal(t, 5, consumeN("unlimited", 5))
+
+	// daily quota is reset at midnight, monthly one at the start of next month.
+	now = time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, 2, consumeN("daily", 5))
+	require.Equal(t, 1, consumeN("monthly", 5))
+
+	now = time.Date(2025, time.February, 2, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, 2, consumeN("daily", 5))
+	require.Equal(t, 0, consumeN("monthly", 5))
+
+	require.Nil(t, newQuotaTracker(config.Clients{Clients: []config.Client{{Login: "unlimited"}}}))
+}
+
+func Test_Server_quotaMiddleware(t *testing.T) {
+	srv := &Server{quotaTracker: newQuotaTracker(config.Clients{Clients: []config.Client{
+		{Login: "quota-limited", Quota: config.Quota{Requests: 1, Period: config.QuotaDay}},
+	}})}
+	handler := srv.quotaMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	})
+	request := func(method, path string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(path)
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = "quota-limited" })
+		handler(ctx)
+		return ctx
+	}
+	rejected := metrics.QuotaRejected.WithLabelValues("quota-limited")
+	before := testutil.ToFloat64(rejected)
+
+	require.Equal(t, fasthttp.StatusOK, request(fasthttp.MethodPost, "/mainnet").Response.StatusCode())
+	ctx := request(fasthttp.MethodPost, "/mainnet")
+	require.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	require.NotEmpty(t, ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter))
+	require.InDelta(t, before+1, testutil.ToFloat64(rejected), 0)
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.QuotaUsed.WithLabelValues("quota-limited")), 0)
+
+	// quota endpoint is available even if quota is exhausted.
+	ctx = request(fasthttp.MethodGet, quotaPath)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	var status quotaStatus
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &status))
+	require.Equal(t, "quota-limited", status.Client)
+	require.Equal(t, int64(1), status.Requests)
+	require.Equal(t, int64(1), status.Used)
+	require.Equal(t, config.QuotaDay, status.Period)
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// rateLimitSweepInterval is how often buckets of idle clients are evicted.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds tokens of client, refilled lazily on every request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits requests of every key, like client or method, by token bucket.
+// Key without own limit uses default one, requests without client share one bucket.
+type rateLimiter struct {
+	defaultLimit config.RateLimit
+	keyToLimit   map[string]config.RateLimit
+	now          func() time.Time
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns nil if neither default nor any client limit is configured.
+func newRateLimiter(cfg config.Clients) *rateLimiter {
+	clientToLimit := make(map[string]config.RateLimit)
+	for _, client := range cfg.Clients {
+		if client.RateLimit.RPS > 0 {
+			clientToLimit[client.Login] = client.RateLimit
+		}
+	}
+	if cfg.RateLimit.RPS <= 0 && len(clientToLimit) == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		defaultLimit: cfg.RateLimit,
+		keyToLimit:   clientToLimit,
+		now:          time.Now,
+		buckets:      make(map[string]*tokenBucket),
+		lastSweep:    time.Now(),
+	}
+}
+
+// newMethodRateLimiter returns limiter of methods with own limit, other methods are not limited.
+// Returns nil if no method is limited.
+func newMethodRateLimiter(methodToLimit map[string]config.RateLimit) *rateLimiter {
+	keyToLimit := make(map[string]config.RateLimit)
+	for method, limit := range methodToLimit {
+		if limit.RPS > 0 {
+			keyToLimit[method] = limit
+		}
+	}
+	if len(keyToLimit) == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		keyToLimit: keyToLimit,
+		now:        time.Now,
+		buckets:    make(map[string]*tokenBucket),
+		lastSweep:  time.Now(),
+	}
+}
+
+// limit returns limit of key, zero RPS means key is not limited.
+func (l *rateLimiter) limit(key string) config.RateLimit {
+	if limit, ok := l.keyToLimit[key]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// allow takes a token of client. Returns false and time until next token if bucket is empty.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	limit := l.limit(client)
+	if limit.RPS <= 0 {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	bucket := l.bucket(client, limit, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, bucket.wait(limit, 1)
+}
+
+// allowAll takes a token of every key, key repeated n times takes n tokens. Tokens are taken only if
+// every key has enough of them, otherwise returns false, the first exceeded key and time until its tokens
+// are refilled.
+func (l *rateLimiter) allowAll(keys []string) (bool, string, time.Duration) {
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		if l.limit(key).RPS > 0 {
+			counts[key]++
+		}
+	}
+	if len(counts) == 0 {
+		return true, "", 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	for _, key := range keys {
+		n, ok := counts[key]
+		if !ok {
+			continue
+		}
+		limit := l.limit(key)
+		if bucket := l.bucket(key, limit, now); bucket.tokens < float64(n) {
+			return false, key, bucket.wait(limit, n)
+		}
+	}
+	for key, n := range counts {
+		l.buckets[key].tokens -= float64(n)
+	}
+	return true, "", 0
+}
+
+// exceedsBurst returns key repeated more times than burst of its limit and true, such keys can never
+// be allowed at once by allowAll however long caller waits.
+func (l *rateLimiter) exceedsBurst(keys []string) (string, bool) {
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		counts[key]++
+		if limit := l.limit(key); limit.RPS > 0 && counts[key] > limit.Burst {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// bucket returns bucket of key refilled at now, new bucket is full. Must be called under mutex.
+func (l *rateLimiter) bucket(key string, limit config.RateLimit, now time.Time) *tokenBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.refill(limit, now)
+	return bucket
+}
+
+// sweep evicts buckets refilled up to burst, such bucket is the same as a new one.
+// Must be called under mutex.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		limit := l.limit(key)
+		bucket.refill(limit, now)
+		if bucket.tokens >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// refill adds tokens accumulated since last refill, up to burst.
+func (b *tokenBucket) refill(limit config.RateLimit, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.RPS)
+	b.last = now
+}
+
+// wait returns time until bucket has n tokens.
+func (b *tokenBucket) wait(limit config.RateLimit, n int) time.Duration {
+	return time.Duration((float64(n) - b.tokens) / limit.RPS * float64(time.Second))
+}
+
+// rateLimitMiddleware rejects requests of clients exceeding their rate limit with 429 and Retry-After header.
+// Limiter is shared by http and websocket handlers, so websocket upgrades take tokens too.
+func (srv *Server) rateLimitMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if srv.rateLimiter == nil {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		client := GetReqCtx(ctx).Client
+		allowed, wait := srv.rateLimiter.allow(client)
+		if !allowed {
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("client", client).
+				Msg("rate limit exceeded")
+			metrics.RateLimitRejected.WithLabelValues(client).Inc()
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONRPCError(ctx, fasthttp.StatusTooManyRequests, jsonRPCLimitExceededCode, "rate limit exceeded")
+			return
+		}
+
+		next(ctx)
+	}
+}
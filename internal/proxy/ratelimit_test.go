@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(config.Clients{
+		RateLimit: config.RateLimit{RPS: 2, Burst: 2},
+		Clients: []config.Client{
+			{Login: "vip", RateLimit: config.RateLimit{RPS: 10, Burst: 5}},
+			{Login: "default"},
+		},
+	})
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+
+	allowN := func(client string, n int) int {
+		allowed := 0
+		for range n {
+			if ok, _ := limiter.allow(client); ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	// burst is available immediately, then client has to wait for refill.
+	require.Equal(t, 2, allowN("default", 10))
+	ok, wait := limiter.allow("default")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+	require.Equal(t, 5, allowN("vip", 10))
+
+	now = now.Add(500 * time.Millisecond)
+	require.Equal(t, 1, allowN("default", 10))
+
+	// buckets refilled up to burst are evicted.
+	now = now.Add(rateLimitSweepInterval)
+	require.Equal(t, 2, allowN("default", 10))
+	require.Len(t, limiter.buckets, 1)
+
+	require.Nil(t, newRateLimiter(config.Clients{Clients: []config.Client{{Login: "default"}}}))
+}
+
+func Test_Server_rateLimitMiddleware(t *testing.T) {
+	srv := &Server{rateLimiter: newRateLimiter(config.Clients{
+		Clients: []config.Client{{Login: "limited", RateLimit: config.RateLimit{RPS: 0.5, Burst: 1}}},
+	})}
+	serve := func(client string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = client })
+		srv.rateLimitMiddleware(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(fasthttp.StatusOK)
+		})(ctx)
+		return ctx
+	}
+
+	require.Equal(t, fasthttp.StatusOK, serve("limited").Response.StatusCode())
+	rejected := testutil.ToFloat64(metrics.RateLimitRejected.WithLabelValues("limited"))
+	ctx := serve("limited")
+	require.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	require.Equal(t, "2", string(ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter)))
+	require.Contains(t, string(ctx.Response.Body()), "rate limit exceeded")
+	require.InDelta(t, rejected+1, testutil.ToFloat64(metrics.RateLimitRejected.WithLabelValues("limited")), 0)
+
+	// clients without limit are not affected.
+	for range 3 {
+		require.Equal(t, fasthttp.StatusOK, serve("other").Response.StatusCode())
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"runtime/debug"
+	"slices"
+
+	"github.com/fasthttp/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// recoverLayer is layer of panics not recovered by any middleware.
+const recoverLayer = "recover"
+
+// layer is named middleware, its panics are recovered and attributed to its name.
+type layer struct {
+	name string
+	wrap func(next fasthttp.RequestHandler) fasthttp.RequestHandler
+}
+
+// rethrownPanic is panic already reported by inner layer, outer layers pass it through as is.
+type rethrownPanic struct {
+	value any
+}
+
+// chain wraps handler into layers, the first layer is the outermost one.
+// Every layer is guarded by recoverMiddleware.
+func (srv *Server) chain(handler fasthttp.RequestHandler, layers ...layer) fasthttp.RequestHandler {
+	for _, l := range slices.Backward(layers) {
+		handler = srv.recoverMiddleware(l.name, l.wrap(handler))
+	}
+	return handler
+}
+
+// recoverMiddleware recovers panic of next, including deferred code of middleware running after its next
+// returned. Request is answered with 500 there, so outer layers log and count it as any failed request.
+func (srv *Server) recoverMiddleware(name string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if rethrown, ok := r.(rethrownPanic); ok {
+				panic(rethrown)
+			}
+			srv.reportPanic(name, ctx.ID(), r)
+			if srv.rethrowPanics {
+				panic(rethrownPanic{value: r})
+			}
+			srv.writeInternalError(ctx)
+		}()
+		next(ctx)
+	}
+}
+
+// recoverHandler is the outermost handler recovering panics not recovered by layers.
+// Panics are rethrown from here with their original value if enabled.
+func (srv *Server) recoverHandler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if rethrown, ok := r.(rethrownPanic); ok {
+				panic(rethrown.value)
+			}
+			srv.reportPanic(recoverLayer, ctx.ID(), r)
+			if srv.rethrowPanics {
+				panic(r)
+			}
+			srv.writeInternalError(ctx)
+		}()
+		next(ctx)
+	}
+}
+
+// writeInternalError answers request with 500 and json-rpc internal error echoing request ids, so clients
+// handle it like any other error. Request is parsed here if panic happened before it was parsed,
+// unparsable one is answered with null id. Panic while writing response is reported and dropped.
+func (srv *Server) writeInternalError(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		if r := recover(); r != nil {
+			srv.reportPanic(recoverLayer, ctx.ID(), r)
+		}
+	}()
+	ctx.Response.Reset()
+	if reqctx := GetReqCtx(ctx); len(reqctx.Request) == 0 && !isEmptyBody(ctx.Request.Body()) {
+		isBatched := isBatch(ctx.Request.Body())
+		if request, err := parseRequests(ctx.Request.Body(), isBatched); err == nil {
+			SetToReqCtx(ctx, func(rc *ReqCtx) {
+				rc.Request = request
+				rc.Batch = isBatched
+			})
+		}
+	}
+	writeJSONRPCError(ctx, fasthttp.StatusInternalServerError, jsonRPCInternalErrorCode, "internal server error")
+}
+
+// recoverWSPanic recovers panic of websocket session, client is disconnected with internal error code.
+func (srv *Server) recoverWSPanic(requestID uint64, conn *websocket.Conn) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	srv.reportPanic("websocket", requestID, r)
+	if srv.rethrowPanics {
+		panic(r)
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal server error"))
+}
+
+// reportPanic logs panic with stack of panicking goroutine and counts it by layer. Stack is captured
+// by recover of the layer, while panicking frames are still on it. Recovered value is logged as text,
+// so errors and other values which have no json fields are readable.
+func (srv *Server) reportPanic(name string, requestID uint64, r any) {
+	metrics.PanicTotal.WithLabelValues(name).Inc()
+	log.Error().
+		Str("layer", name).
+		Uint64("request_id", requestID).
+		Str("panic", fmt.Sprint(r)).
+		Str("stack", string(debug.Stack())).
+		Msg("panic recovered")
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_chain_recover(t *testing.T) {
+	panicBefore := func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(*fasthttp.RequestCtx) { panic("before next") }
+	}
+	panicAfter := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			next(ctx)
+			panic("after next")
+		}
+	}
+	passThrough := func(next fasthttp.RequestHandler) fasthttp.RequestHandler { return next }
+	ok := func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusOK) }
+
+	testCases := []struct {
+		name    string
+		layer   string
+		inner   func(next fasthttp.RequestHandler) fasthttp.RequestHandler
+		handler fasthttp.RequestHandler
+	}{
+		{name: "panic before next", layer: "test_before", inner: panicBefore, handler: ok},
+		{name: "panic after next", layer: "test_after", inner: panicAfter, handler: ok},
+		{
+			name:    "panic at handler",
+			layer:   "test_handler",
+			inner:   passThrough,
+			handler: func(*fasthttp.RequestCtx) { panic("handler") },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &Server{}
+			var outerStatus int
+			outer := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+				return func(ctx *fasthttp.RequestCtx) {
+					next(ctx)
+					outerStatus = ctx.Response.StatusCode()
+				}
+			}
+			panics := testutil.ToFloat64(metrics.PanicTotal.WithLabelValues(tc.layer))
+			outerPanics := testutil.ToFloat64(metrics.PanicTotal.WithLabelValues("test_outer"))
+
+			handler := srv.recoverHandler(srv.chain(srv.recoverMiddleware("test_handler", tc.handler),
+				layer{"test_outer", outer},
+				layer{tc.layer, tc.inner},
+			))
+			var ctx fasthttp.RequestCtx
+			require.NotPanics(t, func() { handler(&ctx) })
+
+			// panic is attributed to its layer and outer layer sees failed request.
+			require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+			require.Equal(t, fasthttp.StatusInternalServerError, outerStatus)
+			require.InDelta(t, panics+1, testutil.ToFloat64(metrics.PanicTotal.WithLabelValues(tc.layer)), 0)
+			require.InDelta(t, outerPanics, testutil.ToFloat64(metrics.PanicTotal.WithLabelValues("test_outer")), 0)
+		})
+	}
+}
+
+func Test_Server_recoverHandler_log(t *testing.T) {
+	buf := captureLogs(t)
+	srv := &Server{}
+	handler := srv.recoverHandler(srv.chain(srv.recoverMiddleware("test_log", panickingHandler)))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, nil, nil)
+	handler(ctx)
+	require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "panic recovered", entry["message"])
+	require.Equal(t, "test_log", entry["layer"])
+	require.InDelta(t, ctx.ID(), entry["request_id"], 0)
+	// error is logged by its text and stack contains frame of panicking function.
+	require.Equal(t, "upstream body is nil", entry["panic"])
+	require.Contains(t, entry["stack"], "proxy.panickingHandler")
+}
+
+func panickingHandler(*fasthttp.RequestCtx) {
+	panic(errors.New("upstream body is nil"))
+}
+
+func Test_Server_recoverHandler_jsonRPCError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		body   string
+		parsed bool
+		want   string
+	}{
+		{
+			name:   "parsed request",
+			body:   `{"jsonrpc":"2.0","id":7,"method":"eth_call"}`,
+			parsed: true,
+			want:   `{"jsonrpc":"2.0","id":7,"error":{"code":-32603,"message":"internal server error"}}`,
+		},
+		{
+			name: "request not parsed yet",
+			body: `[{"jsonrpc":"2.0","id":1,"method":"eth_call"},{"jsonrpc":"2.0","id":"2","method":"eth_chainId"}]`,
+			want: `[{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"internal server error"}},` +
+				`{"jsonrpc":"2.0","id":"2","error":{"code":-32603,"message":"internal server error"}}]`,
+		},
+		{
+			name: "unparsable request",
+			body: `{"jsonrpc":"2.0","id":1,`,
+			want: `{"jsonrpc":"2.0","id":null,"error":{"code":-32603,"message":"internal server error"}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &Server{}
+			handler := srv.recoverHandler(func(ctx *fasthttp.RequestCtx) {
+				if tc.parsed {
+					srv.requestParserMiddleware(func(*fasthttp.RequestCtx) {})(ctx)
+				}
+				// partial response is replaced by error.
+				ctx.Response.SetBodyString("partial response")
+				panic("handler")
+			})
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBodyString(tc.body)
+			require.NotPanics(t, func() { handler(ctx) })
+
+			require.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+			require.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+			require.JSONEq(t, tc.want, string(ctx.Response.Body()))
+		})
+	}
+}
+
+func Test_Server_recoverHandler_rethrow(t *testing.T) {
+	srv := &Server{rethrowPanics: true}
+	panics := testutil.ToFloat64(metrics.PanicTotal.WithLabelValues("test_rethrow"))
+	outerPanics := testutil.ToFloat64(metrics.PanicTotal.WithLabelValues(recoverLayer))
+
+	handler := srv.recoverHandler(srv.chain(func(*fasthttp.RequestCtx) { panic("boom") },
+		layer{"test_rethrow", func(next fasthttp.RequestHandler) fasthttp.RequestHandler { return next }},
+	))
+	require.PanicsWithValue(t, "boom", func() { handler(&fasthttp.RequestCtx{}) })
+
+	// panic is reported once by the layer where it happened.
+	require.InDelta(t, panics+1, testutil.ToFloat64(metrics.PanicTotal.WithLabelValues("test_rethrow")), 0)
+	require.InDelta(t, outerPanics, testutil.ToFloat64(metrics.PanicTotal.WithLabelValues(recoverLayer)), 0)
+}
+
+func Test_Server_wsUpgrader_recover(t *testing.T) {
+	srv := &Server{
+		nameToLBAlgo:  map[string]string{"/ws-panic": config.RRName},
+		nameToChainID: map[string]int64{"/ws-panic": 1},
+		upgrader:      websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(func(*WSContext) { panic("session") })}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	panics := testutil.ToFloat64(metrics.PanicTotal.WithLabelValues("websocket"))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws-panic", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseInternalServerErr))
+	require.InDelta(t, panics+1, testutil.ToFloat64(metrics.PanicTotal.WithLabelValues("websocket")), 0)
+}
@@ -1,6 +1,16 @@
 package proxy
 
-import "github.com/valyala/fasthttp"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+)
 
 // userValueKey is the key used to store ReqCtx inside fasthttp.RequestCtx.
 const userValueKey = "rpcgate.reqctx"
@@ -10,6 +20,7 @@ const userValueKey = "rpcgate.reqctx"
 type ReqCtx struct {
 	Request  []JSONRPCRequest  // json-rpc request from client
 	Response []JSONRPCResponse // json-rpc response from node
+	Batch    bool              // true if client sent batch request
 
 	ConnURL string // provider connection url choiced by balanacer
 
@@ -19,8 +30,15 @@ type ReqCtx struct {
 	RPCName  string // rpc name from config
 	Provider string // provider from config
 
-	Latency       float64 // request latency
-	IsClientError bool    // true if response contains user user
+	Latency         float64       // request latency
+	UpstreamLatency time.Duration // latency of provider response only, zero if provider was not requested
+	IsClientError   bool          // true if response contains user user
+	Deadline        time.Time     // time proxied request must be served by, zero if it is not limited
+	// UpstreamRateLimited is true if provider answered with 429, UpstreamRetryAfter is delay it asked for.
+	UpstreamRateLimited bool
+	UpstreamRetryAfter  time.Duration
+
+	Span trace.Span // root span of request, nil if tracing is disabled
 }
 
 // SetToCtx stores the ReqCtx in the given fasthttp.RequestCtx.
@@ -48,14 +66,23 @@ func GetReqCtx(ctx *fasthttp.RequestCtx) *ReqCtx {
 	return reqctx
 }
 
-// JSONRPCRequest json-rpc request spec struct with method field.
+// JSONRPCRequest json-rpc request spec struct with id, method and params fields.
 type JSONRPCRequest struct {
-	Method string `json:"method"`
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// IsNotification returns true if request has no id, such request is not answered by provider.
+// Request with null id is not a notification.
+func (r JSONRPCRequest) IsNotification() bool {
+	return len(r.ID) == 0
 }
 
 // JSONRPCResponse json-rpc response spec struct with error field.
 type JSONRPCResponse struct {
-	Error JSONRPCError `json:"error"`
+	ID    json.RawMessage `json:"id"`
+	Error JSONRPCError    `json:"error"`
 }
 
 // JSONRPCError json-rpc error spec struct.
@@ -68,3 +95,31 @@ type JSONRPCError struct {
 func (j *JSONRPCResponse) HasError() bool {
 	return j.Error != JSONRPCError{}
 }
+
+// ParamsHash returns hex encoded sha256 hash of methods and params of requests and size of params.
+// Params are compacted before hashing, so hash doesn't depend on whitespaces. Every method and params
+// are prefixed with their length, so boundaries of requests in batch are part of the hash.
+func ParamsHash(requests []JSONRPCRequest) (string, int) {
+	var (
+		size      int
+		compacted bytes.Buffer
+		length    []byte
+	)
+	hash := sha256.New()
+	write := func(b []byte) {
+		length = binary.BigEndian.AppendUint64(length[:0], uint64(len(b)))
+		_, _ = hash.Write(length)
+		_, _ = hash.Write(b)
+	}
+	for _, req := range requests {
+		size += len(req.Params)
+		compacted.Reset()
+		if err := json.Compact(&compacted, req.Params); err != nil {
+			compacted.Reset()
+			compacted.Write(req.Params)
+		}
+		write([]byte(req.Method))
+		write(compacted.Bytes())
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size
+}
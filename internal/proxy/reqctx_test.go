@@ -55,3 +55,22 @@ func Test_ReqCtx(t *testing.T) {
 		require.Equal(t, "test", gotReqCtx.Balancer)
 	})
 }
+
+func Test_ParamsHash(t *testing.T) {
+	hash := func(requests ...proxy.JSONRPCRequest) string {
+		h, _ := proxy.ParamsHash(requests)
+		return h
+	}
+	call := proxy.JSONRPCRequest{Method: "eth_call", Params: []byte(`["0xabc"]`)}
+
+	require.Equal(t, hash(call), hash(proxy.JSONRPCRequest{Method: "eth_call", Params: []byte(`[ "0xabc" ]`)}))
+	require.NotEqual(t, hash(call), hash(proxy.JSONRPCRequest{Method: "eth_estimateGas", Params: call.Params}))
+	// params are not shifted between requests of batch.
+	require.NotEqual(t,
+		hash(proxy.JSONRPCRequest{Method: "a"}, proxy.JSONRPCRequest{Method: "b", Params: []byte(`[1]`)}),
+		hash(proxy.JSONRPCRequest{Method: "a", Params: []byte(`[1]`)}, proxy.JSONRPCRequest{Method: "b"}),
+	)
+
+	_, size := proxy.ParamsHash([]proxy.JSONRPCRequest{call, call})
+	require.Equal(t, 2*len(call.Params), size)
+}
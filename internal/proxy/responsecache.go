@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/cache"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// cacheProvider is provider label of requests answered from response cache.
+const cacheProvider = "cache"
+
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+const newHeadsSubscribeRequest = `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`
+
+// newHeadsRedialInterval is a pause before redialing dropped newHeads subscription.
+const newHeadsRedialInterval = time.Second
+
+// responseCache keeps results of successful responses to cacheable methods.
+//
+// Keys of block dependent methods carry head of rpc, counter bumped on every new block, so new block makes
+// their cached responses unreachable at once. Unreachable entries are evicted as least recently used ones.
+// Response fetched before new block is stored under old head, so it is never served after the block.
+type responseCache struct {
+	lru          *cache.LRU
+	ttl          time.Duration
+	methods      map[string]struct{}
+	blockMethods map[string]struct{}
+	heads        map[string]*atomic.Uint64 // by rpc path
+	newHeadsURLs map[string]string         // by rpc path
+}
+
+// newResponseCache returns nil if response cache is disabled.
+func newResponseCache(cfg config.ResponseCache) *responseCache {
+	if cfg.Size == 0 {
+		return nil
+	}
+	methods := make(map[string]struct{}, len(cfg.Methods))
+	for _, method := range cfg.Methods {
+		methods[method] = struct{}{}
+	}
+	blockMethods := make(map[string]struct{}, len(cfg.NewHeads.Methods))
+	for _, method := range cfg.NewHeads.Methods {
+		blockMethods[method] = struct{}{}
+	}
+	heads := make(map[string]*atomic.Uint64, len(cfg.NewHeads.URLs))
+	newHeadsURLs := make(map[string]string, len(cfg.NewHeads.URLs))
+	for name, url := range cfg.NewHeads.URLs {
+		heads["/"+name] = new(atomic.Uint64)
+		newHeadsURLs["/"+name] = url
+	}
+	return &responseCache{
+		lru:          cache.New(cfg.Size, cfg.Shards),
+		ttl:          cfg.TTL,
+		methods:      methods,
+		blockMethods: blockMethods,
+		heads:        heads,
+		newHeadsURLs: newHeadsURLs,
+	}
+}
+
+// key returns cache key of request to rpc, requests differing only in id share the key.
+func (c *responseCache) key(rpcPath string, req JSONRPCRequest) string {
+	key := responseCacheKey(rpcPath, req)
+	head, followed := c.heads[rpcPath]
+	if _, blockDependent := c.blockMethods[req.Method]; followed && blockDependent {
+		key += "\x00" + strconv.FormatUint(head.Load(), 10)
+	}
+	return key
+}
+
+// invalidate drops cached responses to block dependent methods of rpc.
+func (c *responseCache) invalidate(rpcPath string) {
+	if head, ok := c.heads[rpcPath]; ok {
+		head.Add(1)
+	}
+}
+
+// responseCacheKey returns cache key of request to rpc, requests differing only in id share the key.
+func responseCacheKey(rpcPath string, req JSONRPCRequest) string {
+	paramsHash, _ := ParamsHash([]JSONRPCRequest{req})
+	return strings.Join([]string{rpcPath, req.Method, paramsHash}, "\x00")
+}
+
+// jsonRPCResultResponse json-rpc response spec struct with result field.
+type jsonRPCResultResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// responseCacheMiddleware answers single requests of cacheable methods from cache.
+// Only non-null results of successful responses are cached, cached result is sent with id of request.
+func (srv *Server) responseCacheMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if srv.responseCache == nil {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reqctx := GetReqCtx(ctx)
+		if reqctx.Batch || len(reqctx.Request) != 1 {
+			next(ctx)
+			return
+		}
+		req := reqctx.Request[0]
+		if _, cacheable := srv.responseCache.methods[req.Method]; !cacheable {
+			next(ctx)
+			return
+		}
+
+		rpcPath := string(ctx.Path())
+		key := srv.responseCache.key(rpcPath, req)
+		if result, ok := srv.responseCache.lru.Get(key); ok {
+			metrics.ResponseCacheRequests.WithLabelValues(reqctx.RPCName, req.Method, cacheHit).Inc()
+			srv.writeCachedResponse(ctx, req.ID, result)
+			return
+		}
+		metrics.ResponseCacheRequests.WithLabelValues(reqctx.RPCName, req.Method, cacheMiss).Inc()
+
+		next(ctx)
+
+		reqctx = GetReqCtx(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK ||
+			len(reqctx.Response) != 1 || reqctx.Response[0].HasError() {
+			return
+		}
+		var resp jsonRPCResultResponse
+		if err := json.Unmarshal(ctx.Response.Body(), &resp); err != nil || isNullResult(resp.Result) {
+			return
+		}
+		srv.responseCache.lru.Set(key, resp.Result, srv.responseCache.ttl)
+	}
+}
+
+// isNullResult returns true if result is missing or null, such results may change soon and are not cached.
+func isNullResult(result json.RawMessage) bool {
+	return len(result) == 0 || string(result) == "null"
+}
+
+func (srv *Server) writeCachedResponse(ctx *fasthttp.RequestCtx, id, result json.RawMessage) {
+	raw, err := json.Marshal(jsonRPCResultResponse{JSONRPC: jsonRPCVersion, ID: id, Result: result})
+	if err != nil {
+		log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal cached response")
+		ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+		return
+	}
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.Provider = cacheProvider
+		rc.Response = []JSONRPCResponse{{}}
+	})
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(raw)
+}
+
+// followNewHeads invalidates block dependent cached responses of rpc on every new block reported by newHeads
+// subscription of websocket provider at url, until done is closed. Dropped subscription is redialed,
+// cached responses are invalidated on every subscription as blocks may be missed meanwhile.
+func (srv *Server) followNewHeads(rpcPath, url string, done <-chan struct{}) {
+	for {
+		err := srv.watchNewHeads(rpcPath, url, done)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		log.Warn().Err(err).Str("rpc", strings.TrimPrefix(rpcPath, "/")).
+			Msg("newHeads subscription of response cache dropped, redialing")
+
+		select {
+		case <-done:
+			return
+		case <-time.After(newHeadsRedialInterval):
+		}
+	}
+}
+
+// watchNewHeads subscribes to newHeads and invalidates cached responses of rpc until subscription is dropped.
+func (srv *Server) watchNewHeads(rpcPath, url string, done <-chan struct{}) error {
+	conn, err := srv.initWSConnWithProvider(url, nil)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		_ = conn.Close()
+	}()
+
+	if err = conn.WriteMessage(websocket.TextMessage, []byte(newHeadsSubscribeRequest)); err != nil {
+		return fmt.Errorf("can not subscribe to newHeads: %w", err)
+	}
+	srv.responseCache.invalidate(rpcPath)
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("can not read newHeads: %w", err)
+		}
+		var msg struct {
+			Method string        `json:"method"`
+			Error  *JSONRPCError `json:"error"`
+		}
+		if err = json.Unmarshal(raw, &msg); err != nil {
+			return fmt.Errorf("can not unmarshal newHeads message: %w", err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("provider rejected newHeads subscription: %s", msg.Error.Message)
+		}
+		if msg.Method == ethSubscription {
+			srv.responseCache.invalidate(rpcPath)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_responseCacheMiddleware(t *testing.T) {
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		result := `"0x1"`
+		if req.Method == "eth_getTransactionReceipt" {
+			result = "null"
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":` + result + `}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:           &fasthttp.Client{},
+		nameToChainID: map[string]int64{"/test": 1},
+		nameToLBAlgo:  map[string]string{"/test": config.RRName},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/test": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+		responseCache: newResponseCache(config.ResponseCache{
+			Size:    10,
+			Shards:  2,
+			TTL:     time.Minute,
+			Methods: []string{"eth_chainId", "eth_getTransactionReceipt"},
+		}),
+	}
+	handler := srv.routerHandler(srv.requestParserMiddleware(srv.responseCacheMiddleware(
+		srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))))
+	request := func(body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/test")
+		ctx.Request.SetBodyString(body)
+		handler(ctx)
+		return ctx
+	}
+
+	ctx := request(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, string(ctx.Response.Body()))
+	// cached result is answered with id of request.
+	ctx = request(`{"jsonrpc":"2.0","id":"abc","method":"eth_chainId","params":[]}`)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":"abc","result":"0x1"}`, string(ctx.Response.Body()))
+	require.Equal(t, cacheProvider, GetReqCtx(ctx).Provider)
+	require.Equal(t, int64(1), calls.Load())
+
+	// null results and methods not listed are not cached.
+	request(`{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionReceipt","params":["0x1"]}`)
+	request(`{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionReceipt","params":["0x1"]}`)
+	request(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	request(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	require.Equal(t, int64(5), calls.Load())
+}
+
+func Test_Server_responseCacheMiddleware_newHeads(t *testing.T) {
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+	wsUpstream := &fakeWSUpstream{}
+	wsServer := httptest.NewServer(wsUpstream)
+	defer wsServer.Close()
+
+	srv := &Server{
+		cli:           &fasthttp.Client{},
+		nameToChainID: map[string]int64{"/test": 1},
+		nameToLBAlgo:  map[string]string{"/test": config.RRName},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/test": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+		responseCache: newResponseCache(config.ResponseCache{
+			Size:    10,
+			Shards:  2,
+			TTL:     time.Minute,
+			Methods: []string{"eth_chainId", "eth_blockNumber"},
+			NewHeads: config.ResponseCacheNewHeads{
+				URLs:    map[string]string{"test": "ws://" + strings.TrimPrefix(wsServer.URL, "http://")},
+				Methods: []string{"eth_blockNumber"},
+			},
+		}),
+	}
+	handler := srv.routerHandler(srv.requestParserMiddleware(srv.responseCacheMiddleware(
+		srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))))
+	request := func(method string) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/test")
+		ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`)
+		handler(ctx)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+	head := srv.responseCache.heads["/test"]
+
+	done := make(chan struct{})
+	defer close(done)
+	go srv.followNewHeads("/test", srv.responseCache.newHeadsURLs["/test"], done)
+	require.Eventually(t, func() bool {
+		return wsUpstream.received(ethSubscribe) == 1 && head.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	request("eth_blockNumber")
+	request("eth_blockNumber")
+	request("eth_chainId")
+	request("eth_chainId")
+	require.Equal(t, int64(2), calls.Load())
+
+	// new block invalidates only block dependent responses.
+	wsUpstream.notify(t, "0x1")
+	require.Eventually(t, func() bool { return head.Load() == 2 }, time.Second, 10*time.Millisecond)
+	request("eth_blockNumber")
+	request("eth_chainId")
+	require.Equal(t, int64(3), calls.Load())
+	request("eth_blockNumber")
+	require.Equal(t, int64(3), calls.Load())
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import "github.com/BinaryArchaism/rpcgate/internal/config"
+
+// retryPolicy decides whether failed request can be retried on another provider.
+type retryPolicy struct {
+	attempts int
+	safe     map[string]struct{}
+	unsafe   map[string]struct{}
+}
+
+func newRetryPolicy(cfg config.Retry) retryPolicy {
+	policy := retryPolicy{
+		attempts: cfg.Attempts,
+		safe:     make(map[string]struct{}, len(cfg.SafeMethods)),
+		unsafe:   make(map[string]struct{}, len(cfg.UnsafeMethods)),
+	}
+	for _, method := range cfg.SafeMethods {
+		policy.safe[method] = struct{}{}
+	}
+	for _, method := range cfg.UnsafeMethods {
+		policy.unsafe[method] = struct{}{}
+	}
+	return policy
+}
+
+// isRetrySafe returns true if every request is safe to be sent again.
+func (p retryPolicy) isRetrySafe(requests []JSONRPCRequest) bool {
+	if len(requests) == 0 {
+		return false
+	}
+	for _, req := range requests {
+		if !p.isMethodRetrySafe(req.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+// isMethodRetrySafe checks configured safe and unsafe methods first, then falls back to defaults.
+// Methods unknown to rpcgate may have side effects, so they are not retried unless configured safe.
+func (p retryPolicy) isMethodRetrySafe(method string) bool {
+	if _, ok := p.safe[method]; ok {
+		return true
+	}
+	if _, ok := p.unsafe[method]; ok {
+		return false
+	}
+	return isDefaultRetrySafe(method)
+}
+
+// isDefaultRetrySafe returns true for read-only methods,
+// which have no side effects to be applied twice if retried.
+func isDefaultRetrySafe(method string) bool {
+	switch method {
+	case "eth_blockNumber",
+		"eth_chainId",
+		"eth_syncing",
+		"eth_call",
+		"eth_estimateGas",
+		"eth_createAccessList",
+		"eth_gasPrice",
+		"eth_maxPriorityFeePerGas",
+		"eth_blobBaseFee",
+		"eth_feeHistory",
+		"eth_getBalance",
+		"eth_getCode",
+		"eth_getStorageAt",
+		"eth_getProof",
+		"eth_getTransactionCount",
+		"eth_getBlockByNumber",
+		"eth_getBlockByHash",
+		"eth_getBlockReceipts",
+		"eth_getBlockTransactionCountByNumber",
+		"eth_getBlockTransactionCountByHash",
+		"eth_getTransactionByHash",
+		"eth_getTransactionByBlockNumberAndIndex",
+		"eth_getTransactionByBlockHashAndIndex",
+		"eth_getTransactionReceipt",
+		"eth_getUncleCountByBlockNumber",
+		"eth_getUncleCountByBlockHash",
+		"eth_getLogs",
+		"net_version",
+		"net_listening",
+		"net_peerCount",
+		"web3_clientVersion",
+		"web3_sha3":
+		return true
+	}
+	return false
+}
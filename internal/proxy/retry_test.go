@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_loadBalancerMiddleware_retry(t *testing.T) {
+	var failingHits, healthyHits atomic.Int64
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		failingHits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		healthyHits.Add(1)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer healthy.Close()
+
+	testCases := []struct {
+		name        string
+		method      string
+		retry       config.Retry
+		status      int
+		healthyHits int64
+	}{
+		{
+			name:        "safe method retried",
+			method:      "eth_getBalance",
+			retry:       config.Retry{Attempts: 1},
+			status:      fasthttp.StatusOK,
+			healthyHits: 1,
+		},
+		{
+			name:        "unsafe method not retried",
+			method:      "eth_sendRawTransaction",
+			retry:       config.Retry{Attempts: 1},
+			status:      fasthttp.StatusBadGateway,
+			healthyHits: 0,
+		},
+		{
+			name:        "unlisted method not retried",
+			method:      "eth_sendUserOperation",
+			retry:       config.Retry{Attempts: 1},
+			status:      fasthttp.StatusBadGateway,
+			healthyHits: 0,
+		},
+		{
+			name:        "configured unsafe method not retried",
+			method:      "eth_getBalance",
+			retry:       config.Retry{Attempts: 1, UnsafeMethods: []string{"eth_getBalance"}},
+			status:      fasthttp.StatusBadGateway,
+			healthyHits: 0,
+		},
+		{
+			name:        "configured safe method retried",
+			method:      "getAccountInfo",
+			retry:       config.Retry{Attempts: 1, SafeMethods: []string{"getAccountInfo"}},
+			status:      fasthttp.StatusOK,
+			healthyHits: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			failingHits.Store(0)
+			healthyHits.Store(0)
+
+			srv := &Server{
+				cli:          &fasthttp.Client{},
+				nameToLBAlgo: map[string]string{"/test": config.RRName},
+				chainToRR: map[string]*balancer.RoundRobin{
+					"/test": balancer.NewRoundRobin([]balancer.Payload{
+						{Name: "failing", URL: failing.URL},
+						{Name: "healthy", URL: healthy.URL},
+					}),
+				},
+				nameToRetry: map[string]retryPolicy{"/test": newRetryPolicy(tc.retry)},
+			}
+			handler := srv.requestParserMiddleware(
+				srv.loadBalancerMiddleware(srv.responseParserMiddleware(srv.handler)))
+
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI("/test")
+			ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"` + tc.method + `","params":[]}`))
+			handler(ctx)
+
+			require.Equal(t, tc.status, ctx.Response.StatusCode())
+			require.Equal(t, int64(1), failingHits.Load())
+			require.Equal(t, tc.healthyHits, healthyHits.Load())
+		})
+	}
+}
+
+func Test_retryPolicy_isRetrySafe(t *testing.T) {
+	policy := newRetryPolicy(config.Retry{Attempts: 1})
+	require.True(t, policy.isRetrySafe([]JSONRPCRequest{{Method: "eth_call"}, {Method: "eth_getBalance"}}))
+	require.False(t, policy.isRetrySafe([]JSONRPCRequest{{Method: "eth_call"}, {Method: "eth_sendRawTransaction"}}))
+	require.False(t, policy.isRetrySafe([]JSONRPCRequest{{Method: "eth_call"}, {Method: "sendTransaction"}}))
+	require.False(t, policy.isRetrySafe(nil))
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// parseRetryAfter returns delay requested by provider in Retry-After header as seconds or http date,
+// zero if header is missing, malformed or in the past.
+func parseRetryAfter(value []byte, now time.Time) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.ParseUint(string(value), 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := fasthttp.ParseHTTPDate(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.Equal(t, 30*time.Second, parseRetryAfter([]byte("30"), now))
+	require.Equal(t, 90*time.Second, parseRetryAfter([]byte("Thu, 02 Jan 2025 03:05:35 GMT"), now))
+	require.Zero(t, parseRetryAfter(nil, now))
+	require.Zero(t, parseRetryAfter([]byte("-5"), now))
+	require.Zero(t, parseRetryAfter([]byte("soon"), now))
+	require.Zero(t, parseRetryAfter([]byte("Thu, 02 Jan 2025 03:00:00 GMT"), now))
+}
+
+func Test_Server_proxyToProvider_rateLimited(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"rate limited"}}`))
+	}))
+	defer upstream.Close()
+
+	srv := New(config.Config{RPCs: []config.RPC{{
+		Name: "mainnet",
+		GlobalRPCConfig: config.GlobalRPCConfig{
+			BalancerType: config.P2CEWMAName,
+			P2CEWMA:      config.P2CEWMAConfig{CooldownTimeout: 5 * time.Second},
+		},
+		Providers: []config.Provider{{Name: "node", ConnURL: upstream.URL}},
+	}}})
+	lb, _ := srv.getBalancer("/mainnet")
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/mainnet")
+	ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`)
+	SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = "mainnet" })
+	srv.proxyToProvider(ctx, lb, config.P2CEWMAName, srv.handler)
+	require.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+
+	// cooldown lasts as long as provider asked, not generic 5s, and penalty is heavier than for failure.
+	p2c, ok := lb.(*balancer.P2CEWMA)
+	require.True(t, ok)
+	stats := p2c.Stats()[0]
+	require.False(t, stats.Healthy)
+	require.Greater(t, stats.CooldownRemaining, 29*time.Second)
+	require.LessOrEqual(t, stats.CooldownRemaining, 30*time.Second)
+	require.InDelta(t, 1, stats.Penalty, 0)
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// newClientToRPCs returns rpc names allowed for every client with allowed rpcs configured.
+func newClientToRPCs(cfg config.Clients) map[string]map[string]struct{} {
+	clientToRPCs := make(map[string]map[string]struct{})
+	for _, client := range cfg.Clients {
+		if len(client.AllowedRPCs) == 0 {
+			continue
+		}
+		rpcs := make(map[string]struct{}, len(client.AllowedRPCs))
+		for _, name := range client.AllowedRPCs {
+			rpcs[name] = struct{}{}
+		}
+		clientToRPCs[client.Login] = rpcs
+	}
+
+	return clientToRPCs
+}
+
+// rpcAccessMiddleware rejects requests of clients to rpcs they are not allowed to use.
+// Clients without allowed rpcs configured can use any rpc.
+func (srv *Server) rpcAccessMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if len(srv.clientToRPCs) == 0 {
+		return next
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		reqctx := GetReqCtx(ctx)
+		rpcs, restricted := srv.clientToRPCs[reqctx.Client]
+		if _, allowed := rpcs[reqctx.RPCName]; restricted && !allowed {
+			log.Info().
+				Uint64("request_id", ctx.ID()).
+				Str("client", reqctx.Client).
+				Str("rpc_name", reqctx.RPCName).
+				Msg("rpc is not allowed for client")
+			ctx.Error("forbidden", fasthttp.StatusForbidden)
+			return
+		}
+
+		next(ctx)
+	}
+}
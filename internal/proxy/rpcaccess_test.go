@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_rpcAccessMiddleware(t *testing.T) {
+	srv := &Server{
+		nameToChainID: map[string]int64{"/mainnet": 1, "/polygon": 137},
+		clientToRPCs: newClientToRPCs(config.Clients{Clients: []config.Client{
+			{Login: "indexer", AllowedRPCs: []string{"mainnet"}},
+			{Login: "admin"},
+		}}),
+	}
+	testCases := []struct {
+		name   string
+		client string
+		path   string
+		status int
+	}{
+		{name: "allowed rpc", client: "indexer", path: "/mainnet", status: fasthttp.StatusOK},
+		{name: "forbidden rpc", client: "indexer", path: "/polygon", status: fasthttp.StatusForbidden},
+		{name: "client without restrictions", client: "admin", path: "/polygon", status: fasthttp.StatusOK},
+		{name: "unknown client", client: "_unknown_", path: "/polygon", status: fasthttp.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var served bool
+			handler := srv.routerHandler(srv.rpcAccessMiddleware(func(*fasthttp.RequestCtx) {
+				served = true
+			}))
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetRequestURI(tc.path)
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = tc.client })
+			handler(ctx)
+
+			require.Equal(t, tc.status, ctx.Response.StatusCode())
+			require.Equal(t, tc.status == fasthttp.StatusOK, served)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// rpcServer serves rpc on its own port, started and stopped with proxy server.
+type rpcServer struct {
+	port int64
+	srv  *fasthttp.Server
+}
+
+// newRPCServers returns servers of rpcs with own port, they pass requests to handler of proxy server.
+func (srv *Server) newRPCServers(rpcs []config.RPC, handler fasthttp.RequestHandler) []rpcServer {
+	var servers []rpcServer
+	for _, rpc := range rpcs {
+		if rpc.Port == 0 {
+			continue
+		}
+		s := &fasthttp.Server{
+			Handler: rpcPortHandler(rpc.Name, handler),
+		}
+		if srv.wsHandshakeTimeout > 0 {
+			s.ConnState = handshakeConnState(srv.wsHandshakeTimeout)
+		}
+		servers = append(servers, rpcServer{port: rpc.Port, srv: s})
+	}
+	return servers
+}
+
+// rpcPortHandler routes every request to rpc by rewriting its path, so request on port of rpc
+// is served like request on path of rpc. Port routing takes precedence over path: /healthz
+// and paths of other rpcs are routed to rpc too.
+func rpcPortHandler(rpcName string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	path := "/" + rpcName
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.URI().SetPath(path)
+		next(ctx)
+	}
+}
+
+// serveRPCPorts starts servers of rpcs with own port, they use tls config of proxy server.
+// Http2 is served only on server port.
+func (srv *Server) serveRPCPorts(ctx context.Context) {
+	for _, s := range srv.rpcSrvs {
+		s.srv.TLSConfig = srv.srv.TLSConfig
+		go func() {
+			var err error
+			if s.srv.TLSConfig != nil {
+				err = s.srv.ListenAndServeTLS(fmt.Sprintf(":%d", s.port), "", "")
+			} else {
+				err = s.srv.ListenAndServe(fmt.Sprintf(":%d", s.port))
+			}
+			if err != nil {
+				log.Ctx(ctx).Panic().Err(err).Int64("port", s.port).Msg("Proxy server failed to start on rpc port")
+			}
+		}()
+	}
+}
+
+// shutdownRPCPorts stops servers of rpcs with own port concurrently, first error is returned.
+func (srv *Server) shutdownRPCPorts(ctx context.Context) error {
+	errs := make(chan error, len(srv.rpcSrvs))
+	for _, s := range srv.rpcSrvs {
+		go func() { errs <- s.srv.ShutdownWithContext(ctx) }()
+	}
+	var err error
+	for range srv.rpcSrvs {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
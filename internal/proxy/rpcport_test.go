@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_New_rpcPort(t *testing.T) {
+	newNode := func(result string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + result + `"}`))
+		}))
+	}
+	mainnet, base := newNode("0x1"), newNode("0x2105")
+	defer mainnet.Close()
+	defer base.Close()
+
+	srv := New(config.Config{
+		RPCs: []config.RPC{
+			{
+				Name:            "mainnet",
+				GlobalRPCConfig: config.GlobalRPCConfig{BalancerType: config.RRName},
+				Providers:       []config.Provider{{Name: "node", ConnURL: mainnet.URL}},
+			},
+			{
+				Name:            "base",
+				GlobalRPCConfig: config.GlobalRPCConfig{BalancerType: config.RRName},
+				Port:            8546,
+				Providers:       []config.Provider{{Name: "node", ConnURL: base.URL}},
+			},
+		},
+	})
+	require.Len(t, srv.rpcSrvs, 1)
+	require.Equal(t, int64(8546), srv.rpcSrvs[0].port)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.rpcSrvs[0].srv.Serve(ln) }()
+	defer func() { _ = srv.rpcSrvs[0].srv.Shutdown() }()
+
+	// every path on port of rpc is routed to it, including path of other rpc and healthz.
+	for _, path := range []string{"/", "/base", "/mainnet", "/healthz", "/any/path?x=1"} {
+		resp, err := http.Post("http://"+ln.Addr().String()+path, "application/json", //nolint:noctx // test request
+			strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x2105"}`, string(body), path)
+	}
+}
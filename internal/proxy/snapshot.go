@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+)
+
+// balancerSnapshot is file of provider stats learned by p2cewma balancers keyed by rpc name.
+type balancerSnapshot struct {
+	RPCs map[string]balancer.P2CEWMASnapshot `json:"rpcs"`
+}
+
+// restoreBalancers restores p2cewma balancers from snapshot file. Missing file is skipped,
+// so the first start is cold, and unreadable one is logged and skipped too.
+func (srv *Server) restoreBalancers() {
+	raw, err := os.ReadFile(srv.snapshotCfg.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var snapshot balancerSnapshot
+	if err == nil {
+		err = json.Unmarshal(raw, &snapshot)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("path", srv.snapshotCfg.Path).Msg("can not restore balancer snapshot")
+		return
+	}
+	for name, s := range snapshot.RPCs {
+		if lb, ok := srv.chainToP2CEWMA["/"+name]; ok {
+			lb.Restore(s, srv.snapshotCfg.MaxAge)
+		}
+	}
+	log.Info().Str("path", srv.snapshotCfg.Path).Msg("balancer snapshot restored")
+}
+
+// saveBalancers writes snapshot of p2cewma balancers to file. Snapshot is written to temporary file
+// renamed over the previous one, so snapshot interrupted by crash does not corrupt it.
+func (srv *Server) saveBalancers() error {
+	snapshot := balancerSnapshot{RPCs: make(map[string]balancer.P2CEWMASnapshot, len(srv.chainToP2CEWMA))}
+	for key, lb := range srv.chainToP2CEWMA {
+		snapshot.RPCs[strings.TrimPrefix(key, "/")] = lb.Snapshot()
+	}
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	path := srv.snapshotCfg.Path
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// temporary file is already renamed on success.
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// runBalancerSnapshots saves snapshot of balancers every interval until done is closed.
+func (srv *Server) runBalancerSnapshots(done <-chan struct{}) {
+	ticker := time.NewTicker(srv.snapshotCfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := srv.saveBalancers(); err != nil {
+				log.Error().Err(err).Str("path", srv.snapshotCfg.Path).Msg("can not save balancer snapshot")
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_saveBalancers(t *testing.T) {
+	cfg := config.Config{
+		BalancerSnapshot: config.BalancerSnapshot{
+			Path:     filepath.Join(t.TempDir(), "balancers.json"),
+			Interval: time.Minute,
+			MaxAge:   time.Minute,
+		},
+		RPCs: []config.RPC{{
+			Name: "mainnet",
+			GlobalRPCConfig: config.GlobalRPCConfig{
+				BalancerType: config.P2CEWMAName,
+				P2CEWMA:      config.P2CEWMAConfig{Smooth: 0.3, LoadNormalizer: 8, PenaltyDecay: 0.8},
+			},
+			Providers: []config.Provider{{Name: "node", ConnURL: "http://node"}},
+		}},
+	}
+
+	// the first start is cold.
+	srv := New(cfg)
+	require.Zero(t, srv.chainToP2CEWMA["/mainnet"].Stats()[0].EWMAMS)
+	_, release := srv.chainToP2CEWMA["/mainnet"].Borrow()
+	release(true, 40*time.Millisecond)
+	require.NoError(t, srv.saveBalancers())
+
+	restored := New(cfg)
+	require.Equal(t, srv.chainToP2CEWMA["/mainnet"].Stats(), restored.chainToP2CEWMA["/mainnet"].Stats())
+
+	// unreadable snapshot is skipped.
+	require.NoError(t, os.WriteFile(cfg.BalancerSnapshot.Path, []byte("{"), 0o600))
+	restored = New(cfg)
+	require.Zero(t, restored.chainToP2CEWMA["/mainnet"].Stats()[0].EWMAMS)
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valyala/fasthttp"
+)
+
+// upstreamBodyStream is body of provider response streamed to client, provider response is released
+// once the body is written or client is gone, so connection to provider is kept until then.
+type upstreamBodyStream struct {
+	io.Reader
+
+	resp *fasthttp.Response
+	// onClose is called when body is closed, failed is true if body could not be read from provider.
+	onClose func(failed bool)
+	failed  bool
+	read    int
+}
+
+func (s *upstreamBodyStream) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	s.read += n
+	// body of known size ends with plain EOF when provider drops connection in the middle of it.
+	truncated := errors.Is(err, io.EOF) && s.read < s.resp.Header.ContentLength()
+	if err != nil && (!errors.Is(err, io.EOF) || truncated) {
+		s.failed = true
+	}
+	return n, err
+}
+
+func (s *upstreamBodyStream) Close() error {
+	if s.onClose != nil {
+		s.onClose(s.failed)
+	}
+	fasthttp.ReleaseResponse(s.resp)
+	return nil
+}
+
+// streamResponse streams body of provider response larger than stream threshold of rpc to client
+// without buffering it, head of body up to threshold is read to tell its size. Smaller body is set to resp
+// and false is returned. Streamed resp is owned by client response and must not be released by caller.
+// Compressed body is never streamed, it is decoded in memory anyway.
+func (srv *Server) streamResponse(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) (bool, error) {
+	reqctx := GetReqCtx(ctx)
+	threshold := srv.streamThreshold(reqctx.RPCName, reqctx.Provider)
+	stream := resp.BodyStream()
+	if threshold == 0 || stream == nil || len(resp.Header.ContentEncoding()) > 0 {
+		return false, nil
+	}
+	head, err := io.ReadAll(io.LimitReader(stream, int64(threshold)+1))
+	if err != nil {
+		return false, fmt.Errorf("can not read response body: %w", err)
+	}
+	if len(head) <= threshold {
+		resp.SetBody(head)
+		return false, nil
+	}
+
+	size := resp.Header.ContentLength()
+	if size < 0 {
+		// body of unknown size is sent chunked.
+		size = -1
+	}
+	ctx.Response.SetStatusCode(resp.StatusCode())
+	srv.upstreamHeaders.copy(&ctx.Response.Header, &resp.Header)
+	ctx.Response.SetBodyStream(&upstreamBodyStream{
+		Reader: io.MultiReader(bytes.NewReader(head), stream),
+		resp:   resp,
+	}, size)
+	return true, nil
+}
+
+// responseSize returns size of response body. Streamed body is not read, its size is content length,
+// -1 if it is unknown.
+func responseSize(ctx *fasthttp.RequestCtx) int {
+	if ctx.Response.IsBodyStream() {
+		return ctx.Response.Header.ContentLength()
+	}
+	return len(ctx.Response.Body())
+}
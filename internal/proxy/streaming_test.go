@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// largeResponse returns json-rpc response with result of size bytes.
+func largeResponse(size int) string {
+	return `{"jsonrpc":"2.0","id":1,"result":"` + strings.Repeat("a", size) + `"}`
+}
+
+func newStreamingServer(threshold int) *Server {
+	srv := &Server{
+		cli:              &fasthttp.Client{},
+		nameToHTTPClient: map[string]*httpClient{},
+	}
+	if threshold > 0 {
+		srv.nameToHTTPClient["/mainnet"] = newHTTPClient(config.HTTPClient{StreamResponseBytes: threshold}, nil)
+	}
+	return srv
+}
+
+func serveStreaming(srv *Server, url string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"}`)
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.ConnURL = url
+		rc.RPCName = "mainnet"
+		rc.Provider = "node"
+	})
+	srv.handler(ctx)
+	return ctx
+}
+
+func Test_Server_handler_streamResponse(t *testing.T) {
+	large, small := largeResponse(1000), largeResponse(10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/small":
+			_, _ = w.Write([]byte(small))
+		case "/chunked":
+			// flushed body has no content length.
+			_, _ = w.Write([]byte(large[:500]))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte(large[500:]))
+		default:
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+			_, _ = w.Write([]byte(large))
+		}
+	}))
+	defer upstream.Close()
+
+	testCases := []struct {
+		name      string
+		path      string
+		threshold int
+		want      string
+		streamed  bool
+		wantSize  int
+	}{
+		{name: "large response", path: "/large", threshold: 100, want: large, streamed: true, wantSize: len(large)},
+		{name: "chunked response", path: "/chunked", threshold: 100, want: large, streamed: true, wantSize: -1},
+		{name: "small response", path: "/small", threshold: 100, want: small, wantSize: len(small)},
+		{name: "streaming disabled", path: "/large", want: large, wantSize: len(large)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := serveStreaming(newStreamingServer(tc.threshold), upstream.URL+tc.path)
+
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+			require.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+			require.Equal(t, tc.streamed, ctx.Response.IsBodyStream())
+			require.Equal(t, tc.wantSize, responseSize(ctx))
+			var body bytes.Buffer
+			require.NoError(t, ctx.Response.BodyWriteTo(&body))
+			require.Equal(t, tc.want, body.String())
+		})
+	}
+}
+
+func Test_Server_proxyToProvider_streamedResponse(t *testing.T) {
+	srv := &Server{}
+	lb := &countingBalancer{}
+	ctx := &fasthttp.RequestCtx{}
+	srv.proxyToProvider(ctx, lb, config.RRName, srv.responseParserMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.SetBodyStream(strings.NewReader(largeResponse(100)), -1)
+	}))
+
+	// streamed response is not parsed, so provider is judged by status only.
+	require.Zero(t, lb.failed.Load())
+	require.Empty(t, GetReqCtx(ctx).Response)
+	require.True(t, ctx.Response.IsBodyStream())
+}
+
+func Test_Server_proxyToProvider_releaseStreamedProvider(t *testing.T) {
+	large := largeResponse(1000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		if r.URL.Path == "/truncated" {
+			// provider drops connection in the middle of body.
+			_, _ = w.Write([]byte(large[:500]))
+			return
+		}
+		_, _ = w.Write([]byte(large))
+	}))
+	defer upstream.Close()
+
+	for _, tc := range []struct {
+		path   string
+		failed int64
+	}{
+		{path: "/complete"},
+		{path: "/truncated", failed: 1},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			srv := newStreamingServer(100)
+			lb := &countingBalancer{}
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.SetBodyString(`{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"}`)
+			SetToReqCtx(ctx, func(rc *ReqCtx) { rc.RPCName = "mainnet" })
+			srv.proxyToProvider(ctx, lb, config.RRName, srv.responseParserMiddleware(func(ctx *fasthttp.RequestCtx) {
+				SetToReqCtx(ctx, func(rc *ReqCtx) { rc.ConnURL = upstream.URL + tc.path })
+				srv.handler(ctx)
+			}))
+			require.True(t, ctx.Response.IsBodyStream())
+
+			// provider is busy until streamed body is sent.
+			require.Equal(t, int64(1), lb.inFlight.Load())
+			_ = ctx.Response.BodyWriteTo(io.Discard)
+			require.Zero(t, lb.inFlight.Load())
+			require.Equal(t, tc.failed, lb.failed.Load())
+		})
+	}
+}
+
+func Benchmark_Server_handler_largeResponse(b *testing.B) {
+	const size = 50 << 20
+
+	body := []byte(largeResponse(size))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	for _, bc := range []struct {
+		name      string
+		threshold int
+	}{
+		{name: "buffered"},
+		{name: "streamed", threshold: 1 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			srv := newStreamingServer(bc.threshold)
+			b.ReportAllocs()
+			for b.Loop() {
+				ctx := serveStreaming(srv, upstream.URL)
+				if err := ctx.Response.BodyWriteTo(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+				ctx.Response.Reset()
+			}
+		})
+	}
+}
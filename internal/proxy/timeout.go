@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// adaptiveTimeout is request timeout of provider adjusted by its recent timeouts.
+type adaptiveTimeout struct {
+	cfg config.ProviderTimeout
+
+	mutex    sync.Mutex
+	current  time.Duration
+	timeouts int // consecutive timed out requests since last adjustment
+}
+
+func newAdaptiveTimeout(cfg config.ProviderTimeout) *adaptiveTimeout {
+	return &adaptiveTimeout{cfg: cfg, current: cfg.Initial}
+}
+
+// get returns current timeout of provider.
+func (t *adaptiveTimeout) get() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.current
+}
+
+// observe adjusts timeout by result of request and returns timeout before and after adjustment.
+// Timeout is moved toward limit after threshold of consecutive timed out requests
+// and one step back to initial after request answered in time.
+func (t *adaptiveTimeout) observe(timedOut bool) (time.Duration, time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	prev := t.current
+	if !timedOut {
+		t.timeouts = 0
+		t.current = stepTimeout(t.current, 1/t.cfg.Factor, t.cfg.Initial)
+		return prev, t.current
+	}
+
+	t.timeouts++
+	if t.timeouts >= t.cfg.Threshold {
+		t.timeouts = 0
+		t.current = stepTimeout(t.current, t.cfg.Factor, t.cfg.Limit)
+	}
+	return prev, t.current
+}
+
+// step multiplies timeout by factor without crossing bound.
+func stepTimeout(timeout time.Duration, factor float64, bound time.Duration) time.Duration {
+	next := time.Duration(float64(timeout) * factor)
+	if (factor > 1 && next > bound) || (factor < 1 && next < bound) {
+		return bound
+	}
+	return next
+}
+
+// newProviderToTimeout returns adaptive timeouts of providers keyed by rpc and provider name.
+func newProviderToTimeout(rpcs []config.RPC) map[string]*adaptiveTimeout {
+	providerToTimeout := make(map[string]*adaptiveTimeout)
+	for _, rpc := range rpcs {
+		for _, provider := range rpc.Providers {
+			if provider.Timeout.Initial > 0 {
+				providerToTimeout[rpc.Name+"/"+provider.Name] = newAdaptiveTimeout(provider.Timeout)
+			}
+		}
+	}
+	return providerToTimeout
+}
+
+// providerTimeout returns timeout of provider serving rpc, fallback provider
+// is named as fallback/<rpc>/<provider> and has timeout of its own rpc.
+func (srv *Server) providerTimeout(rpcName, provider string) (*adaptiveTimeout, bool) {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		timeout, exist := srv.providerToTimeout[key]
+		return timeout, exist
+	}
+	timeout, exist := srv.providerToTimeout[rpcName+"/"+provider]
+	return timeout, exist
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_adaptiveTimeout(t *testing.T) {
+	t.Run("extends timeout of slow provider", func(t *testing.T) {
+		timeout := newAdaptiveTimeout(config.ProviderTimeout{
+			Initial: time.Second, Limit: 3 * time.Second, Factor: 2, Threshold: 2,
+		})
+
+		timeout.observe(true)
+		require.Equal(t, time.Second, timeout.get())
+		timeout.observe(true)
+		require.Equal(t, 2*time.Second, timeout.get())
+		timeout.observe(true)
+		timeout.observe(true)
+		require.Equal(t, 3*time.Second, timeout.get())
+
+		// request answered in time resets streak of timeouts and moves timeout back to initial.
+		timeout.observe(true)
+		timeout.observe(false)
+		timeout.observe(true)
+		require.Equal(t, 1500*time.Millisecond, timeout.get())
+		timeout.observe(false)
+		require.Equal(t, time.Second, timeout.get())
+	})
+
+	t.Run("shrinks timeout of unresponsive provider", func(t *testing.T) {
+		timeout := newAdaptiveTimeout(config.ProviderTimeout{
+			Initial: time.Second, Limit: 200 * time.Millisecond, Factor: 0.5, Threshold: 1,
+		})
+
+		prev, next := timeout.observe(true)
+		require.Equal(t, time.Second, prev)
+		require.Equal(t, 500*time.Millisecond, next)
+		timeout.observe(true)
+		timeout.observe(true)
+		require.Equal(t, 200*time.Millisecond, timeout.get())
+		timeout.observe(false)
+		require.Equal(t, 400*time.Millisecond, timeout.get())
+	})
+}
+
+func Test_Server_handler_providerTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	timeout := newAdaptiveTimeout(config.ProviderTimeout{
+		Initial: 10 * time.Millisecond, Limit: time.Second, Factor: 10, Threshold: 1,
+	})
+	srv := &Server{
+		cli:               &fasthttp.Client{},
+		providerToTimeout: map[string]*adaptiveTimeout{"test/slow": timeout},
+	}
+	request := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.RPCName = "test"
+			rc.Provider = "slow"
+			rc.ConnURL = upstream.URL
+		})
+		srv.handler(ctx)
+		return ctx
+	}
+
+	require.Equal(t, fasthttp.StatusGatewayTimeout, request().Response.StatusCode())
+	require.Equal(t, 100*time.Millisecond, timeout.get())
+	require.Equal(t, fasthttp.StatusOK, request().Response.StatusCode())
+	require.Equal(t, 10*time.Millisecond, timeout.get())
+}
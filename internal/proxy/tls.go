@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// newTLSConfig returns tls config serving configured certificate,
+// client certificates are required and verified against client CA if requireClientCert is set.
+func newTLSConfig(cfg config.TLS, requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not load certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if !requireClientCert {
+		return tlsCfg, nil
+	}
+
+	ca, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not read client ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("client ca has no valid certificates")
+	}
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return tlsCfg, nil
+}
+
+// clientNameFromCert returns client name from common name of certificate,
+// first dns name from subject alternative names is used if common name is empty.
+func clientNameFromCert(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// mtlsAuthMiddleware identifies client by certificate verified during tls handshake.
+// Connections without valid certificate are rejected by handshake itself,
+// requests without certificate are rejected here in case of plain connection.
+func (srv *Server) mtlsAuthMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		var client string
+		if state := ctx.TLSConnectionState(); state != nil && len(state.VerifiedChains) > 0 {
+			client = clientNameFromCert(state.VerifiedChains[0][0])
+		}
+		if client == "" {
+			log.Info().Uint64("request_id", ctx.ID()).Msg("client certificate is missing")
+			ctx.Error("", fasthttp.StatusUnauthorized)
+			return
+		}
+		SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Client = client })
+		next(ctx)
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues certificate from template signed by parent, self-signed if parent is nil.
+func newTestCert(t testing.TB, template *x509.Certificate, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCert{cert: cert, key: key, der: der}
+}
+
+func (c testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// writeFiles writes certificate and key in pem to dir and returns their paths.
+func (c testCert) writeFiles(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, name+".crt")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	rawKey, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0o600))
+
+	return certFile, keyFile
+}
+
+func Test_Server_mtlsAuthMiddleware(t *testing.T) {
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "rpcgate test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	ca := newTestCert(t, caTemplate, nil)
+	otherCA := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "other ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rpcgate"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientUsage := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	dir := t.TempDir()
+	certFile, keyFile := serverCert.writeFiles(t, dir, "server")
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	tlsCfg, err := newTLSConfig(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, true)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &Server{clients: config.Clients{Type: "mtls"}}
+	fastSrv := &fasthttp.Server{
+		Handler: srv.authMiddleware(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString(GetReqCtx(ctx).Client)
+		}),
+		// rejected handshakes are expected, so they are not logged.
+		Logger: stdlog.New(io.Discard, "", 0),
+	}
+	go func() { _ = fastSrv.Serve(tls.NewListener(ln, tlsCfg)) }()
+	defer func() { _ = fastSrv.Shutdown() }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cnCert := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "indexer"}, ExtKeyUsage: clientUsage}, &ca)
+	dnsCert := newTestCert(t, &x509.Certificate{DNSNames: []string{"wallet.internal"}, ExtKeyUsage: clientUsage}, &ca)
+	otherCACert := newTestCert(t,
+		&x509.Certificate{Subject: pkix.Name{CommonName: "indexer"}, ExtKeyUsage: clientUsage}, &otherCA)
+	testCases := []struct {
+		name   string
+		cert   *testCert
+		client string
+	}{
+		{name: "client from common name", cert: &cnCert, client: "indexer"},
+		{name: "client from dns name", cert: &dnsCert, client: "wallet.internal"},
+		{name: "certificate of unknown ca", cert: &otherCACert},
+		{name: "missing certificate"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientTLS := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+			if tc.cert != nil {
+				clientTLS.Certificates = []tls.Certificate{tc.cert.tlsCertificate()}
+			}
+			cli := &fasthttp.Client{TLSConfig: clientTLS}
+			status, body, err := cli.Get(nil, "https://"+ln.Addr().String()+"/")
+
+			if tc.client == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, fasthttp.StatusOK, status)
+			require.Equal(t, tc.client, string(body))
+		})
+	}
+}
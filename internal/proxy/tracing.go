@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batchSpanName is name of request span of batch requests.
+const batchSpanName = "batch"
+
+// requestHeaderCarrier carries span context in headers of fasthttp request.
+type requestHeaderCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (c requestHeaderCarrier) Get(key string) string {
+	return string(c.header.Peek(key))
+}
+
+func (c requestHeaderCarrier) Set(key, value string) {
+	c.header.Set(key, value)
+}
+
+func (c requestHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, c.header.Len())
+	for _, key := range c.header.PeekKeys() {
+		keys = append(keys, string(key))
+	}
+	return keys
+}
+
+// startRequestSpan starts root span of proxied request, continuing trace of client traceparent header.
+// Span is named after method of request, methods of batch request are listed in attribute.
+func (srv *Server) startRequestSpan(ctx *fasthttp.RequestCtx) trace.Span {
+	if srv.tracer == nil {
+		return nil
+	}
+	reqctx := GetReqCtx(ctx)
+
+	name := batchSpanName
+	if !reqctx.Batch && len(reqctx.Request) == 1 {
+		name = reqctx.Request[0].Method
+	}
+	attributes := []attribute.KeyValue{
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.Int64("rpcgate.chain_id", reqctx.ChainID),
+		attribute.String("rpcgate.rpc_name", reqctx.RPCName),
+		attribute.String("rpcgate.client", reqctx.Client),
+	}
+	if reqctx.Batch {
+		methods := make([]string, 0, len(reqctx.Request))
+		for _, req := range reqctx.Request {
+			methods = append(methods, req.Method)
+		}
+		attributes = append(attributes, attribute.StringSlice("rpc.batch.methods", methods))
+	} else {
+		attributes = append(attributes, attribute.String("rpc.method", name))
+	}
+	parent := srv.tracer.Extract(requestHeaderCarrier{header: &ctx.Request.Header})
+	_, span := srv.tracer.Start(parent, name, trace.SpanKindServer, attributes...)
+	SetToReqCtx(ctx, func(rc *ReqCtx) { rc.Span = span })
+	return span
+}
+
+// endRequestSpan ends root span of proxied request with provider which served it.
+func (srv *Server) endRequestSpan(ctx *fasthttp.RequestCtx, span trace.Span) {
+	if span == nil {
+		return
+	}
+	reqctx := GetReqCtx(ctx)
+	span.SetAttributes(
+		attribute.String("rpcgate.provider", reqctx.Provider),
+		attribute.String("rpcgate.balancer", reqctx.Balancer),
+		attribute.Int("http.response.status_code", ctx.Response.StatusCode()),
+	)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		span.SetStatus(codes.Error, "response status "+strconv.Itoa(ctx.Response.StatusCode()))
+	}
+	span.End()
+}
+
+// startUpstreamSpan starts span of request to provider as child of request span
+// and propagates it to provider in traceparent header.
+func (srv *Server) startUpstreamSpan(ctx *fasthttp.RequestCtx, req *fasthttp.Request) trace.Span {
+	reqctx := GetReqCtx(ctx)
+	if reqctx.Span == nil {
+		return nil
+	}
+	spanCtx, span := srv.tracer.Start(trace.ContextWithSpan(context.Background(), reqctx.Span),
+		"upstream", trace.SpanKindClient, attribute.String("rpcgate.provider", reqctx.Provider))
+	srv.tracer.Inject(spanCtx, requestHeaderCarrier{header: &req.Header})
+	return span
+}
+
+// endUpstreamSpan ends span of request to provider, failed if provider was not reached or answered with error status.
+func endUpstreamSpan(span trace.Span, err error, resp *fasthttp.Response) {
+	if span == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+	case resp.StatusCode() != fasthttp.StatusOK:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode()))
+		span.SetStatus(codes.Error, "response status "+strconv.Itoa(resp.StatusCode()))
+	default:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode()))
+	}
+	span.End()
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/tracing"
+)
+
+// traceparentHeader is w3c trace context header carrying trace and parent span ids.
+const traceparentHeader = "traceparent"
+
+func Test_Server_loadBalancerMiddleware_tracing(t *testing.T) {
+	const clientTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var (
+		mutex sync.Mutex
+		spans []*tracepb.Span
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err = proto.Unmarshal(body, &req); err != nil {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, resource := range req.GetResourceSpans() {
+			for _, scope := range resource.GetScopeSpans() {
+				spans = append(spans, scope.GetSpans()...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	upstreamTraceparent := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent <- r.Header.Get(traceparentHeader)
+		_, _ = w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x2"}]`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:          &fasthttp.Client{},
+		nameToLBAlgo: map[string]string{"/mainnet": config.RRName},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/mainnet": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+		tracer: tracing.New(config.Tracing{
+			Endpoint: collector.URL, ServiceName: "rpcgate", Sampler: config.SamplerNever,
+		}),
+	}
+	handler := srv.requestParserMiddleware(srv.loadBalancerMiddleware(srv.handler))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/mainnet")
+	ctx.Request.Header.Set(traceparentHeader, clientTraceparent)
+	ctx.Request.SetBody([]byte(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},` +
+		`{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`))
+	SetToReqCtx(ctx, func(rc *ReqCtx) {
+		rc.RPCName = "mainnet"
+		rc.ChainID = 1
+	})
+	handler(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	srv.tracer.Shutdown()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	// client sampled the trace, so spans are exported even with never sampler.
+	require.Len(t, spans, 2)
+	upstreamSpan, requestSpan := spans[0], spans[1]
+	require.Equal(t, "batch", requestSpan.GetName())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(requestSpan.GetTraceId()))
+	require.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(requestSpan.GetParentSpanId()))
+	require.Equal(t, requestSpan.GetTraceId(), upstreamSpan.GetTraceId())
+	require.Equal(t, requestSpan.GetSpanId(), upstreamSpan.GetParentSpanId())
+	require.Equal(t, "00-"+hex.EncodeToString(upstreamSpan.GetTraceId())+"-"+
+		hex.EncodeToString(upstreamSpan.GetSpanId())+"-01", <-upstreamTraceparent)
+
+	var methods []string
+	for _, attr := range requestSpan.GetAttributes() {
+		switch attr.GetKey() {
+		case "rpc.batch.methods":
+			for _, value := range attr.GetValue().GetArrayValue().GetValues() {
+				methods = append(methods, value.GetStringValue())
+			}
+		case "rpcgate.provider":
+			require.Equal(t, "node", attr.GetValue().GetStringValue())
+		}
+	}
+	require.Equal(t, []string{"eth_chainId", "eth_blockNumber"}, methods)
+}
+
+func Test_Server_loadBalancerMiddleware_tracingDisabled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// client traceparent is not forwarded as is without tracing.
+		if r.Header.Get(traceparentHeader) != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli:          &fasthttp.Client{},
+		nameToLBAlgo: map[string]string{"/mainnet": config.RRName},
+		chainToRR: map[string]*balancer.RoundRobin{
+			"/mainnet": balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: upstream.URL}}),
+		},
+	}
+	handler := srv.requestParserMiddleware(srv.loadBalancerMiddleware(srv.handler))
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/mainnet")
+	ctx.Request.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx.Request.SetBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	handler(ctx)
+
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Nil(t, GetReqCtx(ctx).Span)
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// errBatchOverGet is returned for batch which can not be sent in query string of get request.
+var errBatchOverGet = errors.New("batch can not be sent with GET")
+
+// upstreamRequest is http method and content type of requests to providers of rpc.
+// Nil upstreamRequest sends json body with POST.
+type upstreamRequest struct {
+	method      string
+	contentType string
+}
+
+// newUpstreamRequest returns upstream request of rpc, nil if it is default POST of json.
+func newUpstreamRequest(cfg config.UpstreamRequest) *upstreamRequest {
+	if cfg.Method == config.UpstreamMethodPost && cfg.ContentType == config.DefaultUpstreamContentType {
+		return nil
+	}
+	return &upstreamRequest{method: cfg.Method, contentType: cfg.ContentType}
+}
+
+// upstreamRequest returns upstream request of provider serving rpc. Fallback provider is named
+// as fallback/<rpc>/<provider> and is requested as providers of its own rpc.
+func (srv *Server) upstreamRequest(rpcName, provider string) *upstreamRequest {
+	if key, ok := strings.CutPrefix(provider, "fallback/"); ok {
+		rpcName, _, _ = strings.Cut(key, "/")
+	}
+	return srv.nameToUpstreamRequest["/"+rpcName]
+}
+
+// set sets method, content type and body of req. GET request carries json-rpc request in query string
+// as in json-rpc over http: jsonrpc, method, id as json value and base64 encoded params,
+// query of provider url is kept.
+func (u *upstreamRequest) set(req *fasthttp.Request, body []byte) error {
+	if u == nil {
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.Header.SetContentType(config.DefaultUpstreamContentType)
+		req.SetBody(body)
+		return nil
+	}
+	if u.method == config.UpstreamMethodPost {
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.Header.SetContentType(u.contentType)
+		req.SetBody(body)
+		return nil
+	}
+
+	s := jsonScanner{data: body}
+	if s.skipSpace() == '[' {
+		return errBatchOverGet
+	}
+	var request JSONRPCRequest
+	err := s.scanRequest(&request)
+	if err == nil {
+		err = s.end()
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.SetMethod(fasthttp.MethodGet)
+	args := req.URI().QueryArgs()
+	args.Set("jsonrpc", "2.0")
+	args.Set("method", request.Method)
+	if len(request.ID) > 0 {
+		args.SetBytesV("id", request.ID)
+	}
+	if len(request.Params) > 0 && string(request.Params) != "null" {
+		args.Set("params", base64.URLEncoding.EncodeToString(request.Params))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_upstreamRequest_set(t *testing.T) {
+	const body = `{"jsonrpc":"2.0","id":"a1","method":"eth_getBalance","params":["0x1","latest"]}`
+
+	testCases := []struct {
+		name        string
+		cfg         config.UpstreamRequest
+		body        string
+		method      string
+		contentType string
+		uri         string
+		needErr     bool
+	}{
+		{
+			name:        "default",
+			cfg:         config.UpstreamRequest{Method: config.UpstreamMethodPost, ContentType: "application/json"},
+			body:        body,
+			method:      fasthttp.MethodPost,
+			contentType: "application/json",
+			uri:         "http://node/rpc?key=1",
+		},
+		{
+			name:        "content type",
+			cfg:         config.UpstreamRequest{Method: config.UpstreamMethodPost, ContentType: "application/json-rpc"},
+			body:        body,
+			method:      fasthttp.MethodPost,
+			contentType: "application/json-rpc",
+			uri:         "http://node/rpc?key=1",
+		},
+		{
+			name:   "get",
+			cfg:    config.UpstreamRequest{Method: config.UpstreamMethodGet},
+			body:   body,
+			method: fasthttp.MethodGet,
+			uri: "http://node/rpc?key=1&jsonrpc=2.0&method=eth_getBalance&id=%22a1%22&params=" +
+				url.QueryEscape(base64.URLEncoding.EncodeToString([]byte(`["0x1","latest"]`))),
+		},
+		{
+			name:   "get notification without params",
+			cfg:    config.UpstreamRequest{Method: config.UpstreamMethodGet},
+			body:   `{"jsonrpc":"2.0","method":"eth_blockNumber"}`,
+			method: fasthttp.MethodGet,
+			uri:    "http://node/rpc?key=1&jsonrpc=2.0&method=eth_blockNumber",
+		},
+		{
+			name:    "get batch",
+			cfg:     config.UpstreamRequest{Method: config.UpstreamMethodGet},
+			body:    `[` + body + `]`,
+			needErr: true,
+		},
+		{
+			name:    "get malformed",
+			cfg:     config.UpstreamRequest{Method: config.UpstreamMethodGet},
+			body:    `{"method":`,
+			needErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("http://node/rpc?key=1")
+
+			err := newUpstreamRequest(tc.cfg).set(req, []byte(tc.body))
+			if tc.needErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.method, string(req.Header.Method()))
+			require.Equal(t, tc.uri, req.URI().String())
+			if tc.method == fasthttp.MethodGet {
+				require.Empty(t, req.Body())
+				return
+			}
+			require.Equal(t, tc.contentType, string(req.Header.ContentType()))
+			require.Equal(t, tc.body, string(req.Body()))
+		})
+	}
+}
+
+func Test_Server_handler_upstreamGet(t *testing.T) {
+	var method, query string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, query = r.Method, r.URL.Query().Get("method")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		cli: &fasthttp.Client{},
+		nameToUpstreamRequest: map[string]*upstreamRequest{
+			"/get-test": newUpstreamRequest(config.UpstreamRequest{Method: config.UpstreamMethodGet}),
+		},
+	}
+	serve := func(body, provider string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, nil, nil)
+		ctx.Request.SetBodyString(body)
+		SetToReqCtx(ctx, func(rc *ReqCtx) {
+			rc.ConnURL = upstream.URL
+			rc.RPCName = "mainnet"
+			rc.Provider = provider
+		})
+		srv.handler(ctx)
+		return ctx
+	}
+
+	// fallback provider is requested with method of its own rpc.
+	ctx := serve(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`, "fallback/get-test/node")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, http.MethodGet, method)
+	require.Equal(t, "eth_chainId", query)
+
+	ctx = serve(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}]`, "fallback/get-test/node")
+	require.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	require.Contains(t, string(ctx.Response.Body()), `"code":-32600`)
+
+	ctx = serve(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`, "node")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, http.MethodPost, method)
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/buildinfo"
+)
+
+// versionPath is path of endpoint reporting build of running rpcgate.
+const versionPath = "/version"
+
+// versionMiddleware answers build info on version path, it is served without auth like health probes.
+func (srv *Server) versionMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) != versionPath {
+			next(ctx)
+			return
+		}
+		raw, err := json.Marshal(buildinfo.Get())
+		if err != nil {
+			log.Error().Uint64("request_id", ctx.ID()).Err(err).Msg("can not marshal build info")
+			ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+			return
+		}
+		ctx.Response.Header.SetContentType("application/json")
+		ctx.Response.SetBody(raw)
+	}
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/buildinfo"
+)
+
+func Test_Server_versionMiddleware(t *testing.T) {
+	srv := &Server{}
+	handler := srv.versionMiddleware(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(versionPath)
+	handler(ctx)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	require.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+	var info buildinfo.Info
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &info))
+	require.Equal(t, buildinfo.Get(), info)
+	require.NotEmpty(t, info.GoVersion)
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/mainnet")
+	handler(ctx)
+	require.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+}
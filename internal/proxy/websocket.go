@@ -1,6 +1,16 @@
 package proxy
 
-import "github.com/fasthttp/websocket"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
 
 type WSContext struct {
 	conn *websocket.Conn
@@ -14,6 +24,129 @@ type WSContext struct {
 	chainID       string
 	rpcName       string
 	method        string
+	// forwardedHeaders are headers of client handshake forwarded to provider.
+	forwardedHeaders http.Header
+
+	// subscriptions tracks eth_subscribe calls of client, nil if reconnect is disabled.
+	subscriptions *wsSubscriptions
 }
 
 type WSHandler func(ctx *WSContext)
+
+// errWSEmptyMessage is returned reading message without payload if empty messages are not skipped.
+var errWSEmptyMessage = errors.New("empty websocket message")
+
+// readWSMessage reads next json message from connection. Ping and pong frames are handled by connection
+// and never returned, messages without payload are skipped unless closeOnEmpty is set.
+func readWSMessage(conn *websocket.Conn, closeOnEmpty bool) (json.RawMessage, error) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if isEmptyWSMessage(data) {
+			if closeOnEmpty {
+				return nil, errWSEmptyMessage
+			}
+			continue
+		}
+		var msg json.RawMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// isEmptyWSMessage returns true if message has no payload or consists of whitespace only.
+func isEmptyWSMessage(data []byte) bool {
+	return len(bytes.TrimSpace(data)) == 0
+}
+
+// jsonWriter writes json encoded messages to websocket connection.
+type jsonWriter interface {
+	WriteJSON(v any) error
+}
+
+// wsLockedWriter serializes writes to websocket connection,
+// because websocket.Conn supports only one concurrent writer.
+type wsLockedWriter struct {
+	mutex sync.Mutex
+	conn  *websocket.Conn
+}
+
+// WriteJSON writes json encoded message to connection.
+func (w *wsLockedWriter) WriteJSON(v any) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.conn.WriteJSON(v)
+}
+
+// WriteMessage writes message of messageType to connection.
+func (w *wsLockedWriter) WriteMessage(messageType int, data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.conn.WriteMessage(messageType, data)
+}
+
+// wsSubscriptionPolicy decides which eth_subscribe subscription types are allowed for rpc.
+type wsSubscriptionPolicy struct {
+	allowed map[string]struct{}
+	denied  map[string]struct{}
+}
+
+func newWSSubscriptionPolicy(cfg config.WSSubscriptions) wsSubscriptionPolicy {
+	toSet := func(list []string) map[string]struct{} {
+		set := make(map[string]struct{}, len(list))
+		for _, v := range list {
+			set[v] = struct{}{}
+		}
+		return set
+	}
+	return wsSubscriptionPolicy{
+		allowed: toSet(cfg.Allowed),
+		denied:  toSet(cfg.Denied),
+	}
+}
+
+// isAllowed returns true if subscription type passes allow and deny lists.
+func (p wsSubscriptionPolicy) isAllowed(subscription string) bool {
+	if len(p.allowed) > 0 {
+		_, ok := p.allowed[subscription]
+		return ok
+	}
+	_, denied := p.denied[subscription]
+	return !denied
+}
+
+// wsRequest is a json-rpc request received over websocket.
+type wsRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// subscription returns eth_subscribe subscription type or empty string for other requests.
+func (r wsRequest) subscription() string {
+	const subscribeMethod = "eth_subscribe"
+	if r.Method != subscribeMethod || len(r.Params) == 0 {
+		return ""
+	}
+	var subscription string
+	if err := json.Unmarshal(r.Params[0], &subscription); err != nil {
+		return ""
+	}
+	return subscription
+}
+
+// wsMessage is a message received from upstream.
+type wsMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Params struct {
+		Subscription string `json:"subscription"`
+	} `json:"params"`
+}
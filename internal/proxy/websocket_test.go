@@ -0,0 +1,389 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+func Test_Server_rejectedWSSubscription(t *testing.T) {
+	srv := &Server{
+		nameToWSSubscriptions: map[string]wsSubscriptionPolicy{
+			"/denied": newWSSubscriptionPolicy(config.WSSubscriptions{Denied: []string{"logs"}}),
+			"/allowed": newWSSubscriptionPolicy(config.WSSubscriptions{
+				Allowed: []string{"newHeads"},
+			}),
+		},
+	}
+	testCases := []struct {
+		name     string
+		path     string
+		msg      string
+		rejected bool
+	}{
+		{
+			name:     "denied subscription",
+			path:     "/denied",
+			msg:      `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["logs",{}]}`,
+			rejected: true,
+		},
+		{
+			name:     "not denied subscription",
+			path:     "/denied",
+			msg:      `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`,
+			rejected: false,
+		},
+		{
+			name:     "allowed subscription",
+			path:     "/allowed",
+			msg:      `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`,
+			rejected: false,
+		},
+		{
+			name:     "not allowed subscription",
+			path:     "/allowed",
+			msg:      `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newPendingTransactions"]}`,
+			rejected: true,
+		},
+		{
+			name:     "other method with allowlist",
+			path:     "/allowed",
+			msg:      `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`,
+			rejected: false,
+		},
+		{
+			name: "batch with denied subscription",
+			path: "/denied",
+			msg: `[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},` +
+				`{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["logs"]}]`,
+			rejected: true,
+		},
+		{
+			name:     "no policy",
+			path:     "/unknown",
+			msg:      `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["logs"]}`,
+			rejected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, rejected := srv.rejectedWSSubscription(&WSContext{requestPath: tc.path}, json.RawMessage(tc.msg))
+			require.Equal(t, tc.rejected, rejected)
+			if !tc.rejected {
+				require.Nil(t, resp)
+				return
+			}
+			raw, err := json.Marshal(resp)
+			require.NoError(t, err)
+			require.Contains(t, string(raw), `"code":-32600`)
+		})
+	}
+}
+
+func Test_Server_wsHandler_maxMessageBytes(t *testing.T) {
+	const maxMessageBytes = 64
+
+	// upstream answers every message with oversized one when asked.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg []byte
+			if _, msg, err = conn.ReadMessage(); err != nil {
+				return
+			}
+			resp := `{"jsonrpc":"2.0","id":1,"result":"0x1"}`
+			if strings.Contains(string(msg), "big") {
+				resp = `{"jsonrpc":"2.0","id":1,"result":"` + strings.Repeat("f", 2*maxMessageBytes) + `"}`
+			}
+			if err = conn.WriteMessage(websocket.TextMessage, []byte(resp)); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		nameToLBAlgo:       map[string]string{"/limited": config.RRName},
+		nameToChainID:      map[string]int64{"/limited": 1},
+		nameToWSMaxMessage: map[string]int64{"/limited": maxMessageBytes},
+		upgrader:           websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(func(ctx *WSContext) {
+		ctx.providerURL = "ws://" + strings.TrimPrefix(upstream.URL, "http://")
+		srv.wsHandler(ctx)
+	})}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	dial := func(t *testing.T) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/limited", nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		return conn
+	}
+	closeCode := func(t *testing.T, conn *websocket.Conn) int {
+		t.Helper()
+		for {
+			_, _, err := conn.ReadMessage()
+			if err == nil {
+				continue
+			}
+			var closeErr *websocket.CloseError
+			require.ErrorAs(t, err, &closeErr)
+			return closeErr.Code
+		}
+	}
+
+	t.Run("message within limit", func(t *testing.T) {
+		conn := dial(t)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"eth_chainId"}`)))
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, string(msg))
+	})
+	t.Run("oversized client message", func(t *testing.T) {
+		conn := dial(t)
+		oversized := `{"id":1,"method":"eth_call","params":["` + strings.Repeat("a", maxMessageBytes) + `"]}`
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(oversized)))
+		require.Equal(t, websocket.CloseMessageTooBig, closeCode(t, conn))
+	})
+	t.Run("oversized upstream message", func(t *testing.T) {
+		conn := dial(t)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"big"}`)))
+		require.Equal(t, websocket.ClosePolicyViolation, closeCode(t, conn))
+	})
+}
+
+func Test_Server_wsUpgrader_handshakeTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+
+	srv := &Server{
+		nameToLBAlgo:       map[string]string{"/mainnet": config.RRName},
+		nameToChainID:      map[string]int64{"/mainnet": 1},
+		upgrader:           websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		wsHandshakeTimeout: timeout,
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{
+		Handler: srv.wsUpgrader(func(ctx *WSContext) {
+			for {
+				messageType, msg, err := ctx.conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err = ctx.conn.WriteMessage(messageType, msg); err != nil {
+					return
+				}
+			}
+		}),
+		ConnState: handshakeConnState(timeout),
+	}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	t.Run("slow handshake is aborted", func(t *testing.T) {
+		// server sets deadline once connection is accepted, so start is taken before dial.
+		start := time.Now()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET /mainnet HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		// server closes connection once timeout is reached.
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.NotContains(t, string(resp), "101 Switching Protocols")
+		require.GreaterOrEqual(t, time.Since(start), timeout)
+	})
+	t.Run("upgraded connection outlives timeout", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/mainnet", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		time.Sleep(2 * timeout)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ping")))
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(msg))
+	})
+}
+
+func Test_Server_wsHandler_emptyMessages(t *testing.T) {
+	// upstream precedes every response with empty message and ping frame.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg []byte
+			if _, msg, err = conn.ReadMessage(); err != nil {
+				return
+			}
+			if err = conn.WriteMessage(websocket.TextMessage, nil); err != nil {
+				return
+			}
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+			if err = conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		nameToLBAlgo:   map[string]string{"/skip": config.RRName, "/close": config.RRName},
+		nameToChainID:  map[string]int64{"/skip": 1, "/close": 1},
+		wsCloseOnEmpty: map[string]struct{}{"/close": {}},
+		upgrader:       websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(func(ctx *WSContext) {
+		ctx.providerURL = "ws://" + strings.TrimPrefix(upstream.URL, "http://")
+		srv.wsHandler(ctx)
+	})}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	dial := func(t *testing.T, path string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+path, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		return conn
+	}
+
+	t.Run("empty and control frames are skipped", func(t *testing.T) {
+		conn := dial(t, "/skip")
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, nil))
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(" \n")))
+		require.NoError(t, conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)))
+		const req = `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(req)))
+
+		// upstream empty message is not forwarded, response is the first message received.
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.JSONEq(t, req, string(msg))
+	})
+	t.Run("empty message closes connection", func(t *testing.T) {
+		conn := dial(t, "/close")
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, nil))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		require.Equal(t, websocket.CloseInvalidFramePayloadData, closeErr.Code)
+	})
+}
+
+func Test_Server_wsHandler_metrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(messageType, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		nameToLBAlgo:  map[string]string{"/ws-metrics": config.RRName},
+		nameToChainID: map[string]int64{"/ws-metrics": 1},
+		upgrader:      websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(func(ctx *WSContext) {
+		ctx.providerName = "node"
+		ctx.providerURL = "ws://" + strings.TrimPrefix(upstream.URL, "http://")
+		srv.wsHandler(ctx)
+	})}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	active := metrics.WSActiveConnections.WithLabelValues("ws-metrics", "node")
+	toUpstream := metrics.WSMessages.WithLabelValues("ws-metrics", "node", metrics.WSClientToUpstream)
+	toClient := metrics.WSMessages.WithLabelValues("ws-metrics", "node", metrics.WSUpstreamToClient)
+
+	// counters are process global, so changes are asserted.
+	activeBefore := testutil.ToFloat64(active)
+	toUpstreamBefore := testutil.ToFloat64(toUpstream)
+	toClientBefore := testutil.ToFloat64(toClient)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws-metrics", nil)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for range 2 {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"eth_chainId"}`)))
+		_, _, err = conn.ReadMessage()
+		require.NoError(t, err)
+	}
+	require.InDelta(t, activeBefore+1, testutil.ToFloat64(active), 0)
+	require.InDelta(t, toUpstreamBefore+2, testutil.ToFloat64(toUpstream), 0)
+	require.InDelta(t, toClientBefore+2, testutil.ToFloat64(toClient), 0)
+
+	// abrupt client disconnect goes through error path of handler.
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(active) == activeBefore
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// serveWS serves websocket handler of srv with every client connected to providerURL as client login,
+// returns address of server.
+func serveWS(t *testing.T, srv *Server, providerURL, login string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(func(ctx *WSContext) {
+		ctx.client = login
+		ctx.providerName = "node"
+		ctx.providerURL = providerURL
+		srv.wsHandler(ctx)
+	})}
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return ln.Addr().String()
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+// errNoAffinityProvider is returned if no provider with tags of subscription type is available.
+var errNoAffinityProvider = errors.New("no provider available for subscription")
+
+// wsAffinityRoute is upstream connection serving subscriptions of one type within websocket session.
+type wsAffinityRoute struct {
+	provider string
+	conn     *websocket.Conn
+	writer   *wsLockedWriter
+	release  balancer.Release
+}
+
+// wsAffinity routes eth_subscribe calls of session to providers having tags required by subscription type
+// and eth_unsubscribe calls to provider serving subscription. Upstreams are dialed on first subscription
+// of type, subscriptions which session provider can serve are sent to it as usual.
+type wsAffinity struct {
+	srv  *Server
+	ctx  *WSContext
+	tags map[string]map[string]string // subscription type -> required provider tags
+	pipe func(route *wsAffinityRoute) // starts forwarding messages of route upstream to client
+
+	mutex         sync.Mutex
+	routes        map[string]*wsAffinityRoute // subscription type -> route
+	pending       map[string]*wsAffinityRoute // eth_subscribe request id -> route
+	subscriptions map[string]*wsAffinityRoute // subscription id -> route
+}
+
+// newWSAffinity returns subscription router of session, nil if rpc has no subscription affinity.
+func (srv *Server) newWSAffinity(ctx *WSContext, pipe func(route *wsAffinityRoute)) *wsAffinity {
+	tags, ok := srv.nameToWSAffinity[ctx.requestPath]
+	if !ok {
+		return nil
+	}
+	return &wsAffinity{
+		srv:           srv,
+		ctx:           ctx,
+		tags:          tags,
+		pipe:          pipe,
+		routes:        make(map[string]*wsAffinityRoute),
+		pending:       make(map[string]*wsAffinityRoute),
+		subscriptions: make(map[string]*wsAffinityRoute),
+	}
+}
+
+// hasTags returns true if provider of rpc has all passed tags.
+func (srv *Server) hasTags(rpcName, provider string, tags map[string]string) bool {
+	providerTags := srv.providerTags(rpcName, provider)
+	for key, value := range tags {
+		if providerTags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// route sends client message to upstream of its subscription. False is returned if message
+// must be sent to session upstream, error is returned if routed message was not sent.
+func (a *wsAffinity) route(msg json.RawMessage) (bool, error) {
+	if a == nil || isBatch(msg) {
+		return false, nil
+	}
+	var req wsRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return false, nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch req.Method {
+	case ethSubscribe:
+		subscription := req.subscription()
+		tags, ok := a.tags[subscription]
+		if !ok || a.srv.hasTags(a.ctx.rpcName, a.ctx.providerName, tags) {
+			return false, nil
+		}
+		route, err := a.routeOf(subscription, tags)
+		if err != nil {
+			return true, err
+		}
+		if req.ID != nil {
+			a.pending[string(req.ID)] = route
+		}
+		return true, route.writer.WriteJSON(msg)
+	case ethUnsubscribe:
+		if len(req.Params) == 0 {
+			return false, nil
+		}
+		var id string
+		if err := json.Unmarshal(req.Params[0], &id); err != nil {
+			return false, nil
+		}
+		route, ok := a.subscriptions[id]
+		if !ok {
+			return false, nil
+		}
+		delete(a.subscriptions, id)
+		return true, route.writer.WriteJSON(msg)
+	}
+	return false, nil
+}
+
+// routeOf returns route of subscription type, upstream is dialed if it is the first subscription of type.
+// Must be called under mutex.
+func (a *wsAffinity) routeOf(subscription string, tags map[string]string) (*wsAffinityRoute, error) {
+	if route, ok := a.routes[subscription]; ok {
+		return route, nil
+	}
+	lb, _ := a.srv.getBalancer(a.ctx.requestPath)
+	if lb == nil {
+		return nil, errNoAffinityProvider
+	}
+	payload, release := a.srv.borrowWSProvider(a.ctx.requestPath, lb, func(name string) bool {
+		return !a.srv.hasTags(a.ctx.rpcName, name, tags)
+	})
+	if payload.URL == "" {
+		return nil, errNoAffinityProvider
+	}
+	conn, err := a.srv.initWSConnWithProvider(payload.URL, a.srv.wsHandshakeHeaders(a.ctx, payload.Name))
+	if err != nil {
+		release(false, 0)
+		return nil, err
+	}
+	metrics.ProviderSelected.WithLabelValues(a.ctx.rpcName, payload.Name, a.ctx.loadBalanacer).Inc()
+	log.Debug().
+		Uint64("request_id", a.ctx.requestID).
+		Str("subscription", subscription).
+		Str("provider", payload.Name).
+		Msg("subscription routed to affinity provider")
+
+	route := &wsAffinityRoute{
+		provider: payload.Name,
+		conn:     conn,
+		writer:   &wsLockedWriter{conn: conn},
+		release:  release,
+	}
+	a.routes[subscription] = route
+	a.pipe(route)
+	return route, nil
+}
+
+// trackResponse remembers subscription id of eth_subscribe answered by route upstream,
+// so eth_unsubscribe of client is sent to the same upstream.
+func (a *wsAffinity) trackResponse(route *wsAffinityRoute, msg json.RawMessage) {
+	var resp wsMessage
+	if isBatch(msg) || json.Unmarshal(msg, &resp) != nil || resp.ID == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.pending[string(resp.ID)] != route {
+		return
+	}
+	delete(a.pending, string(resp.ID))
+	var id string
+	if err := json.Unmarshal(resp.Result, &id); err == nil && id != "" {
+		a.subscriptions[id] = route
+	}
+}
+
+// close asks route upstreams to close connection, so their pipes stop once session is over.
+func (a *wsAffinity) close() {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, route := range a.routes {
+		_ = route.writer.WriteMessage(websocket.CloseMessage, nil)
+		_ = route.conn.SetReadDeadline(time.Now().Add(wsCloseTimeout))
+	}
+}
+
+// release closes route upstreams and returns their providers to balancer.
+func (a *wsAffinity) release() {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, route := range a.routes {
+		_ = route.conn.Close()
+		route.release(true, 0)
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// subscriptionRecorder records subscription calls received by upstreams by provider name.
+type subscriptionRecorder struct {
+	mutex sync.Mutex
+	calls map[string][]string // provider -> method:params[0]
+}
+
+func (r *subscriptionRecorder) received(provider string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls[provider]
+}
+
+// upstream returns websocket upstream answering eth_subscribe with subscription id
+// prefixed by provider name and recording calls.
+func (r *subscriptionRecorder) upstream(t *testing.T, provider string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg wsRequest
+			if err = conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			var param string
+			if len(msg.Params) > 0 {
+				_ = json.Unmarshal(msg.Params[0], &param)
+			}
+			r.mutex.Lock()
+			r.calls[provider] = append(r.calls[provider], msg.Method+":"+param)
+			r.mutex.Unlock()
+
+			result := json.RawMessage(`true`)
+			if msg.Method == ethSubscribe {
+				result, _ = json.Marshal(provider + "-" + string(msg.ID))
+			}
+			if err = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": result}); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws://" + strings.TrimPrefix(server.URL, "http://")
+}
+
+func Test_Server_wsHandler_subscriptionAffinity(t *testing.T) {
+	recorder := &subscriptionRecorder{calls: make(map[string][]string)}
+	providers := []balancer.Payload{
+		{Name: "big", URL: recorder.upstream(t, "big")},
+		{Name: "small1", URL: recorder.upstream(t, "small1")},
+		{Name: "small2", URL: recorder.upstream(t, "small2")},
+	}
+	srv := &Server{
+		nameToLBAlgo:     map[string]string{"/ws": config.RRName},
+		nameToChainID:    map[string]int64{"/ws": 1},
+		chainToRR:        map[string]*balancer.RoundRobin{"/ws": balancer.NewRoundRobin(providers)},
+		providerToTags:   map[string]map[string]string{"ws/big": {"tier": "high"}},
+		nameToWSAffinity: map[string]map[string]map[string]string{"/ws": {"logs": {"tier": "high"}}},
+		upgrader:         websocket.FastHTTPUpgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fasthttp.Server{Handler: srv.wsUpgrader(srv.wsLoadBalancerMiddleware(srv.wsHandler))}
+	go func() { _ = server.Serve(ln) }()
+	defer server.Shutdown() //nolint:errcheck // test server
+
+	const sessions = 3
+	for range sessions {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		call := func(req string) wsMessage {
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(req)))
+			var resp wsMessage
+			require.NoError(t, conn.ReadJSON(&resp))
+			return resp
+		}
+		var logsID string
+		require.NoError(t, json.Unmarshal(call(`{"id":1,"method":"eth_subscribe","params":["logs"]}`).Result, &logsID))
+		require.Equal(t, "big-1", logsID)
+		call(`{"id":2,"method":"eth_subscribe","params":["newHeads"]}`)
+
+		// unsubscribe is sent to provider serving subscription.
+		resp := call(`{"id":3,"method":"eth_unsubscribe","params":["` + logsID + `"]}`)
+		require.JSONEq(t, `true`, string(resp.Result))
+		require.NoError(t, conn.Close())
+	}
+
+	require.Eventually(t, func() bool {
+		return len(recorder.received("big"))+len(recorder.received("small1"))+len(recorder.received("small2")) ==
+			3*sessions
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// logs subscriptions always land on high capacity provider.
+	var newHeadsProviders int
+	for _, provider := range providers {
+		calls := recorder.received(provider.Name)
+		if provider.Name == "big" {
+			require.Equal(t, sessions, countCalls(calls, "eth_subscribe:logs"))
+			require.Equal(t, sessions, countCalls(calls, "eth_unsubscribe:big-1"))
+		} else {
+			require.Zero(t, countCalls(calls, "eth_subscribe:logs"))
+		}
+		if countCalls(calls, "eth_subscribe:newHeads") > 0 {
+			newHeadsProviders++
+		}
+	}
+	// newHeads subscriptions follow session provider, so they spread out.
+	require.Greater(t, newHeadsProviders, 1)
+}
+
+func countCalls(calls []string, call string) int {
+	var n int
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
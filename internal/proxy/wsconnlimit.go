@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// wsConnLimit counts websocket sessions of provider against its max_ws_connections.
+type wsConnLimit struct {
+	limit  int64
+	active atomic.Int64
+}
+
+// acquire takes websocket session slot of provider, returns false if provider is at capacity.
+func (l *wsConnLimit) acquire() bool {
+	if l.active.Add(1) > l.limit {
+		l.active.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (l *wsConnLimit) release() {
+	l.active.Add(-1)
+}
+
+// full returns true if provider is at capacity.
+func (l *wsConnLimit) full() bool {
+	return l.active.Load() >= l.limit
+}
+
+// wsConnLimits are websocket connection limits of rpc providers.
+type wsConnLimits struct {
+	providers int                     // number of providers of rpc, bounds attempts to borrow one
+	limits    map[string]*wsConnLimit // providers without limit are missing
+}
+
+// newNameToWSConnLimits returns websocket connection limits of rpcs having at least one limited provider.
+func newNameToWSConnLimits(rpcs []config.RPC) map[string]wsConnLimits {
+	nameToWSConnLimits := make(map[string]wsConnLimits)
+	for _, rpc := range rpcs {
+		limits := make(map[string]*wsConnLimit)
+		for _, provider := range rpc.Providers {
+			if provider.MaxWSConnections > 0 {
+				limits[provider.Name] = &wsConnLimit{limit: provider.MaxWSConnections}
+			}
+		}
+		if len(limits) > 0 {
+			nameToWSConnLimits["/"+rpc.Name] = wsConnLimits{providers: len(rpc.Providers), limits: limits}
+		}
+	}
+	return nameToWSConnLimits
+}
+
+// exceptBorrower is implemented by balancers which can borrow provider skipping some of them.
+type exceptBorrower interface {
+	BorrowExcept(skip func(name string) bool) (balancer.Payload, balancer.Release)
+}
+
+// borrowWSProvider borrows provider of rpc below its websocket connection limit from lb, providers
+// for which skip returns true are never borrowed. Empty payload is returned if every provider is at capacity.
+func (srv *Server) borrowWSProvider(
+	rpcPath string,
+	lb Balancer,
+	skip func(name string) bool,
+) (balancer.Payload, balancer.Release) {
+	connLimits, limited := srv.nameToWSConnLimits[rpcPath]
+	borrower, ok := lb.(exceptBorrower)
+	if !ok {
+		payload, release := lb.Borrow()
+		if skip != nil && payload.URL != "" && skip(payload.Name) {
+			release(true, 0)
+			return balancer.Payload{}, func(bool, time.Duration) {}
+		}
+		return payload, release
+	}
+	if !limited {
+		return borrower.BorrowExcept(skip)
+	}
+
+	full := func(name string) bool {
+		if skip != nil && skip(name) {
+			return true
+		}
+		limit, exist := connLimits.limits[name]
+		return exist && limit.full()
+	}
+	// concurrent sessions may take last slot of provider between borrow and acquire, so borrow is retried.
+	for range connLimits.providers {
+		payload, release := borrower.BorrowExcept(full)
+		limit, exist := connLimits.limits[payload.Name]
+		if payload.URL == "" || !exist {
+			return payload, release
+		}
+		if limit.acquire() {
+			return payload, func(success bool, latency time.Duration) {
+				limit.release()
+				release(success, latency)
+			}
+		}
+		release(true, 0)
+	}
+	return balancer.Payload{}, func(bool, time.Duration) {}
+}
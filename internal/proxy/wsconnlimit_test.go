@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_Server_borrowWSProvider(t *testing.T) {
+	rpcs := []config.RPC{{
+		Name: "mainnet",
+		Providers: []config.Provider{
+			{Name: "limited", ConnURL: "ws://limited", MaxWSConnections: 1},
+			{Name: "unlimited", ConnURL: "ws://unlimited"},
+		},
+	}}
+	providers := []balancer.Payload{{Name: "limited", URL: "ws://limited"}, {Name: "unlimited", URL: "ws://unlimited"}}
+
+	for _, tc := range []struct {
+		name string
+		lb   Balancer
+	}{
+		{name: config.RRName, lb: balancer.NewRoundRobin(providers)},
+		{name: config.LCName, lb: balancer.NewLeastConnection(providers)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &Server{nameToWSConnLimits: newNameToWSConnLimits(rpcs)}
+
+			var (
+				limited  balancer.Release
+				borrowed = make(map[string]int)
+			)
+			for range 10 {
+				payload, release := srv.borrowWSProvider("/mainnet", tc.lb, nil)
+				borrowed[payload.Name]++
+				if payload.Name == "limited" && limited == nil {
+					limited = release
+				}
+			}
+			// saturated provider is not selected for new sessions.
+			require.Equal(t, 1, borrowed["limited"])
+			require.Equal(t, 9, borrowed["unlimited"])
+
+			// closed session frees slot of provider.
+			limited(true, 0)
+			for range 2 {
+				payload, _ := srv.borrowWSProvider("/mainnet", tc.lb, nil)
+				borrowed[payload.Name]++
+			}
+			require.Equal(t, 2, borrowed["limited"])
+		})
+	}
+}
+
+func Test_Server_borrowWSProvider_allSaturated(t *testing.T) {
+	srv := &Server{nameToWSConnLimits: newNameToWSConnLimits([]config.RPC{{
+		Name:      "mainnet",
+		Providers: []config.Provider{{Name: "node", ConnURL: "ws://node", MaxWSConnections: 2}},
+	}})}
+	lb := balancer.NewRoundRobin([]balancer.Payload{{Name: "node", URL: "ws://node"}})
+
+	for range 2 {
+		payload, _ := srv.borrowWSProvider("/mainnet", lb, nil)
+		require.Equal(t, "node", payload.Name)
+	}
+	payload, _ := srv.borrowWSProvider("/mainnet", lb, nil)
+	require.Equal(t, balancer.Payload{}, payload)
+}
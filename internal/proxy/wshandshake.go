@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// handshakeConnState bounds time of reading request from connection, so client sending websocket
+// upgrade request slowly is disconnected after timeout. Request headers must be read before it is known
+// whether request is an upgrade, so the same bound applies to plain http requests.
+// Deadline is cleared once connection is idle, hijacked connections are cleared by fasthttp.
+func handshakeConnState(timeout time.Duration) func(net.Conn, fasthttp.ConnState) {
+	return func(conn net.Conn, state fasthttp.ConnState) {
+		switch state {
+		case fasthttp.StateActive:
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		case fasthttp.StateIdle:
+			_ = conn.SetReadDeadline(time.Time{})
+		default:
+		}
+	}
+}
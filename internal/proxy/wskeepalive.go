@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// sides of websocket connection closed by keepalive, used as metric label.
+const (
+	wsKeepaliveClient   = "client"
+	wsKeepaliveUpstream = "upstream"
+)
+
+// wsKeepalive sends ping frames to connection and extends its read deadline on every pong,
+// so reader of connection fails with timeout if peer stopped responding.
+type wsKeepalive struct {
+	conn     *websocket.Conn
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+// startWSKeepalive starts pinging conn, must be called before conn reader is started.
+func startWSKeepalive(conn *websocket.Conn, cfg config.WSKeepalive) *wsKeepalive {
+	k := &wsKeepalive{
+		conn:     conn,
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		done:     make(chan struct{}),
+	}
+	_ = conn.SetReadDeadline(k.deadline())
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(k.deadline())
+	})
+	go k.run()
+
+	return k
+}
+
+// deadline returns read deadline: next ping plus time given to peer to respond.
+func (k *wsKeepalive) deadline() time.Time {
+	return time.Now().Add(k.interval + k.timeout)
+}
+
+func (k *wsKeepalive) run() {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			// WriteControl is safe to call concurrently with other writers.
+			err := k.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(k.timeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// stop stops pinging, safe to call multiple times.
+func (k *wsKeepalive) stop() {
+	k.once.Do(func() { close(k.done) })
+}
+
+// isWSKeepaliveTimeout returns true if connection read failed because pong was not received in time.
+func isWSKeepaliveTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
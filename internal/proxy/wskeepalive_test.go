@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+func Test_wsKeepalive(t *testing.T) {
+	cfg := config.WSKeepalive{Interval: 20 * time.Millisecond, Timeout: 20 * time.Millisecond}
+
+	// dial returns connection to peer, which answers pings only if responsive.
+	dial := func(t *testing.T, responsive bool) *websocket.Conn {
+		t.Helper()
+
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgrader := websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if !responsive {
+				<-release
+				return
+			}
+			// pongs are sent by default ping handler while reading.
+			for {
+				if _, _, err = conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+		t.Cleanup(server.Close)
+		t.Cleanup(func() { close(release) })
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+strings.TrimPrefix(server.URL, "http://"), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	read := func(conn *websocket.Conn) chan error {
+		errChan := make(chan error, 1)
+		go func() {
+			_, _, err := conn.ReadMessage()
+			errChan <- err
+		}()
+		return errChan
+	}
+
+	t.Run("responsive peer", func(t *testing.T) {
+		conn := dial(t, true)
+		keepalive := startWSKeepalive(conn, cfg)
+		defer keepalive.stop()
+
+		errChan := read(conn)
+		select {
+		case err := <-errChan:
+			require.FailNow(t, "connection closed", err)
+		case <-time.After(10 * (cfg.Interval + cfg.Timeout)):
+		}
+	})
+	t.Run("dead peer", func(t *testing.T) {
+		conn := dial(t, false)
+		keepalive := startWSKeepalive(conn, cfg)
+		defer keepalive.stop()
+
+		select {
+		case err := <-read(conn):
+			require.True(t, isWSKeepaliveTimeout(err))
+		case <-time.After(time.Second):
+			require.FailNow(t, "dead connection is not detected")
+		}
+	})
+}
@@ -0,0 +1,692 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+const (
+	ethSubscribe    = "eth_subscribe"
+	ethUnsubscribe  = "eth_unsubscribe"
+	ethSubscription = "eth_subscription"
+)
+
+// wsMessageWriter writes raw websocket messages, implemented by wsLockedWriter.
+type wsMessageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// wsCountingWriter counts data messages written to client.
+type wsCountingWriter struct {
+	wsMessageWriter
+	count func()
+}
+
+// WriteMessage counts text messages and writes message of messageType to client.
+func (w wsCountingWriter) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.TextMessage {
+		w.count()
+	}
+	return w.wsMessageWriter.WriteMessage(messageType, data)
+}
+
+// wsMuxClient is a client session attached to multiplexed upstream connection.
+type wsMuxClient struct {
+	writer wsMessageWriter
+	// close terminates client session when upstream connection is lost.
+	close func()
+}
+
+// writeResult writes json-rpc result response to client.
+func (c *wsMuxClient) writeResult(id json.RawMessage, result any) error {
+	raw, err := json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result"`
+	}{JSONRPC: jsonRPCVersion, ID: id, Result: result})
+	if err != nil {
+		return fmt.Errorf("can not marshal result: %w", err)
+	}
+	return c.writer.WriteMessage(websocket.TextMessage, raw)
+}
+
+// writeJSON writes json encoded v to client.
+func (c *wsMuxClient) writeJSON(v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("can not marshal message: %w", err)
+	}
+	return c.writer.WriteMessage(websocket.TextMessage, raw)
+}
+
+// wsMuxPool holds multiplexed upstream connections by provider url.
+type wsMuxPool struct {
+	mutex sync.Mutex
+	muxes map[string]*wsMux
+	dial  func(url string, header http.Header) (*websocket.Conn, error)
+}
+
+func newWSMuxPool(dial func(url string, header http.Header) (*websocket.Conn, error)) *wsMuxPool {
+	return &wsMuxPool{
+		muxes: make(map[string]*wsMux),
+		dial:  dial,
+	}
+}
+
+// join attaches client to multiplexed connection of provider url, dialing it with handshake header if necessary.
+// Provider is dialed outside of pool lock, so slow handshake doesn't block joins to other providers.
+func (p *wsMuxPool) join(url string, header http.Header, client *wsMuxClient) (*wsMux, error) {
+	if mux := p.joinExisting(url, client); mux != nil {
+		return mux, nil
+	}
+
+	conn, err := p.dial(url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// concurrent join may have dialed the provider first, its connection is shared then.
+	if mux, ok := p.muxes[url]; ok && mux.addClient(client) {
+		_ = conn.Close()
+		return mux, nil
+	}
+	var mux *wsMux
+	mux = newWSMux(conn, func() { p.remove(url, mux) })
+	mux.addClient(client)
+	p.muxes[url] = mux
+	go mux.readLoop()
+
+	return mux, nil
+}
+
+// joinExisting attaches client to open multiplexed connection of provider url, nil is returned if there is none.
+func (p *wsMuxPool) joinExisting(url string, client *wsMuxClient) *wsMux {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if mux, ok := p.muxes[url]; ok && mux.addClient(client) {
+		return mux
+	}
+	return nil
+}
+
+// remove deletes closed mux from pool.
+func (p *wsMuxPool) remove(url string, mux *wsMux) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.muxes[url] == mux {
+		delete(p.muxes, url)
+	}
+}
+
+// wsMux shares one upstream websocket connection between many clients.
+//
+// Client request ids are rewritten to unique upstream ids and restored in responses.
+// Identical eth_subscribe calls share one upstream subscription, notifications are fanned out
+// to every subscriber, and upstream subscription is removed when its last subscriber leaves.
+type wsMux struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	onClose func()
+
+	mutex     sync.Mutex
+	closed    bool
+	nextID    uint64
+	clients   map[*wsMuxClient]struct{}
+	pending   map[uint64]*wsMuxPending
+	subsByKey map[string]*wsMuxSubscription
+	subsByID  map[string]*wsMuxSubscription
+}
+
+// wsMuxPending is a request sent upstream waiting for response.
+type wsMuxPending struct {
+	client *wsMuxClient // nil for requests made by rpcgate itself
+	id     json.RawMessage
+	sub    *wsMuxSubscription // not nil for eth_subscribe requests
+}
+
+// wsMuxSubscription is an upstream subscription shared by clients with identical params.
+type wsMuxSubscription struct {
+	key         string
+	upstreamID  string
+	subscribers map[*wsMuxClient]struct{}
+	waiting     []wsMuxWaiter
+}
+
+// wsMuxWaiter is a client waiting for upstream eth_subscribe response.
+type wsMuxWaiter struct {
+	client *wsMuxClient
+	id     json.RawMessage
+}
+
+// wsMuxRequest is a request sent upstream by rpcgate.
+type wsMuxRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      uint64            `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+func newWSMux(conn *websocket.Conn, onClose func()) *wsMux {
+	return &wsMux{
+		conn:      conn,
+		onClose:   onClose,
+		clients:   make(map[*wsMuxClient]struct{}),
+		pending:   make(map[uint64]*wsMuxPending),
+		subsByKey: make(map[string]*wsMuxSubscription),
+		subsByID:  make(map[string]*wsMuxSubscription),
+	}
+}
+
+// addClient attaches client, returns false if connection is already closed.
+func (m *wsMux) addClient(client *wsMuxClient) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return false
+	}
+	m.clients[client] = struct{}{}
+	return true
+}
+
+// register stores pending request and returns its upstream id, must be called under mutex.
+func (m *wsMux) register(pending *wsMuxPending) uint64 {
+	m.nextID++
+	m.pending[m.nextID] = pending
+	return m.nextID
+}
+
+// write sends message upstream.
+func (m *wsMux) write(msg []byte) error {
+	m.writeMu.Lock()
+	err := m.conn.WriteMessage(websocket.TextMessage, msg)
+	m.writeMu.Unlock()
+	if err != nil {
+		m.fail(err)
+		return fmt.Errorf("can not write to upstream: %w", err)
+	}
+	return nil
+}
+
+// writeRequest sends request made by rpcgate itself upstream.
+func (m *wsMux) writeRequest(id uint64, method string, params ...json.RawMessage) error {
+	raw, err := json.Marshal(wsMuxRequest{JSONRPC: jsonRPCVersion, ID: id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("can not marshal request: %w", err)
+	}
+	return m.write(raw)
+}
+
+// handle sends client message upstream.
+func (m *wsMux) handle(client *wsMuxClient, msg json.RawMessage) error {
+	if isBatch(msg) {
+		return m.handleBatch(client, msg)
+	}
+
+	var req wsRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return client.writeJSON(newJSONRPCErrorResponse(nil, jsonRPCParseErrorCode, "parse error"))
+	}
+	switch req.Method {
+	case ethSubscribe:
+		return m.subscribe(client, req)
+	case ethUnsubscribe:
+		return m.unsubscribe(client, req)
+	}
+	if req.ID == nil {
+		// notification doesn't expect response, so it is forwarded as is.
+		return m.write(msg)
+	}
+
+	m.mutex.Lock()
+	id := m.register(&wsMuxPending{client: client, id: req.ID})
+	m.mutex.Unlock()
+
+	rewritten, err := replaceJSONRPCID(msg, []byte(strconv.FormatUint(id, 10)))
+	if err != nil {
+		return client.writeJSON(newJSONRPCErrorResponse(req.ID, jsonRPCParseErrorCode, "parse error"))
+	}
+	return m.write(rewritten)
+}
+
+// handleBatch rewrites ids of batch requests and sends batch upstream.
+// Subscriptions can't be multiplexed inside batch, so such batches are rejected.
+func (m *wsMux) handleBatch(client *wsMuxClient, msg json.RawMessage) error {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(msg, &batch); err != nil {
+		return client.writeJSON(newJSONRPCErrorResponse(nil, jsonRPCParseErrorCode, "parse error"))
+	}
+	requests := make([]wsRequest, len(batch))
+	for i, raw := range batch {
+		if err := json.Unmarshal(raw, &requests[i]); err != nil {
+			return client.writeJSON(newJSONRPCErrorResponse(nil, jsonRPCParseErrorCode, "parse error"))
+		}
+	}
+	if slices.ContainsFunc(requests, func(req wsRequest) bool {
+		return req.Method == ethSubscribe || req.Method == ethUnsubscribe
+	}) {
+		resp := make([]jsonRPCErrorResponse, 0, len(requests))
+		for _, req := range requests {
+			resp = append(resp, newJSONRPCErrorResponse(req.ID, jsonRPCInvalidRequestCode,
+				"subscriptions in batch are not supported"))
+		}
+		return client.writeJSON(resp)
+	}
+
+	m.mutex.Lock()
+	for i, req := range requests {
+		if req.ID == nil {
+			continue
+		}
+		id := m.register(&wsMuxPending{client: client, id: req.ID})
+		rewritten, err := replaceJSONRPCID(batch[i], []byte(strconv.FormatUint(id, 10)))
+		if err != nil {
+			m.mutex.Unlock()
+			return client.writeJSON(newJSONRPCErrorResponse(nil, jsonRPCParseErrorCode, "parse error"))
+		}
+		batch[i] = rewritten
+	}
+	m.mutex.Unlock()
+
+	raw, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("can not marshal batch: %w", err)
+	}
+	return m.write(raw)
+}
+
+// subscribe attaches client to existing upstream subscription with identical params or creates new one.
+func (m *wsMux) subscribe(client *wsMuxClient, req wsRequest) error {
+	key, err := json.Marshal(req.Params)
+	if err != nil {
+		return client.writeJSON(newJSONRPCErrorResponse(req.ID, jsonRPCParseErrorCode, "parse error"))
+	}
+
+	m.mutex.Lock()
+	sub, exist := m.subsByKey[string(key)]
+	if exist && sub.upstreamID != "" {
+		sub.subscribers[client] = struct{}{}
+		m.mutex.Unlock()
+		return client.writeResult(req.ID, sub.upstreamID)
+	}
+	if exist {
+		sub.waiting = append(sub.waiting, wsMuxWaiter{client: client, id: req.ID})
+		m.mutex.Unlock()
+		return nil
+	}
+	sub = &wsMuxSubscription{
+		key:         string(key),
+		subscribers: make(map[*wsMuxClient]struct{}),
+		waiting:     []wsMuxWaiter{{client: client, id: req.ID}},
+	}
+	m.subsByKey[sub.key] = sub
+	id := m.register(&wsMuxPending{sub: sub})
+	m.mutex.Unlock()
+
+	return m.writeRequest(id, ethSubscribe, req.Params...)
+}
+
+// unsubscribe detaches client from subscription, upstream is unsubscribed when last subscriber leaves.
+func (m *wsMux) unsubscribe(client *wsMuxClient, req wsRequest) error {
+	var subID string
+	if len(req.Params) > 0 {
+		_ = json.Unmarshal(req.Params[0], &subID)
+	}
+
+	m.mutex.Lock()
+	sub, exist := m.subsByID[subID]
+	if !exist {
+		m.mutex.Unlock()
+		return client.writeResult(req.ID, false)
+	}
+	if _, subscribed := sub.subscribers[client]; !subscribed {
+		m.mutex.Unlock()
+		return client.writeResult(req.ID, false)
+	}
+	delete(sub.subscribers, client)
+	id, last := m.removeIfUnused(sub)
+	m.mutex.Unlock()
+
+	if last {
+		if err := m.writeRequest(id, ethUnsubscribe, json.RawMessage(strconv.Quote(subID))); err != nil {
+			return err
+		}
+	}
+	return client.writeResult(req.ID, true)
+}
+
+// removeIfUnused removes subscription without subscribers and returns id of upstream
+// eth_unsubscribe request to be sent, must be called under mutex.
+func (m *wsMux) removeIfUnused(sub *wsMuxSubscription) (uint64, bool) {
+	if len(sub.subscribers) > 0 || len(sub.waiting) > 0 || sub.upstreamID == "" {
+		return 0, false
+	}
+	delete(m.subsByKey, sub.key)
+	delete(m.subsByID, sub.upstreamID)
+	return m.register(&wsMuxPending{}), true
+}
+
+// leave detaches client, removes its subscriptions and pending requests.
+// Upstream connection is closed when last client leaves.
+func (m *wsMux) leave(client *wsMuxClient) {
+	type unsubscribe struct {
+		id    uint64
+		subID string
+	}
+
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+	delete(m.clients, client)
+	for id, pending := range m.pending {
+		if pending.client == client {
+			delete(m.pending, id)
+		}
+	}
+	if len(m.clients) == 0 {
+		m.closed = true
+		m.mutex.Unlock()
+
+		m.onClose()
+		_ = m.conn.Close()
+		return
+	}
+	var unsubscribes []unsubscribe
+	for _, sub := range m.subsByKey {
+		delete(sub.subscribers, client)
+		sub.waiting = slices.DeleteFunc(sub.waiting, func(w wsMuxWaiter) bool { return w.client == client })
+		if id, last := m.removeIfUnused(sub); last {
+			unsubscribes = append(unsubscribes, unsubscribe{id: id, subID: sub.upstreamID})
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, u := range unsubscribes {
+		if err := m.writeRequest(u.id, ethUnsubscribe, json.RawMessage(strconv.Quote(u.subID))); err != nil {
+			return
+		}
+	}
+}
+
+// fail closes upstream connection and all attached clients.
+func (m *wsMux) fail(err error) {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+	m.closed = true
+	clients := make([]*wsMuxClient, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.Unlock()
+
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		log.Err(err).Int("clients", len(clients)).Msg("multiplexed upstream error")
+	}
+	m.onClose()
+	_ = m.conn.Close()
+	for _, client := range clients {
+		client.close()
+	}
+}
+
+// readLoop dispatches upstream messages to clients until connection is closed.
+func (m *wsMux) readLoop() {
+	for {
+		_, raw, err := m.conn.ReadMessage()
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		if isEmptyWSMessage(raw) {
+			continue
+		}
+		if isBatch(raw) {
+			m.dispatchBatch(raw)
+			continue
+		}
+		m.dispatch(raw)
+	}
+}
+
+// dispatch sends upstream notification to subscribers or response to requesting client.
+func (m *wsMux) dispatch(raw []byte) {
+	var msg wsMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		log.Error().Err(err).Msg("can not parse multiplexed upstream message")
+		return
+	}
+
+	if msg.Method == ethSubscription {
+		m.mutex.Lock()
+		var subscribers []*wsMuxClient
+		if sub, exist := m.subsByID[msg.Params.Subscription]; exist {
+			for client := range sub.subscribers {
+				subscribers = append(subscribers, client)
+			}
+		}
+		m.mutex.Unlock()
+
+		for _, client := range subscribers {
+			_ = client.writer.WriteMessage(websocket.TextMessage, raw)
+		}
+		return
+	}
+
+	id, err := strconv.ParseUint(string(msg.ID), 10, 64)
+	if err != nil {
+		return
+	}
+	m.mutex.Lock()
+	pending, exist := m.pending[id]
+	delete(m.pending, id)
+	m.mutex.Unlock()
+	if !exist {
+		return
+	}
+
+	if pending.sub != nil {
+		m.dispatchSubscribed(pending.sub, msg.Result, raw)
+		return
+	}
+	if pending.client == nil {
+		return
+	}
+	rewritten, err := replaceJSONRPCID(raw, pending.id)
+	if err != nil {
+		return
+	}
+	_ = pending.client.writer.WriteMessage(websocket.TextMessage, rewritten)
+}
+
+// dispatchSubscribed completes upstream eth_subscribe, responding to all waiting clients.
+func (m *wsMux) dispatchSubscribed(sub *wsMuxSubscription, result json.RawMessage, raw []byte) {
+	var upstreamID string
+	_ = json.Unmarshal(result, &upstreamID)
+
+	m.mutex.Lock()
+	waiting := sub.waiting
+	sub.waiting = nil
+	if upstreamID == "" {
+		delete(m.subsByKey, sub.key)
+		m.mutex.Unlock()
+
+		for _, w := range waiting {
+			if rewritten, err := replaceJSONRPCID(raw, w.id); err == nil {
+				_ = w.client.writer.WriteMessage(websocket.TextMessage, rewritten)
+			}
+		}
+		return
+	}
+	sub.upstreamID = upstreamID
+	m.subsByID[upstreamID] = sub
+	for _, w := range waiting {
+		sub.subscribers[w.client] = struct{}{}
+	}
+	// all waiting clients could leave before upstream responded.
+	unsubscribeID, unused := m.removeIfUnused(sub)
+	m.mutex.Unlock()
+
+	if unused {
+		_ = m.writeRequest(unsubscribeID, ethUnsubscribe, json.RawMessage(strconv.Quote(upstreamID)))
+		return
+	}
+	for _, w := range waiting {
+		_ = w.client.writeResult(w.id, upstreamID)
+	}
+}
+
+// dispatchBatch restores client ids in batch response and sends it to requesting client.
+func (m *wsMux) dispatchBatch(raw []byte) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(raw, &batch); err != nil {
+		log.Error().Err(err).Msg("can not parse multiplexed upstream batch")
+		return
+	}
+
+	var client *wsMuxClient
+	resp := make([]json.RawMessage, 0, len(batch))
+	for _, elem := range batch {
+		var msg wsMessage
+		if err := json.Unmarshal(elem, &msg); err != nil {
+			continue
+		}
+		id, err := strconv.ParseUint(string(msg.ID), 10, 64)
+		if err != nil {
+			continue
+		}
+		m.mutex.Lock()
+		pending, exist := m.pending[id]
+		delete(m.pending, id)
+		m.mutex.Unlock()
+		if !exist || pending.client == nil {
+			continue
+		}
+		rewritten, err := replaceJSONRPCID(elem, pending.id)
+		if err != nil {
+			continue
+		}
+		client = pending.client
+		resp = append(resp, rewritten)
+	}
+	if client == nil {
+		return
+	}
+	_ = client.writeJSON(resp)
+}
+
+// replaceJSONRPCID returns json-rpc message with id field replaced.
+func replaceJSONRPCID(msg []byte, id json.RawMessage) ([]byte, error) {
+	if len(bytes.TrimSpace(id)) == 0 {
+		id = json.RawMessage("null")
+	}
+	return replaceJSONField(msg, "id", id)
+}
+
+// replaceJSONField returns json object with top level field replaced.
+func replaceJSONField(msg []byte, field string, value json.RawMessage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return nil, fmt.Errorf("can not parse message: %w", err)
+	}
+	fields[field] = value
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("can not marshal message: %w", err)
+	}
+	return raw, nil
+}
+
+// wsMuxHandler serves client over multiplexed upstream connection of borrowed provider.
+func (srv *Server) wsMuxHandler(ctx *WSContext) {
+	clientConn := &wsLockedWriter{conn: ctx.conn}
+	client := &wsMuxClient{
+		writer: wsCountingWriter{wsMessageWriter: clientConn, count: func() {
+			metrics.WSMessages.WithLabelValues(ctx.rpcName, ctx.providerName, metrics.WSUpstreamToClient).Inc()
+		}},
+		close: func() {
+			msg := fmt.Sprintf("upstream [%s] closed connection", ctx.providerName)
+			_ = clientConn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, msg))
+			_ = ctx.conn.Close()
+		},
+	}
+	mux, err := srv.wsMuxes.join(ctx.providerURL, srv.wsHandshakeHeaders(ctx, ctx.providerName), client)
+	if err != nil {
+		_ = ctx.conn.WriteMessage(websocket.CloseMessage, nil)
+		log.Error().
+			Err(err).
+			Uint64("request_id", ctx.requestID).
+			Str("provider", ctx.providerName).
+			Msg("can not init connection to provider")
+		return
+	}
+	defer mux.leave(client)
+
+	if keepalive, ok := srv.nameToWSKeepalive[ctx.requestPath]; ok {
+		defer startWSKeepalive(ctx.conn, keepalive).stop()
+	}
+	ctx.conn.SetReadLimit(srv.nameToWSMaxMessage[ctx.requestPath])
+
+	_, closeOnEmpty := srv.wsCloseOnEmpty[ctx.requestPath]
+	for {
+		var msg json.RawMessage
+		if msg, err = readWSMessage(ctx.conn, closeOnEmpty); err != nil {
+			break
+		}
+		metrics.WSMessages.WithLabelValues(ctx.rpcName, ctx.providerName, metrics.WSClientToUpstream).Inc()
+		ctx.method = srv.extractMethodFromBody(msg)
+		metrics.RequestTotalCounter.WithLabelValues(srv.wsStatusMetricLabels(ctx)...).
+			Inc()
+
+		if rejection, code, rejected := srv.rejectedWSMessage(ctx, msg); rejected {
+			log.Info().Uint64("request_id", ctx.requestID).Str("client", ctx.client).Msg("request rejected")
+			metrics.ClientRequestError.WithLabelValues(srv.wsErrorMetricLabels(ctx, code)...).
+				Inc()
+			if err = clientConn.WriteJSON(rejection); err != nil {
+				break
+			}
+			continue
+		}
+		if err = mux.handle(client, msg); err != nil {
+			break
+		}
+	}
+	if errors.Is(err, errWSEmptyMessage) {
+		_ = clientConn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, err.Error()))
+	}
+	if isWSKeepaliveTimeout(err) {
+		metrics.WSKeepaliveClosed.WithLabelValues(ctx.rpcName, wsKeepaliveClient).Inc()
+	}
+	if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) &&
+		!errors.Is(err, net.ErrClosed) {
+		log.Err(err).Uint64("request_id", ctx.requestID).Str("client", ctx.client).Msg("client error")
+	}
+	log.Info().
+		Uint64("request_id", ctx.requestID).
+		Str("client", ctx.client).
+		Str("provider", ctx.providerName).
+		Msg("websocket closed")
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWSUpstream struct {
+	mutex       sync.Mutex
+	conns       []*websocket.Conn
+	methods     []string
+	subscribes  int
+	unsubscribe []string
+	closed      int
+}
+
+func (u *fakeWSUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	u.mutex.Lock()
+	u.conns = append(u.conns, conn)
+	u.mutex.Unlock()
+	defer func() {
+		u.mutex.Lock()
+		u.closed++
+		u.mutex.Unlock()
+	}()
+
+	for {
+		var raw []byte
+		_, raw, err = conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		u.mutex.Lock()
+		var resp any
+		if isBatch(raw) {
+			var batch []json.RawMessage
+			_ = json.Unmarshal(raw, &batch)
+			responses := make([]any, 0, len(batch))
+			for _, req := range batch {
+				responses = append(responses, u.respond(req))
+			}
+			resp = responses
+		} else {
+			resp = u.respond(raw)
+		}
+		err = conn.WriteJSON(resp)
+		u.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// respond returns response to request, must be called under mutex.
+func (u *fakeWSUpstream) respond(raw []byte) any {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	_ = json.Unmarshal(raw, &req)
+
+	u.methods = append(u.methods, req.Method)
+	var result any = req.Method
+	switch req.Method {
+	case ethSubscribe:
+		u.subscribes++
+		result = fmt.Sprintf("0x%d", u.subscribes)
+	case ethUnsubscribe:
+		var subID string
+		_ = json.Unmarshal(req.Params[0], &subID)
+		u.unsubscribe = append(u.unsubscribe, subID)
+		result = true
+	}
+	return map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result}
+}
+
+func (u *fakeWSUpstream) notify(t *testing.T, subID string) {
+	t.Helper()
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	require.Len(t, u.conns, 1)
+	require.NoError(t, u.conns[0].WriteJSON(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  ethSubscription,
+		"params":  map[string]any{"subscription": subID, "result": "0xblock"},
+	}))
+}
+
+func (u *fakeWSUpstream) received(method string) int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	count := 0
+	for _, m := range u.methods {
+		if m == method {
+			count++
+		}
+	}
+	return count
+}
+
+type fakeWSMuxWriter struct {
+	messages chan []byte
+}
+
+func (w *fakeWSMuxWriter) WriteMessage(_ int, data []byte) error {
+	w.messages <- data
+	return nil
+}
+
+func newFakeWSMuxClient() (*wsMuxClient, chan []byte) {
+	messages := make(chan []byte, 16)
+	return &wsMuxClient{writer: &fakeWSMuxWriter{messages: messages}, close: func() {}}, messages
+}
+
+func receive(t *testing.T, messages chan []byte) map[string]any {
+	t.Helper()
+
+	select {
+	case raw := <-messages:
+		var msg map[string]any
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		return msg
+	case <-time.After(time.Second):
+		require.FailNow(t, "message not received")
+		return nil
+	}
+}
+
+func Test_wsMux(t *testing.T) {
+	upstream := &fakeWSUpstream{}
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+	url := "ws://" + strings.TrimPrefix(server.URL, "http://")
+
+	pool := newWSMuxPool(func(url string, header http.Header) (*websocket.Conn, error) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		return conn, err
+	})
+	client1, messages1 := newFakeWSMuxClient()
+	client2, messages2 := newFakeWSMuxClient()
+
+	mux, err := pool.join(url, nil, client1)
+	require.NoError(t, err)
+	mux2, err := pool.join(url, nil, client2)
+	require.NoError(t, err)
+	require.Same(t, mux, mux2)
+
+	// identical subscriptions share one upstream subscription.
+	const subscribe = `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`
+	require.NoError(t, mux.handle(client1, json.RawMessage(subscribe)))
+	resp := receive(t, messages1)
+	require.InDelta(t, 1, resp["id"], 0)
+	require.Equal(t, "0x1", resp["result"])
+	require.NoError(t, mux.handle(client2, json.RawMessage(subscribe)))
+	resp = receive(t, messages2)
+	require.InDelta(t, 1, resp["id"], 0)
+	require.Equal(t, "0x1", resp["result"])
+	require.Equal(t, 1, upstream.received(ethSubscribe))
+
+	// request ids of clients are rewritten, so equal ids don't collide.
+	const blockNumber = `{"jsonrpc":"2.0","id":7,"method":"eth_blockNumber","params":[]}`
+	require.NoError(t, mux.handle(client1, json.RawMessage(blockNumber)))
+	require.NoError(t, mux.handle(client2, json.RawMessage(blockNumber)))
+	for _, messages := range []chan []byte{messages1, messages2} {
+		resp = receive(t, messages)
+		require.InDelta(t, 7, resp["id"], 0)
+		require.Equal(t, "eth_blockNumber", resp["result"])
+	}
+	require.Empty(t, messages1)
+	require.Empty(t, messages2)
+
+	// notifications are fanned out to every subscriber.
+	upstream.notify(t, "0x1")
+	for _, messages := range []chan []byte{messages1, messages2} {
+		resp = receive(t, messages)
+		require.Equal(t, ethSubscription, resp["method"])
+	}
+
+	// upstream is unsubscribed only when last subscriber leaves.
+	const unsubscribe = `{"jsonrpc":"2.0","id":"unsub","method":"eth_unsubscribe","params":["0x1"]}`
+	require.NoError(t, mux.handle(client1, json.RawMessage(unsubscribe)))
+	resp = receive(t, messages1)
+	require.Equal(t, "unsub", resp["id"])
+	require.Equal(t, true, resp["result"])
+	require.NoError(t, mux.handle(client1, json.RawMessage(unsubscribe)))
+	resp = receive(t, messages1)
+	require.Equal(t, false, resp["result"])
+	require.Equal(t, 0, upstream.received(ethUnsubscribe))
+
+	upstream.notify(t, "0x1")
+	resp = receive(t, messages2)
+	require.Equal(t, ethSubscription, resp["method"])
+	require.Empty(t, messages1)
+
+	mux.leave(client2)
+	require.Eventually(t, func() bool {
+		return upstream.received(ethUnsubscribe) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// upstream connection is closed when last client leaves.
+	mux.leave(client1)
+	pool.mutex.Lock()
+	require.Empty(t, pool.muxes)
+	pool.mutex.Unlock()
+}
+
+func Test_wsMux_batchSubscription(t *testing.T) {
+	upstream := &fakeWSUpstream{}
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+strings.TrimPrefix(server.URL, "http://"), nil)
+	require.NoError(t, err)
+	mux := newWSMux(conn, func() {})
+	defer mux.fail(websocket.ErrCloseSent)
+	go mux.readLoop()
+
+	client, messages := newFakeWSMuxClient()
+	require.True(t, mux.addClient(client))
+
+	require.NoError(t, mux.handle(client, json.RawMessage(
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},`+
+			`{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["newHeads"]}]`,
+	)))
+	var resp []jsonRPCErrorResponse
+	require.NoError(t, json.Unmarshal(<-messages, &resp))
+	require.Len(t, resp, 2)
+	require.Equal(t, int64(jsonRPCInvalidRequestCode), resp[0].Error.Code)
+	require.Equal(t, 0, upstream.received(ethSubscribe))
+
+	require.NoError(t, mux.handle(client, json.RawMessage(
+		`[{"jsonrpc":"2.0","id":"a","method":"eth_blockNumber"},{"jsonrpc":"2.0","id":"b","method":"eth_chainId"}]`,
+	)))
+	var batch []map[string]any
+	require.NoError(t, json.Unmarshal(<-messages, &batch))
+	require.Len(t, batch, 2)
+	require.Equal(t, "a", batch[0]["id"])
+	require.Equal(t, "b", batch[1]["id"])
+}
+
+func Test_wsMuxPool_join_concurrentDial(t *testing.T) {
+	upstream := &fakeWSUpstream{}
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+	url := "ws://" + strings.TrimPrefix(server.URL, "http://")
+
+	slow := make(chan struct{})
+	var dials sync.WaitGroup
+	dials.Add(2)
+	pool := newWSMuxPool(func(dialURL string, header http.Header) (*websocket.Conn, error) {
+		if dialURL == "ws://slow" {
+			<-slow
+			return nil, websocket.ErrBadHandshake
+		}
+		dials.Done()
+		// both joins dial before either stores its connection.
+		dials.Wait()
+		conn, _, err := websocket.DefaultDialer.Dial(dialURL, header)
+		return conn, err
+	})
+
+	slowDone := make(chan error)
+	go func() {
+		client, _ := newFakeWSMuxClient()
+		_, err := pool.join("ws://slow", nil, client)
+		slowDone <- err
+	}()
+
+	// slow handshake of one provider doesn't block joins to another.
+	muxes := make(chan *wsMux, 2)
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			client, _ := newFakeWSMuxClient()
+			mux, err := pool.join(url, nil, client)
+			errs <- err
+			muxes <- mux
+		}()
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.Same(t, <-muxes, <-muxes)
+
+	// connection of losing join is closed, the winning one is kept.
+	require.Eventually(t, func() bool {
+		upstream.mutex.Lock()
+		defer upstream.mutex.Unlock()
+		return len(upstream.conns) == 2 && upstream.closed == 1
+	}, time.Second, 10*time.Millisecond)
+
+	close(slow)
+	require.ErrorIs(t, <-slowDone, websocket.ErrBadHandshake)
+	pool.mutex.Lock()
+	require.Len(t, pool.muxes, 1)
+	pool.mutex.Unlock()
+}
@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/rs/zerolog/log"
+
+	"github.com/BinaryArchaism/rpcgate/internal/balancer"
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+// wsSubscriptions tracks eth_subscribe calls of client, so they can be replayed after reconnect.
+// Client keeps subscription ids returned by first upstream, ids of replayed subscriptions
+// are rewritten in notifications and eth_unsubscribe calls.
+type wsSubscriptions struct {
+	mutex      sync.Mutex
+	nextID     uint64
+	pending    map[string]json.RawMessage // request id -> eth_subscribe waiting for response
+	active     map[string]json.RawMessage // client subscription id -> eth_subscribe request
+	toClient   map[string]string          // upstream subscription id -> client subscription id
+	toUpstream map[string]string          // client subscription id -> upstream subscription id
+	replayed   map[string]string          // replayed request id -> client subscription id
+}
+
+func newWSSubscriptions() *wsSubscriptions {
+	return &wsSubscriptions{
+		pending:    make(map[string]json.RawMessage),
+		active:     make(map[string]json.RawMessage),
+		toClient:   make(map[string]string),
+		toUpstream: make(map[string]string),
+		replayed:   make(map[string]string),
+	}
+}
+
+// trackRequest remembers client eth_subscribe and rewrites eth_unsubscribe to upstream subscription id.
+// Batches are not tracked.
+func (s *wsSubscriptions) trackRequest(msg json.RawMessage) json.RawMessage {
+	if isBatch(msg) {
+		return msg
+	}
+	var req wsRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return msg
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch req.Method {
+	case ethSubscribe:
+		if req.ID != nil {
+			s.pending[string(req.ID)] = msg
+		}
+	case ethUnsubscribe:
+		if len(req.Params) == 0 {
+			return msg
+		}
+		var clientID string
+		if err := json.Unmarshal(req.Params[0], &clientID); err != nil {
+			return msg
+		}
+		upstreamID, exist := s.toUpstream[clientID]
+		if !exist {
+			return msg
+		}
+		delete(s.active, clientID)
+		delete(s.toUpstream, clientID)
+		delete(s.toClient, upstreamID)
+		if upstreamID == clientID {
+			return msg
+		}
+		rewritten, err := replaceJSONField(msg, "params", json.RawMessage(`[`+strconv.Quote(upstreamID)+`]`))
+		if err != nil {
+			return msg
+		}
+		return rewritten
+	}
+	return msg
+}
+
+// trackResponse activates subscriptions confirmed by upstream and rewrites notifications
+// to client subscription ids. Returns false if msg is a response to replayed request
+// and must not be forwarded to client.
+func (s *wsSubscriptions) trackResponse(msg json.RawMessage) (json.RawMessage, bool) {
+	if isBatch(msg) {
+		return msg, true
+	}
+	var resp wsMessage
+	if err := json.Unmarshal(msg, &resp); err != nil {
+		return msg, true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if resp.Method == ethSubscription {
+		clientID, exist := s.toClient[resp.Params.Subscription]
+		if !exist || clientID == resp.Params.Subscription {
+			return msg, true
+		}
+		return s.rewriteNotification(msg, clientID), true
+	}
+	if resp.ID == nil {
+		return msg, true
+	}
+
+	var upstreamID string
+	_ = json.Unmarshal(resp.Result, &upstreamID)
+	if clientID, exist := s.replayed[string(resp.ID)]; exist {
+		delete(s.replayed, string(resp.ID))
+		if upstreamID == "" {
+			delete(s.active, clientID)
+			log.Warn().Str("subscription", clientID).Msg("can not replay websocket subscription")
+			return nil, false
+		}
+		s.toClient[upstreamID] = clientID
+		s.toUpstream[clientID] = upstreamID
+		return nil, false
+	}
+	if req, exist := s.pending[string(resp.ID)]; exist {
+		delete(s.pending, string(resp.ID))
+		if upstreamID != "" {
+			s.active[upstreamID] = req
+			s.toClient[upstreamID] = upstreamID
+			s.toUpstream[upstreamID] = upstreamID
+		}
+	}
+	return msg, true
+}
+
+// rewriteNotification replaces subscription id of eth_subscription notification, must be called under mutex.
+func (s *wsSubscriptions) rewriteNotification(msg json.RawMessage, clientID string) json.RawMessage {
+	var notification struct {
+		Params map[string]json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &notification); err != nil {
+		return msg
+	}
+	notification.Params["subscription"] = json.RawMessage(strconv.Quote(clientID))
+	params, err := json.Marshal(notification.Params)
+	if err != nil {
+		return msg
+	}
+	rewritten, err := replaceJSONField(msg, "params", params)
+	if err != nil {
+		return msg
+	}
+	return rewritten
+}
+
+// replay returns requests restoring client subscriptions on new upstream connection.
+// Subscriptions waiting for response are resent as is, so client receives the response.
+func (s *wsSubscriptions) replay() []json.RawMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clear(s.toClient)
+	clear(s.toUpstream)
+	clear(s.replayed)
+
+	requests := make([]json.RawMessage, 0, len(s.pending)+len(s.active))
+	for _, req := range s.pending {
+		requests = append(requests, req)
+	}
+	for clientID, req := range s.active {
+		s.nextID++
+		id := strconv.Quote("rpcgate-replay-" + strconv.FormatUint(s.nextID, 10))
+		rewritten, err := replaceJSONRPCID(req, json.RawMessage(id))
+		if err != nil {
+			continue
+		}
+		s.replayed[id] = clientID
+		requests = append(requests, rewritten)
+	}
+	return requests
+}
+
+// wsUpstreamConn is upstream websocket connection which is replaced on reconnect.
+type wsUpstreamConn struct {
+	mutex         sync.Mutex
+	conn          *websocket.Conn
+	subscriptions *wsSubscriptions // nil if reconnect is disabled
+	// release returns provider borrowed on reconnect to balancer.
+	release balancer.Release
+}
+
+// WriteJSON writes message to current upstream connection.
+// With reconnect enabled write errors are ignored, because broken connection
+// is noticed by upstream reader, which reconnects and replays subscriptions.
+func (u *wsUpstreamConn) WriteJSON(v any) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if msg, ok := v.(json.RawMessage); ok && u.subscriptions != nil {
+		v = u.subscriptions.trackRequest(msg)
+	}
+	err := u.conn.WriteJSON(v)
+	if u.subscriptions != nil {
+		return nil
+	}
+	return err
+}
+
+// WriteMessage writes message of messageType to current upstream connection.
+func (u *wsUpstreamConn) WriteMessage(messageType int, data []byte) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.conn.WriteMessage(messageType, data)
+}
+
+// swap replaces broken connection and replays client subscriptions on new one.
+func (u *wsUpstreamConn) swap(conn *websocket.Conn, release balancer.Release) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	_ = u.conn.Close()
+	if u.release != nil {
+		u.release(false, 0)
+	}
+	u.conn = conn
+	u.release = release
+
+	for _, req := range u.subscriptions.replay() {
+		if err := conn.WriteJSON(req); err != nil {
+			return fmt.Errorf("can not replay subscription: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes current upstream connection.
+func (u *wsUpstreamConn) Close() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.release != nil {
+		u.release(true, 0)
+		u.release = nil
+	}
+	return u.conn.Close()
+}
+
+// wsReconnect redials upstream after connection drop, possibly to another provider,
+// and replays client subscriptions on new connection.
+func (srv *Server) wsReconnect(
+	ctx *WSContext, cfg config.WSReconnect, upstream *wsUpstreamConn,
+) (*websocket.Conn, error) {
+	lb, _ := srv.getBalancer(ctx.requestPath)
+	if lb == nil {
+		return nil, errors.New("no balancer configured for rpc")
+	}
+
+	var (
+		backoff = cfg.Backoff
+		err     error
+	)
+	for attempt := 1; attempt <= cfg.Attempts; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+
+		payload, release := srv.borrowWSProvider(ctx.requestPath, lb, nil)
+		var conn *websocket.Conn
+		conn, err = srv.initWSConnWithProvider(payload.URL, srv.wsHandshakeHeaders(ctx, payload.Name))
+		if err != nil {
+			release(false, 0)
+			log.Warn().
+				Err(err).
+				Uint64("request_id", ctx.requestID).
+				Str("provider", payload.Name).
+				Int("attempt", attempt).
+				Msg("websocket reconnect failed")
+			continue
+		}
+		if err = upstream.swap(conn, release); err != nil {
+			continue
+		}
+		log.Info().
+			Uint64("request_id", ctx.requestID).
+			Str("provider", payload.Name).
+			Int("attempt", attempt).
+			Msg("websocket reconnected")
+		return conn, nil
+	}
+	return nil, fmt.Errorf("can not reconnect after %d attempts: %w", cfg.Attempts, err)
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_wsSubscriptions_replay(t *testing.T) {
+	subs := newWSSubscriptions()
+
+	subscribe := json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`)
+	require.JSONEq(t, string(subscribe), string(subs.trackRequest(subscribe)))
+	resp, forward := subs.trackResponse(json.RawMessage(`{"jsonrpc":"2.0","id":1,"result":"0xold"}`))
+	require.True(t, forward)
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0xold"}`, string(resp))
+
+	pending := json.RawMessage(`{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["logs",{}]}`)
+	subs.trackRequest(pending)
+
+	// active subscription is replayed with internal id, pending one is resent as is.
+	requests := subs.replay()
+	require.Len(t, requests, 2)
+	var replayID json.RawMessage
+	for _, req := range requests {
+		var r wsRequest
+		require.NoError(t, json.Unmarshal(req, &r))
+		if string(r.ID) == "2" {
+			require.JSONEq(t, string(pending), string(req))
+			continue
+		}
+		replayID = r.ID
+	}
+	require.NotNil(t, replayID)
+
+	resp, forward = subs.trackResponse(json.RawMessage(`{"jsonrpc":"2.0","id":` + string(replayID) + `,"result":"0xnew"}`))
+	require.False(t, forward)
+	require.Nil(t, resp)
+	resp, forward = subs.trackResponse(json.RawMessage(`{"jsonrpc":"2.0","id":2,"result":"0xlogs"}`))
+	require.True(t, forward)
+	require.Contains(t, string(resp), "0xlogs")
+
+	// notifications of replayed subscription keep client subscription id.
+	resp, forward = subs.trackResponse(json.RawMessage(
+		`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xnew","result":{"number":"0x1"}}}`,
+	))
+	require.True(t, forward)
+	require.JSONEq(t,
+		`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xold","result":{"number":"0x1"}}}`,
+		string(resp),
+	)
+	resp, _ = subs.trackResponse(json.RawMessage(
+		`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xlogs","result":{}}}`,
+	))
+	require.Contains(t, string(resp), "0xlogs")
+
+	// unsubscribe is sent upstream with upstream subscription id.
+	unsubscribe := subs.trackRequest(json.RawMessage(`{"jsonrpc":"2.0","id":3,"method":"eth_unsubscribe","params":["0xold"]}`))
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":3,"method":"eth_unsubscribe","params":["0xnew"]}`, string(unsubscribe))
+	require.Len(t, subs.replay(), 1)
+}
@@ -2,20 +2,31 @@ package startstop
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
 )
 
-const shutdownTimeout = 5 * time.Second
+// forceStopGrace is time given to components to force remaining work to stop after shutdown timeout,
+// before they are reported as not stopped.
+const forceStopGrace = time.Second
 
 type StartStop interface {
 	Start(ctx context.Context)
 	Stop()
 }
 
-func RunGracefull(ctx context.Context, srvs ...StartStop) {
+// named is implemented by components which have name in shutdown logs and metrics,
+// type of component is used otherwise.
+type named interface {
+	Name() string
+}
+
+func RunGracefull(ctx context.Context, shutdownTimeout time.Duration, srvs ...StartStop) {
 	log.Info().Msg("Starting application")
 	for _, srv := range srvs {
 		go srv.Start(ctx)
@@ -23,10 +34,40 @@ func RunGracefull(ctx context.Context, srvs ...StartStop) {
 
 	<-ctx.Done()
 	log.Info().Msg("Stopping application")
-	timer := time.Tick(shutdownTimeout)
-	wg := sync.WaitGroup{}
-	for _, srv := range srvs {
-		wg.Go(srv.Stop)
+	shutdown(shutdownTimeout+forceStopGrace, srvs...)
+}
+
+// shutdown stops components concurrently and waits for them up to timeout.
+// Stop duration of every component is logged and exported, components still stopping
+// when timeout is exceeded are reported by name.
+func shutdown(timeout time.Duration, srvs ...StartStop) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// pending is filled before components are stopped and keyed by index, as components may share name.
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		pending = make(map[int]string, len(srvs))
+	)
+	names := make([]string, len(srvs))
+	for i, srv := range srvs {
+		names[i] = componentName(srv)
+		pending[i] = names[i]
+	}
+	for i, srv := range srvs {
+		name := names[i]
+		wg.Go(func() {
+			start := time.Now()
+			srv.Stop()
+			duration := time.Since(start)
+
+			metrics.ShutdownDurationSeconds.WithLabelValues(name).Set(duration.Seconds())
+			log.Info().Str("component", name).Dur("duration", duration).Msg("Component stopped")
+			mutex.Lock()
+			delete(pending, i)
+			mutex.Unlock()
+		})
 	}
 
 	done := make(chan struct{})
@@ -36,9 +77,22 @@ func RunGracefull(ctx context.Context, srvs ...StartStop) {
 	}()
 
 	select {
-	case <-timer:
+	case <-timer.C:
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, name := range pending {
+			metrics.ShutdownTimeoutExceeded.WithLabelValues(name).Inc()
+			log.Error().Str("component", name).Dur("timeout", timeout).Msg("Component not stopped within timeout")
+		}
 		log.Error().Msg("Application stopped before all goroutines done")
 	case <-done:
 		log.Info().Msg("Application stopped")
 	}
 }
+
+func componentName(srv StartStop) string {
+	if n, ok := srv.(named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", srv)
+}
@@ -0,0 +1,56 @@
+package startstop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/BinaryArchaism/rpcgate/internal/metrics"
+)
+
+type component struct {
+	name  string
+	delay time.Duration
+}
+
+func (c *component) Start(context.Context) {}
+
+func (c *component) Stop() { time.Sleep(c.delay) }
+
+func (c *component) Name() string { return c.name }
+
+type unnamed struct{}
+
+func (unnamed) Start(context.Context) {}
+
+func (unnamed) Stop() {}
+
+func Test_shutdown(t *testing.T) {
+	fast := &component{name: "fast"}
+	slow := &component{name: "slow", delay: 20 * time.Millisecond}
+	stuck := &component{name: "stuck", delay: time.Second}
+	exceeded := testutil.ToFloat64(metrics.ShutdownTimeoutExceeded.WithLabelValues("stuck"))
+
+	shutdown(100*time.Millisecond, fast, slow)
+	require.Less(t, testutil.ToFloat64(metrics.ShutdownDurationSeconds.WithLabelValues("fast")), 0.01)
+	require.GreaterOrEqual(t, testutil.ToFloat64(metrics.ShutdownDurationSeconds.WithLabelValues("slow")), 0.02)
+	require.InDelta(t, exceeded, testutil.ToFloat64(metrics.ShutdownTimeoutExceeded.WithLabelValues("stuck")), 0)
+
+	shutdown(10*time.Millisecond, fast, stuck)
+	require.InDelta(t, exceeded+1, testutil.ToFloat64(metrics.ShutdownTimeoutExceeded.WithLabelValues("stuck")), 0)
+	require.InDelta(t, 0, testutil.ToFloat64(metrics.ShutdownTimeoutExceeded.WithLabelValues("fast")), 0)
+
+	// component sharing name with stopped one is still reported.
+	exceeded = testutil.ToFloat64(metrics.ShutdownTimeoutExceeded.WithLabelValues("twin"))
+	shutdown(10*time.Millisecond, &component{name: "twin"}, &component{name: "twin", delay: time.Second})
+	require.InDelta(t, exceeded+1, testutil.ToFloat64(metrics.ShutdownTimeoutExceeded.WithLabelValues("twin")), 0)
+}
+
+func Test_componentName(t *testing.T) {
+	require.Equal(t, "proxy", componentName(&component{name: "proxy"}))
+
+	require.Equal(t, "*startstop.unnamed", componentName(&unnamed{}))
+}
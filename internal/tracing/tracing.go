@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+const (
+	instrumentationName = "rpcgate"
+	shutdownTimeout     = 10 * time.Second
+)
+
+// Tracer starts spans and exports sampled ones to OTLP/HTTP collector in batches.
+// Span context is propagated in w3c trace context headers.
+type Tracer struct {
+	tracer     trace.Tracer
+	provider   *sdktrace.TracerProvider
+	propagator propagation.TraceContext
+}
+
+// New returns tracer exporting spans to configured collector, nil if tracing is disabled.
+func New(cfg config.Tracing) *Tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", cfg.Endpoint).Msg("can not create span exporter, tracing is disabled")
+		return nil
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Warn().Err(err).Msg("tracing error")
+	}))
+	return newTracer(cfg, sdktrace.WithBatcher(exporter))
+}
+
+func newTracer(cfg config.Tracing, processor sdktrace.TracerProviderOption) *Tracer {
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithSampler(newSampler(cfg)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	return &Tracer{
+		tracer:   provider.Tracer(instrumentationName),
+		provider: provider,
+	}
+}
+
+// newSampler returns sampler deciding on traces started by gateway,
+// traces continued from parent follow its sampling decision.
+func newSampler(cfg config.Tracing) sdktrace.Sampler {
+	root := sdktrace.AlwaysSample()
+	switch cfg.Sampler {
+	case config.SamplerNever:
+		root = sdktrace.NeverSample()
+	case config.SamplerRatio:
+		root = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+	return sdktrace.ParentBased(root)
+}
+
+// Extract returns context with remote span context propagated in carrier headers,
+// context without span if headers carry none.
+func (t *Tracer) Extract(carrier propagation.TextMapCarrier) context.Context {
+	return t.propagator.Extract(context.Background(), carrier)
+}
+
+// Inject propagates span context of ctx in carrier headers.
+func (t *Tracer) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	t.propagator.Inject(ctx, carrier)
+}
+
+// Start starts span of kind as child of span of ctx, new trace is started if ctx has no span.
+func (t *Tracer) Start(
+	ctx context.Context,
+	name string,
+	kind trace.SpanKind,
+	attributes ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+}
+
+// Shutdown exports spans ended so far and stops exporter.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("can not export spans on shutdown")
+	}
+}
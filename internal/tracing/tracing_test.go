@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BinaryArchaism/rpcgate/internal/config"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func Test_Tracer_Start(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := newTracer(config.Tracing{ServiceName: "gateway", Sampler: config.SamplerNever},
+		sdktrace.WithSyncer(exporter))
+
+	// sampler decides on traces started by gateway.
+	_, root := tracer.Start(context.Background(), "root", trace.SpanKindServer)
+	require.True(t, root.SpanContext().IsValid())
+	require.False(t, root.SpanContext().IsSampled())
+	root.End()
+
+	// sampling decision of parent is followed.
+	parent := tracer.Extract(propagation.MapCarrier{"traceparent": traceparent})
+	ctx, request := tracer.Start(parent, "eth_call", trace.SpanKindServer, attribute.String("rpc.method", "eth_call"))
+	_, upstream := tracer.Start(ctx, "upstream", trace.SpanKindClient)
+	upstream.SetStatus(codes.Error, "bad gateway")
+	upstream.End()
+	request.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].SpanContext.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[1].Parent.SpanID().String())
+	require.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	require.Equal(t, trace.SpanKindClient, spans[0].SpanKind)
+	require.Equal(t, codes.Error, spans[0].Status.Code)
+	require.Equal(t, []attribute.KeyValue{attribute.String("rpc.method", "eth_call")}, spans[1].Attributes)
+	serviceName, ok := spans[1].Resource.Set().Value("service.name")
+	require.True(t, ok)
+	require.Equal(t, "gateway", serviceName.AsString())
+
+	// span is propagated to provider as child of parent trace.
+	carrier := propagation.MapCarrier{}
+	tracer.Inject(trace.ContextWithSpan(context.Background(), upstream), carrier)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+upstream.SpanContext().SpanID().String()+"-01",
+		carrier.Get("traceparent"))
+}
+
+func Test_newSampler(t *testing.T) {
+	for _, tc := range []struct {
+		cfg     config.Tracing
+		sampled bool
+	}{
+		{cfg: config.Tracing{Sampler: config.SamplerAlways}, sampled: true},
+		{cfg: config.Tracing{Sampler: config.SamplerNever}, sampled: false},
+		{cfg: config.Tracing{Sampler: config.SamplerRatio, SampleRatio: 1}, sampled: true},
+	} {
+		t.Run(tc.cfg.Sampler, func(t *testing.T) {
+			tracer := newTracer(tc.cfg, sdktrace.WithSyncer(tracetest.NewInMemoryExporter()))
+			_, span := tracer.Start(context.Background(), "root", trace.SpanKindServer)
+			require.Equal(t, tc.sampled, span.SpanContext().IsSampled())
+		})
+	}
+}
+
+func Test_Tracer_nil(t *testing.T) {
+	var tracer *Tracer
+	require.Nil(t, New(config.Tracing{}))
+	tracer.Shutdown()
+}